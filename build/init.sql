@@ -7,29 +7,212 @@ CREATE DATABASE "user";
 CREATE EXTENSION IF NOT EXISTS pgcrypto;
 
 CREATE TABLE IF NOT EXISTS user_records (
-  id BIGINT PRIMARY KEY,
+  id BIGSERIAL PRIMARY KEY,
   username TEXT NOT NULL,
   password_hash TEXT NOT NULL,
   role TEXT NOT NULL,
+  disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  email TEXT NOT NULL DEFAULT '',
   CONSTRAINT uni_user_records_username UNIQUE (username)
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_records_email ON user_records (email) WHERE email <> '';
+
 CREATE TABLE IF NOT EXISTS service_records (
-  id BIGINT PRIMARY KEY,
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL DEFAULT '',
+  secret_hash TEXT NOT NULL DEFAULT '',
+  role TEXT NOT NULL,
+  disabled BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS service_secrets (
+  id BIGSERIAL PRIMARY KEY,
+  service_id BIGINT NOT NULL,
   secret_hash TEXT NOT NULL,
-  role TEXT NOT NULL
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_secrets_service_id ON service_secrets (service_id);
+
+-- roles held next to the role column; token_type is "user" or "service"
+CREATE TABLE IF NOT EXISTS role_assignments (
+  token_type TEXT NOT NULL,
+  subject_id BIGINT NOT NULL,
+  role TEXT NOT NULL,
+  PRIMARY KEY (token_type, subject_id, role)
+);
+
+-- long-lived API keys, stored by sha256 hash; prefix is the visible part used for lookup
+CREATE TABLE IF NOT EXISTS api_keys (
+  id BIGSERIAL PRIMARY KEY,
+  key_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  prefix TEXT NOT NULL,
+  key_hash TEXT NOT NULL,
+  token_type TEXT NOT NULL,
+  subject_id BIGINT NOT NULL,
+  roles TEXT NOT NULL,
+  scope TEXT NOT NULL DEFAULT '',
+  rate_limit BIGINT NOT NULL DEFAULT 0,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  last_used_at TIMESTAMPTZ,
+  CONSTRAINT uni_api_keys_key_id UNIQUE (key_id),
+  CONSTRAINT uni_api_keys_prefix UNIQUE (prefix)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_subject_id ON api_keys (subject_id);
+
+-- HMAC request signing keys; the secret is stored raw because verification needs it
+CREATE TABLE IF NOT EXISTS service_signing_keys (
+  id BIGSERIAL PRIMARY KEY,
+  key_id TEXT NOT NULL,
+  api_key TEXT NOT NULL,
+  service_id BIGINT NOT NULL,
+  secret TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  last_used_at TIMESTAMPTZ,
+  CONSTRAINT uni_service_signing_keys_key_id UNIQUE (key_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_signing_keys_service_id ON service_signing_keys (service_id);
+
+-- TOTP enrollment; the secret is stored raw because codes are derived from it
+CREATE TABLE IF NOT EXISTS user_mfas (
+  user_id BIGINT PRIMARY KEY,
+  secret TEXT NOT NULL,
+  confirmed_at TIMESTAMPTZ,
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  code_hash TEXT NOT NULL,
+  used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+  id BIGSERIAL PRIMARY KEY,
+  challenge_hash TEXT NOT NULL,
+  user_id BIGINT NOT NULL,
+  expires_in BIGINT NOT NULL DEFAULT 0,
+  attempts BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  CONSTRAINT uni_mfa_challenges_challenge_hash UNIQUE (challenge_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user_id ON mfa_challenges (user_id);
+
+-- append-only audit trail; auth_gw prunes it after auth_settings.audit.retention_days
+CREATE TABLE IF NOT EXISTS auth_events (
+  id BIGSERIAL PRIMARY KEY,
+  event_type TEXT NOT NULL,
+  outcome TEXT NOT NULL,
+  token_type TEXT,
+  subject TEXT,
+  actor TEXT,
+  reason TEXT,
+  client_ip TEXT,
+  user_agent TEXT,
+  request_id TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_events_event_type ON auth_events (event_type);
+CREATE INDEX IF NOT EXISTS idx_auth_events_subject ON auth_events (subject);
+CREATE INDEX IF NOT EXISTS idx_auth_events_client_ip ON auth_events (client_ip);
+CREATE INDEX IF NOT EXISTS idx_auth_events_created_at ON auth_events (created_at);
+
+-- access tokens handed out, listed by GET /auth/sessions; auth_gw deletes them once expired
+CREATE TABLE IF NOT EXISTS issued_tokens (
+  jti TEXT PRIMARY KEY,
+  token_type TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  "grant" TEXT,
+  family_id TEXT,
+  act_sub TEXT,
+  act_token_type TEXT,
+  client_ip TEXT,
+  user_agent TEXT,
+  issued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_issued_tokens_subject ON issued_tokens (token_type, subject);
+CREATE INDEX IF NOT EXISTS idx_issued_tokens_expires_at ON issued_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+  id BIGSERIAL PRIMARY KEY,
+  provider TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  user_id BIGINT NOT NULL,
+  email TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_login_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_provider_subject ON user_identities (provider, subject);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+  state_hash TEXT PRIMARY KEY,
+  provider TEXT NOT NULL,
+  nonce TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  session_cookie BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_login_states_expires_at ON oidc_login_states (expires_at);
+
+CREATE TABLE IF NOT EXISTS email_tokens (
+  token_hash TEXT PRIMARY KEY,
+  purpose TEXT NOT NULL,
+  email TEXT NOT NULL,
+  user_id BIGINT NOT NULL DEFAULT 0,
+  username TEXT NOT NULL DEFAULT '',
+  password_hash TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_tokens_email ON email_tokens (purpose, email);
+CREATE INDEX IF NOT EXISTS idx_email_tokens_expires_at ON email_tokens (expires_at);
+
 INSERT INTO user_records (id, username, password_hash, role)
 VALUES
   (1, 'user_all', crypt('123', gen_salt('bf')), 'user_all'),
   (2, 'user_users', crypt('123', gen_salt('bf')), 'user_users'),
-  (3, 'user_orders', crypt('123', gen_salt('bf')), 'user_orders')
+  (3, 'user_orders', crypt('123', gen_salt('bf')), 'user_orders'),
+  (4, 'admin', crypt('123', gen_salt('bf')), 'admin')
 ON CONFLICT (id) DO NOTHING;
 
-INSERT INTO service_records (id, secret_hash, role)
+-- users created through the admin API continue after the seeded ids
+SELECT setval(pg_get_serial_sequence('user_records', 'id'), (SELECT MAX(id) FROM user_records));
+
+INSERT INTO service_records (id, name, role)
 VALUES
-  (1, crypt('123', gen_salt('bf')), 'api_gw'),
-  (2, crypt('123', gen_salt('bf')), 'users_gw'),
-  (3, crypt('123', gen_salt('bf')), 'orders_gw')
+  (1, 'api_gw', 'api_gw'),
+  (2, 'users_gw', 'users_gw'),
+  (3, 'orders_gw', 'orders_gw'),
+  (4, 'gw_admin', 'gw_admin')
 ON CONFLICT (id) DO NOTHING;
+
+-- services registered through the admin API continue after the seeded ids
+SELECT setval(pg_get_serial_sequence('service_records', 'id'), (SELECT MAX(id) FROM service_records));
+
+INSERT INTO service_secrets (service_id, secret_hash)
+SELECT s.id, crypt('123', gen_salt('bf'))
+FROM service_records s
+WHERE NOT EXISTS (SELECT 1 FROM service_secrets existing WHERE existing.service_id = s.id);
@@ -33,5 +33,11 @@ func InitConfiguration() {
 		os.Exit(1)
 	}
 
+	err = configuration_manager.ReadCustomConfig("admin_configuration", &Cfg.AdminConfiguration)
+	if err != nil {
+		// admin API stays closed without configured roles
+		fmt.Printf("admin configuration not loaded, admin API disabled: %v\n", err)
+	}
+
 	zap.ReplaceGlobals(Cfg.StandardConfigs.Clients.Logger)
 }
@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const adminAuditKey = "audit:admin"
+
+// AdminRepo defines admin audit log persistence.
+type AdminRepo interface {
+	AppendAudit(ctx context.Context, entry types.AdminAuditEntry) error
+	ListAudit(ctx context.Context, limit int) ([]types.AdminAuditEntry, error)
+}
+
+// AdminRepoImpl implements AdminRepo as a capped Redis list.
+type AdminRepoImpl struct {
+	client     *redis.Client
+	maxEntries int
+}
+
+// NewAdminRepo constructs an AdminRepo implementation.
+func NewAdminRepo(client *redis.Client, maxEntries int) *AdminRepoImpl {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+
+	return &AdminRepoImpl{
+		client:     client,
+		maxEntries: maxEntries,
+	}
+}
+
+// AppendAudit pushes an audit entry to the head of the list and trims old entries.
+func (r *AdminRepoImpl) AppendAudit(ctx context.Context, entry types.AdminAuditEntry) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		zap.L().Error("marshal admin audit entry", zap.Error(err))
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.LPush(ctx, adminAuditKey, payload)
+	pipe.LTrim(ctx, adminAuditKey, 0, int64(r.maxEntries-1))
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		zap.L().Error("redis append admin audit", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListAudit returns up to limit audit entries, newest first.
+func (r *AdminRepoImpl) ListAudit(ctx context.Context, limit int) ([]types.AdminAuditEntry, error) {
+	if limit <= 0 || limit > r.maxEntries {
+		limit = r.maxEntries
+	}
+
+	raw, err := r.client.LRange(ctx, adminAuditKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		zap.L().Error("redis list admin audit", zap.Error(err))
+		return nil, err
+	}
+
+	entries := make([]types.AdminAuditEntry, 0, len(raw))
+	for _, item := range raw {
+		var entry types.AdminAuditEntry
+		if err = json.Unmarshal([]byte(item), &entry); err != nil {
+			zap.L().Warn("skip malformed admin audit entry", zap.Error(err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
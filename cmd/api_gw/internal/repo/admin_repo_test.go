@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestAdminRepoAuditCappedNewestFirst verifies audit entries are capped and listed newest first.
+func TestAdminRepoAuditCappedNewestFirst(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	adminRepo := NewAdminRepo(client, 2)
+
+	for _, action := range []string{"token.patch", "token.delete", "cache.purge"} {
+		err = adminRepo.AppendAudit(context.Background(), types.AdminAuditEntry{
+			At:     time.Now().UTC(),
+			Actor:  "4",
+			Action: action,
+		})
+		if err != nil {
+			t.Fatalf("append audit %s: %v", action, err)
+		}
+	}
+
+	entries, err := adminRepo.ListAudit(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("list audit: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries after cap, got %d", len(entries))
+	}
+	if entries[0].Action != "cache.purge" || entries[1].Action != "token.delete" {
+		t.Fatalf("unexpected audit order: %#v", entries)
+	}
+}
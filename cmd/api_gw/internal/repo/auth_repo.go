@@ -3,9 +3,13 @@ package repo
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -14,13 +18,41 @@ import (
 
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 var errTokenNotFound = errors.New("token not found")
 var errUnauthorized = errors.New("unauthorized")
 
+// touchLastSeenScript sets last_seen_at only on existing metadata, so no hash is left without its expiry.
+var touchLastSeenScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("HSET", KEYS[1], "last_seen_at", ARGV[1])
+end
+return 0
+`)
+
+// revocationChannel is the auth_gw pub/sub channel for revocation events.
+const revocationChannel = "auth:revocations"
+
+// maxCachedValidations bounds the local validation cache before expired entries are swept.
+const maxCachedValidations = 10000
+
+const (
+	// serviceTokenRenewFraction of the service token lifetime passes before it is renewed.
+	serviceTokenRenewFraction = 0.8
+	// serviceTokenFallbackLifetime is assumed when the service token expiry is unknown.
+	serviceTokenFallbackLifetime = 5 * time.Minute
+	// serviceTokenForceInterval is the minimum service token age before a validate 401 forces a renewal;
+	// auth_gw answers 401 for bad client tokens too, so this bounds renewals caused by them.
+	serviceTokenForceInterval = 10 * time.Second
+	// serviceTokenMaxBackoff caps the retry delay of failed background renewals.
+	serviceTokenMaxBackoff = 30 * time.Second
+)
+
 // ErrTokenNotFound exposes the not-found sentinel error.
 func ErrTokenNotFound() error {
 	return errTokenNotFound
@@ -29,10 +61,38 @@ func ErrTokenNotFound() error {
 // AuthRepo defines auth_gw integration operations.
 type AuthRepo interface {
 	ValidateToken(ctx context.Context, token string) (types.ValidateResponse, error)
+	ValidateAPIKey(ctx context.Context, key string) (types.ValidateResponse, error)
+	VerifySignature(ctx context.Context, sig types.SignatureValidation) (types.ValidateResponse, error)
+	ClaimNonce(ctx context.Context, keyID string, nonce string, ttl time.Duration) (bool, error)
 
 	GetTokenMetaFromRedis(ctx context.Context, apiKey string) (types.TokenMetadata, error)
 	SetToken(ctx context.Context, metadata types.TokenMetadata) error
 	TouchExpiry(ctx context.Context, apiKey string, expiresAt time.Time) error
+	TouchLastSeen(ctx context.Context, apiKey string, seenAt time.Time) error
+	DeleteToken(ctx context.Context, apiKey string) (bool, error)
+	PurgeTokens(ctx context.Context) (int64, error)
+	IsRevoked(ctx context.Context, validation types.ValidateResponse) (bool, error)
+}
+
+// cachedValidation holds a validate response until the local cache entry expires.
+type cachedValidation struct {
+	resp      types.ValidateResponse
+	expiresAt time.Time
+}
+
+// serviceTokenState is the cached api_gw service token and its renewal bookkeeping.
+type serviceTokenState struct {
+	token     string
+	fetchedAt time.Time
+	renewAt   time.Time
+	expiresAt time.Time
+	lastErr   error // last renewal failure, cleared by the next success.
+}
+
+// serviceTokenMetrics tracks service token renewals.
+type serviceTokenMetrics struct {
+	renewals  *prometheus.CounterVec
+	expiresAt prometheus.Gauge
 }
 
 // AuthRepoImpl implements AuthRepo against auth_gw HTTP endpoints.
@@ -42,35 +102,289 @@ type AuthRepoImpl struct {
 	secret       string
 	httpClient   *http.Client
 	tokenMu      sync.Mutex
-	serviceToken string
+	serviceToken serviceTokenState
+	renewals     singleflight.Group
+	metrics      serviceTokenMetrics
 	redisClient  *redis.Client
+
+	cacheTTL    time.Duration
+	cacheMu     sync.RWMutex
+	validations map[string]cachedValidation
 }
 
 // NewAuthRepo constructs an AuthRepo implementation.
-func NewAuthRepo(endpoint string, serviceID string, secret string, redisClient *redis.Client) *AuthRepoImpl {
+// A zero validationCacheTTL disables local caching of validate responses.
+func NewAuthRepo(endpoint string, serviceID string, secret string, redisClient *redis.Client, validationCacheTTL time.Duration) *AuthRepoImpl {
 	return &AuthRepoImpl{
 		endpoint:    endpoint,
 		serviceID:   serviceID,
 		secret:      secret,
 		redisClient: redisClient,
 		httpClient:  &http.Client{Timeout: 5 * time.Second},
+		cacheTTL:    validationCacheTTL,
+		validations: make(map[string]cachedValidation),
+		metrics:     newServiceTokenMetrics(),
+	}
+}
+
+// newServiceTokenMetrics builds unregistered service token collectors; the router registers them.
+func newServiceTokenMetrics() serviceTokenMetrics {
+	return serviceTokenMetrics{
+		renewals: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "service_token_renewals_total",
+				Help:        "Service token renewals against auth_gw by reason and result.",
+				ConstLabels: prometheus.Labels{"service": "api_gw"},
+			},
+			[]string{"reason", "result"},
+		),
+		expiresAt: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "service_token_expiry_timestamp_seconds",
+			Help:        "Unix expiry of the current service token.",
+			ConstLabels: prometheus.Labels{"service": "api_gw"},
+		}),
 	}
 }
 
-// ValidateToken validates a client token by calling auth_gw.
+// Collectors returns the service token Prometheus collectors.
+func (r *AuthRepoImpl) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{r.metrics.renewals, r.metrics.expiresAt}
+}
+
+// ValidateToken validates a client token by calling auth_gw, serving recent results from the local cache.
 func (r *AuthRepoImpl) ValidateToken(ctx context.Context, token string) (types.ValidateResponse, error) {
+	return r.validate(ctx, token, types.ValidateRequest{Token: token})
+}
+
+// ValidateAPIKey validates a client API key by calling auth_gw, serving recent results from the local cache.
+func (r *AuthRepoImpl) ValidateAPIKey(ctx context.Context, key string) (types.ValidateResponse, error) {
+	return r.validate(ctx, key, types.ValidateRequest{APIKey: key})
+}
+
+// validate sends a client credential to auth_gw validate unless a recent result is cached.
+func (r *AuthRepoImpl) validate(ctx context.Context, credential string, payload types.ValidateRequest) (types.ValidateResponse, error) {
+	cacheKey := validationCacheKey(credential)
+	if resp, ok := r.cachedValidation(cacheKey); ok {
+		return resp, nil
+	}
+
+	resp, err := r.validateRemote(ctx, payload)
+	if err != nil {
+		return types.ValidateResponse{}, err
+	}
+
+	r.storeValidation(cacheKey, resp)
+	return resp, nil
+}
+
+// VerifySignature asks auth_gw to verify a request signature; results are never cached since every signature is single-use.
+func (r *AuthRepoImpl) VerifySignature(ctx context.Context, sig types.SignatureValidation) (types.ValidateResponse, error) {
+	return r.validateRemote(ctx, types.ValidateRequest{Signature: &sig})
+}
+
+// ClaimNonce records a request signing nonce and reports whether it was unused; the claim expires after ttl.
+func (r *AuthRepoImpl) ClaimNonce(ctx context.Context, keyID string, nonce string, ttl time.Duration) (bool, error) {
+	key := signatureNonceKey(keyID, nonce)
+	fresh, err := r.redisClient.SetNX(ctx, key, 1, ttl).Result()
+	if err != nil {
+		zap.L().Error("redis setnx signature nonce", zap.String("key", key), zap.Error(err))
+		return false, err
+	}
+
+	return fresh, nil
+}
+
+// validateRemote calls auth_gw validate, retrying once with a renewed service token.
+func (r *AuthRepoImpl) validateRemote(ctx context.Context, payload types.ValidateRequest) (types.ValidateResponse, error) {
 	serviceToken, err := r.getServiceToken(ctx)
 	if err != nil {
 		zap.L().Error("get service token", zap.Error(err))
 		return types.ValidateResponse{}, err
 	}
 
-	return r.validateWithServiceToken(ctx, token, serviceToken)
+	resp, err := r.validateWithServiceToken(ctx, payload, serviceToken)
+	if errors.Is(err, errUnauthorized) && r.currentServiceToken().fetchedAt.Before(time.Now().Add(-serviceTokenForceInterval)) {
+		// the 401 may be about an expired or revoked service token rather than the client token
+		serviceToken, err = r.renewServiceToken(ctx, serviceToken, "unauthorized")
+		if err != nil {
+			return types.ValidateResponse{}, err
+		}
+		resp, err = r.validateWithServiceToken(ctx, payload, serviceToken)
+	}
+	if err != nil {
+		return types.ValidateResponse{}, err
+	}
+
+	return resp, nil
+}
+
+// IsRevoked checks auth_gw revocation markers for the token jti and its subject cutoff.
+// Impersonation tokens are also revoked by a cutoff on their actor.
+func (r *AuthRepoImpl) IsRevoked(ctx context.Context, validation types.ValidateResponse) (bool, error) {
+	keys := []string{
+		revokedTokenKey(validation.APIKey),
+		revokedSubjectKey(validation.TokenType, validation.Subject),
+	}
+	if validation.Actor != nil {
+		keys = append(keys, revokedSubjectKey(validation.Actor.TokenType, validation.Actor.Subject))
+	}
+
+	values, err := r.redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		zap.L().Error("redis check revocation", zap.String("api_key", validation.APIKey), zap.Error(err))
+		return false, err
+	}
+
+	if values[0] != nil {
+		return true, nil
+	}
+
+	for _, value := range values[1:] {
+		if subjectCutoffCovers(value, validation.IssuedAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// subjectCutoffCovers reports whether a subject revocation cutoff applies to a token issued at issuedAt.
+func subjectCutoffCovers(value any, issuedAtRaw string) bool {
+	cutoff, ok := value.(string)
+	if !ok || cutoff == "" {
+		return false
+	}
+
+	// unix seconds with milliseconds; older cutoffs are whole seconds
+	revokedBefore, err := strconv.ParseFloat(cutoff, 64)
+	if err != nil {
+		zap.L().Warn("invalid subject revocation cutoff", zap.String("value", cutoff), zap.Error(err))
+		return false
+	}
+
+	issuedAt, err := time.Parse(time.RFC3339, issuedAtRaw)
+	if err != nil {
+		// unknown issue time cannot be proven newer than the cutoff
+		return true
+	}
+
+	// whole-second cutoffs keep covering that whole second
+	if !strings.Contains(cutoff, ".") {
+		return issuedAt.Unix() <= int64(revokedBefore)
+	}
+	return issuedAt.UnixMilli() <= int64(math.Round(revokedBefore*1000))
+}
+
+// ListenRevocations subscribes to auth_gw revocation events and drops local cached state until ctx ends.
+func (r *AuthRepoImpl) ListenRevocations(ctx context.Context) {
+	pubsub := r.redisClient.Subscribe(ctx, revocationChannel)
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var event types.RevocationEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				zap.L().Warn("decode revocation event", zap.String("payload", msg.Payload), zap.Error(err))
+				continue
+			}
+			r.applyRevocation(ctx, event)
+		}
+	}
+}
+
+// applyRevocation evicts cached validations and token metadata affected by a revocation event.
+func (r *AuthRepoImpl) applyRevocation(ctx context.Context, event types.RevocationEvent) {
+	switch event.Type {
+	case "token":
+		r.evictValidations(func(resp types.ValidateResponse) bool {
+			return resp.APIKey == event.JTI
+		})
+		if _, err := r.DeleteToken(ctx, event.JTI); err != nil {
+			zap.L().Warn("drop revoked token metadata", zap.String("api_key", event.JTI), zap.Error(err))
+		}
+	case "subject":
+		r.evictValidations(func(resp types.ValidateResponse) bool {
+			return resp.TokenType == event.TokenType && resp.Subject == event.Subject
+		})
+	default:
+		zap.L().Warn("unknown revocation event type", zap.String("type", event.Type))
+		return
+	}
+
+	zap.L().Info("revocation applied",
+		zap.String("type", event.Type),
+		zap.String("jti", event.JTI),
+		zap.String("sub", event.Subject),
+		zap.String("token_type", event.TokenType),
+	)
+}
+
+// cachedValidation returns a non-expired cached validate response.
+func (r *AuthRepoImpl) cachedValidation(cacheKey string) (types.ValidateResponse, bool) {
+	if r.cacheTTL <= 0 {
+		return types.ValidateResponse{}, false
+	}
+
+	r.cacheMu.RLock()
+	entry, ok := r.validations[cacheKey]
+	r.cacheMu.RUnlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		return types.ValidateResponse{}, false
+	}
+
+	return entry.resp, true
+}
+
+// storeValidation caches a validate response no longer than the token itself lives.
+func (r *AuthRepoImpl) storeValidation(cacheKey string, resp types.ValidateResponse) {
+	if r.cacheTTL <= 0 {
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(r.cacheTTL)
+	if tokenExpiry, err := time.Parse(time.RFC3339, resp.ExpiresAt); err == nil && tokenExpiry.Before(expiresAt) {
+		expiresAt = tokenExpiry
+	}
+
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	if len(r.validations) >= maxCachedValidations {
+		for key, entry := range r.validations {
+			if now.After(entry.expiresAt) {
+				delete(r.validations, key)
+			}
+		}
+		if len(r.validations) >= maxCachedValidations {
+			r.validations = make(map[string]cachedValidation)
+		}
+	}
+	r.validations[cacheKey] = cachedValidation{resp: resp, expiresAt: expiresAt}
+}
+
+// evictValidations removes cached validate responses matching the predicate.
+func (r *AuthRepoImpl) evictValidations(match func(resp types.ValidateResponse) bool) {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	for key, entry := range r.validations {
+		if match(entry.resp) {
+			delete(r.validations, key)
+		}
+	}
 }
 
 // validateWithServiceToken calls auth_gw validate endpoint using service bearer token.
-func (r *AuthRepoImpl) validateWithServiceToken(ctx context.Context, token string, serviceToken string) (types.ValidateResponse, error) {
-	payload, err := json.Marshal(types.ValidateRequest{Token: token})
+func (r *AuthRepoImpl) validateWithServiceToken(ctx context.Context, validateReq types.ValidateRequest, serviceToken string) (types.ValidateResponse, error) {
+	payload, err := json.Marshal(validateReq)
 	if err != nil {
 		zap.L().Error("marshal validate payload", zap.Error(err))
 		return types.ValidateResponse{}, err
@@ -110,68 +424,175 @@ func (r *AuthRepoImpl) validateWithServiceToken(ctx context.Context, token strin
 	return resp, nil
 }
 
-// getServiceToken returns cached service token or fetches a new one.
-func (r *AuthRepoImpl) getServiceToken(ctx context.Context) (string, error) {
-	r.tokenMu.Lock()
-	defer r.tokenMu.Unlock()
+// RenewServiceToken renews the service token ahead of its expiry until ctx ends, backing off after failures.
+func (r *AuthRepoImpl) RenewServiceToken(ctx context.Context) {
+	var backoff time.Duration
+	for {
+		wait := backoff
+		if wait == 0 {
+			wait = time.Until(r.currentServiceToken().renewAt)
+		}
 
-	if r.serviceToken == "" {
-		if err := r.refreshServiceTokenLocked(ctx); err != nil {
-			return "", err
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
 		}
+
+		state := r.currentServiceToken()
+		if state.token != "" && time.Now().Before(state.renewAt) {
+			backoff = 0
+			continue
+		}
+
+		_, err := r.renewServiceToken(ctx, state.token, renewReason(state))
+		if err != nil {
+			backoff = min(max(2*backoff, time.Second), serviceTokenMaxBackoff)
+			continue
+		}
+		backoff = 0
 	}
+}
+
+// ServiceTokenReady reports whether api_gw holds a valid service token and its last renewal succeeded.
+func (r *AuthRepoImpl) ServiceTokenReady(ctx context.Context) error {
+	state := r.currentServiceToken()
+	switch {
+	case state.lastErr != nil:
+		return fmt.Errorf("service token renewal failing: %w", state.lastErr)
+	case state.token == "":
+		return errors.New("no service token yet")
+	case !time.Now().Before(state.expiresAt):
+		return errors.New("service token expired")
+	}
+
+	return nil
+}
+
+// getServiceToken returns the cached service token, renewing it once it is due.
+// A still valid token is returned when the renewal fails.
+func (r *AuthRepoImpl) getServiceToken(ctx context.Context) (string, error) {
+	state := r.currentServiceToken()
+	now := time.Now()
+	if state.token != "" && now.Before(state.renewAt) {
+		return state.token, nil
+	}
+
+	token, err := r.renewServiceToken(ctx, state.token, renewReason(state))
+	if err != nil && state.token != "" && now.Before(state.expiresAt) {
+		zap.L().Warn("service token renewal failed, using current token", zap.Time("expires_at", state.expiresAt), zap.Error(err))
+		return state.token, nil
+	}
+
+	return token, err
+}
 
-	return r.serviceToken, nil
+// renewServiceToken replaces stale with a new service token; concurrent callers share one auth_gw call.
+func (r *AuthRepoImpl) renewServiceToken(ctx context.Context, stale string, reason string) (string, error) {
+	result := r.renewals.DoChan("service-token", func() (any, error) {
+		// a caller that waited for an earlier renewal already has a fresh token
+		current := r.currentServiceToken()
+		if current.token != "" && current.token != stale && time.Now().Before(current.renewAt) {
+			return current.token, nil
+		}
+
+		// shared with other callers, so one caller's cancellation must not fail the others
+		return r.fetchServiceToken(context.WithoutCancel(ctx), reason)
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	}
 }
 
-// refreshServiceToken refreshes cached service token with synchronization.
-func (r *AuthRepoImpl) refreshServiceToken(ctx context.Context) error {
+// fetchServiceToken logs api_gw in to auth_gw and stores the token with its renewal time.
+func (r *AuthRepoImpl) fetchServiceToken(ctx context.Context, reason string) (string, error) {
+	resp, err := r.requestServiceToken(ctx)
+	if err != nil {
+		r.metrics.renewals.WithLabelValues(reason, "failure").Inc()
+		r.tokenMu.Lock()
+		r.serviceToken.lastErr = err
+		r.tokenMu.Unlock()
+		return "", err
+	}
+
+	now := time.Now()
+	expiresAt, err := tokenExpiry(resp.Token)
+	if err != nil {
+		expiresAt = now.Add(serviceTokenFallbackLifetime)
+		if resp.ExpiresIn > 0 {
+			expiresAt = now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+		}
+		zap.L().Warn("service token expiry not decodable, using fallback", zap.Time("expires_at", expiresAt), zap.Error(err))
+	}
+	// at least a second between renewals, even for tokens that look expired due to clock skew
+	renewAt := now.Add(max(time.Duration(float64(expiresAt.Sub(now))*serviceTokenRenewFraction), time.Second))
+
 	r.tokenMu.Lock()
-	defer r.tokenMu.Unlock()
-	return r.refreshServiceTokenLocked(ctx)
+	r.serviceToken = serviceTokenState{token: resp.Token, fetchedAt: now, renewAt: renewAt, expiresAt: expiresAt}
+	r.tokenMu.Unlock()
+
+	r.metrics.renewals.WithLabelValues(reason, "success").Inc()
+	r.metrics.expiresAt.Set(float64(expiresAt.Unix()))
+	zap.L().Info("service token renewed", zap.String("reason", reason), zap.Time("renew_at", renewAt), zap.Time("expires_at", expiresAt))
+	return resp.Token, nil
 }
 
-// refreshServiceTokenLocked must run with tokenMu held.
-func (r *AuthRepoImpl) refreshServiceTokenLocked(ctx context.Context) error {
+// requestServiceToken calls the auth_gw service-token endpoint with api_gw credentials.
+func (r *AuthRepoImpl) requestServiceToken(ctx context.Context) (types.ServiceTokenResponse, error) {
 	payload, err := json.Marshal(types.ServiceTokenRequest{ServiceID: r.serviceID, Secret: r.secret})
 	if err != nil {
 		zap.L().Error("marshal service-token payload", zap.Error(err))
-		return err
+		return types.ServiceTokenResponse{}, err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/auth/service-token", r.endpoint), bytes.NewReader(payload))
 	if err != nil {
 		zap.L().Error("build service-token request", zap.Error(err))
-		return err
+		return types.ServiceTokenResponse{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	res, err := r.httpClient.Do(req)
 	if err != nil {
 		zap.L().Error("do service-token request", zap.Error(err))
-		return err
+		return types.ServiceTokenResponse{}, err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		err = fmt.Errorf("auth service-token failed: %d", res.StatusCode)
 		zap.L().Error("auth service-token non-200", zap.Int("status_code", res.StatusCode), zap.Error(err))
-		return err
+		return types.ServiceTokenResponse{}, err
 	}
 
 	var resp types.ServiceTokenResponse
 	if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
 		zap.L().Error("decode service-token response", zap.Error(err))
-		return err
+		return types.ServiceTokenResponse{}, err
 	}
 	if resp.Token == "" {
 		err = fmt.Errorf("auth service-token empty")
 		zap.L().Error("service-token empty", zap.Error(err))
-		return err
+		return types.ServiceTokenResponse{}, err
 	}
 
-	r.serviceToken = resp.Token
-	return nil
+	return resp, nil
+}
+
+// currentServiceToken returns a snapshot of the service token state.
+func (r *AuthRepoImpl) currentServiceToken() serviceTokenState {
+	r.tokenMu.Lock()
+	defer r.tokenMu.Unlock()
+	return r.serviceToken
 }
 
 // GetTokenMetaFromRedis fetches token metadata from Redis.
@@ -205,12 +626,20 @@ func (r *AuthRepoImpl) GetTokenMetaFromRedis(ctx context.Context, apiKey string)
 		apiKeyValue = apiKey
 	}
 
+	// last_seen_at is optional; unparsable values read as never seen
+	lastSeenAt, _ := time.Parse(time.RFC3339, values["last_seen_at"])
+
 	return types.TokenMetadata{
-		APIKey:        apiKeyValue,
-		Owner:         strings.TrimSpace(values["owner"]),
-		RateLimit:     rateLimit,
-		ExpiresAt:     expiresAt,
-		AllowedRoutes: allowedRoutes,
+		APIKey:         apiKeyValue,
+		Owner:          strings.TrimSpace(values["owner"]),
+		Subject:        values["sub"],
+		TokenType:      values["token_type"],
+		RateLimit:      rateLimit,
+		ExpiresAt:      expiresAt,
+		AllowedRoutes:  allowedRoutes,
+		ActorSubject:   values["act_sub"],
+		ActorTokenType: values["act_token_type"],
+		LastSeenAt:     lastSeenAt,
 	}, nil
 }
 
@@ -226,6 +655,19 @@ func (r *AuthRepoImpl) TouchExpiry(ctx context.Context, apiKey string, expiresAt
 	return nil
 }
 
+// TouchLastSeen records when a token was last used; auth_gw reads it for session listings.
+// The field is only written while the metadata key exists, so it never outlives the token.
+func (r *AuthRepoImpl) TouchLastSeen(ctx context.Context, apiKey string, seenAt time.Time) error {
+	key := tokenKey(apiKey)
+	err := touchLastSeenScript.Run(ctx, r.redisClient, []string{key}, seenAt.UTC().Format(time.RFC3339)).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		zap.L().Error("redis touch token last_seen_at", zap.String("key", key), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
 // SetToken writes token metadata to Redis and aligns key expiry with token expiry.
 func (r *AuthRepoImpl) SetToken(ctx context.Context, metadata types.TokenMetadata) error {
 	key := tokenKey(metadata.APIKey)
@@ -236,11 +678,15 @@ func (r *AuthRepoImpl) SetToken(ctx context.Context, metadata types.TokenMetadat
 	}
 
 	record := types.RedisTokenRecord{
-		APIKey:        metadata.APIKey,
-		Owner:         metadata.Owner,
-		RateLimit:     metadata.RateLimit,
-		ExpiresAt:     metadata.ExpiresAt.UTC().Format(time.RFC3339),
-		AllowedRoutes: string(allowedRoutesJSON),
+		APIKey:         metadata.APIKey,
+		Owner:          metadata.Owner,
+		Subject:        metadata.Subject,
+		TokenType:      metadata.TokenType,
+		RateLimit:      metadata.RateLimit,
+		ExpiresAt:      metadata.ExpiresAt.UTC().Format(time.RFC3339),
+		AllowedRoutes:  string(allowedRoutesJSON),
+		ActorSubject:   metadata.ActorSubject,
+		ActorTokenType: metadata.ActorTokenType,
 	}
 
 	err = r.redisClient.HSet(ctx, key, record).Err()
@@ -257,11 +703,104 @@ func (r *AuthRepoImpl) SetToken(ctx context.Context, metadata types.TokenMetadat
 	return nil
 }
 
+// DeleteToken removes token metadata from Redis and reports whether it existed.
+func (r *AuthRepoImpl) DeleteToken(ctx context.Context, apiKey string) (bool, error) {
+	key := tokenKey(apiKey)
+	deleted, err := r.redisClient.Del(ctx, key).Result()
+	if err != nil {
+		zap.L().Error("redis del token metadata", zap.String("key", key), zap.Error(err))
+		return false, err
+	}
+
+	r.evictValidations(func(resp types.ValidateResponse) bool {
+		return resp.APIKey == apiKey
+	})
+	return deleted > 0, nil
+}
+
+// PurgeTokens removes all token metadata keys and cached validations; metadata is rebuilt lazily from role defaults.
+func (r *AuthRepoImpl) PurgeTokens(ctx context.Context) (int64, error) {
+	r.cacheMu.Lock()
+	r.validations = make(map[string]cachedValidation)
+	r.cacheMu.Unlock()
+
+	var purged int64
+	iter := r.redisClient.Scan(ctx, 0, tokenKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		deleted, err := r.redisClient.Del(ctx, iter.Val()).Result()
+		if err != nil {
+			zap.L().Error("redis del token metadata", zap.String("key", iter.Val()), zap.Error(err))
+			return purged, err
+		}
+		purged += deleted
+	}
+	if err := iter.Err(); err != nil {
+		zap.L().Error("redis scan token metadata", zap.Error(err))
+		return purged, err
+	}
+
+	return purged, nil
+}
+
+// renewReason labels why a service token renewal happens.
+func renewReason(state serviceTokenState) string {
+	if state.token == "" {
+		return "initial"
+	}
+	return "expiring"
+}
+
+// tokenExpiry decodes the exp claim of a JWT without verifying it; api_gw does not hold the signing key.
+func tokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("token is not a jwt")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, errors.New("missing exp")
+	}
+
+	return time.Unix(claims.Exp, 0), nil
+}
+
 // tokenKey builds redis key for token metadata.
 func tokenKey(apiKey string) string {
 	return fmt.Sprintf("token:%s", apiKey)
 }
 
+// revokedTokenKey builds redis key of an auth_gw jti revocation marker.
+func revokedTokenKey(apiKey string) string {
+	return fmt.Sprintf("revoked:jti:%s", apiKey)
+}
+
+// revokedSubjectKey builds redis key of an auth_gw subject revocation cutoff.
+func revokedSubjectKey(tokenType string, subject string) string {
+	return fmt.Sprintf("revoked:sub:%s:%s", tokenType, subject)
+}
+
+// signatureNonceKey builds redis key of a claimed request signing nonce.
+func signatureNonceKey(keyID string, nonce string) string {
+	return fmt.Sprintf("sig:nonce:%s:%s", keyID, nonce)
+}
+
+// validationCacheKey hashes the raw token or API key so it is never kept in memory as a map key.
+func validationCacheKey(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
+
 // parseAllowedRoutes parses allowed_routes from JSON array or CSV fallback.
 func parseAllowedRoutes(raw string) []string {
 	if raw == "" {
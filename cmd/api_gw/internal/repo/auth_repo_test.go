@@ -2,12 +2,20 @@ package repo
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -20,7 +28,7 @@ func TestAuthRepoSetAndGetTokenMeta(t *testing.T) {
 	defer mr.Close()
 
 	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
-	authRepo := NewAuthRepo("http://auth:8084", "1", "123", client)
+	authRepo := NewAuthRepo("http://auth:8084", "1", "123", client, 0)
 
 	expiresAt := time.Now().UTC().Add(1 * time.Hour).Truncate(time.Second)
 	in := types.TokenMetadata{
@@ -56,3 +64,412 @@ func TestAuthRepoSetAndGetTokenMeta(t *testing.T) {
 		t.Fatalf("allowed routes mismatch: got %#v", out.AllowedRoutes)
 	}
 }
+
+// TestAuthRepoTouchLastSeen verifies last_seen_at survives metadata rewrites and never creates a key.
+func TestAuthRepoTouchLastSeen(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	authRepo := NewAuthRepo("http://auth:8084", "1", "123", client, 0)
+	ctx := context.Background()
+	seenAt := time.Now().UTC().Truncate(time.Second)
+
+	if err = authRepo.TouchLastSeen(ctx, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", seenAt); err != nil {
+		t.Fatalf("touch missing token: %v", err)
+	}
+	if mr.Exists("token:6ba7b810-9dad-11d1-80b4-00c04fd430c8") {
+		t.Fatal("expected no metadata key for unknown token")
+	}
+
+	in := types.TokenMetadata{
+		APIKey:        "550e8400-e29b-41d4-a716-446655440000",
+		Owner:         "user_users",
+		ExpiresAt:     seenAt.Add(time.Hour),
+		AllowedRoutes: []string{"/api/v1/users/*"},
+	}
+	if err = authRepo.SetToken(ctx, in); err != nil {
+		t.Fatalf("set token: %v", err)
+	}
+	if err = authRepo.TouchLastSeen(ctx, in.APIKey, seenAt); err != nil {
+		t.Fatalf("touch token: %v", err)
+	}
+	in.Owner = "user_all"
+	if err = authRepo.SetToken(ctx, in); err != nil {
+		t.Fatalf("rewrite token: %v", err)
+	}
+
+	out, err := authRepo.GetTokenMetaFromRedis(ctx, in.APIKey)
+	if err != nil {
+		t.Fatalf("get token: %v", err)
+	}
+	if !out.LastSeenAt.Equal(seenAt) {
+		t.Fatalf("last seen mismatch: got %s want %s", out.LastSeenAt, seenAt)
+	}
+}
+
+// TestAuthRepoDeleteAndPurgeTokens verifies single and bulk token metadata removal.
+func TestAuthRepoDeleteAndPurgeTokens(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	authRepo := NewAuthRepo("http://auth:8084", "1", "123", client, 0)
+
+	expiresAt := time.Now().UTC().Add(1 * time.Hour).Truncate(time.Second)
+	for _, apiKey := range []string{"key-a", "key-b", "key-c"} {
+		err = authRepo.SetToken(context.Background(), types.TokenMetadata{
+			APIKey:        apiKey,
+			RateLimit:     5,
+			ExpiresAt:     expiresAt,
+			AllowedRoutes: []string{"/api/v1/users/*"},
+		})
+		if err != nil {
+			t.Fatalf("set token %s: %v", apiKey, err)
+		}
+	}
+	mr.Set("rl:key-a:users:1", "1")
+
+	deleted, err := authRepo.DeleteToken(context.Background(), "key-a")
+	if err != nil || !deleted {
+		t.Fatalf("expected key-a deleted, got deleted=%v err=%v", deleted, err)
+	}
+	deleted, err = authRepo.DeleteToken(context.Background(), "key-a")
+	if err != nil || deleted {
+		t.Fatalf("expected second delete to report missing, got deleted=%v err=%v", deleted, err)
+	}
+
+	purged, err := authRepo.PurgeTokens(context.Background())
+	if err != nil {
+		t.Fatalf("purge tokens: %v", err)
+	}
+	if purged != 2 {
+		t.Fatalf("expected 2 purged tokens, got %d", purged)
+	}
+	if !mr.Exists("rl:key-a:users:1") {
+		t.Fatalf("expected purge to leave rate-limit keys untouched")
+	}
+}
+
+// newStubAuthServer fakes auth_gw service-token and validate endpoints and counts validate calls.
+func newStubAuthServer(t *testing.T, resp types.ValidateResponse, validateCalls *int32) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/service-token":
+			_ = json.NewEncoder(w).Encode(types.ServiceTokenResponse{Token: "service-token"})
+		case "/auth/validate":
+			atomic.AddInt32(validateCalls, 1)
+			_ = json.NewEncoder(w).Encode(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// TestAuthRepoValidationCacheEvictedByRevocation verifies cached validations and metadata are dropped on revocation events.
+func TestAuthRepoValidationCacheEvictedByRevocation(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	expiresAt := time.Now().UTC().Add(1 * time.Hour).Truncate(time.Second)
+	validation := types.ValidateResponse{
+		APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+		Subject:   "2",
+		TokenType: "user",
+		Role:      "user_users",
+		IssuedAt:  time.Now().UTC().Format(time.RFC3339),
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+	}
+	var validateCalls int32
+	server := newStubAuthServer(t, validation, &validateCalls)
+	defer server.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	authRepo := NewAuthRepo(server.URL, "1", "123", client, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err = authRepo.ValidateToken(context.Background(), "client-token"); err != nil {
+			t.Fatalf("validate #%d: %v", i+1, err)
+		}
+	}
+	if calls := atomic.LoadInt32(&validateCalls); calls != 1 {
+		t.Fatalf("expected cached validation after first call, got %d auth_gw calls", calls)
+	}
+
+	err = authRepo.SetToken(context.Background(), types.TokenMetadata{
+		APIKey:        validation.APIKey,
+		ExpiresAt:     expiresAt,
+		AllowedRoutes: []string{"/api/v1/users/*"},
+	})
+	if err != nil {
+		t.Fatalf("set token: %v", err)
+	}
+
+	authRepo.applyRevocation(context.Background(), types.RevocationEvent{Type: "token", JTI: validation.APIKey})
+
+	if mr.Exists(tokenKey(validation.APIKey)) {
+		t.Fatalf("expected token metadata to be dropped on revocation")
+	}
+	if _, err = authRepo.ValidateToken(context.Background(), "client-token"); err != nil {
+		t.Fatalf("validate after revocation: %v", err)
+	}
+	if calls := atomic.LoadInt32(&validateCalls); calls != 2 {
+		t.Fatalf("expected cache eviction to force auth_gw call, got %d calls", calls)
+	}
+}
+
+// TestAuthRepoIsRevoked verifies jti markers and subject cutoffs written by auth_gw.
+func TestAuthRepoIsRevoked(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	authRepo := NewAuthRepo("http://auth:8084", "1", "123", client, 0)
+
+	issuedAt := time.Now().UTC().Add(-10 * time.Minute).Truncate(time.Second)
+	validation := types.ValidateResponse{
+		APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+		Subject:   "2",
+		TokenType: "user",
+		IssuedAt:  issuedAt.Format(time.RFC3339),
+	}
+
+	revoked, err := authRepo.IsRevoked(context.Background(), validation)
+	if err != nil || revoked {
+		t.Fatalf("expected token not revoked, got revoked=%v err=%v", revoked, err)
+	}
+
+	mr.Set("revoked:sub:user:2", strconv.FormatInt(issuedAt.Add(-time.Minute).Unix(), 10))
+	revoked, err = authRepo.IsRevoked(context.Background(), validation)
+	if err != nil || revoked {
+		t.Fatalf("expected token issued after cutoff to stay valid, got revoked=%v err=%v", revoked, err)
+	}
+
+	mr.Set("revoked:sub:user:2", strconv.FormatInt(time.Now().UTC().Unix(), 10))
+	revoked, err = authRepo.IsRevoked(context.Background(), validation)
+	if err != nil || !revoked {
+		t.Fatalf("expected subject cutoff to revoke token, got revoked=%v err=%v", revoked, err)
+	}
+
+	// millisecond cutoffs tell apart tokens of the same second
+	sameSecond := validation
+	sameSecond.IssuedAt = issuedAt.Add(700 * time.Millisecond).Format(time.RFC3339Nano)
+	mr.Set("revoked:sub:user:2", strconv.FormatInt(issuedAt.Unix(), 10)+".500")
+	revoked, err = authRepo.IsRevoked(context.Background(), sameSecond)
+	if err != nil || revoked {
+		t.Fatalf("expected token issued after the cutoff in the same second to stay valid, got revoked=%v err=%v", revoked, err)
+	}
+	sameSecond.IssuedAt = issuedAt.Add(300 * time.Millisecond).Format(time.RFC3339Nano)
+	revoked, err = authRepo.IsRevoked(context.Background(), sameSecond)
+	if err != nil || !revoked {
+		t.Fatalf("expected token issued before the cutoff in the same second to be revoked, got revoked=%v err=%v", revoked, err)
+	}
+
+	mr.Del("revoked:sub:user:2")
+	mr.Set("revoked:jti:"+validation.APIKey, "1")
+	revoked, err = authRepo.IsRevoked(context.Background(), validation)
+	if err != nil || !revoked {
+		t.Fatalf("expected jti marker to revoke token, got revoked=%v err=%v", revoked, err)
+	}
+}
+
+// serviceTokenStub fakes auth_gw with JWT-shaped service tokens; validate accepts only the latest service token.
+type serviceTokenStub struct {
+	server        *httptest.Server
+	lifetime      time.Duration
+	fail          atomic.Bool
+	tokenCalls    atomic.Int32
+	validateCalls atomic.Int32
+	latest        atomic.Value
+}
+
+// newServiceTokenStub starts a serviceTokenStub issuing tokens that expire after lifetime.
+func newServiceTokenStub(t *testing.T, lifetime time.Duration) *serviceTokenStub {
+	t.Helper()
+	stub := &serviceTokenStub{lifetime: lifetime}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/service-token":
+			if stub.fail.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			n := stub.tokenCalls.Add(1)
+			payload, _ := json.Marshal(map[string]any{"exp": time.Now().Add(stub.lifetime).Unix(), "n": n})
+			token := "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+			stub.latest.Store(token)
+			_ = json.NewEncoder(w).Encode(types.ServiceTokenResponse{Token: token})
+		case "/auth/validate":
+			stub.validateCalls.Add(1)
+			if r.Header.Get("Authorization") != "Bearer "+stub.latest.Load().(string) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(types.ValidateResponse{APIKey: "550e8400-e29b-41d4-a716-446655440000"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// TestAuthRepoServiceTokenRenewal verifies one shared fetch for concurrent callers and renewal before expiry.
+func TestAuthRepoServiceTokenRenewal(t *testing.T) {
+	stub := newServiceTokenStub(t, time.Hour)
+	authRepo := NewAuthRepo(stub.server.URL, "1", "123", nil, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := authRepo.ValidateToken(context.Background(), "client-token"); err != nil {
+				t.Errorf("validate: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls := stub.tokenCalls.Load(); calls != 1 {
+		t.Fatalf("expected concurrent callers to share one service-token call, got %d", calls)
+	}
+
+	state := authRepo.currentServiceToken()
+	if lifetime := state.expiresAt.Sub(state.fetchedAt); lifetime < 59*time.Minute || lifetime > time.Hour+time.Second {
+		t.Fatalf("expected decoded exp about one hour ahead, got %s", lifetime)
+	}
+	if !state.renewAt.Before(state.expiresAt) {
+		t.Fatalf("expected renewal before expiry, got renew_at %s expires_at %s", state.renewAt, state.expiresAt)
+	}
+	if err := authRepo.ServiceTokenReady(context.Background()); err != nil {
+		t.Fatalf("expected ready: %v", err)
+	}
+
+	// pretend the renewal point has passed
+	authRepo.tokenMu.Lock()
+	authRepo.serviceToken.renewAt = time.Now().Add(-time.Second)
+	authRepo.tokenMu.Unlock()
+
+	if _, err := authRepo.ValidateToken(context.Background(), "client-token"); err != nil {
+		t.Fatalf("validate after renewal point: %v", err)
+	}
+	if calls := stub.tokenCalls.Load(); calls != 2 {
+		t.Fatalf("expected renewal once due, got %d service-token calls", calls)
+	}
+}
+
+// TestAuthRepoServiceTokenForcedRefreshOn401 verifies a rejected service token is renewed once and the call retried.
+func TestAuthRepoServiceTokenForcedRefreshOn401(t *testing.T) {
+	stub := newServiceTokenStub(t, time.Hour)
+	authRepo := NewAuthRepo(stub.server.URL, "1", "123", nil, 0)
+
+	if _, err := authRepo.getServiceToken(context.Background()); err != nil {
+		t.Fatalf("get service token: %v", err)
+	}
+	// auth_gw no longer accepts the cached token, e.g. after a revocation
+	stub.latest.Store("rotated-elsewhere")
+
+	// a freshly fetched token is not renewed again on 401
+	if _, err := authRepo.ValidateToken(context.Background(), "client-token"); err == nil {
+		t.Fatalf("expected 401 while the service token is newer than the force interval")
+	}
+	if calls := stub.tokenCalls.Load(); calls != 1 {
+		t.Fatalf("expected no forced renewal for a fresh token, got %d service-token calls", calls)
+	}
+
+	authRepo.tokenMu.Lock()
+	authRepo.serviceToken.fetchedAt = time.Now().Add(-time.Minute)
+	authRepo.tokenMu.Unlock()
+
+	if _, err := authRepo.ValidateToken(context.Background(), "client-token"); err != nil {
+		t.Fatalf("expected validate to succeed after forced renewal: %v", err)
+	}
+	if calls := stub.tokenCalls.Load(); calls != 2 {
+		t.Fatalf("expected one forced renewal, got %d service-token calls", calls)
+	}
+}
+
+// TestAuthRepoServiceTokenRenewalFailureNotReady verifies failed renewals surface in readiness and metrics.
+func TestAuthRepoServiceTokenRenewalFailureNotReady(t *testing.T) {
+	stub := newServiceTokenStub(t, time.Hour)
+	authRepo := NewAuthRepo(stub.server.URL, "1", "123", nil, 0)
+
+	if err := authRepo.ServiceTokenReady(context.Background()); err == nil {
+		t.Fatalf("expected not ready before the first service token")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go authRepo.RenewServiceToken(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for authRepo.ServiceTokenReady(context.Background()) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected background renewal to fetch the first token")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stub.fail.Store(true)
+	authRepo.tokenMu.Lock()
+	authRepo.serviceToken.renewAt = time.Now().Add(-time.Second)
+	authRepo.tokenMu.Unlock()
+
+	// the current token still works while renewals fail
+	token, err := authRepo.getServiceToken(context.Background())
+	if err != nil || token == "" {
+		t.Fatalf("expected current token while it is valid, got %q %v", token, err)
+	}
+	if err = authRepo.ServiceTokenReady(context.Background()); err == nil {
+		t.Fatalf("expected not ready after a failed renewal")
+	}
+	if failures := testutil.ToFloat64(authRepo.metrics.renewals.WithLabelValues("expiring", "failure")); failures < 1 {
+		t.Fatalf("expected renewal failure metric, got %v", failures)
+	}
+}
+
+// TestAuthRepoClaimNonce verifies a signing nonce can be claimed once per key until its claim expires.
+func TestAuthRepoClaimNonce(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	authRepo := NewAuthRepo("http://auth:8084", "1", "123", redis.NewClient(&redis.Options{Addr: mr.Addr()}), 0)
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		fresh, err := authRepo.ClaimNonce(ctx, "gws_0123456789abcdef", "n1", time.Minute)
+		if err != nil {
+			t.Fatalf("claim nonce: %v", err)
+		}
+		if fresh != want {
+			t.Fatalf("claim %d: expected fresh=%v, got %v", i, want, fresh)
+		}
+	}
+
+	fresh, err := authRepo.ClaimNonce(ctx, "gws_fedcba9876543210", "n1", time.Minute)
+	if err != nil || !fresh {
+		t.Fatalf("expected nonce of another key to be independent, got %v, %v", fresh, err)
+	}
+
+	mr.FastForward(time.Minute + time.Second)
+	fresh, err = authRepo.ClaimNonce(ctx, "gws_0123456789abcdef", "n1", time.Minute)
+	if err != nil || !fresh {
+		t.Fatalf("expected expired claim to be reusable, got %v, %v", fresh, err)
+	}
+}
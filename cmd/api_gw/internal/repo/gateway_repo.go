@@ -1,10 +1,14 @@
 package repo
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,21 +23,55 @@ type GatewayRepo interface {
 	BuildRouteEntries(configs []types.EndpointConfig) ([]types.RouteEntry, error)
 	MatchRoute(routes []types.RouteEntry, r *http.Request) (types.RouteEntry, bool)
 	IsAllowedRoute(allowed []string, r *http.Request) bool
-	IsRoleAllowed(allowedRoles []string, role string) bool
+	IsRoleAllowed(allowedRoles []string, roles []string) bool
+	RequiredScopes(requiredScopes map[string][]string, method string) []string
+	HasScopes(required []string, granted string) bool
+	FailedPolicies(rules []types.PolicyRule, r *http.Request, validation types.ValidateResponse, roles []string) []types.PolicyRule
+	CheckUpstreamHealth(ctx context.Context, entry types.RouteEntry, healthPath string, timeout time.Duration) types.UpstreamHealth
 }
 
 // GatewayRepoImpl implements GatewayRepo.
-type GatewayRepoImpl struct{}
+type GatewayRepoImpl struct {
+	healthClient *http.Client
+}
 
 // NewGatewayRepo constructs a GatewayRepo implementation.
 func NewGatewayRepo() *GatewayRepoImpl {
-	return &GatewayRepoImpl{}
+	return &GatewayRepoImpl{
+		healthClient: &http.Client{},
+	}
 }
 
 // BuildRouteEntries compiles endpoint config into route entries.
 func (g *GatewayRepoImpl) BuildRouteEntries(configs []types.EndpointConfig) ([]types.RouteEntry, error) {
 	routes := make([]types.RouteEntry, 0, len(configs))
 	for _, cfg := range configs {
+		switch cfg.RateLimitScope {
+		case "":
+			cfg.RateLimitScope = types.RateLimitScopeToken
+		case types.RateLimitScopeToken, types.RateLimitScopeSubject:
+		default:
+			err := fmt.Errorf("invalid rate_limit_scope %q for %s", cfg.RateLimitScope, cfg.GwEndpoint)
+			zap.L().Error("build route entries", zap.Error(err))
+			return nil, err
+		}
+
+		requiredScopes, err := normalizeRequiredScopes(cfg.RequiredScopes)
+		if err != nil {
+			err = fmt.Errorf("invalid required_scopes for %s: %w", cfg.GwEndpoint, err)
+			zap.L().Error("build route entries", zap.Error(err))
+			return nil, err
+		}
+		cfg.RequiredScopes = requiredScopes
+
+		policies, err := normalizePolicies(cfg.Policies)
+		if err != nil {
+			err = fmt.Errorf("invalid policies for %s: %w", cfg.GwEndpoint, err)
+			zap.L().Error("build route entries", zap.Error(err))
+			return nil, err
+		}
+		cfg.Policies = policies
+
 		proxy, err := newReverseProxy(cfg.LiveEndpoint, cfg.LiveTimeoutSec)
 		if err != nil {
 			zap.L().Error("build reverse proxy", zap.String("live_endpoint", cfg.LiveEndpoint), zap.Error(err))
@@ -84,20 +122,183 @@ func (g *GatewayRepoImpl) IsAllowedRoute(allowed []string, r *http.Request) bool
 	return false
 }
 
-// IsRoleAllowed validates whether role belongs to the configured allowed roles.
-func (g *GatewayRepoImpl) IsRoleAllowed(allowedRoles []string, role string) bool {
+// IsRoleAllowed validates whether any of roles belongs to the configured allowed roles.
+func (g *GatewayRepoImpl) IsRoleAllowed(allowedRoles []string, roles []string) bool {
 	if len(allowedRoles) == 0 {
 		return true
 	}
 
 	for _, allowedRole := range allowedRoles {
-		if allowedRole == role {
+		if slices.Contains(roles, allowedRole) {
 			return true
 		}
 	}
 	return false
 }
 
+// RequiredScopes returns the scopes a method needs; unlisted methods use "*", and nil means no scope is required.
+func (g *GatewayRepoImpl) RequiredScopes(requiredScopes map[string][]string, method string) []string {
+	if scopes, ok := requiredScopes[strings.ToUpper(method)]; ok {
+		return scopes
+	}
+	return requiredScopes["*"]
+}
+
+// HasScopes validates whether the space-delimited granted scopes contain every required scope.
+func (g *GatewayRepoImpl) HasScopes(required []string, granted string) bool {
+	grantedScopes := strings.Fields(granted)
+	for _, scope := range required {
+		if !slices.Contains(grantedScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// FailedPolicies returns the rules matching the request whose path parameter differs from the token claim.
+func (g *GatewayRepoImpl) FailedPolicies(rules []types.PolicyRule, r *http.Request, validation types.ValidateResponse, roles []string) []types.PolicyRule {
+	failed := make([]types.PolicyRule, 0)
+	path := normalizePath(r.URL.Path)
+	for _, rule := range rules {
+		if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, r.Method) {
+			continue
+		}
+		if len(rule.TokenTypes) > 0 && !slices.Contains(rule.TokenTypes, validation.TokenType) {
+			continue
+		}
+		params, ok := matchPathParams(rule.Path, path)
+		if !ok {
+			continue
+		}
+		if slices.ContainsFunc(rule.BypassRoles, func(role string) bool { return slices.Contains(roles, role) }) {
+			continue
+		}
+
+		claim := validation.Subject
+		if rule.Claim == types.PolicyClaimTokenType {
+			claim = validation.TokenType
+		}
+		if claim == "" || params[rule.Param] != claim {
+			failed = append(failed, rule)
+		}
+	}
+	return failed
+}
+
+// CheckUpstreamHealth probes the route live endpoint health path within timeout.
+func (g *GatewayRepoImpl) CheckUpstreamHealth(ctx context.Context, entry types.RouteEntry, healthPath string, timeout time.Duration) types.UpstreamHealth {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	target := strings.TrimRight(entry.Config.LiveEndpoint, "/") + normalizePath(healthPath)
+	startedAt := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		zap.L().Error("build upstream health request", zap.String("target", target), zap.Error(err))
+		return types.UpstreamHealth{Error: err.Error()}
+	}
+
+	res, err := g.healthClient.Do(req)
+	latency := time.Since(startedAt).Milliseconds()
+	if err != nil {
+		zap.L().Warn("upstream health probe failed", zap.String("target", target), zap.Error(err))
+		return types.UpstreamHealth{LatencyMs: latency, Error: err.Error()}
+	}
+	defer res.Body.Close()
+
+	return types.UpstreamHealth{
+		Healthy:    res.StatusCode >= 200 && res.StatusCode < 300,
+		StatusCode: res.StatusCode,
+		LatencyMs:  latency,
+	}
+}
+
+// normalizeRequiredScopes upper-cases method keys, which viper lower-cases, and rejects malformed scopes.
+func normalizeRequiredScopes(requiredScopes map[string][]string) (map[string][]string, error) {
+	if len(requiredScopes) == 0 {
+		return nil, nil
+	}
+
+	normalized := make(map[string][]string, len(requiredScopes))
+	for method, scopes := range requiredScopes {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			return nil, errors.New("empty method key")
+		}
+		for _, scope := range scopes {
+			// RFC 6749 scope tokens exclude spaces, quotes and backslashes
+			if scope == "" || strings.ContainsAny(scope, " \t\"\\") {
+				return nil, fmt.Errorf("invalid scope %q for %s", scope, method)
+			}
+		}
+		normalized[method] = scopes
+	}
+
+	return normalized, nil
+}
+
+// normalizePolicies fills policy defaults, upper-cases methods and rejects rules that can never match.
+func normalizePolicies(rules []types.PolicyRule) ([]types.PolicyRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	normalized := make([]types.PolicyRule, 0, len(rules))
+	for i, rule := range rules {
+		rule.Path = normalizePath(rule.Path)
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("policy_%d", i)
+		}
+		if rule.Param == "" || !slices.Contains(strings.Split(rule.Path, "/"), "{"+rule.Param+"}") {
+			return nil, fmt.Errorf("rule %s: param %q is not captured by path %q", rule.Name, rule.Param, rule.Path)
+		}
+		switch rule.Claim {
+		case "":
+			rule.Claim = types.PolicyClaimSubject
+		case types.PolicyClaimSubject, types.PolicyClaimTokenType:
+		default:
+			return nil, fmt.Errorf("rule %s: unsupported claim %q", rule.Name, rule.Claim)
+		}
+		methods := make([]string, 0, len(rule.Methods))
+		for _, method := range rule.Methods {
+			methods = append(methods, strings.ToUpper(strings.TrimSpace(method)))
+		}
+		rule.Methods = methods
+		normalized = append(normalized, rule)
+	}
+
+	return normalized, nil
+}
+
+// matchPathParams matches path against a pattern with {param} segments and an optional trailing /*.
+func matchPathParams(pattern string, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	pathParts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	wildcard := patternParts[len(patternParts)-1] == "*"
+	if wildcard {
+		patternParts = patternParts[:len(patternParts)-1]
+	}
+	if len(pathParts) < len(patternParts) || (!wildcard && len(pathParts) != len(patternParts)) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return nil, false
+			}
+			params[strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}")] = pathParts[i]
+			continue
+		}
+		if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
 func sanitizeRateKey(pattern string) string {
 	replacer := strings.NewReplacer("/", "-", "{", "", "}", "", "?", "", "*", "")
 	return replacer.Replace(pattern)
@@ -159,6 +360,8 @@ func newReverseProxy(target string, timeoutSec int) (*httputil.ReverseProxy, err
 	director := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		director(req)
+		// long-lived keys are a gateway credential and must not reach upstreams
+		req.Header.Del(types.APIKeyHeader)
 		if req.Header.Get("X-Request-Id") == "" {
 			req.Header.Set("X-Request-Id", "api-gw-"+utils.NewRequestID())
 		}
@@ -1,8 +1,11 @@
 package repo
 
 import (
+	"context"
+	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
 )
@@ -42,13 +45,173 @@ func TestGatewayRepoAllowedRouteWildcard(t *testing.T) {
 // TestGatewayRepoIsRoleAllowed verifies endpoint role authorization behavior.
 func TestGatewayRepoIsRoleAllowed(t *testing.T) {
 	gwRepo := NewGatewayRepo()
-	if !gwRepo.IsRoleAllowed([]string{"user_all", "user_users"}, "user_users") {
+	if !gwRepo.IsRoleAllowed([]string{"user_all", "user_users"}, []string{"user_users"}) {
 		t.Fatalf("expected role to be allowed")
 	}
-	if gwRepo.IsRoleAllowed([]string{"user_users"}, "user_orders") {
+	if gwRepo.IsRoleAllowed([]string{"user_users"}, []string{"user_orders"}) {
 		t.Fatalf("expected role to be denied")
 	}
-	if !gwRepo.IsRoleAllowed(nil, "user_any") {
+	if !gwRepo.IsRoleAllowed([]string{"user_users"}, []string{"admin", "user_all", "user_users"}) {
+		t.Fatalf("expected inherited role to be allowed")
+	}
+	if gwRepo.IsRoleAllowed([]string{"user_users"}, nil) {
+		t.Fatalf("expected empty role set to be denied")
+	}
+	if !gwRepo.IsRoleAllowed(nil, []string{"user_any"}) {
 		t.Fatalf("expected empty role list to allow all roles")
 	}
 }
+
+// TestGatewayRepoCheckUpstreamHealth verifies health probe status mapping.
+func TestGatewayRepoCheckUpstreamHealth(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	gwRepo := NewGatewayRepo()
+	entry := types.RouteEntry{Config: types.EndpointConfig{LiveEndpoint: upstream.URL}}
+
+	healthy := gwRepo.CheckUpstreamHealth(context.Background(), entry, "/healthz", time.Second)
+	if !healthy.Healthy || healthy.StatusCode != http.StatusOK {
+		t.Fatalf("expected healthy upstream, got %#v", healthy)
+	}
+
+	unhealthy := gwRepo.CheckUpstreamHealth(context.Background(), entry, "/readyz", time.Second)
+	if unhealthy.Healthy || unhealthy.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected unhealthy upstream, got %#v", unhealthy)
+	}
+}
+
+// TestGatewayRepoBuildRouteEntriesRateLimitScope verifies scope defaulting and validation.
+func TestGatewayRepoBuildRouteEntriesRateLimitScope(t *testing.T) {
+	gwRepo := NewGatewayRepo()
+	routes, err := gwRepo.BuildRouteEntries([]types.EndpointConfig{
+		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087"},
+		{GwEndpoint: "/api/v1/orders/*", LiveEndpoint: "http://orders:8086", RateLimitScope: types.RateLimitScopeSubject},
+	})
+	if err != nil {
+		t.Fatalf("build route entries: %v", err)
+	}
+	if routes[0].Config.RateLimitScope != types.RateLimitScopeToken {
+		t.Fatalf("expected default token scope, got %q", routes[0].Config.RateLimitScope)
+	}
+	if routes[1].Config.RateLimitScope != types.RateLimitScopeSubject {
+		t.Fatalf("expected subject scope, got %q", routes[1].Config.RateLimitScope)
+	}
+
+	_, err = gwRepo.BuildRouteEntries([]types.EndpointConfig{
+		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087", RateLimitScope: "ip"},
+	})
+	if err == nil {
+		t.Fatalf("expected invalid rate_limit_scope to fail")
+	}
+}
+
+// TestGatewayRepoRequiredScopes verifies method lookup with the "*" fallback and scope checks.
+func TestGatewayRepoRequiredScopes(t *testing.T) {
+	gwRepo := NewGatewayRepo()
+	routes, err := gwRepo.BuildRouteEntries([]types.EndpointConfig{
+		{
+			GwEndpoint:     "/api/v1/orders/*",
+			LiveEndpoint:   "http://orders:8086",
+			RequiredScopes: map[string][]string{"get": {"orders:read"}, "*": {"orders:write"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("build route entries: %v", err)
+	}
+
+	required := routes[0].Config.RequiredScopes
+	if got := gwRepo.RequiredScopes(required, http.MethodGet); len(got) != 1 || got[0] != "orders:read" {
+		t.Fatalf("expected orders:read for GET, got %#v", got)
+	}
+	if got := gwRepo.RequiredScopes(required, http.MethodDelete); len(got) != 1 || got[0] != "orders:write" {
+		t.Fatalf("expected orders:write fallback for DELETE, got %#v", got)
+	}
+	if got := gwRepo.RequiredScopes(nil, http.MethodGet); got != nil {
+		t.Fatalf("expected no scopes without required_scopes, got %#v", got)
+	}
+
+	if !gwRepo.HasScopes([]string{"orders:read"}, "users:read orders:read") {
+		t.Fatalf("expected granted scope to satisfy requirement")
+	}
+	if gwRepo.HasScopes([]string{"orders:read", "orders:write"}, "orders:read") {
+		t.Fatalf("expected missing scope to fail")
+	}
+	if !gwRepo.HasScopes(nil, "") {
+		t.Fatalf("expected no required scopes to pass")
+	}
+
+	_, err = gwRepo.BuildRouteEntries([]types.EndpointConfig{
+		{GwEndpoint: "/api/v1/orders/*", LiveEndpoint: "http://orders:8086", RequiredScopes: map[string][]string{"get": {"orders read"}}},
+	})
+	if err == nil {
+		t.Fatalf("expected scope with a space to be rejected")
+	}
+}
+
+// TestGatewayRepoFailedPolicies verifies path parameters are compared with token claims.
+func TestGatewayRepoFailedPolicies(t *testing.T) {
+	gwRepo := NewGatewayRepo()
+	routes, err := gwRepo.BuildRouteEntries([]types.EndpointConfig{
+		{
+			GwEndpoint:   "/api/v1/users/*",
+			LiveEndpoint: "http://users:8087",
+			Policies: []types.PolicyRule{
+				{Path: "/api/v1/users/{id}/*", Methods: []string{"get"}, TokenTypes: []string{"user"}, Param: "id", BypassRoles: []string{"user_all"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("build route entries: %v", err)
+	}
+	rules := routes[0].Config.Policies
+	if rules[0].Name != "policy_0" || rules[0].Claim != types.PolicyClaimSubject || rules[0].Methods[0] != http.MethodGet {
+		t.Fatalf("expected policy defaults, got %#v", rules[0])
+	}
+
+	owner := types.ValidateResponse{Subject: "2", TokenType: "user"}
+	failed := func(method string, path string, validation types.ValidateResponse, roles []string) int {
+		return len(gwRepo.FailedPolicies(rules, httptest.NewRequest(method, path, nil), validation, roles))
+	}
+
+	if n := failed(http.MethodGet, "/api/v1/users/2/contact", owner, []string{"user_users"}); n != 0 {
+		t.Fatalf("expected own record to pass, got %d failed rules", n)
+	}
+	if n := failed(http.MethodGet, "/api/v1/users/2", owner, []string{"user_users"}); n != 0 {
+		t.Fatalf("expected own record without suffix to pass, got %d failed rules", n)
+	}
+	if n := failed(http.MethodGet, "/api/v1/users/1/contact", owner, []string{"user_users"}); n != 1 {
+		t.Fatalf("expected foreign record to fail, got %d failed rules", n)
+	}
+	if n := failed(http.MethodGet, "/api/v1/users/1/contact", owner, []string{"user_all", "user_users"}); n != 0 {
+		t.Fatalf("expected bypass role to pass, got %d failed rules", n)
+	}
+	if n := failed(http.MethodGet, "/api/v1/users", owner, []string{"user_users"}); n != 0 {
+		t.Fatalf("expected path without parameter to be out of scope, got %d failed rules", n)
+	}
+	if n := failed(http.MethodPost, "/api/v1/users/1/contact", owner, []string{"user_users"}); n != 0 {
+		t.Fatalf("expected unlisted method to be out of scope, got %d failed rules", n)
+	}
+	if n := failed(http.MethodGet, "/api/v1/users/1/contact", types.ValidateResponse{Subject: "2", TokenType: "service"}, nil); n != 0 {
+		t.Fatalf("expected unlisted token type to be out of scope, got %d failed rules", n)
+	}
+
+	_, err = gwRepo.BuildRouteEntries([]types.EndpointConfig{
+		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087", Policies: []types.PolicyRule{{Path: "/api/v1/users/*", Param: "id"}}},
+	})
+	if err == nil {
+		t.Fatalf("expected param missing from path to be rejected")
+	}
+	_, err = gwRepo.BuildRouteEntries([]types.EndpointConfig{
+		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087", Policies: []types.PolicyRule{{Path: "/api/v1/users/{id}", Param: "id", Claim: "email"}}},
+	})
+	if err == nil {
+		t.Fatalf("expected unsupported claim to be rejected")
+	}
+}
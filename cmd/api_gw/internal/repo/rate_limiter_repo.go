@@ -2,9 +2,15 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
+
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
@@ -12,6 +18,8 @@ import (
 // RateLimiterRepo defines redis operations for rate limiting.
 type RateLimiterRepo interface {
 	Increment(ctx context.Context, apiKey string, endpointKey string) (int64, time.Time, error)
+	IncrementSubject(ctx context.Context, tokenType string, subject string, endpointKey string) (int64, time.Time, error)
+	Counters(ctx context.Context, apiKey string) ([]types.RateLimitCounter, error)
 }
 
 // RateLimiterRepoImpl implements RateLimiterRepo using Redis.
@@ -24,11 +32,21 @@ func NewRateLimiterRepo(client *redis.Client) *RateLimiterRepoImpl {
 	return &RateLimiterRepoImpl{client: client}
 }
 
-// Increment increments the rate counter for the current second.
+// Increment increments the per-token rate counter for the current second.
 func (r *RateLimiterRepoImpl) Increment(ctx context.Context, apiKey string, endpointKey string) (int64, time.Time, error) {
+	return r.incrementWindow(ctx, apiKey, endpointKey)
+}
+
+// IncrementSubject increments the counter shared by all tokens of a subject for the current second.
+func (r *RateLimiterRepoImpl) IncrementSubject(ctx context.Context, tokenType string, subject string, endpointKey string) (int64, time.Time, error) {
+	return r.incrementWindow(ctx, subjectRateOwner(tokenType, subject), endpointKey)
+}
+
+// incrementWindow increments rl:{owner}:{endpoint}:{unix_second}.
+func (r *RateLimiterRepoImpl) incrementWindow(ctx context.Context, owner string, endpointKey string) (int64, time.Time, error) {
 	now := time.Now().UTC()
 	window := now.Unix() // unix second, since our rate limit is per second, this works.
-	key := fmt.Sprintf("rl:%s:%s:%d", apiKey, endpointKey, window)
+	key := fmt.Sprintf("rl:%s:%s:%d", owner, endpointKey, window)
 
 	pipe := r.client.Pipeline()
 	incr := pipe.Incr(ctx, key)
@@ -41,3 +59,69 @@ func (r *RateLimiterRepoImpl) Increment(ctx context.Context, apiKey string, endp
 
 	return incr.Val(), time.Unix(window+1, 0).UTC(), nil
 }
+
+// Counters lists live rate-limit window counters for a token across endpoints.
+func (r *RateLimiterRepoImpl) Counters(ctx context.Context, apiKey string) ([]types.RateLimitCounter, error) {
+	prefix := fmt.Sprintf("rl:%s:", apiKey)
+	counters := make([]types.RateLimitCounter, 0)
+
+	iter := r.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		endpointKey, window, ok := parseRateKeySuffix(strings.TrimPrefix(key, prefix))
+		if !ok {
+			zap.L().Warn("skip malformed rate limit key", zap.String("key", key))
+			continue
+		}
+
+		count, err := r.client.Get(ctx, key).Int64()
+		if err != nil {
+			// window expired between scan and read
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			zap.L().Error("rate limiter get counter", zap.String("key", key), zap.Error(err))
+			return nil, err
+		}
+
+		counters = append(counters, types.RateLimitCounter{
+			EndpointKey: endpointKey,
+			Window:      window,
+			Count:       count,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		zap.L().Error("rate limiter scan counters", zap.Error(err))
+		return nil, err
+	}
+
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].EndpointKey == counters[j].EndpointKey {
+			return counters[i].Window > counters[j].Window
+		}
+		return counters[i].EndpointKey < counters[j].EndpointKey
+	})
+
+	return counters, nil
+}
+
+// subjectRateOwner builds the counter owner segment for subject-scoped limits.
+// The sub: prefix cannot collide with UUID api keys.
+func subjectRateOwner(tokenType string, subject string) string {
+	return fmt.Sprintf("sub:%s:%s", tokenType, subject)
+}
+
+// parseRateKeySuffix splits "{endpoint}:{unix_second}" from a rate-limit key.
+func parseRateKeySuffix(suffix string) (string, int64, bool) {
+	idx := strings.LastIndex(suffix, ":")
+	if idx <= 0 || idx == len(suffix)-1 {
+		return "", 0, false
+	}
+
+	window, err := strconv.ParseInt(suffix[idx+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return suffix[:idx], window, true
+}
@@ -35,3 +35,80 @@ func TestRateLimiterIncrement(t *testing.T) {
 		t.Fatalf("expected second count=2, got %d", count2)
 	}
 }
+
+// TestRateLimiterCounters verifies live counters are listed per endpoint for one token only.
+func TestRateLimiterCounters(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	limiter := NewRateLimiterRepo(client)
+
+	for i := 0; i < 3; i++ {
+		if _, _, err = limiter.Increment(context.Background(), "api-key-1", "-api-v1-users-"); err != nil {
+			t.Fatalf("increment users: %v", err)
+		}
+	}
+	if _, _, err = limiter.Increment(context.Background(), "api-key-1", "-api-v1-orders-"); err != nil {
+		t.Fatalf("increment orders: %v", err)
+	}
+	if _, _, err = limiter.Increment(context.Background(), "api-key-2", "-api-v1-users-"); err != nil {
+		t.Fatalf("increment other token: %v", err)
+	}
+
+	counters, err := limiter.Counters(context.Background(), "api-key-1")
+	if err != nil {
+		t.Fatalf("counters: %v", err)
+	}
+	if len(counters) != 2 {
+		t.Fatalf("expected 2 counters, got %#v", counters)
+	}
+	if counters[0].EndpointKey != "-api-v1-orders-" || counters[0].Count != 1 {
+		t.Fatalf("unexpected orders counter: %#v", counters[0])
+	}
+	if counters[1].EndpointKey != "-api-v1-users-" || counters[1].Count != 3 {
+		t.Fatalf("unexpected users counter: %#v", counters[1])
+	}
+}
+
+// TestRateLimiterIncrementSubject verifies subject counters are shared and kept apart from token counters.
+func TestRateLimiterIncrementSubject(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	limiter := NewRateLimiterRepo(client)
+
+	if _, _, err = limiter.IncrementSubject(context.Background(), "user", "2", "users"); err != nil {
+		t.Fatalf("increment subject #1: %v", err)
+	}
+	count, _, err := limiter.IncrementSubject(context.Background(), "user", "2", "users")
+	if err != nil {
+		t.Fatalf("increment subject #2: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected shared subject count=2, got %d", count)
+	}
+
+	count, _, err = limiter.IncrementSubject(context.Background(), "service", "2", "users")
+	if err != nil {
+		t.Fatalf("increment service subject: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected service subject counted separately, got %d", count)
+	}
+
+	counters, err := limiter.Counters(context.Background(), "api-key-1")
+	if err != nil {
+		t.Fatalf("counters: %v", err)
+	}
+	if len(counters) != 0 {
+		t.Fatalf("expected subject counters to stay out of token counters, got %#v", counters)
+	}
+}
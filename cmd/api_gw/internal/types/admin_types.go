@@ -0,0 +1,90 @@
+package types
+
+import "time"
+
+// AdminRouteResponse describes a compiled route and its upstream health.
+type AdminRouteResponse struct {
+	GwEndpoint         string              `json:"gw_endpoint"`
+	LiveEndpoint       string              `json:"live_endpoint"`
+	LiveTimeoutSec     int                 `json:"live_timeout_sec"`
+	RateLimitReqPerSec int                 `json:"rate_limit_req_per_sec"`
+	AllowedRole        []string            `json:"allowed_role"`
+	RequiredScopes     map[string][]string `json:"required_scopes,omitempty"`
+	Policies           []PolicyRule        `json:"policies,omitempty"`
+	DenyImpersonation  bool                `json:"deny_impersonation"`
+	RateKey            string              `json:"rate_key"`
+	Upstream           UpstreamHealth      `json:"upstream"`
+}
+
+// AdminRoutesResponse wraps the compiled route list.
+type AdminRoutesResponse struct {
+	Routes []AdminRouteResponse `json:"routes"`
+}
+
+// UpstreamHealth captures the result of a live endpoint health probe.
+type UpstreamHealth struct {
+	Healthy    bool   `json:"healthy"`
+	StatusCode int    `json:"status_code,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// AdminTokenResponse exposes token metadata stored in Redis.
+type AdminTokenResponse struct {
+	APIKey         string   `json:"api_key"`
+	Owner          string   `json:"owner"`
+	Subject        string   `json:"sub"`
+	TokenType      string   `json:"token_type"`
+	RateLimit      int      `json:"rate_limit"`
+	ExpiresAt      string   `json:"expires_at"`
+	AllowedRoutes  []string `json:"allowed_routes"`
+	ActorSubject   string   `json:"act_sub,omitempty"`
+	ActorTokenType string   `json:"act_token_type,omitempty"`
+	LastSeenAt     string   `json:"last_seen_at,omitempty"`
+}
+
+// AdminTokenPatchRequest captures mutable token metadata fields.
+// Nil fields are left unchanged.
+type AdminTokenPatchRequest struct {
+	RateLimit     *int      `json:"rate_limit"`
+	AllowedRoutes *[]string `json:"allowed_routes"`
+}
+
+// RateLimitCounter represents a live rate-limit window counter for a token.
+type RateLimitCounter struct {
+	EndpointKey string `json:"endpoint_key"`
+	Window      int64  `json:"window"`
+	Count       int64  `json:"count"`
+}
+
+// AdminRateLimitsResponse lists current rate-limit counters for a token.
+type AdminRateLimitsResponse struct {
+	APIKey   string             `json:"api_key"`
+	Counters []RateLimitCounter `json:"counters"`
+}
+
+// AdminPurgeRequest captures cache purge scope; empty api_key purges all tokens.
+type AdminPurgeRequest struct {
+	APIKey string `json:"api_key"`
+}
+
+// AdminPurgeResponse reports how many cache entries were removed.
+type AdminPurgeResponse struct {
+	Purged int64 `json:"purged"`
+}
+
+// AdminAuditEntry represents one admin mutation audit record.
+type AdminAuditEntry struct {
+	At        time.Time         `json:"at"`
+	Actor     string            `json:"actor"`
+	ActorRole string            `json:"actor_role"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"request_id"`
+}
+
+// AdminAuditResponse lists recent audit entries, newest first.
+type AdminAuditResponse struct {
+	Entries []AdminAuditEntry `json:"entries"`
+}
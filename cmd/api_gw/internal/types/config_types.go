@@ -7,37 +7,94 @@ import (
 	cmt "github.com/yirez/go-gw-test/pkg/configuration_manager/types"
 )
 
+// Rate limit scopes for EndpointConfig.RateLimitScope.
+const (
+	RateLimitScopeToken   = "token"   // counters per api_key.
+	RateLimitScopeSubject = "subject" // counters shared by every token of a sub + token_type.
+)
+
+// Token claims a PolicyRule can compare path parameters with.
+const (
+	PolicyClaimSubject   = "sub"
+	PolicyClaimTokenType = "token_type"
+)
+
+// APIKeyHeader carries long-lived API keys as an alternative to bearer tokens.
+const APIKeyHeader = "X-API-Key"
+
+// Identity headers api_gw sets on proxied requests; client-sent values are dropped.
+const (
+	SubjectHeader        = "X-Gw-Subject"
+	TokenTypeHeader      = "X-Gw-Token-Type"
+	ActorSubjectHeader   = "X-Gw-Actor-Subject"    // only on impersonation tokens.
+	ActorTokenTypeHeader = "X-Gw-Actor-Token-Type" // only on impersonation tokens.
+)
+
 // AppConfig wraps api_gw configuration and standard configs.
 type AppConfig struct {
 	StandardConfigs       cmt.StandardConfig
 	EndpointConfiguration []EndpointConfig
+	AdminConfiguration    AdminConfig
 }
 
 // EndpointConfig defines gateway routing rules.
 type EndpointConfig struct {
-	LiveEndpoint       string   `mapstructure:"live_endpoint"`
-	LiveTimeoutSec     int      `mapstructure:"live_timeout_sec"`
-	GwEndpoint         string   `mapstructure:"gw_endpoint"`
-	RateLimitReqPerSec int      `mapstructure:"rate_limit_req_per_sec"`
-	AllowedRole        []string `mapstructure:"allowed_role"`
+	LiveEndpoint       string              `mapstructure:"live_endpoint"`
+	LiveTimeoutSec     int                 `mapstructure:"live_timeout_sec"`
+	GwEndpoint         string              `mapstructure:"gw_endpoint"`
+	RateLimitReqPerSec int                 `mapstructure:"rate_limit_req_per_sec"`
+	RateLimitScope     string              `mapstructure:"rate_limit_scope"` // token (default) or subject.
+	AllowedRole        []string            `mapstructure:"allowed_role"`
+	RequiredScopes     map[string][]string `mapstructure:"required_scopes"` // per HTTP method; "*" covers unlisted methods.
+	Policies           []PolicyRule        `mapstructure:"policies"`
+	DenyImpersonation  bool                `mapstructure:"deny_impersonation"` // refuse tokens carrying an act claim.
+}
+
+// PolicyRule requires a captured path parameter to equal a token claim.
+type PolicyRule struct {
+	Name        string   `mapstructure:"name" json:"name"`
+	Path        string   `mapstructure:"path" json:"path"`                         // {param} captures one segment, a trailing /* any rest.
+	Methods     []string `mapstructure:"methods" json:"methods,omitempty"`         // empty applies to every method.
+	TokenTypes  []string `mapstructure:"token_types" json:"token_types,omitempty"` // empty applies to every token type.
+	Param       string   `mapstructure:"param" json:"param"`
+	Claim       string   `mapstructure:"claim" json:"claim"`                         // sub (default) or token_type.
+	BypassRoles []string `mapstructure:"bypass_roles" json:"bypass_roles,omitempty"` // any effective role skips the rule.
+	DryRun      bool     `mapstructure:"dry_run" json:"dry_run"`                     // log denials without enforcing them.
+}
+
+// AdminConfig defines admin API access and audit settings.
+type AdminConfig struct {
+	AllowedRoles             []string `mapstructure:"allowed_roles"`
+	AuditMaxEntries          int      `mapstructure:"audit_max_entries"`
+	UpstreamHealthPath       string   `mapstructure:"upstream_health_path"`
+	UpstreamHealthTimeoutSec int      `mapstructure:"upstream_health_timeout_sec"`
 }
 
 // TokenMetadata represents token data stored in Redis.
 type TokenMetadata struct {
-	APIKey        string
-	Owner         string
-	RateLimit     int
-	ExpiresAt     time.Time
-	AllowedRoutes []string
+	APIKey         string
+	Owner          string
+	Subject        string
+	TokenType      string
+	RateLimit      int
+	ExpiresAt      time.Time
+	AllowedRoutes  []string
+	ActorSubject   string // act claim of impersonation tokens; empty otherwise.
+	ActorTokenType string
+	LastSeenAt     time.Time // zero until the first proxied request; written only by TouchLastSeen.
 }
 
 // RedisTokenRecord represents token metadata as stored in Redis hash fields.
 type RedisTokenRecord struct {
-	APIKey        string `redis:"api_key"`
-	Owner         string `redis:"owner"`
-	RateLimit     int    `redis:"rate_limit"`
-	ExpiresAt     string `redis:"expires_at"`
-	AllowedRoutes string `redis:"allowed_routes"`
+	APIKey         string `redis:"api_key"`
+	Owner          string `redis:"owner"`
+	Subject        string `redis:"sub"`
+	TokenType      string `redis:"token_type"`
+	RateLimit      int    `redis:"rate_limit"`
+	ExpiresAt      string `redis:"expires_at"`
+	AllowedRoutes  string `redis:"allowed_routes"`
+	ActorSubject   string `redis:"act_sub"`
+	ActorTokenType string `redis:"act_token_type"`
 }
 
 // ServiceTokenRequest captures service-to-service login payload.
@@ -48,19 +105,52 @@ type ServiceTokenRequest struct {
 
 // ServiceTokenResponse captures service token response.
 type ServiceTokenResponse struct {
-	Token string `json:"token"`
+	Token     string `json:"token"`
+	ExpiresIn int64  `json:"expires_in"`
 }
 
-// ValidateRequest captures token validation payload.
+// ValidateRequest captures token validation payload; exactly one of token, api_key or signature is set.
 type ValidateRequest struct {
-	Token string `json:"token"`
+	Token     string               `json:"token,omitempty"`
+	APIKey    string               `json:"api_key,omitempty"` // long-lived key from X-API-Key.
+	Signature *SignatureValidation `json:"signature,omitempty"`
+}
+
+// SignatureValidation asks auth_gw to verify a signed request after api_gw checked its timestamp and nonce.
+type SignatureValidation struct {
+	KeyID        string `json:"key_id"`
+	StringToSign string `json:"string_to_sign"` // canonical request rebuilt from the incoming request.
+	Signature    string `json:"signature"`
 }
 
 // ValidateResponse represents auth_gw validate response payload.
 type ValidateResponse struct {
-	APIKey    string `json:"api_key"` // UUID from JWT jti.
-	Role      string `json:"role"`
-	ExpiresAt string `json:"expires_at"`
+	APIKey    string      `json:"api_key"` // UUID from JWT jti.
+	Subject   string      `json:"sub"`
+	TokenType string      `json:"token_type"` // user or service.
+	Role      string      `json:"role"`
+	Roles     []string    `json:"roles"` // effective roles including inherited ones; empty for older auth_gw versions.
+	Scope     string      `json:"scope"` // space-delimited scopes granted to the token.
+	IssuedAt  string      `json:"issued_at"`
+	ExpiresAt string      `json:"expires_at"`
+	RateLimit int         `json:"rate_limit"`    // per-key limit of API keys; 0 for JWTs.
+	Actor     *ActorClaim `json:"act,omitempty"` // set on impersonation tokens.
+}
+
+// ActorClaim is the RFC 8693 act claim naming who acts on behalf of the token subject.
+type ActorClaim struct {
+	Subject   string `json:"sub"`
+	TokenType string `json:"token_type"`
+}
+
+// RevocationEvent represents auth_gw revocation notifications on Redis pub/sub.
+type RevocationEvent struct {
+	Type          string `json:"type"` // token or subject.
+	JTI           string `json:"jti,omitempty"`
+	Subject       string `json:"subject,omitempty"`
+	TokenType     string `json:"token_type,omitempty"`
+	RevokedBefore int64  `json:"revoked_before,omitempty"`
+	ExpiresAt     int64  `json:"expires_at,omitempty"`
 }
 
 // RouteEntry holds compiled routing data.
@@ -0,0 +1,459 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/repo"
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/utils"
+	"github.com/yirez/go-gw-test/pkg/rest_qol"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	ctxKeyAdminPrincipal contextKey = "admin_principal"
+
+	defaultUpstreamHealthPath    = "/healthz"
+	defaultUpstreamHealthTimeout = 2 * time.Second
+)
+
+// AdminUseCase implements the api_gw admin API for routes, tokens, and limits.
+type AdminUseCase struct {
+	ar     repo.AuthRepo
+	rr     repo.RateLimiterRepo
+	gr     repo.GatewayRepo
+	adr    repo.AdminRepo
+	routes []types.RouteEntry
+	cfg    types.AdminConfig
+}
+
+// NewAdminUseCase constructs an AdminUseCase.
+func NewAdminUseCase(ar repo.AuthRepo, rr repo.RateLimiterRepo, gr repo.GatewayRepo, adr repo.AdminRepo, configs []types.EndpointConfig, cfg types.AdminConfig) (*AdminUseCase, error) {
+	routes, err := gr.BuildRouteEntries(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AdminUseCase{
+		ar:     ar,
+		rr:     rr,
+		gr:     gr,
+		adr:    adr,
+		routes: routes,
+		cfg:    cfg,
+	}, nil
+}
+
+// AdminAuthMiddleware allows only service tokens carrying a configured admin role.
+func (a *AdminUseCase) AdminAuthMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := rest_qol.BearerTokenFromRequest(r)
+			if err != nil {
+				utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+
+			principal, err := a.ar.ValidateToken(r.Context(), token)
+			if err != nil {
+				utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+
+			if principal.TokenType != "service" || !a.isAdminRole(effectiveRoles(principal)) {
+				zap.L().Warn("admin access denied",
+					zap.String("sub", principal.Subject),
+					zap.String("role", principal.Role),
+					zap.Strings("roles", principal.Roles),
+					zap.String("token_type", principal.TokenType),
+					zap.String("path", r.URL.Path),
+					zap.String("request_id", r.Header.Get("X-Request-Id")),
+				)
+				utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ctxKeyAdminPrincipal, principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ListRoutes returns compiled routes with live upstream health.
+// @Summary List routes
+// @Description Lists compiled gateway routes and probes each upstream health endpoint.
+// @Tags api-gw-admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} types.AdminRoutesResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /admin/routes [get]
+func (a *AdminUseCase) ListRoutes(w http.ResponseWriter, r *http.Request) {
+	healthPath := a.cfg.UpstreamHealthPath
+	if healthPath == "" {
+		healthPath = defaultUpstreamHealthPath
+	}
+	timeout := defaultUpstreamHealthTimeout
+	if a.cfg.UpstreamHealthTimeoutSec > 0 {
+		timeout = time.Duration(a.cfg.UpstreamHealthTimeoutSec) * time.Second
+	}
+
+	resp := types.AdminRoutesResponse{Routes: make([]types.AdminRouteResponse, len(a.routes))}
+	var wg sync.WaitGroup
+	for i, entry := range a.routes {
+		resp.Routes[i] = types.AdminRouteResponse{
+			GwEndpoint:         entry.Config.GwEndpoint,
+			LiveEndpoint:       entry.Config.LiveEndpoint,
+			LiveTimeoutSec:     entry.Config.LiveTimeoutSec,
+			RateLimitReqPerSec: entry.Config.RateLimitReqPerSec,
+			AllowedRole:        entry.Config.AllowedRole,
+			RequiredScopes:     entry.Config.RequiredScopes,
+			Policies:           entry.Config.Policies,
+			DenyImpersonation:  entry.Config.DenyImpersonation,
+			RateKey:            entry.RateKey,
+		}
+
+		wg.Add(1)
+		go func(i int, entry types.RouteEntry) {
+			defer wg.Done()
+			resp.Routes[i].Upstream = a.gr.CheckUpstreamHealth(r.Context(), entry, healthPath, timeout)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// GetToken returns token metadata stored in Redis.
+// @Summary Get token metadata
+// @Tags api-gw-admin
+// @Security BearerAuth
+// @Produce json
+// @Param api_key path string true "Token api_key"
+// @Success 200 {object} types.AdminTokenResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/tokens/{api_key} [get]
+func (a *AdminUseCase) GetToken(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := adminAPIKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	metadata, err := a.ar.GetTokenMetaFromRedis(r.Context(), apiKey)
+	if err != nil {
+		writeTokenLookupError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, mapAdminTokenResponse(metadata))
+}
+
+// PatchToken updates rate_limit and/or allowed_routes of stored token metadata.
+// @Summary Patch token metadata
+// @Tags api-gw-admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param api_key path string true "Token api_key"
+// @Param request body types.AdminTokenPatchRequest true "Fields to update"
+// @Success 200 {object} types.AdminTokenResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/tokens/{api_key} [patch]
+func (a *AdminUseCase) PatchToken(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := adminAPIKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req types.AdminTokenPatchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode admin token patch", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.RateLimit == nil && req.AllowedRoutes == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "nothing to update"})
+		return
+	}
+	if req.RateLimit != nil && *req.RateLimit < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "rate_limit must be >= 0"})
+		return
+	}
+	if req.AllowedRoutes != nil && !validAllowedRoutes(*req.AllowedRoutes) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "allowed_routes must be non-empty absolute paths"})
+		return
+	}
+
+	metadata, err := a.ar.GetTokenMetaFromRedis(r.Context(), apiKey)
+	if err != nil {
+		writeTokenLookupError(w, err)
+		return
+	}
+
+	details := make(map[string]string)
+	if req.RateLimit != nil {
+		details["rate_limit"] = strconv.Itoa(metadata.RateLimit) + " -> " + strconv.Itoa(*req.RateLimit)
+		metadata.RateLimit = *req.RateLimit
+	}
+	if req.AllowedRoutes != nil {
+		details["allowed_routes"] = strings.Join(metadata.AllowedRoutes, ",") + " -> " + strings.Join(*req.AllowedRoutes, ",")
+		metadata.AllowedRoutes = *req.AllowedRoutes
+	}
+
+	err = a.ar.SetToken(r.Context(), metadata)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "token update failed"})
+		return
+	}
+
+	a.audit(r, "token.patch", apiKey, details)
+	utils.WriteJSON(w, http.StatusOK, mapAdminTokenResponse(metadata))
+}
+
+// DeleteToken removes token metadata; the next request rebuilds it from role defaults.
+// @Summary Delete token metadata
+// @Tags api-gw-admin
+// @Security BearerAuth
+// @Param api_key path string true "Token api_key"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/tokens/{api_key} [delete]
+func (a *AdminUseCase) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := adminAPIKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := a.ar.DeleteToken(r.Context(), apiKey)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "token delete failed"})
+		return
+	}
+	if !deleted {
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "token not found"})
+		return
+	}
+
+	a.audit(r, "token.delete", apiKey, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTokenRateLimits returns current rate-limit counters for a token.
+// @Summary Token rate-limit counters
+// @Tags api-gw-admin
+// @Security BearerAuth
+// @Produce json
+// @Param api_key path string true "Token api_key"
+// @Success 200 {object} types.AdminRateLimitsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/tokens/{api_key}/rate-limits [get]
+func (a *AdminUseCase) GetTokenRateLimits(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := adminAPIKeyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	counters, err := a.rr.Counters(r.Context(), apiKey)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "rate limit lookup failed"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.AdminRateLimitsResponse{APIKey: apiKey, Counters: counters})
+}
+
+// PurgeCache removes cached token metadata for one api_key or for all tokens.
+// @Summary Purge token cache
+// @Tags api-gw-admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.AdminPurgeRequest false "Purge scope"
+// @Success 200 {object} types.AdminPurgeResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/cache/purge [post]
+func (a *AdminUseCase) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	var req types.AdminPurgeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		zap.L().Error("decode admin purge request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.APIKey != "" {
+		if _, err := uuid.Parse(req.APIKey); err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid api_key"})
+			return
+		}
+
+		deleted, err := a.ar.DeleteToken(r.Context(), req.APIKey)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "cache purge failed"})
+			return
+		}
+
+		var purged int64
+		if deleted {
+			purged = 1
+		}
+		a.audit(r, "cache.purge", req.APIKey, map[string]string{"purged": strconv.FormatInt(purged, 10)})
+		utils.WriteJSON(w, http.StatusOK, types.AdminPurgeResponse{Purged: purged})
+		return
+	}
+
+	purged, err := a.ar.PurgeTokens(r.Context())
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "cache purge failed"})
+		return
+	}
+
+	a.audit(r, "cache.purge", "*", map[string]string{"purged": strconv.FormatInt(purged, 10)})
+	utils.WriteJSON(w, http.StatusOK, types.AdminPurgeResponse{Purged: purged})
+}
+
+// ListAudit returns recent admin audit entries.
+// @Summary Admin audit log
+// @Tags api-gw-admin
+// @Security BearerAuth
+// @Produce json
+// @Param limit query int false "Max entries (default 100)"
+// @Success 200 {object} types.AdminAuditResponse
+// @Failure 500 {object} map[string]string
+// @Router /admin/audit [get]
+func (a *AdminUseCase) ListAudit(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := a.adr.ListAudit(r.Context(), limit)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "audit lookup failed"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.AdminAuditResponse{Entries: entries})
+}
+
+// audit records an admin mutation; failures are logged with the full entry so it is not lost.
+func (a *AdminUseCase) audit(r *http.Request, action string, target string, details map[string]string) {
+	principal, _ := r.Context().Value(ctxKeyAdminPrincipal).(types.ValidateResponse)
+	entry := types.AdminAuditEntry{
+		At:        time.Now().UTC(),
+		Actor:     principal.Subject,
+		ActorRole: principal.Role,
+		Action:    action,
+		Target:    target,
+		Details:   details,
+		RequestID: r.Header.Get("X-Request-Id"),
+	}
+
+	fields := []zap.Field{
+		zap.String("actor", entry.Actor),
+		zap.String("actor_role", entry.ActorRole),
+		zap.String("action", entry.Action),
+		zap.String("target", entry.Target),
+		zap.Any("details", entry.Details),
+		zap.String("request_id", entry.RequestID),
+	}
+
+	err := a.adr.AppendAudit(r.Context(), entry)
+	if err != nil {
+		zap.L().Error("admin audit write failed", append(fields, zap.Error(err))...)
+		return
+	}
+
+	zap.L().Info("admin audit", fields...)
+}
+
+// isAdminRole reports whether any of roles is configured for admin access; an empty list denies all.
+func (a *AdminUseCase) isAdminRole(roles []string) bool {
+	for _, allowed := range a.cfg.AllowedRoles {
+		if slices.Contains(roles, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminAPIKeyFromPath reads and validates the api_key path variable.
+func adminAPIKeyFromPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	apiKey := mux.Vars(r)["api_key"]
+	if _, err := uuid.Parse(apiKey); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid api_key"})
+		return "", false
+	}
+
+	return apiKey, true
+}
+
+// writeTokenLookupError maps token metadata lookup errors to HTTP responses.
+func writeTokenLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, repo.ErrTokenNotFound()) {
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "token not found"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "token lookup failed"})
+}
+
+// validAllowedRoutes checks route patterns are non-empty absolute paths.
+func validAllowedRoutes(routes []string) bool {
+	if len(routes) == 0 {
+		return false
+	}
+
+	for _, route := range routes {
+		if !strings.HasPrefix(route, "/") {
+			return false
+		}
+	}
+	return true
+}
+
+// mapAdminTokenResponse maps token metadata into the admin response shape.
+func mapAdminTokenResponse(metadata types.TokenMetadata) types.AdminTokenResponse {
+	resp := types.AdminTokenResponse{
+		APIKey:         metadata.APIKey,
+		Owner:          metadata.Owner,
+		Subject:        metadata.Subject,
+		TokenType:      metadata.TokenType,
+		RateLimit:      metadata.RateLimit,
+		ExpiresAt:      metadata.ExpiresAt.UTC().Format(time.RFC3339),
+		AllowedRoutes:  metadata.AllowedRoutes,
+		ActorSubject:   metadata.ActorSubject,
+		ActorTokenType: metadata.ActorTokenType,
+	}
+	if !metadata.LastSeenAt.IsZero() {
+		resp.LastSeenAt = metadata.LastSeenAt.UTC().Format(time.RFC3339)
+	}
+	return resp
+}
@@ -0,0 +1,164 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/repo"
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeRateLimiterRepo struct {
+	counters []types.RateLimitCounter
+}
+
+func (f *fakeRateLimiterRepo) Increment(ctx context.Context, apiKey string, endpointKey string) (int64, time.Time, error) {
+	return 1, time.Now().UTC(), nil
+}
+
+func (f *fakeRateLimiterRepo) IncrementSubject(ctx context.Context, tokenType string, subject string, endpointKey string) (int64, time.Time, error) {
+	return 1, time.Now().UTC(), nil
+}
+
+func (f *fakeRateLimiterRepo) Counters(ctx context.Context, apiKey string) ([]types.RateLimitCounter, error) {
+	return f.counters, nil
+}
+
+type fakeAdminRepo struct {
+	entries []types.AdminAuditEntry
+}
+
+func (f *fakeAdminRepo) AppendAudit(ctx context.Context, entry types.AdminAuditEntry) error {
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+func (f *fakeAdminRepo) ListAudit(ctx context.Context, limit int) ([]types.AdminAuditEntry, error) {
+	return f.entries, nil
+}
+
+// newTestAdminRouter wires admin handlers behind the admin middleware like router.go does.
+func newTestAdminRouter(t *testing.T, authRepo *fakeAuthRepo, adminRepo *fakeAdminRepo) http.Handler {
+	t.Helper()
+	useCase, err := NewAdminUseCase(authRepo, &fakeRateLimiterRepo{}, repo.NewGatewayRepo(), adminRepo, []types.EndpointConfig{
+		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087"},
+	}, types.AdminConfig{AllowedRoles: []string{"gw_admin"}})
+	if err != nil {
+		t.Fatalf("new admin usecase: %v", err)
+	}
+
+	router := mux.NewRouter()
+	adminRouter := router.PathPrefix("/admin/").Subrouter()
+	adminRouter.Use(useCase.AdminAuthMiddleware())
+	adminRouter.HandleFunc("/tokens/{api_key}", useCase.PatchToken).Methods(http.MethodPatch)
+	adminRouter.HandleFunc("/tokens/{api_key}", useCase.DeleteToken).Methods(http.MethodDelete)
+	return router
+}
+
+// TestAdminAuthMiddlewareRejectsUserTokens verifies admin access requires a service token.
+func TestAdminAuthMiddlewareRejectsUserTokens(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{APIKey: "550e8400-e29b-41d4-a716-446655440000", Subject: "1", TokenType: "user", Role: "gw_admin"},
+	}
+	router := newTestAdminRouter(t, authRepo, &fakeAdminRepo{})
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/tokens/550e8400-e29b-41d4-a716-446655440000", nil)
+	req.Header.Set("Authorization", "Bearer user-token")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %d", rr.Code)
+	}
+}
+
+// TestAdminAuthMiddlewareRejectsNonAdminService verifies service tokens without admin role are denied.
+func TestAdminAuthMiddlewareRejectsNonAdminService(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{Subject: "2", TokenType: "service", Role: "users_gw"},
+	}
+	router := newTestAdminRouter(t, authRepo, &fakeAdminRepo{})
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/tokens/550e8400-e29b-41d4-a716-446655440000", nil)
+	req.Header.Set("Authorization", "Bearer service-token")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %d", rr.Code)
+	}
+}
+
+// TestAdminPatchTokenWritesAudit verifies metadata patch persists and is audited with the actor.
+func TestAdminPatchTokenWritesAudit(t *testing.T) {
+	expiresAt := time.Now().UTC().Add(30 * time.Minute).Truncate(time.Second)
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{Subject: "4", TokenType: "service", Role: "gw_admin"},
+		metaResp: types.TokenMetadata{
+			APIKey:        "550e8400-e29b-41d4-a716-446655440000",
+			Owner:         "user_users",
+			RateLimit:     5,
+			ExpiresAt:     expiresAt,
+			AllowedRoutes: []string{"/api/v1/users/*"},
+		},
+	}
+	adminRepo := &fakeAdminRepo{}
+	router := newTestAdminRouter(t, authRepo, adminRepo)
+
+	req := httptest.NewRequest(http.MethodPatch, "/admin/tokens/550e8400-e29b-41d4-a716-446655440000", strings.NewReader(`{"rate_limit":1}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var resp types.AdminTokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.RateLimit != 1 || authRepo.metaResp.RateLimit != 1 {
+		t.Fatalf("expected rate limit 1, got response=%d stored=%d", resp.RateLimit, authRepo.metaResp.RateLimit)
+	}
+	if len(resp.AllowedRoutes) != 1 || resp.AllowedRoutes[0] != "/api/v1/users/*" {
+		t.Fatalf("expected allowed routes unchanged, got %#v", resp.AllowedRoutes)
+	}
+
+	if len(adminRepo.entries) != 1 {
+		t.Fatalf("expected one audit entry, got %d", len(adminRepo.entries))
+	}
+	entry := adminRepo.entries[0]
+	if entry.Action != "token.patch" || entry.Actor != "4" || entry.Target != "550e8400-e29b-41d4-a716-446655440000" {
+		t.Fatalf("unexpected audit entry: %#v", entry)
+	}
+}
+
+// TestAdminDeleteTokenNotFound verifies deleting missing metadata returns 404 without an audit entry.
+func TestAdminDeleteTokenNotFound(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{Subject: "4", TokenType: "service", Role: "gw_admin"},
+		deleted:      false,
+	}
+	adminRepo := &fakeAdminRepo{}
+	router := newTestAdminRouter(t, authRepo, adminRepo)
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/tokens/550e8400-e29b-41d4-a716-446655440000", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rr.Code)
+	}
+	if len(adminRepo.entries) != 0 {
+		t.Fatalf("expected no audit entries, got %d", len(adminRepo.entries))
+	}
+}
@@ -3,10 +3,12 @@ package usecase
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/repo"
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/utils"
 	"github.com/yirez/go-gw-test/pkg/rest_qol"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,29 +19,47 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// defaultSignatureMaxSkew applies when auth.signature_max_skew_sec is unset.
+	defaultSignatureMaxSkew = 5 * time.Minute
+	// maxSignedBodyBytes bounds the body api_gw buffers to hash a signed request.
+	maxSignedBodyBytes = 10 << 20
+	// lastSeenResolution is how stale a token's last_seen_at may get before it is rewritten.
+	lastSeenResolution = time.Minute
+)
+
 // AuthUseCaseImpl calls auth_gw for token validation.
 type AuthUseCaseImpl struct {
 	ar     repo.AuthRepo
 	gr     repo.GatewayRepo
 	routes []types.RouteEntry
+
+	signatureMaxSkew time.Duration
 }
 
 type AuthUseCase interface {
 	ValidateToken(ctx context.Context, token string) (types.ValidateResponse, error)
+	ValidateAPIKey(ctx context.Context, key string) (types.ValidateResponse, error)
 	TokenValidationMiddleware() mux.MiddlewareFunc
 }
 
 // NewAuthUseCase constructs an AuthUseCaseImpl.
-func NewAuthUseCase(ar repo.AuthRepo, gr repo.GatewayRepo, endpointConfigs []types.EndpointConfig) (AuthUseCase, error) {
+// signatureMaxSkew bounds how far signed request timestamps may drift; zero uses defaultSignatureMaxSkew.
+func NewAuthUseCase(ar repo.AuthRepo, gr repo.GatewayRepo, endpointConfigs []types.EndpointConfig, signatureMaxSkew time.Duration) (AuthUseCase, error) {
 	routes, err := gr.BuildRouteEntries(endpointConfigs)
 	if err != nil {
 		return nil, err
 	}
 
+	if signatureMaxSkew <= 0 {
+		signatureMaxSkew = defaultSignatureMaxSkew
+	}
+
 	return &AuthUseCaseImpl{
-		ar:     ar,
-		gr:     gr,
-		routes: routes,
+		ar:               ar,
+		gr:               gr,
+		routes:           routes,
+		signatureMaxSkew: signatureMaxSkew,
 	}, nil
 }
 
@@ -48,7 +68,12 @@ func (u *AuthUseCaseImpl) ValidateToken(ctx context.Context, token string) (type
 	return u.ar.ValidateToken(ctx, token)
 }
 
-// TokenValidationMiddleware validates incoming bearer tokens for proxy routes.
+// ValidateAPIKey validates an API key via auth_gw and returns the same metadata as for tokens.
+func (u *AuthUseCaseImpl) ValidateAPIKey(ctx context.Context, key string) (types.ValidateResponse, error) {
+	return u.ar.ValidateAPIKey(ctx, key)
+}
+
+// TokenValidationMiddleware validates incoming bearer tokens, API keys or request signatures for proxy routes.
 func (u *AuthUseCaseImpl) TokenValidationMiddleware() mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -57,13 +82,12 @@ func (u *AuthUseCaseImpl) TokenValidationMiddleware() mux.MiddlewareFunc {
 				return
 			}
 
-			clientToken, err := rest_qol.BearerTokenFromRequest(r)
-			if err != nil {
-				utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			validateResp, err := u.validateCredential(r)
+			if errors.Is(err, rest_qol.ErrCSRFTokenInvalid) {
+				zap.L().Warn("session cookie without valid csrf token", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.String("request_id", r.Header.Get("X-Request-Id")))
+				utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "invalid csrf token"})
 				return
 			}
-
-			validateResp, err := u.ValidateToken(r.Context(), clientToken)
 			if err != nil {
 				utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 				return
@@ -83,12 +107,28 @@ func (u *AuthUseCaseImpl) TokenValidationMiddleware() mux.MiddlewareFunc {
 				return
 			}
 
+			// validation may come from the local cache, so revocation markers are always checked
+			revoked, err := u.ar.IsRevoked(r.Context(), validateResp)
+			if err != nil {
+				utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation check failed"})
+				return
+			}
+			if revoked {
+				zap.L().Warn("revoked token rejected",
+					zap.String("api_key", apiKey),
+					zap.String("sub", validateResp.Subject),
+					zap.String("request_id", r.Header.Get("X-Request-Id")),
+				)
+				utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+
 			// token valid at this point
 			metadata, err := u.ar.GetTokenMetaFromRedis(r.Context(), apiKey)
 			if err != nil {
 				// no key for newly minted token, prep one with roles and allowed routes
 				if errors.Is(err, repo.ErrTokenNotFound()) {
-					metadata, err = u.buildDefaultTokenMetadata(apiKey, validateResp.Role, expiresAt)
+					metadata, err = u.buildDefaultTokenMetadata(validateResp, expiresAt)
 					if err != nil {
 						utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
 						return
@@ -105,11 +145,27 @@ func (u *AuthUseCaseImpl) TokenValidationMiddleware() mux.MiddlewareFunc {
 				}
 			}
 
-			// Keep metadata owner aligned with auth role for easier token ownership debugging in Redis.
-			if validateResp.Role != "" && metadata.Owner != validateResp.Role {
-				metadata.Owner = validateResp.Role
+			// Keep metadata owner aligned with auth role for easier token ownership debugging in Redis,
+			// and subject and actor fields current for subject-scoped rate limits and identity headers.
+			ownerStale := validateResp.Role != "" && metadata.Owner != validateResp.Role
+			subjectStale := validateResp.Subject != "" && (metadata.Subject != validateResp.Subject || metadata.TokenType != validateResp.TokenType)
+			actorSubject, actorTokenType := "", ""
+			if validateResp.Actor != nil {
+				actorSubject, actorTokenType = validateResp.Actor.Subject, validateResp.Actor.TokenType
+			}
+			actorStale := metadata.ActorSubject != actorSubject || metadata.ActorTokenType != actorTokenType
+			if ownerStale || subjectStale || actorStale {
+				metadata.ActorSubject = actorSubject
+				metadata.ActorTokenType = actorTokenType
+				if validateResp.Role != "" {
+					metadata.Owner = validateResp.Role
+				}
+				if validateResp.Subject != "" {
+					metadata.Subject = validateResp.Subject
+					metadata.TokenType = validateResp.TokenType
+				}
 				if err = u.ar.SetToken(r.Context(), metadata); err != nil {
-					zap.L().Warn("failed to update token owner metadata",
+					zap.L().Warn("failed to update token owner/subject/actor metadata",
 						zap.String("api_key", apiKey),
 						zap.String("owner", validateResp.Role),
 						zap.Error(err),
@@ -129,6 +185,13 @@ func (u *AuthUseCaseImpl) TokenValidationMiddleware() mux.MiddlewareFunc {
 				return
 			}
 
+			// last seen feeds auth_gw session listings; minute resolution keeps it off the hot path
+			if now.Sub(metadata.LastSeenAt) >= lastSeenResolution {
+				if err = u.ar.TouchLastSeen(r.Context(), apiKey, now); err != nil {
+					zap.L().Warn("failed to update token last_seen_at", zap.String("api_key", apiKey), zap.Error(err))
+				}
+			}
+
 			if !u.gr.IsAllowedRoute(metadata.AllowedRoutes, r) {
 				utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
 				return
@@ -140,7 +203,49 @@ func (u *AuthUseCaseImpl) TokenValidationMiddleware() mux.MiddlewareFunc {
 				return
 			}
 
-			if !u.gr.IsRoleAllowed(entry.Config.AllowedRole, validateResp.Role) {
+			if validateResp.Actor != nil {
+				if entry.Config.DenyImpersonation {
+					zap.L().Warn("impersonation token refused by route",
+						zap.String("api_key", apiKey),
+						zap.String("sub", validateResp.Subject),
+						zap.String("act_sub", validateResp.Actor.Subject),
+						zap.String("route", entry.Config.GwEndpoint),
+						zap.String("request_id", r.Header.Get("X-Request-Id")),
+					)
+					utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "impersonation not allowed"})
+					return
+				}
+				zap.L().Info("impersonated request",
+					zap.String("sub", validateResp.Subject),
+					zap.String("act_sub", validateResp.Actor.Subject),
+					zap.String("act_token_type", validateResp.Actor.TokenType),
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.String("request_id", r.Header.Get("X-Request-Id")),
+				)
+			}
+
+			if !u.gr.IsRoleAllowed(entry.Config.AllowedRole, effectiveRoles(validateResp)) {
+				utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+				return
+			}
+
+			requiredScopes := u.gr.RequiredScopes(entry.Config.RequiredScopes, r.Method)
+			if !u.gr.HasScopes(requiredScopes, validateResp.Scope) {
+				scope := strings.Join(requiredScopes, " ")
+				zap.L().Warn("insufficient scope",
+					zap.String("api_key", apiKey),
+					zap.String("required", scope),
+					zap.String("granted", validateResp.Scope),
+					zap.String("request_id", r.Header.Get("X-Request-Id")),
+				)
+				// RFC 6750 section 3.1 challenge naming the scopes the request needs
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+				utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "insufficient_scope", "scope": scope})
+				return
+			}
+
+			if !u.enforcePolicies(r, entry.Config.Policies, validateResp) {
 				utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
 				return
 			}
@@ -152,13 +257,16 @@ func (u *AuthUseCaseImpl) TokenValidationMiddleware() mux.MiddlewareFunc {
 }
 
 // buildDefaultTokenMetadata constructs fallback Redis token metadata from role permissions.
-func (u *AuthUseCaseImpl) buildDefaultTokenMetadata(apiKey string, role string, expiresAt time.Time) (types.TokenMetadata, error) {
+func (u *AuthUseCaseImpl) buildDefaultTokenMetadata(validation types.ValidateResponse, expiresAt time.Time) (types.TokenMetadata, error) {
+	apiKey := validation.APIKey
+	role := validation.Role
+	roles := effectiveRoles(validation)
 	allowedRoutes := make([]string, 0)
 	maxRateLimit := 0
 	seenRoutes := make(map[string]struct{})
 
 	for _, route := range u.routes {
-		if !u.gr.IsRoleAllowed(route.Config.AllowedRole, role) {
+		if !u.gr.IsRoleAllowed(route.Config.AllowedRole, roles) {
 			continue
 		}
 
@@ -172,15 +280,139 @@ func (u *AuthUseCaseImpl) buildDefaultTokenMetadata(apiKey string, role string,
 	}
 
 	if len(allowedRoutes) == 0 {
-		zap.L().Warn("no allowed routes for role", zap.String("role", role), zap.String("api_key", apiKey))
+		zap.L().Warn("no allowed routes for role", zap.String("role", role), zap.Strings("roles", roles), zap.String("api_key", apiKey))
 		return types.TokenMetadata{}, errors.New("no allowed routes for role")
 	}
 
-	return types.TokenMetadata{
+	// API keys carry their own limit; Proxy still applies the lower of it and the route limit
+	rateLimit := maxRateLimit
+	if validation.RateLimit > 0 {
+		rateLimit = validation.RateLimit
+	}
+
+	metadata := types.TokenMetadata{
 		APIKey:        apiKey,
 		Owner:         role,
-		RateLimit:     maxRateLimit,
+		Subject:       validation.Subject,
+		TokenType:     validation.TokenType,
+		RateLimit:     rateLimit,
 		ExpiresAt:     expiresAt.UTC(),
 		AllowedRoutes: allowedRoutes,
-	}, nil
+	}
+	if validation.Actor != nil {
+		metadata.ActorSubject = validation.Actor.Subject
+		metadata.ActorTokenType = validation.Actor.TokenType
+	}
+	return metadata, nil
+}
+
+// validateCredential validates the X-API-Key header when present, else a request signature or the bearer token,
+// which may come from the session cookie; sending an API key together with an Authorization header is refused.
+func (u *AuthUseCaseImpl) validateCredential(r *http.Request) (types.ValidateResponse, error) {
+	apiKey := strings.TrimSpace(r.Header.Get(types.APIKeyHeader))
+	if apiKey == "" {
+		if rest_qol.IsSignedRequest(r) {
+			return u.validateSignedRequest(r)
+		}
+
+		token, err := rest_qol.BearerTokenFromRequest(r)
+		if err != nil {
+			return types.ValidateResponse{}, err
+		}
+		return u.ValidateToken(r.Context(), token)
+	}
+
+	if r.Header.Get("Authorization") != "" {
+		zap.L().Warn("both bearer token and api key sent", zap.String("request_id", r.Header.Get("X-Request-Id")))
+		return types.ValidateResponse{}, errors.New("ambiguous credentials")
+	}
+	return u.ValidateAPIKey(r.Context(), apiKey)
+}
+
+// validateSignedRequest checks the timestamp of a signed request, has auth_gw verify the signature
+// and then claims the nonce so the same request cannot be replayed within the skew window.
+func (u *AuthUseCaseImpl) validateSignedRequest(r *http.Request) (types.ValidateResponse, error) {
+	keyID, signature, err := rest_qol.ParseSignatureAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return types.ValidateResponse{}, err
+	}
+
+	timestamp := r.Header.Get(rest_qol.SignatureTimestampHeader)
+	nonce := r.Header.Get(rest_qol.SignatureNonceHeader)
+	if nonce == "" || len(nonce) > 128 {
+		return types.ValidateResponse{}, errors.New("invalid signature nonce")
+	}
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return types.ValidateResponse{}, errors.New("invalid signature timestamp")
+	}
+	if skew := time.Since(time.Unix(signedAt, 0)); skew > u.signatureMaxSkew || skew < -u.signatureMaxSkew {
+		zap.L().Warn("signed request outside clock skew window",
+			zap.String("key_id", keyID),
+			zap.Duration("skew", skew),
+			zap.String("request_id", r.Header.Get("X-Request-Id")),
+		)
+		return types.ValidateResponse{}, errors.New("signature timestamp outside skew window")
+	}
+
+	bodyHash, err := rest_qol.RequestBodyHash(r, maxSignedBodyBytes)
+	if err != nil {
+		return types.ValidateResponse{}, err
+	}
+
+	resp, err := u.ar.VerifySignature(r.Context(), types.SignatureValidation{
+		KeyID:        keyID,
+		StringToSign: rest_qol.CanonicalRequest(r.Method, r.URL.EscapedPath(), r.URL.RawQuery, timestamp, nonce, bodyHash),
+		Signature:    signature,
+	})
+	if err != nil {
+		return types.ValidateResponse{}, err
+	}
+
+	// claimed only after verification so unsigned requests cannot burn nonces of a key;
+	// a timestamp is accepted for 2x skew at most, after which the claim may expire
+	fresh, err := u.ar.ClaimNonce(r.Context(), keyID, nonce, 2*u.signatureMaxSkew)
+	if err != nil {
+		return types.ValidateResponse{}, err
+	}
+	if !fresh {
+		zap.L().Warn("replayed signed request rejected", zap.String("key_id", keyID), zap.String("request_id", r.Header.Get("X-Request-Id")))
+		return types.ValidateResponse{}, errors.New("signature nonce already used")
+	}
+
+	return resp, nil
+}
+
+// enforcePolicies logs every failed policy rule and reports whether the request may continue; dry-run rules never block.
+func (u *AuthUseCaseImpl) enforcePolicies(r *http.Request, rules []types.PolicyRule, validation types.ValidateResponse) bool {
+	allowed := true
+	for _, rule := range u.gr.FailedPolicies(rules, r, validation, effectiveRoles(validation)) {
+		zap.L().Warn("policy denied",
+			zap.String("rule", rule.Name),
+			zap.String("param", rule.Param),
+			zap.String("claim", rule.Claim),
+			zap.Bool("dry_run", rule.DryRun),
+			zap.String("api_key", validation.APIKey),
+			zap.String("sub", validation.Subject),
+			zap.String("token_type", validation.TokenType),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("request_id", r.Header.Get("X-Request-Id")),
+		)
+		if !rule.DryRun {
+			allowed = false
+		}
+	}
+	return allowed
+}
+
+// effectiveRoles returns the roles claim of a validated token, falling back to its single role.
+func effectiveRoles(validation types.ValidateResponse) []string {
+	if len(validation.Roles) > 0 {
+		return validation.Roles
+	}
+	if validation.Role == "" {
+		return nil
+	}
+	return []string{validation.Role}
 }
@@ -3,13 +3,18 @@ package usecase
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/repo"
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
+	"github.com/yirez/go-gw-test/pkg/rest_qol"
 )
 
 type fakeAuthRepo struct {
@@ -20,12 +25,49 @@ type fakeAuthRepo struct {
 	setErr       error
 	touchErr     error
 	setCalled    bool
+	deleted      bool
+	deleteErr    error
+	purged       int64
+	purgeErr     error
+	revoked      bool
+	revokedErr   error
+	validatedKey string
+	validatedJWT string
+	lastSeen     time.Time
+
+	signingSecret []byte // when set VerifySignature checks signatures with it.
+	nonces        map[string]bool
 }
 
 func (f *fakeAuthRepo) ValidateToken(ctx context.Context, token string) (types.ValidateResponse, error) {
+	f.validatedJWT = token
+	return f.validateResp, f.validateErr
+}
+
+func (f *fakeAuthRepo) ValidateAPIKey(ctx context.Context, key string) (types.ValidateResponse, error) {
+	f.validatedKey = key
+	return f.validateResp, f.validateErr
+}
+
+func (f *fakeAuthRepo) VerifySignature(ctx context.Context, sig types.SignatureValidation) (types.ValidateResponse, error) {
+	if rest_qol.ComputeSignature(f.signingSecret, sig.StringToSign) != sig.Signature {
+		return types.ValidateResponse{}, errors.New("unauthorized")
+	}
 	return f.validateResp, f.validateErr
 }
 
+func (f *fakeAuthRepo) ClaimNonce(ctx context.Context, keyID string, nonce string, ttl time.Duration) (bool, error) {
+	if f.nonces == nil {
+		f.nonces = make(map[string]bool)
+	}
+	claimKey := keyID + ":" + nonce
+	if f.nonces[claimKey] {
+		return false, nil
+	}
+	f.nonces[claimKey] = true
+	return true, nil
+}
+
 func (f *fakeAuthRepo) GetTokenMetaFromRedis(ctx context.Context, apiKey string) (types.TokenMetadata, error) {
 	return f.metaResp, f.metaErr
 }
@@ -40,13 +82,30 @@ func (f *fakeAuthRepo) TouchExpiry(ctx context.Context, apiKey string, expiresAt
 	return f.touchErr
 }
 
+func (f *fakeAuthRepo) DeleteToken(ctx context.Context, apiKey string) (bool, error) {
+	return f.deleted, f.deleteErr
+}
+
+func (f *fakeAuthRepo) PurgeTokens(ctx context.Context) (int64, error) {
+	return f.purged, f.purgeErr
+}
+
+func (f *fakeAuthRepo) TouchLastSeen(ctx context.Context, apiKey string, seenAt time.Time) error {
+	f.lastSeen = seenAt
+	return nil
+}
+
+func (f *fakeAuthRepo) IsRevoked(ctx context.Context, validation types.ValidateResponse) (bool, error) {
+	return f.revoked, f.revokedErr
+}
+
 // TestTokenValidationMiddlewareUnauthorizedWithoutHeader verifies missing bearer token handling.
 func TestTokenValidationMiddlewareUnauthorizedWithoutHeader(t *testing.T) {
 	authRepo := &fakeAuthRepo{}
 	gatewayRepo := repo.NewGatewayRepo()
 	useCase, err := NewAuthUseCase(authRepo, gatewayRepo, []types.EndpointConfig{
 		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087", AllowedRole: []string{"user_users"}},
-	})
+	}, 0)
 	if err != nil {
 		t.Fatalf("new auth usecase: %v", err)
 	}
@@ -84,7 +143,7 @@ func TestTokenValidationMiddlewareInitializesMissingRedisMetadata(t *testing.T)
 			AllowedRole:        []string{"user_all", "user_users"},
 			RateLimitReqPerSec: 5,
 		},
-	})
+	}, 0)
 	if err != nil {
 		t.Fatalf("new auth usecase: %v", err)
 	}
@@ -147,7 +206,7 @@ func TestTokenValidationMiddlewareForbiddenRole(t *testing.T) {
 			AllowedRole:        []string{"user_users"},
 			RateLimitReqPerSec: 5,
 		},
-	})
+	}, 0)
 	if err != nil {
 		t.Fatalf("new auth usecase: %v", err)
 	}
@@ -181,7 +240,7 @@ func TestTokenValidationMiddlewarePropagatesRepoErrors(t *testing.T) {
 	gatewayRepo := repo.NewGatewayRepo()
 	useCase, err := NewAuthUseCase(authRepo, gatewayRepo, []types.EndpointConfig{
 		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087", AllowedRole: []string{"user_users"}},
-	})
+	}, 0)
 	if err != nil {
 		t.Fatalf("new auth usecase: %v", err)
 	}
@@ -221,7 +280,7 @@ func TestTokenValidationMiddlewareBackfillsOwner(t *testing.T) {
 	gatewayRepo := repo.NewGatewayRepo()
 	useCase, err := NewAuthUseCase(authRepo, gatewayRepo, []types.EndpointConfig{
 		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087", AllowedRole: []string{"user_users"}},
-	})
+	}, 0)
 	if err != nil {
 		t.Fatalf("new auth usecase: %v", err)
 	}
@@ -252,3 +311,450 @@ func TestTokenValidationMiddlewareBackfillsOwner(t *testing.T) {
 		t.Fatalf("owner mismatch after backfill: got %s want %s", authRepo.metaResp.Owner, "user_users")
 	}
 }
+
+// TestTokenValidationMiddlewareRejectsRevokedToken verifies revoked api_keys are refused even with valid validation.
+func TestTokenValidationMiddlewareRejectsRevokedToken(t *testing.T) {
+	expiresAt := time.Now().UTC().Add(30 * time.Minute).Truncate(time.Second)
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{
+			APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+			Subject:   "2",
+			TokenType: "user",
+			Role:      "user_users",
+			ExpiresAt: expiresAt.Format(time.RFC3339),
+		},
+		metaResp: types.TokenMetadata{
+			APIKey:        "550e8400-e29b-41d4-a716-446655440000",
+			Owner:         "user_users",
+			ExpiresAt:     expiresAt,
+			AllowedRoutes: []string{"/api/v1/users/*"},
+		},
+		revoked: true,
+	}
+
+	useCase, err := NewAuthUseCase(authRepo, repo.NewGatewayRepo(), []types.EndpointConfig{
+		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087", AllowedRole: []string{"user_users"}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("new auth usecase: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil)
+	req.Header.Set("Authorization", "Bearer revoked-token")
+	rr := httptest.NewRecorder()
+
+	handler := useCase.TokenValidationMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+	if authRepo.setCalled {
+		t.Fatalf("expected revoked token not to touch metadata")
+	}
+}
+
+// TestTokenValidationMiddlewareRequiredScopes verifies per-method scopes and the insufficient_scope challenge.
+func TestTokenValidationMiddlewareRequiredScopes(t *testing.T) {
+	expiresAt := time.Now().UTC().Add(30 * time.Minute).Truncate(time.Second)
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{
+			APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+			Role:      "user_orders",
+			Scope:     "orders:read",
+			ExpiresAt: expiresAt.Format(time.RFC3339),
+		},
+		metaResp: types.TokenMetadata{
+			APIKey:        "550e8400-e29b-41d4-a716-446655440000",
+			Owner:         "user_orders",
+			RateLimit:     5,
+			ExpiresAt:     expiresAt,
+			AllowedRoutes: []string{"/api/v1/orders/*"},
+		},
+	}
+
+	useCase, err := NewAuthUseCase(authRepo, repo.NewGatewayRepo(), []types.EndpointConfig{
+		{
+			GwEndpoint:   "/api/v1/orders/*",
+			LiveEndpoint: "http://orders:8086",
+			// viper delivers lower-cased method keys
+			RequiredScopes: map[string][]string{"get": {"orders:read"}, "*": {"orders:write"}},
+		},
+	}, 0)
+	if err != nil {
+		t.Fatalf("new auth usecase: %v", err)
+	}
+
+	handler := useCase.TokenValidationMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/orders/1", nil)
+		req.Header.Set("Authorization", "Bearer valid-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve(http.MethodGet); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected GET with orders:read to pass, got %d", rr.Code)
+	}
+
+	rr := serve(http.MethodPost)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected POST without orders:write to be forbidden, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "insufficient_scope") {
+		t.Fatalf("expected insufficient_scope error, got %s", rr.Body.String())
+	}
+	if got := rr.Header().Get("WWW-Authenticate"); got != `Bearer error="insufficient_scope", scope="orders:write"` {
+		t.Fatalf("unexpected WWW-Authenticate header %q", got)
+	}
+
+	authRepo.validateResp.Scope = "orders:read orders:write"
+	if rr = serve(http.MethodPost); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected POST with orders:write to pass, got %d", rr.Code)
+	}
+}
+
+// TestTokenValidationMiddlewareInheritedRoles verifies routes are granted by any role of the roles claim.
+func TestTokenValidationMiddlewareInheritedRoles(t *testing.T) {
+	expiresAt := time.Now().UTC().Add(30 * time.Minute).Truncate(time.Second)
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{
+			APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+			Role:      "admin",
+			Roles:     []string{"admin", "user_all", "user_users", "user_orders"},
+			ExpiresAt: expiresAt.Format(time.RFC3339),
+		},
+		metaErr: repo.ErrTokenNotFound(),
+	}
+
+	useCase, err := NewAuthUseCase(authRepo, repo.NewGatewayRepo(), []types.EndpointConfig{
+		{
+			GwEndpoint:         "/api/v1/users/*",
+			LiveEndpoint:       "http://users:8087",
+			AllowedRole:        []string{"user_users"},
+			RateLimitReqPerSec: 5,
+		},
+		{
+			GwEndpoint:         "/api/v1/orders/*",
+			LiveEndpoint:       "http://orders:8086",
+			AllowedRole:        []string{"user_orders"},
+			RateLimitReqPerSec: 10,
+		},
+	}, 0)
+	if err != nil {
+		t.Fatalf("new auth usecase: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/1", nil)
+	req.Header.Set("Authorization", "Bearer valid-token")
+	rr := httptest.NewRecorder()
+	useCase.TokenValidationMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+	if got := authRepo.metaResp.AllowedRoutes; !slices.Equal(got, []string{"/api/v1/users/*", "/api/v1/orders/*"}) {
+		t.Fatalf("unexpected allowed routes %#v", got)
+	}
+	if authRepo.metaResp.Owner != "admin" || authRepo.metaResp.RateLimit != 10 {
+		t.Fatalf("unexpected token metadata %#v", authRepo.metaResp)
+	}
+}
+
+// TestTokenValidationMiddlewarePolicies verifies ownership policies are enforced unless in dry-run mode.
+func TestTokenValidationMiddlewarePolicies(t *testing.T) {
+	expiresAt := time.Now().UTC().Add(30 * time.Minute).Truncate(time.Second)
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{
+			APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+			Subject:   "2",
+			TokenType: "user",
+			Role:      "user_users",
+			ExpiresAt: expiresAt.Format(time.RFC3339),
+		},
+		metaResp: types.TokenMetadata{
+			APIKey:        "550e8400-e29b-41d4-a716-446655440000",
+			Owner:         "user_users",
+			Subject:       "2",
+			TokenType:     "user",
+			RateLimit:     5,
+			ExpiresAt:     expiresAt,
+			AllowedRoutes: []string{"/api/v1/users/*"},
+		},
+	}
+
+	newHandler := func(dryRun bool) http.Handler {
+		useCase, err := NewAuthUseCase(authRepo, repo.NewGatewayRepo(), []types.EndpointConfig{
+			{
+				GwEndpoint:   "/api/v1/users/*",
+				LiveEndpoint: "http://users:8087",
+				Policies: []types.PolicyRule{
+					{Name: "own_user_records", Path: "/api/v1/users/{id}/*", Param: "id", BypassRoles: []string{"user_all"}, DryRun: dryRun},
+				},
+			},
+		}, 0)
+		if err != nil {
+			t.Fatalf("new auth usecase: %v", err)
+		}
+		return useCase.TokenValidationMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+	serve := func(handler http.Handler, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer valid-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	enforced := newHandler(false)
+	if code := serve(enforced, "/api/v1/users/2/contact"); code != http.StatusNoContent {
+		t.Fatalf("expected own record to pass, got %d", code)
+	}
+	if code := serve(enforced, "/api/v1/users/1/contact"); code != http.StatusForbidden {
+		t.Fatalf("expected foreign record to be forbidden, got %d", code)
+	}
+	if code := serve(newHandler(true), "/api/v1/users/1/contact"); code != http.StatusNoContent {
+		t.Fatalf("expected dry-run policy to only log, got %d", code)
+	}
+
+	authRepo.validateResp.Roles = []string{"user_all", "user_users"}
+	if code := serve(enforced, "/api/v1/users/1/contact"); code != http.StatusNoContent {
+		t.Fatalf("expected bypass role to pass, got %d", code)
+	}
+}
+
+// TestTokenValidationMiddlewareAPIKey verifies X-API-Key maps onto token metadata with the key's rate limit.
+func TestTokenValidationMiddlewareAPIKey(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{
+			APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+			Subject:   "1",
+			TokenType: "user",
+			Role:      "user_all",
+			RateLimit: 2,
+			ExpiresAt: time.Now().UTC().Add(30 * time.Minute).Format(time.RFC3339),
+		},
+		metaErr: repo.ErrTokenNotFound(),
+	}
+	useCase, err := NewAuthUseCase(authRepo, repo.NewGatewayRepo(), []types.EndpointConfig{
+		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: "http://users:8087", AllowedRole: []string{"user_all"}, RateLimitReqPerSec: 5},
+	}, 0)
+	if err != nil {
+		t.Fatalf("new auth usecase: %v", err)
+	}
+
+	var metadata types.TokenMetadata
+	handler := useCase.TokenValidationMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata, _ = r.Context().Value(ctxKeyTokenMetadata).(types.TokenMetadata)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil)
+	req.Header.Set(types.APIKeyHeader, "gwk_0123456789ab_secret")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+	if authRepo.validatedKey != "gwk_0123456789ab_secret" {
+		t.Fatalf("expected api key to be validated, got %q", authRepo.validatedKey)
+	}
+	if metadata.APIKey != authRepo.validateResp.APIKey || metadata.Subject != "1" || metadata.RateLimit != 2 {
+		t.Fatalf("expected api key metadata with its own rate limit, got %#v", metadata)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil)
+	req.Header.Set(types.APIKeyHeader, "gwk_0123456789ab_secret")
+	req.Header.Set("Authorization", "Bearer valid-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected both credentials to be refused, got %d", rr.Code)
+	}
+}
+
+// TestTokenValidationMiddlewareSignedRequest verifies signed requests pass once and are refused when tampered, stale or replayed.
+func TestTokenValidationMiddlewareSignedRequest(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{
+			APIKey:    "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+			Subject:   "2",
+			TokenType: "service",
+			Role:      "users_gw",
+			ExpiresAt: time.Now().UTC().Add(30 * time.Minute).Format(time.RFC3339),
+		},
+		metaErr:       repo.ErrTokenNotFound(),
+		signingSecret: []byte("signing-secret"),
+	}
+	useCase, err := NewAuthUseCase(authRepo, repo.NewGatewayRepo(), []types.EndpointConfig{
+		{GwEndpoint: "/api/v1/orders/*", LiveEndpoint: "http://orders:8088", AllowedRole: []string{"users_gw"}, RateLimitReqPerSec: 5},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("new auth usecase: %v", err)
+	}
+
+	var body string
+	handler := useCase.TokenValidationMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		body = string(raw)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	signed := func(secret string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/orders/1?b=2&a=1", strings.NewReader(`{"qty":1}`))
+		if err := rest_qol.SignRequest(req, "gws_0123456789abcdef", []byte(secret)); err != nil {
+			t.Fatalf("sign request: %v", err)
+		}
+		return req
+	}
+	serve := func(req *http.Request) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	req := signed("signing-secret")
+	replay := req.Clone(req.Context())
+	replay.Body = io.NopCloser(strings.NewReader(`{"qty":1}`))
+	if code := serve(req); code != http.StatusNoContent {
+		t.Fatalf("expected signed request to pass, got %d", code)
+	}
+	if body != `{"qty":1}` {
+		t.Fatalf("expected body to reach the upstream handler intact, got %q", body)
+	}
+	if code := serve(replay); code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed nonce to be refused, got %d", code)
+	}
+
+	if code := serve(signed("other-secret")); code != http.StatusUnauthorized {
+		t.Fatalf("expected signature with another secret to be refused, got %d", code)
+	}
+
+	req = signed("signing-secret")
+	req.Body = io.NopCloser(strings.NewReader(`{"qty":9}`))
+	if code := serve(req); code != http.StatusUnauthorized {
+		t.Fatalf("expected tampered body to be refused, got %d", code)
+	}
+
+	req = signed("signing-secret")
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	req.Header.Set(rest_qol.SignatureTimestampHeader, stale)
+	if code := serve(req); code != http.StatusUnauthorized {
+		t.Fatalf("expected timestamp outside the skew window to be refused, got %d", code)
+	}
+}
+
+// TestTokenValidationMiddlewareDenyImpersonation verifies routes with deny_impersonation refuse tokens carrying an act claim.
+func TestTokenValidationMiddlewareDenyImpersonation(t *testing.T) {
+	expiresAt := time.Now().UTC().Add(15 * time.Minute).Truncate(time.Second)
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{
+			APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+			Role:      "user_orders",
+			Subject:   "2",
+			TokenType: "user",
+			ExpiresAt: expiresAt.Format(time.RFC3339),
+			Actor:     &types.ActorClaim{Subject: "1", TokenType: "user"},
+		},
+		metaErr: repo.ErrTokenNotFound(),
+	}
+
+	useCase, err := NewAuthUseCase(authRepo, repo.NewGatewayRepo(), []types.EndpointConfig{
+		{GwEndpoint: "/api/v1/orders/*", LiveEndpoint: "http://orders:8086", AllowedRole: []string{"user_orders"}},
+		{GwEndpoint: "/api/v1/payments/*", LiveEndpoint: "http://payments:8088", AllowedRole: []string{"user_orders"}, DenyImpersonation: true},
+	}, 0)
+	if err != nil {
+		t.Fatalf("new auth usecase: %v", err)
+	}
+
+	var got types.TokenMetadata
+	handler := useCase.TokenValidationMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = r.Context().Value(ctxKeyTokenMetadata).(types.TokenMetadata)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer impersonation-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve("/api/v1/orders/1"); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected impersonated request to pass on open route, got %d", rr.Code)
+	}
+	if got.ActorSubject != "1" || got.ActorTokenType != "user" {
+		t.Fatalf("expected actor in token metadata, got %#v", got)
+	}
+
+	rr := serve("/api/v1/payments/1")
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "impersonation not allowed") {
+		t.Fatalf("expected impersonation to be refused, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	authRepo.validateResp.Actor = nil
+	if rr = serve("/api/v1/payments/1"); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected own token to pass, got %d", rr.Code)
+	}
+	if got.ActorSubject != "" {
+		t.Fatalf("expected actor to be cleared from metadata, got %#v", got)
+	}
+}
+
+// TestTokenValidationMiddlewareSessionCookie verifies session cookies are accepted, with a CSRF token on unsafe methods.
+func TestTokenValidationMiddlewareSessionCookie(t *testing.T) {
+	expiresAt := time.Now().UTC().Add(15 * time.Minute).Truncate(time.Second)
+	authRepo := &fakeAuthRepo{
+		validateResp: types.ValidateResponse{
+			APIKey:    "550e8400-e29b-41d4-a716-446655440000",
+			Role:      "user_orders",
+			Subject:   "3",
+			TokenType: "user",
+			ExpiresAt: expiresAt.Format(time.RFC3339),
+		},
+		metaErr: repo.ErrTokenNotFound(),
+	}
+	useCase, err := NewAuthUseCase(authRepo, repo.NewGatewayRepo(), []types.EndpointConfig{
+		{GwEndpoint: "/api/v1/orders/*", LiveEndpoint: "http://orders:8086", AllowedRole: []string{"user_orders"}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("new auth usecase: %v", err)
+	}
+
+	handler := useCase.TokenValidationMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method string, csrfToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/orders/1", nil)
+		req.AddCookie(&http.Cookie{Name: rest_qol.SessionCookieName, Value: "cookie-token"})
+		if csrfToken != "" {
+			req.Header.Set(rest_qol.CSRFHeader, csrfToken)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve(http.MethodGet, ""); rr.Code != http.StatusNoContent || authRepo.validatedJWT != "cookie-token" {
+		t.Fatalf("expected cookie token to be validated on GET, got %d %q", rr.Code, authRepo.validatedJWT)
+	}
+	if rr := serve(http.MethodPost, ""); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "csrf") {
+		t.Fatalf("expected POST without csrf token to be refused, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := serve(http.MethodPost, rest_qol.CSRFTokenFor("other-token")); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected csrf token of another session to be refused, got %d", rr.Code)
+	}
+	if rr := serve(http.MethodPost, rest_qol.CSRFTokenFor("cookie-token")); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected POST with csrf token to pass, got %d", rr.Code)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/repo"
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/utils"
+	"github.com/yirez/go-gw-test/pkg/rest_qol"
 
 	"go.uber.org/zap"
 )
@@ -87,7 +88,21 @@ func (g *GatewayUseCase) Proxy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if limit > 0 {
-		count, _, err := g.rr.Increment(r.Context(), metadata.APIKey, entry.RateKey)
+		scope := entry.Config.RateLimitScope
+		if scope == types.RateLimitScopeSubject && metadata.Subject == "" {
+			// metadata written before sub was tracked; fall back to the token counter
+			zap.L().Warn("subject rate limit without subject, using token scope", zap.String("api_key", metadata.APIKey))
+			scope = types.RateLimitScopeToken
+		}
+
+		var count int64
+		var err error
+		if scope == types.RateLimitScopeSubject {
+			count, _, err = g.rr.IncrementSubject(r.Context(), metadata.TokenType, metadata.Subject, entry.RateKey)
+		} else {
+			scope = types.RateLimitScopeToken
+			count, _, err = g.rr.Increment(r.Context(), metadata.APIKey, entry.RateKey)
+		}
 		if err != nil {
 			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "rate limiter failed"})
 			return
@@ -96,6 +111,9 @@ func (g *GatewayUseCase) Proxy(w http.ResponseWriter, r *http.Request) {
 			zap.L().Warn("rate limit exceeded",
 				zap.String("api_key", metadata.APIKey),
 				zap.String("owner", metadata.Owner),
+				zap.String("sub", metadata.Subject),
+				zap.String("act_sub", metadata.ActorSubject),
+				zap.String("scope", scope),
 				zap.String("endpoint", entry.Config.GwEndpoint),
 				zap.Int("limit", limit),
 				zap.Int64("count", count),
@@ -103,14 +121,34 @@ func (g *GatewayUseCase) Proxy(w http.ResponseWriter, r *http.Request) {
 				zap.String("path", r.URL.Path),
 				zap.String("method", r.Method),
 			)
-			utils.WriteJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+			utils.WriteJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded", "scope": scope})
 			return
 		}
 	}
 
+	setIdentityHeaders(r, metadata)
+	// the session cookie is the gateway token itself; upstreams get the identity headers instead
+	rest_qol.StripSessionCookies(r)
 	entry.Proxy.ServeHTTP(w, r)
 }
 
+// setIdentityHeaders replaces client-sent identity headers with the subject and actor of the validated token.
+func setIdentityHeaders(r *http.Request, metadata types.TokenMetadata) {
+	r.Header.Del(types.SubjectHeader)
+	r.Header.Del(types.TokenTypeHeader)
+	r.Header.Del(types.ActorSubjectHeader)
+	r.Header.Del(types.ActorTokenTypeHeader)
+
+	if metadata.Subject != "" {
+		r.Header.Set(types.SubjectHeader, metadata.Subject)
+		r.Header.Set(types.TokenTypeHeader, metadata.TokenType)
+	}
+	if metadata.ActorSubject != "" {
+		r.Header.Set(types.ActorSubjectHeader, metadata.ActorSubject)
+		r.Header.Set(types.ActorTokenTypeHeader, metadata.ActorTokenType)
+	}
+}
+
 // NotFound returns a JSON 404 response for unmatched routes.
 func (g *GatewayUseCase) NotFound(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
@@ -0,0 +1,131 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/repo"
+	"github.com/yirez/go-gw-test/cmd/api_gw/internal/types"
+	"github.com/yirez/go-gw-test/pkg/rest_qol"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// serveProxy runs Proxy for a request carrying the given token metadata.
+func serveProxy(g *GatewayUseCase, metadata types.TokenMetadata) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ctxKeyTokenMetadata, metadata))
+	rr := httptest.NewRecorder()
+	g.Proxy(rr, req)
+	return rr
+}
+
+// TestGatewayProxyRateLimitScopes verifies subject scope aggregates a subject's tokens while token scope does not.
+func TestGatewayProxyRateLimitScopes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tokenA := types.TokenMetadata{APIKey: "550e8400-e29b-41d4-a716-446655440000", Subject: "2", TokenType: "user", ExpiresAt: time.Now().Add(time.Hour)}
+	tokenB := types.TokenMetadata{APIKey: "6ba7b810-9dad-11d1-80b4-00c04fd430c8", Subject: "2", TokenType: "user", ExpiresAt: time.Now().Add(time.Hour)}
+
+	for _, scope := range []string{types.RateLimitScopeToken, types.RateLimitScopeSubject} {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("start miniredis: %v", err)
+		}
+
+		g, err := NewGatewayUseCase(repo.NewRateLimiterRepo(redis.NewClient(&redis.Options{Addr: mr.Addr()})), repo.NewGatewayRepo(), []types.EndpointConfig{
+			{GwEndpoint: "/api/v1/users/*", LiveEndpoint: upstream.URL, RateLimitReqPerSec: 1, RateLimitScope: scope},
+		})
+		if err != nil {
+			mr.Close()
+			t.Fatalf("new gateway usecase: %v", err)
+		}
+
+		// both requests must land in the same one-second window
+		if now := time.Now(); now.Sub(now.Truncate(time.Second)) > 800*time.Millisecond {
+			time.Sleep(time.Second - now.Sub(now.Truncate(time.Second)))
+		}
+		if rr := serveProxy(g, tokenA); rr.Code != http.StatusOK {
+			mr.Close()
+			t.Fatalf("%s scope: expected first request to pass, got %d", scope, rr.Code)
+		}
+		rr := serveProxy(g, tokenB)
+		mr.Close()
+
+		if scope == types.RateLimitScopeToken {
+			if rr.Code != http.StatusOK {
+				t.Fatalf("token scope: expected second token to have its own quota, got %d", rr.Code)
+			}
+			continue
+		}
+
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("subject scope: expected shared quota to be exhausted, got %d", rr.Code)
+		}
+		var body map[string]string
+		if err = json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("decode 429 body: %v", err)
+		}
+		if body["scope"] != types.RateLimitScopeSubject {
+			t.Fatalf("expected 429 to name subject scope, got %#v", body)
+		}
+	}
+}
+
+// TestGatewayProxyIdentityHeaders verifies upstreams get subject and actor headers from the token, never from the client.
+func TestGatewayProxyIdentityHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	g, err := NewGatewayUseCase(nil, repo.NewGatewayRepo(), []types.EndpointConfig{
+		{GwEndpoint: "/api/v1/users/*", LiveEndpoint: upstream.URL},
+	})
+	if err != nil {
+		t.Fatalf("new gateway usecase: %v", err)
+	}
+
+	serve := func(metadata types.TokenMetadata) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil)
+		req.Header.Set(types.SubjectHeader, "99")
+		req.Header.Set(types.ActorSubjectHeader, "99")
+		req.AddCookie(&http.Cookie{Name: rest_qol.SessionCookieName, Value: "cookie-token"})
+		req.AddCookie(&http.Cookie{Name: rest_qol.CSRFCookieName, Value: "csrf"})
+		req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+		req = req.WithContext(context.WithValue(req.Context(), ctxKeyTokenMetadata, metadata))
+		rr := httptest.NewRecorder()
+		g.Proxy(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected proxied request to pass, got %d", rr.Code)
+		}
+	}
+
+	metadata := types.TokenMetadata{APIKey: "550e8400-e29b-41d4-a716-446655440000", Subject: "2", TokenType: "user", ExpiresAt: time.Now().Add(time.Hour)}
+	serve(metadata)
+	if got.Get(types.SubjectHeader) != "2" || got.Get(types.TokenTypeHeader) != "user" {
+		t.Fatalf("unexpected subject headers %v", got)
+	}
+	if got.Get(types.ActorSubjectHeader) != "" {
+		t.Fatalf("expected client actor header to be dropped, got %q", got.Get(types.ActorSubjectHeader))
+	}
+	if got.Get("Cookie") != "theme=dark" {
+		t.Fatalf("expected session cookies to be stripped, got %q", got.Get("Cookie"))
+	}
+
+	metadata.ActorSubject, metadata.ActorTokenType = "1", "user"
+	serve(metadata)
+	if got.Get(types.ActorSubjectHeader) != "1" || got.Get(types.ActorTokenTypeHeader) != "user" {
+		t.Fatalf("unexpected actor headers %v", got)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	g "github.com/yirez/go-gw-test/cmd/api_gw/internal/globals"
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/repo"
 	"github.com/yirez/go-gw-test/cmd/api_gw/internal/usecase"
 	"github.com/yirez/go-gw-test/pkg/rest_qol"
 	"net/http"
+	"time"
 
 	_ "github.com/yirez/go-gw-test/cmd/api_gw/docs"
 
@@ -22,8 +24,13 @@ func NewRouter() http.Handler {
 		g.Cfg.StandardConfigs.AuthConfig.Endpoint,
 		g.Cfg.StandardConfigs.AuthConfig.ServiceID,
 		g.Cfg.StandardConfigs.AuthConfig.Secret,
-		g.Cfg.StandardConfigs.Clients.Redis)
-	authUseCase, err := usecase.NewAuthUseCase(authRepo, gatewayRepo, g.Cfg.EndpointConfiguration)
+		g.Cfg.StandardConfigs.Clients.Redis,
+		time.Duration(g.Cfg.StandardConfigs.AuthConfig.ValidationCacheTTLSec)*time.Second)
+	go authRepo.ListenRevocations(context.Background())
+	go authRepo.RenewServiceToken(context.Background())
+
+	authUseCase, err := usecase.NewAuthUseCase(authRepo, gatewayRepo, g.Cfg.EndpointConfiguration,
+		time.Duration(g.Cfg.StandardConfigs.AuthConfig.SignatureMaxSkewSec)*time.Second)
 	if err != nil {
 		zap.L().Fatal("init auth usecase", zap.Error(err))
 	}
@@ -33,10 +40,28 @@ func NewRouter() http.Handler {
 		zap.L().Fatal("init gateway usecase", zap.Error(err))
 	}
 
+	adminRepo := repo.NewAdminRepo(g.Cfg.StandardConfigs.Clients.Redis, g.Cfg.AdminConfiguration.AuditMaxEntries)
+	adminUseCase, err := usecase.NewAdminUseCase(authRepo, rateLimiter, gatewayRepo, adminRepo, g.Cfg.EndpointConfiguration, g.Cfg.AdminConfiguration)
+	if err != nil {
+		zap.L().Fatal("init admin usecase", zap.Error(err))
+	}
+
 	router := mux.NewRouter()
 	metrics := rest_qol.NewHTTPMetrics("api_gw")
+	metrics.MustRegister(authRepo.Collectors()...)
+
+	rest_qol.RegisterOperationalRoutes(router, httpSwagger.WrapHandler, metrics.Handler(),
+		rest_qol.ReadinessCheck{Name: "auth_service_token", Check: authRepo.ServiceTokenReady})
 
-	rest_qol.RegisterOperationalRoutes(router, httpSwagger.WrapHandler, metrics.Handler())
+	adminRouter := router.PathPrefix("/admin/").Subrouter()
+	adminRouter.Use(adminUseCase.AdminAuthMiddleware())
+	adminRouter.HandleFunc("/routes", adminUseCase.ListRoutes).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/tokens/{api_key}", adminUseCase.GetToken).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/tokens/{api_key}", adminUseCase.PatchToken).Methods(http.MethodPatch)
+	adminRouter.HandleFunc("/tokens/{api_key}", adminUseCase.DeleteToken).Methods(http.MethodDelete)
+	adminRouter.HandleFunc("/tokens/{api_key}/rate-limits", adminUseCase.GetTokenRateLimits).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/cache/purge", adminUseCase.PurgeCache).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/audit", adminUseCase.ListAudit).Methods(http.MethodGet)
 
 	router.PathPrefix("/api/v1/").HandlerFunc(gatewayUseCase.Proxy)
 
@@ -17,14 +17,39 @@ func InitConfiguration() {
 	var err error
 	Cfg.StandardConfigs, err = configuration_manager.InitStandardConfigs(
 		cmt.InitChecklist{
-			DB:              true,
-			Redis:           false,
-			AutoMigrateList: []any{&types.UserRecord{}, &types.ServiceRecord{}},
+			DB:    true,
+			Redis: true,
+			AutoMigrateList: []any{
+				&types.UserRecord{},
+				&types.ServiceRecord{},
+				&types.ServiceSecret{},
+				&types.ServiceSigningKey{},
+				&types.UserMFA{},
+				&types.MFARecoveryCode{},
+				&types.MFAChallenge{},
+				&types.RoleAssignment{},
+				&types.APIKeyRecord{},
+				&types.RevokedToken{},
+				&types.SubjectRevocation{},
+				&types.RefreshToken{},
+				&types.IssuedToken{},
+				&types.UserIdentity{},
+				&types.OIDCLoginState{},
+				&types.EmailToken{},
+				&types.LockoutEvent{},
+				&types.AuthEvent{},
+			},
 		})
 	if err != nil {
 		fmt.Printf("failed init configs: %v\n", err)
 		os.Exit(1)
 	}
+
+	err = configuration_manager.ReadCustomConfig("auth_settings", &Cfg.AuthSettings)
+	if err != nil {
+		fmt.Printf("failed load auth settings: %v\n", err)
+		os.Exit(1)
+	}
 	zap.ReplaceGlobals(Cfg.StandardConfigs.Clients.Logger)
 
 	// Signing key setup by current time
@@ -2,17 +2,99 @@ package repo
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
 
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrRefreshTokenReused is returned when a refresh token was already rotated.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrUsernameTaken is returned when creating a user whose username exists.
+	ErrUsernameTaken = errors.New("username taken")
+	// ErrEmailTaken is returned when creating a user whose email belongs to another user.
+	ErrEmailTaken = errors.New("email taken")
 )
 
 // AuthRepo defines persistence operations needed by auth_gw.
 type AuthRepo interface {
 	FindUserByUsername(ctx context.Context, username string) (types.UserRecord, error)
+	FindUserByID(ctx context.Context, userID int64) (types.UserRecord, error)
+	FindUserByEmail(ctx context.Context, email string) (types.UserRecord, error)
+	ListUsers(ctx context.Context) ([]types.UserRecord, error)
+	CreateUser(ctx context.Context, record types.UserRecord) (types.UserRecord, error)
+	SetUserDisabled(ctx context.Context, userID int64, disabled bool) error
+	UpdateUserPassword(ctx context.Context, userID int64, passwordHash string) error
+	DeleteUser(ctx context.Context, userID int64) error
 	FindServiceByID(ctx context.Context, serviceID int64) (types.ServiceRecord, error)
+	ListServices(ctx context.Context) ([]types.ServiceRecord, error)
+	CreateService(ctx context.Context, record types.ServiceRecord, secret types.ServiceSecret) (types.ServiceRecord, types.ServiceSecret, error)
+	SetServiceDisabled(ctx context.Context, serviceID int64, disabled bool) error
+	ListServiceSecrets(ctx context.Context, serviceID int64) ([]types.ServiceSecret, error)
+	AddServiceSecret(ctx context.Context, secret types.ServiceSecret, retireAt *time.Time) (types.ServiceSecret, error)
+	DeleteServiceSecret(ctx context.Context, serviceID int64, secretID int64) error
+	TouchServiceSecret(ctx context.Context, secretID int64, usedAt time.Time) error
+	CreateSigningKey(ctx context.Context, key types.ServiceSigningKey) (types.ServiceSigningKey, error)
+	ListSigningKeys(ctx context.Context, serviceID int64) ([]types.ServiceSigningKey, error)
+	FindSigningKey(ctx context.Context, keyID string) (types.ServiceSigningKey, error)
+	DeleteSigningKey(ctx context.Context, serviceID int64, keyID string) (types.ServiceSigningKey, error)
+	TouchSigningKey(ctx context.Context, id int64, usedAt time.Time) error
+	ListRoleAssignments(ctx context.Context, tokenType string, subjectID int64) ([]string, error)
+	SetRoleAssignments(ctx context.Context, tokenType string, subjectID int64, roles []string) error
+
+	CreateAPIKey(ctx context.Context, record types.APIKeyRecord) (types.APIKeyRecord, error)
+	ListAPIKeys(ctx context.Context) ([]types.APIKeyRecord, error)
+	FindAPIKeyByPrefix(ctx context.Context, prefix string) (types.APIKeyRecord, error)
+	DeleteAPIKey(ctx context.Context, id int64) (types.APIKeyRecord, error)
+	TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error
+
+	FindUserMFA(ctx context.Context, userID int64) (types.UserMFA, error)
+	SaveUserMFA(ctx context.Context, mfa types.UserMFA) error
+	ConfirmUserMFA(ctx context.Context, userID int64, confirmedAt time.Time, step int64, codeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string, usedAt time.Time) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int64) (int64, error)
+	DeleteUserMFA(ctx context.Context, userID int64) error
+	SaveMFAChallenge(ctx context.Context, challenge types.MFAChallenge) (types.MFAChallenge, error)
+	FindMFAChallenge(ctx context.Context, challengeHash string) (types.MFAChallenge, error)
+	IncrementMFAChallengeAttempts(ctx context.Context, id int64) error
+	DeleteMFAChallenge(ctx context.Context, id int64) (bool, error)
+
+	SaveRevokedToken(ctx context.Context, record types.RevokedToken) error
+	SaveSubjectRevocation(ctx context.Context, record types.SubjectRevocation) error
+	ListRevokedTokens(ctx context.Context, expiresAfter time.Time) ([]types.RevokedToken, error)
+	ListSubjectRevocations(ctx context.Context, revokedAfter time.Time) ([]types.SubjectRevocation, error)
+
+	SaveRefreshToken(ctx context.Context, record types.RefreshToken) error
+	FindRefreshToken(ctx context.Context, tokenHash string) (types.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, tokenHash string, next types.RefreshToken) error
+	RevokeRefreshFamily(ctx context.Context, familyID string, revokedAt time.Time) ([]types.RefreshToken, error)
+
+	SaveIssuedToken(ctx context.Context, record types.IssuedToken) error
+	FindIssuedToken(ctx context.Context, jti string) (types.IssuedToken, error)
+	ListIssuedTokens(ctx context.Context, tokenType string, subject string, expiresAfter time.Time) ([]types.IssuedToken, error)
+	DeleteIssuedTokensExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error)
+
+	SaveOIDCLoginState(ctx context.Context, state types.OIDCLoginState) error
+	ConsumeOIDCLoginState(ctx context.Context, stateHash string) (types.OIDCLoginState, error)
+	DeleteOIDCLoginStatesExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error)
+	SyncFederatedUser(ctx context.Context, identity types.UserIdentity, username string, roles []string) (types.UserRecord, []string, error)
+
+	SaveEmailToken(ctx context.Context, token types.EmailToken) error
+	ConsumeEmailToken(ctx context.Context, tokenHash string, purpose string) (types.EmailToken, error)
+	DeleteEmailTokens(ctx context.Context, purpose string, email string) (int64, error)
+	DeleteEmailTokensExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error)
+
+	SaveLockoutEvent(ctx context.Context, event types.LockoutEvent) error
+	SaveAuthEvent(ctx context.Context, event types.AuthEvent) error
+	ListAuthEvents(ctx context.Context, filter types.AuthEventFilter) ([]types.AuthEvent, error)
+	DeleteAuthEventsBefore(ctx context.Context, cutoff time.Time) (int64, error)
 }
 
 // AuthRepoImpl implements AuthRepo using GORM.
@@ -39,6 +121,159 @@ func (r *AuthRepoImpl) FindUserByUsername(ctx context.Context, username string)
 	return record, nil
 }
 
+// FindUserByID loads a user record by ID.
+func (r *AuthRepoImpl) FindUserByID(ctx context.Context, userID int64) (types.UserRecord, error) {
+	var record types.UserRecord
+	err := r.db.WithContext(ctx).Where("id = ?", userID).First(&record).Error
+	if err != nil {
+		zap.L().Error("find user by id", zap.Error(err))
+		return types.UserRecord{}, err
+	}
+
+	return record, nil
+}
+
+// ListUsers loads all user records ordered by ID.
+func (r *AuthRepoImpl) ListUsers(ctx context.Context) ([]types.UserRecord, error) {
+	var records []types.UserRecord
+	err := r.db.WithContext(ctx).Order("id").Find(&records).Error
+	if err != nil {
+		zap.L().Error("list users", zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// CreateUser inserts a user and returns it with its generated ID.
+func (r *AuthRepoImpl) CreateUser(ctx context.Context, record types.UserRecord) (types.UserRecord, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&types.UserRecord{}).Where("username = ?", record.Username).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUsernameTaken
+		}
+		if record.Email != "" {
+			err = tx.Model(&types.UserRecord{}).Where("email = ?", record.Email).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrEmailTaken
+			}
+		}
+
+		return tx.Create(&record).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// a concurrent create took the username or email between the counts and the insert
+		err = r.takenUserError(ctx, record)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrUsernameTaken) && !errors.Is(err, ErrEmailTaken) {
+			zap.L().Error("create user", zap.String("username", record.Username), zap.Error(err))
+		}
+		return types.UserRecord{}, err
+	}
+
+	return record, nil
+}
+
+// takenUserError tells which unique column of record a failed insert collided with.
+func (r *AuthRepoImpl) takenUserError(ctx context.Context, record types.UserRecord) error {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&types.UserRecord{}).Where("username = ?", record.Username).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 || record.Email == "" {
+		return ErrUsernameTaken
+	}
+
+	return ErrEmailTaken
+}
+
+// FindUserByEmail loads a user record by lower-cased email; it returns gorm.ErrRecordNotFound for unknown addresses.
+func (r *AuthRepoImpl) FindUserByEmail(ctx context.Context, email string) (types.UserRecord, error) {
+	var record types.UserRecord
+	err := r.db.WithContext(ctx).Where("email = ? AND email <> ''", email).First(&record).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("find user by email", zap.Error(err))
+		}
+		return types.UserRecord{}, err
+	}
+
+	return record, nil
+}
+
+// SetUserDisabled flips the disabled flag; it returns gorm.ErrRecordNotFound for unknown users.
+func (r *AuthRepoImpl) SetUserDisabled(ctx context.Context, userID int64, disabled bool) error {
+	return r.updateByID(ctx, &types.UserRecord{}, userID, "disabled", disabled)
+}
+
+// UpdateUserPassword stores a new password hash; it returns gorm.ErrRecordNotFound for unknown users.
+func (r *AuthRepoImpl) UpdateUserPassword(ctx context.Context, userID int64, passwordHash string) error {
+	return r.updateByID(ctx, &types.UserRecord{}, userID, "password_hash", passwordHash)
+}
+
+// DeleteUser removes a user with its role assignments, API keys, federated identities, reset tokens and MFA; it returns gorm.ErrRecordNotFound for unknown users.
+func (r *AuthRepoImpl) DeleteUser(ctx context.Context, userID int64) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", userID).Delete(&types.UserRecord{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		err := tx.Where("token_type = ? AND subject_id = ?", "user", userID).Delete(&types.RoleAssignment{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("token_type = ? AND subject_id = ?", "user", userID).Delete(&types.APIKeyRecord{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ?", userID).Delete(&types.UserIdentity{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ? AND purpose = ?", userID, types.EmailTokenPasswordReset).Delete(&types.EmailToken{}).Error
+		if err != nil {
+			return err
+		}
+
+		return deleteUserMFA(tx, userID)
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		zap.L().Error("delete user", zap.Int64("user_id", userID), zap.Error(err))
+	}
+
+	return err
+}
+
+// updateByID sets one column of the model row with id.
+func (r *AuthRepoImpl) updateByID(ctx context.Context, model any, id int64, column string, value any) error {
+	result := r.db.WithContext(ctx).Model(model).Where("id = ?", id).Update(column, value)
+	if result.Error != nil {
+		zap.L().Error("update record", zap.Int64("id", id), zap.String("column", column), zap.Error(result.Error))
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
 // FindServiceByID loads a service record by ID.
 func (r *AuthRepoImpl) FindServiceByID(ctx context.Context, serviceID int64) (types.ServiceRecord, error) {
 	var record types.ServiceRecord
@@ -50,3 +285,917 @@ func (r *AuthRepoImpl) FindServiceByID(ctx context.Context, serviceID int64) (ty
 
 	return record, nil
 }
+
+// ListServices loads all service records ordered by ID.
+func (r *AuthRepoImpl) ListServices(ctx context.Context) ([]types.ServiceRecord, error) {
+	var records []types.ServiceRecord
+	err := r.db.WithContext(ctx).Order("id").Find(&records).Error
+	if err != nil {
+		zap.L().Error("list services", zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// CreateService inserts a service with its first secret.
+func (r *AuthRepoImpl) CreateService(ctx context.Context, record types.ServiceRecord, secret types.ServiceSecret) (types.ServiceRecord, types.ServiceSecret, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&record).Error
+		if err != nil {
+			return err
+		}
+
+		secret.ServiceID = record.ID
+		return tx.Create(&secret).Error
+	})
+	if err != nil {
+		zap.L().Error("create service", zap.String("name", record.Name), zap.Error(err))
+		return types.ServiceRecord{}, types.ServiceSecret{}, err
+	}
+
+	return record, secret, nil
+}
+
+// SetServiceDisabled flips the disabled flag; it returns gorm.ErrRecordNotFound for unknown services.
+func (r *AuthRepoImpl) SetServiceDisabled(ctx context.Context, serviceID int64, disabled bool) error {
+	return r.updateByID(ctx, &types.ServiceRecord{}, serviceID, "disabled", disabled)
+}
+
+// ListServiceSecrets loads every secret of a service, expired ones included, ordered by ID.
+func (r *AuthRepoImpl) ListServiceSecrets(ctx context.Context, serviceID int64) ([]types.ServiceSecret, error) {
+	var secrets []types.ServiceSecret
+	err := r.db.WithContext(ctx).Where("service_id = ?", serviceID).Order("id").Find(&secrets).Error
+	if err != nil {
+		zap.L().Error("list service secrets", zap.Int64("service_id", serviceID), zap.Error(err))
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// AddServiceSecret stores a new secret and, when retireAt is set, moves earlier expiries of existing secrets to retireAt.
+// A legacy secret_hash is moved into service_secrets first so it can take part in the overlap.
+func (r *AuthRepoImpl) AddServiceSecret(ctx context.Context, secret types.ServiceSecret, retireAt *time.Time) (types.ServiceSecret, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var service types.ServiceRecord
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", secret.ServiceID).First(&service).Error
+		if err != nil {
+			return err
+		}
+
+		if service.SecretHash != "" {
+			legacy := types.ServiceSecret{ServiceID: service.ID, SecretHash: service.SecretHash, CreatedAt: secret.CreatedAt}
+			if err = tx.Create(&legacy).Error; err != nil {
+				return err
+			}
+			err = tx.Model(&types.ServiceRecord{}).Where("id = ?", service.ID).Update("secret_hash", "").Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if retireAt != nil {
+			err = tx.Model(&types.ServiceSecret{}).
+				Where("service_id = ? AND (expires_at IS NULL OR expires_at > ?)", service.ID, *retireAt).
+				Update("expires_at", *retireAt).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&secret).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("add service secret", zap.Int64("service_id", secret.ServiceID), zap.Error(err))
+		}
+		return types.ServiceSecret{}, err
+	}
+
+	return secret, nil
+}
+
+// DeleteServiceSecret removes one secret of a service; it returns gorm.ErrRecordNotFound when it does not exist.
+func (r *AuthRepoImpl) DeleteServiceSecret(ctx context.Context, serviceID int64, secretID int64) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND service_id = ?", secretID, serviceID).Delete(&types.ServiceSecret{})
+	if result.Error != nil {
+		zap.L().Error("delete service secret", zap.Int64("service_id", serviceID), zap.Int64("secret_id", secretID), zap.Error(result.Error))
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TouchServiceSecret records when a secret was last used.
+func (r *AuthRepoImpl) TouchServiceSecret(ctx context.Context, secretID int64, usedAt time.Time) error {
+	return r.updateByID(ctx, &types.ServiceSecret{}, secretID, "last_used_at", usedAt)
+}
+
+// CreateSigningKey stores a signing key of an existing service; it returns gorm.ErrRecordNotFound for unknown services.
+func (r *AuthRepoImpl) CreateSigningKey(ctx context.Context, key types.ServiceSigningKey) (types.ServiceSigningKey, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&types.ServiceRecord{}).Where("id = ?", key.ServiceID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Create(&key).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("create signing key", zap.Int64("service_id", key.ServiceID), zap.Error(err))
+		}
+		return types.ServiceSigningKey{}, err
+	}
+
+	return key, nil
+}
+
+// ListSigningKeys loads every signing key of a service, expired ones included, ordered by ID.
+func (r *AuthRepoImpl) ListSigningKeys(ctx context.Context, serviceID int64) ([]types.ServiceSigningKey, error) {
+	var keys []types.ServiceSigningKey
+	err := r.db.WithContext(ctx).Where("service_id = ?", serviceID).Order("id").Find(&keys).Error
+	if err != nil {
+		zap.L().Error("list signing keys", zap.Int64("service_id", serviceID), zap.Error(err))
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// FindSigningKey loads a signing key by its public key id.
+func (r *AuthRepoImpl) FindSigningKey(ctx context.Context, keyID string) (types.ServiceSigningKey, error) {
+	var key types.ServiceSigningKey
+	err := r.db.WithContext(ctx).Where("key_id = ?", keyID).First(&key).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("find signing key", zap.String("key_id", keyID), zap.Error(err))
+		}
+		return types.ServiceSigningKey{}, err
+	}
+
+	return key, nil
+}
+
+// DeleteSigningKey removes one signing key of a service and returns it; it returns gorm.ErrRecordNotFound when it does not exist.
+func (r *AuthRepoImpl) DeleteSigningKey(ctx context.Context, serviceID int64, keyID string) (types.ServiceSigningKey, error) {
+	var key types.ServiceSigningKey
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("key_id = ? AND service_id = ?", keyID, serviceID).First(&key).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&key).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("delete signing key", zap.Int64("service_id", serviceID), zap.String("key_id", keyID), zap.Error(err))
+		}
+		return types.ServiceSigningKey{}, err
+	}
+
+	return key, nil
+}
+
+// TouchSigningKey records when a signing key was last used.
+func (r *AuthRepoImpl) TouchSigningKey(ctx context.Context, id int64, usedAt time.Time) error {
+	return r.updateByID(ctx, &types.ServiceSigningKey{}, id, "last_used_at", usedAt)
+}
+
+// ListRoleAssignments loads the additional roles of a user or service ordered by name.
+func (r *AuthRepoImpl) ListRoleAssignments(ctx context.Context, tokenType string, subjectID int64) ([]string, error) {
+	var roles []string
+	err := r.db.WithContext(ctx).Model(&types.RoleAssignment{}).
+		Where("token_type = ? AND subject_id = ?", tokenType, subjectID).
+		Order("role").
+		Pluck("role", &roles).Error
+	if err != nil {
+		zap.L().Error("list role assignments", zap.String("token_type", tokenType), zap.Int64("subject_id", subjectID), zap.Error(err))
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// SetRoleAssignments replaces the additional roles of a user or service; it returns gorm.ErrRecordNotFound for unknown subjects.
+func (r *AuthRepoImpl) SetRoleAssignments(ctx context.Context, tokenType string, subjectID int64, roles []string) error {
+	var model any
+	switch tokenType {
+	case "user":
+		model = &types.UserRecord{}
+	case "service":
+		model = &types.ServiceRecord{}
+	default:
+		return fmt.Errorf("unknown token type %q", tokenType)
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(model).Where("id = ?", subjectID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		err = tx.Where("token_type = ? AND subject_id = ?", tokenType, subjectID).Delete(&types.RoleAssignment{}).Error
+		if err != nil || len(roles) == 0 {
+			return err
+		}
+
+		assignments := make([]types.RoleAssignment, 0, len(roles))
+		for _, role := range roles {
+			assignments = append(assignments, types.RoleAssignment{TokenType: tokenType, SubjectID: subjectID, Role: role})
+		}
+		return tx.Create(&assignments).Error
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		zap.L().Error("set role assignments", zap.String("token_type", tokenType), zap.Int64("subject_id", subjectID), zap.Error(err))
+	}
+
+	return err
+}
+
+// CreateAPIKey inserts an API key and returns it with its generated ID.
+func (r *AuthRepoImpl) CreateAPIKey(ctx context.Context, record types.APIKeyRecord) (types.APIKeyRecord, error) {
+	err := r.db.WithContext(ctx).Create(&record).Error
+	if err != nil {
+		zap.L().Error("create api key", zap.String("name", record.Name), zap.Error(err))
+		return types.APIKeyRecord{}, err
+	}
+
+	return record, nil
+}
+
+// ListAPIKeys loads all API keys, expired ones included, ordered by ID.
+func (r *AuthRepoImpl) ListAPIKeys(ctx context.Context) ([]types.APIKeyRecord, error) {
+	var records []types.APIKeyRecord
+	err := r.db.WithContext(ctx).Order("id").Find(&records).Error
+	if err != nil {
+		zap.L().Error("list api keys", zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// FindAPIKeyByPrefix loads an API key by its visible prefix.
+func (r *AuthRepoImpl) FindAPIKeyByPrefix(ctx context.Context, prefix string) (types.APIKeyRecord, error) {
+	var record types.APIKeyRecord
+	err := r.db.WithContext(ctx).Where("prefix = ?", prefix).First(&record).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("find api key", zap.String("prefix", prefix), zap.Error(err))
+		}
+		return types.APIKeyRecord{}, err
+	}
+
+	return record, nil
+}
+
+// DeleteAPIKey removes an API key and returns it; it returns gorm.ErrRecordNotFound for unknown keys.
+func (r *AuthRepoImpl) DeleteAPIKey(ctx context.Context, id int64) (types.APIKeyRecord, error) {
+	var record types.APIKeyRecord
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id = ?", id).First(&record).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&record).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("delete api key", zap.Int64("id", id), zap.Error(err))
+		}
+		return types.APIKeyRecord{}, err
+	}
+
+	return record, nil
+}
+
+// TouchAPIKey records when an API key was last used.
+func (r *AuthRepoImpl) TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error {
+	return r.updateByID(ctx, &types.APIKeyRecord{}, id, "last_used_at", usedAt)
+}
+
+// FindUserMFA loads the TOTP enrollment of a user; it returns gorm.ErrRecordNotFound when there is none.
+func (r *AuthRepoImpl) FindUserMFA(ctx context.Context, userID int64) (types.UserMFA, error) {
+	var mfa types.UserMFA
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&mfa).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("find user mfa", zap.Int64("user_id", userID), zap.Error(err))
+		}
+		return types.UserMFA{}, err
+	}
+
+	return mfa, nil
+}
+
+// SaveUserMFA stores a pending enrollment, replacing an earlier one of the user.
+func (r *AuthRepoImpl) SaveUserMFA(ctx context.Context, mfa types.UserMFA) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&mfa).Error
+	if err != nil {
+		zap.L().Error("save user mfa", zap.Int64("user_id", mfa.UserID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ConfirmUserMFA confirms a pending enrollment with its first accepted step and replaces the recovery codes.
+// It returns gorm.ErrRecordNotFound when no pending enrollment exists.
+func (r *AuthRepoImpl) ConfirmUserMFA(ctx context.Context, userID int64, confirmedAt time.Time, step int64, codeHashes []string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&types.UserMFA{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]any{"confirmed_at": confirmedAt, "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		err := tx.Where("user_id = ?", userID).Delete(&types.MFARecoveryCode{}).Error
+		if err != nil || len(codeHashes) == 0 {
+			return err
+		}
+
+		codes := make([]types.MFARecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, types.MFARecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		zap.L().Error("confirm user mfa", zap.Int64("user_id", userID), zap.Error(err))
+	}
+
+	return err
+}
+
+// UseTOTPStep records step as used and reports false when it, or a later step, was already accepted.
+func (r *AuthRepoImpl) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	// conditional update so two concurrent logins cannot both spend the same code
+	result := r.db.WithContext(ctx).Model(&types.UserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		zap.L().Error("use totp step", zap.Int64("user_id", userID), zap.Error(result.Error))
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used and reports whether one matched.
+func (r *AuthRepoImpl) UseRecoveryCode(ctx context.Context, userID int64, codeHash string, usedAt time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&types.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		zap.L().Error("use recovery code", zap.Int64("user_id", userID), zap.Error(result.Error))
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// CountRecoveryCodes counts the unused recovery codes of a user.
+func (r *AuthRepoImpl) CountRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&types.MFARecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	if err != nil {
+		zap.L().Error("count recovery codes", zap.Int64("user_id", userID), zap.Error(err))
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// DeleteUserMFA removes the enrollment, recovery codes and open challenges of a user.
+// It returns gorm.ErrRecordNotFound when the user had no enrollment.
+func (r *AuthRepoImpl) DeleteUserMFA(ctx context.Context, userID int64) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&types.UserMFA{}).Where("user_id = ?", userID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return deleteUserMFA(tx, userID)
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		zap.L().Error("delete user mfa", zap.Int64("user_id", userID), zap.Error(err))
+	}
+
+	return err
+}
+
+// deleteUserMFA removes every MFA row of a user inside tx.
+func deleteUserMFA(tx *gorm.DB, userID int64) error {
+	for _, model := range []any{&types.UserMFA{}, &types.MFARecoveryCode{}, &types.MFAChallenge{}} {
+		err := tx.Where("user_id = ?", userID).Delete(model).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveMFAChallenge stores an MFA login challenge.
+func (r *AuthRepoImpl) SaveMFAChallenge(ctx context.Context, challenge types.MFAChallenge) (types.MFAChallenge, error) {
+	err := r.db.WithContext(ctx).Create(&challenge).Error
+	if err != nil {
+		zap.L().Error("save mfa challenge", zap.Int64("user_id", challenge.UserID), zap.Error(err))
+		return types.MFAChallenge{}, err
+	}
+
+	return challenge, nil
+}
+
+// FindMFAChallenge loads an MFA login challenge by hash.
+func (r *AuthRepoImpl) FindMFAChallenge(ctx context.Context, challengeHash string) (types.MFAChallenge, error) {
+	var challenge types.MFAChallenge
+	err := r.db.WithContext(ctx).Where("challenge_hash = ?", challengeHash).First(&challenge).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("find mfa challenge", zap.Error(err))
+		}
+		return types.MFAChallenge{}, err
+	}
+
+	return challenge, nil
+}
+
+// IncrementMFAChallengeAttempts counts a wrong code against a challenge.
+func (r *AuthRepoImpl) IncrementMFAChallengeAttempts(ctx context.Context, id int64) error {
+	return r.updateByID(ctx, &types.MFAChallenge{}, id, "attempts", gorm.Expr("attempts + 1"))
+}
+
+// DeleteMFAChallenge removes a challenge and reports whether it still existed, so only one caller can complete it.
+func (r *AuthRepoImpl) DeleteMFAChallenge(ctx context.Context, id int64) (bool, error) {
+	result := r.db.WithContext(ctx).Where("id = ?", id).Delete(&types.MFAChallenge{})
+	if result.Error != nil {
+		zap.L().Error("delete mfa challenge", zap.Int64("id", id), zap.Error(result.Error))
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// SaveRevokedToken stores a jti revocation; repeated revocations keep the first record.
+func (r *AuthRepoImpl) SaveRevokedToken(ctx context.Context, record types.RevokedToken) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&record).Error
+	if err != nil {
+		zap.L().Error("save revoked token", zap.String("jti", record.JTI), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// SaveSubjectRevocation stores or moves forward the revocation cutoff of a subject.
+func (r *AuthRepoImpl) SaveSubjectRevocation(ctx context.Context, record types.SubjectRevocation) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token_type"}, {Name: "subject"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before", "reason", "revoked_by"}),
+	}).Create(&record).Error
+	if err != nil {
+		zap.L().Error("save subject revocation", zap.String("subject", record.Subject), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListRevokedTokens loads jti revocations whose tokens have not expired yet.
+func (r *AuthRepoImpl) ListRevokedTokens(ctx context.Context, expiresAfter time.Time) ([]types.RevokedToken, error) {
+	var records []types.RevokedToken
+	err := r.db.WithContext(ctx).Where("expires_at > ?", expiresAfter).Find(&records).Error
+	if err != nil {
+		zap.L().Error("list revoked tokens", zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// ListSubjectRevocations loads subject revocations that can still affect live tokens.
+func (r *AuthRepoImpl) ListSubjectRevocations(ctx context.Context, revokedAfter time.Time) ([]types.SubjectRevocation, error) {
+	var records []types.SubjectRevocation
+	err := r.db.WithContext(ctx).Where("revoked_before > ?", revokedAfter).Find(&records).Error
+	if err != nil {
+		zap.L().Error("list subject revocations", zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// SaveRefreshToken stores a new refresh token record.
+func (r *AuthRepoImpl) SaveRefreshToken(ctx context.Context, record types.RefreshToken) error {
+	err := r.db.WithContext(ctx).Create(&record).Error
+	if err != nil {
+		zap.L().Error("save refresh token", zap.String("family_id", record.FamilyID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// FindRefreshToken loads a refresh token record by hash.
+func (r *AuthRepoImpl) FindRefreshToken(ctx context.Context, tokenHash string) (types.RefreshToken, error) {
+	var record types.RefreshToken
+	err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&record).Error
+	if err != nil {
+		zap.L().Error("find refresh token", zap.Error(err))
+		return types.RefreshToken{}, err
+	}
+
+	return record, nil
+}
+
+// RotateRefreshToken marks tokenHash as used and stores next in one transaction.
+// It returns ErrRefreshTokenReused when tokenHash was already rotated or revoked.
+func (r *AuthRepoImpl) RotateRefreshToken(ctx context.Context, tokenHash string, next types.RefreshToken) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// conditional update so two concurrent exchanges of the same token cannot both win
+		result := tx.Model(&types.RefreshToken{}).
+			Where("token_hash = ? AND rotated_at IS NULL AND revoked_at IS NULL", tokenHash).
+			Update("rotated_at", next.CreatedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		return tx.Create(&next).Error
+	})
+	if err != nil && !errors.Is(err, ErrRefreshTokenReused) {
+		zap.L().Error("rotate refresh token", zap.String("family_id", next.FamilyID), zap.Error(err))
+	}
+
+	return err
+}
+
+// RevokeRefreshFamily revokes every refresh token of a family and returns the family records.
+func (r *AuthRepoImpl) RevokeRefreshFamily(ctx context.Context, familyID string, revokedAt time.Time) ([]types.RefreshToken, error) {
+	var records []types.RefreshToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&types.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", revokedAt).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("family_id = ?", familyID).Find(&records).Error
+	})
+	if err != nil {
+		zap.L().Error("revoke refresh family", zap.String("family_id", familyID), zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// SaveLockoutEvent appends a lockout audit record.
+func (r *AuthRepoImpl) SaveLockoutEvent(ctx context.Context, event types.LockoutEvent) error {
+	err := r.db.WithContext(ctx).Create(&event).Error
+	if err != nil {
+		zap.L().Error("save lockout event", zap.String("kind", event.Kind), zap.String("action", event.Action), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// SaveIssuedToken stores the record of an issued access token.
+func (r *AuthRepoImpl) SaveIssuedToken(ctx context.Context, record types.IssuedToken) error {
+	err := r.db.WithContext(ctx).Create(&record).Error
+	if err != nil {
+		zap.L().Error("save issued token", zap.String("jti", record.JTI), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// FindIssuedToken loads an issued token record by jti.
+func (r *AuthRepoImpl) FindIssuedToken(ctx context.Context, jti string) (types.IssuedToken, error) {
+	var record types.IssuedToken
+	err := r.db.WithContext(ctx).Where("jti = ?", jti).First(&record).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("find issued token", zap.String("jti", jti), zap.Error(err))
+		}
+		return types.IssuedToken{}, err
+	}
+
+	return record, nil
+}
+
+// ListIssuedTokens loads the tokens of a subject expiring after expiresAfter, newest first.
+func (r *AuthRepoImpl) ListIssuedTokens(ctx context.Context, tokenType string, subject string, expiresAfter time.Time) ([]types.IssuedToken, error) {
+	var records []types.IssuedToken
+	err := r.db.WithContext(ctx).
+		Where("token_type = ? AND subject = ? AND expires_at > ?", tokenType, subject, expiresAfter).
+		Order("issued_at DESC").
+		Find(&records).Error
+	if err != nil {
+		zap.L().Error("list issued tokens", zap.String("token_type", tokenType), zap.String("sub", subject), zap.Error(err))
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// DeleteIssuedTokensExpiredBefore removes records of tokens that expired before cutoff and returns how many were deleted.
+func (r *AuthRepoImpl) DeleteIssuedTokensExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at < ?", cutoff).Delete(&types.IssuedToken{})
+	if result.Error != nil {
+		zap.L().Error("delete expired issued tokens", zap.Time("cutoff", cutoff), zap.Error(result.Error))
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// SaveOIDCLoginState stores a started OIDC login.
+func (r *AuthRepoImpl) SaveOIDCLoginState(ctx context.Context, state types.OIDCLoginState) error {
+	err := r.db.WithContext(ctx).Create(&state).Error
+	if err != nil {
+		zap.L().Error("save oidc login state", zap.String("provider", state.Provider), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeOIDCLoginState loads and deletes a started OIDC login, so a state is only ever used once.
+// It returns gorm.ErrRecordNotFound for unknown or already used states; expiry is left to the caller.
+func (r *AuthRepoImpl) ConsumeOIDCLoginState(ctx context.Context, stateHash string) (types.OIDCLoginState, error) {
+	var state types.OIDCLoginState
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("state_hash = ?", stateHash).First(&state).Error
+		if err != nil {
+			return err
+		}
+
+		// conditional delete so two concurrent callbacks with the same state cannot both win
+		result := tx.Where("state_hash = ?", stateHash).Delete(&types.OIDCLoginState{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("consume oidc login state", zap.Error(err))
+		}
+		return types.OIDCLoginState{}, err
+	}
+
+	return state, nil
+}
+
+// DeleteOIDCLoginStatesExpiredBefore removes abandoned OIDC logins that expired before cutoff.
+func (r *AuthRepoImpl) DeleteOIDCLoginStatesExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at < ?", cutoff).Delete(&types.OIDCLoginState{})
+	if result.Error != nil {
+		zap.L().Error("delete expired oidc login states", zap.Time("cutoff", cutoff), zap.Error(result.Error))
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// SaveEmailToken stores a mailed registration or password reset token.
+func (r *AuthRepoImpl) SaveEmailToken(ctx context.Context, token types.EmailToken) error {
+	err := r.db.WithContext(ctx).Create(&token).Error
+	if err != nil {
+		zap.L().Error("save email token", zap.String("purpose", token.Purpose), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeEmailToken loads and deletes a mailed token of purpose, so it is only ever used once.
+// It returns gorm.ErrRecordNotFound for unknown or already used tokens; expiry is left to the caller.
+func (r *AuthRepoImpl) ConsumeEmailToken(ctx context.Context, tokenHash string, purpose string) (types.EmailToken, error) {
+	var token types.EmailToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("token_hash = ? AND purpose = ?", tokenHash, purpose).First(&token).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Where("token_hash = ?", tokenHash).Delete(&types.EmailToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			zap.L().Error("consume email token", zap.String("purpose", purpose), zap.Error(err))
+		}
+		return types.EmailToken{}, err
+	}
+
+	return token, nil
+}
+
+// DeleteEmailTokens removes all outstanding tokens of purpose mailed to email.
+func (r *AuthRepoImpl) DeleteEmailTokens(ctx context.Context, purpose string, email string) (int64, error) {
+	result := r.db.WithContext(ctx).Where("purpose = ? AND email = ?", purpose, email).Delete(&types.EmailToken{})
+	if result.Error != nil {
+		zap.L().Error("delete email tokens", zap.String("purpose", purpose), zap.Error(result.Error))
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// DeleteEmailTokensExpiredBefore removes unused mailed tokens that expired before cutoff.
+func (r *AuthRepoImpl) DeleteEmailTokensExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at < ?", cutoff).Delete(&types.EmailToken{})
+	if result.Error != nil {
+		zap.L().Error("delete expired email tokens", zap.Time("cutoff", cutoff), zap.Error(result.Error))
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// SyncFederatedUser finds the local user linked to identity, creating it with username on first login,
+// and replaces its roles with roles, the first being the primary role. The user gets no password hash,
+// so it cannot log in with a password. It returns ErrUsernameTaken when a new user's username exists.
+// previous holds the roles a linked user had before, primary first; it is nil for new users.
+func (r *AuthRepoImpl) SyncFederatedUser(ctx context.Context, identity types.UserIdentity, username string, roles []string) (types.UserRecord, []string, error) {
+	if len(roles) == 0 {
+		return types.UserRecord{}, nil, errors.New("federated user without roles")
+	}
+
+	var user types.UserRecord
+	var previous []string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var linked types.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			var count int64
+			err = tx.Model(&types.UserRecord{}).Where("username = ?", username).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrUsernameTaken
+			}
+
+			user = types.UserRecord{Username: username, Role: roles[0]}
+			err = tx.Create(&user).Error
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				// federated users have no email, so only a concurrently created username can collide
+				return ErrUsernameTaken
+			}
+			if err != nil {
+				return err
+			}
+
+			identity.UserID = user.ID
+			identity.CreatedAt = identity.LastLoginAt
+			err = tx.Create(&identity).Error
+		case err != nil:
+			return err
+		default:
+			err = tx.Where("id = ?", linked.UserID).First(&user).Error
+			if err != nil {
+				return err
+			}
+
+			var assigned []string
+			err = tx.Model(&types.RoleAssignment{}).Where("token_type = ? AND subject_id = ?", "user", user.ID).
+				Order("role").Pluck("role", &assigned).Error
+			if err != nil {
+				return err
+			}
+			previous = append([]string{user.Role}, assigned...)
+
+			user.Role = roles[0]
+			err = tx.Model(&types.UserRecord{}).Where("id = ?", user.ID).Update("role", user.Role).Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Model(&types.UserIdentity{}).Where("id = ?", linked.ID).
+				Updates(map[string]any{"email": identity.Email, "last_login_at": identity.LastLoginAt}).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		// roles follow the provider's groups on every login, so removed groups drop their roles
+		err = tx.Where("token_type = ? AND subject_id = ?", "user", user.ID).Delete(&types.RoleAssignment{}).Error
+		if err != nil || len(roles) == 1 {
+			return err
+		}
+
+		assignments := make([]types.RoleAssignment, 0, len(roles)-1)
+		for _, role := range roles[1:] {
+			assignments = append(assignments, types.RoleAssignment{TokenType: "user", SubjectID: user.ID, Role: role})
+		}
+		return tx.Create(&assignments).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrUsernameTaken) {
+			zap.L().Error("sync federated user", zap.String("provider", identity.Provider), zap.String("sub", identity.Subject), zap.Error(err))
+		}
+		return types.UserRecord{}, nil, err
+	}
+
+	return user, previous, nil
+}
+
+// SaveAuthEvent appends an authentication audit record.
+func (r *AuthRepoImpl) SaveAuthEvent(ctx context.Context, event types.AuthEvent) error {
+	err := r.db.WithContext(ctx).Create(&event).Error
+	if err != nil {
+		zap.L().Error("save auth event", zap.String("event_type", event.EventType), zap.String("subject", event.Subject), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListAuthEvents loads audit records matching filter, newest first.
+func (r *AuthRepoImpl) ListAuthEvents(ctx context.Context, filter types.AuthEventFilter) ([]types.AuthEvent, error) {
+	query := r.db.WithContext(ctx).Model(&types.AuthEvent{})
+	for column, value := range map[string]string{
+		"event_type": filter.EventType,
+		"outcome":    filter.Outcome,
+		"token_type": filter.TokenType,
+		"subject":    filter.Subject,
+		"actor":      filter.Actor,
+		"client_ip":  filter.ClientIP,
+		"request_id": filter.RequestID,
+	} {
+		if value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.BeforeID > 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+
+	var events []types.AuthEvent
+	err := query.Order("id DESC").Limit(filter.Limit).Find(&events).Error
+	if err != nil {
+		zap.L().Error("list auth events", zap.Error(err))
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DeleteAuthEventsBefore removes audit records created before cutoff and returns how many were deleted.
+func (r *AuthRepoImpl) DeleteAuthEventsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("created_at < ?", cutoff).Delete(&types.AuthEvent{})
+	if result.Error != nil {
+		zap.L().Error("delete auth events", zap.Time("cutoff", cutoff), zap.Error(result.Error))
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
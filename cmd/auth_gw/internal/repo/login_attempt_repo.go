@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// LoginAttemptRepo defines Redis failed-attempt counters, delays and lockouts.
+type LoginAttemptRepo interface {
+	RetryAfter(ctx context.Context, keys ...types.AttemptKey) (time.Duration, error)
+	RecordFailure(ctx context.Context, key types.AttemptKey, window time.Duration) (int64, error)
+	Delay(ctx context.Context, key types.AttemptKey, delay time.Duration) error
+	Lock(ctx context.Context, key types.AttemptKey, duration time.Duration) (bool, error)
+	Reset(ctx context.Context, key types.AttemptKey) error
+	Unlock(ctx context.Context, key types.AttemptKey) (bool, error)
+}
+
+// LoginAttemptRepoImpl implements LoginAttemptRepo using Redis keys with TTLs.
+type LoginAttemptRepoImpl struct {
+	client *redis.Client
+}
+
+// NewLoginAttemptRepo constructs a LoginAttemptRepo implementation.
+func NewLoginAttemptRepo(client *redis.Client) *LoginAttemptRepoImpl {
+	return &LoginAttemptRepoImpl{client: client}
+}
+
+// RetryAfter returns the longest remaining delay or lockout across keys; zero means not blocked.
+func (r *LoginAttemptRepoImpl) RetryAfter(ctx context.Context, keys ...types.AttemptKey) (time.Duration, error) {
+	pipe := r.client.Pipeline()
+	ttls := make([]*redis.DurationCmd, 0, len(keys)*2)
+	for _, key := range keys {
+		ttls = append(ttls, pipe.PTTL(ctx, attemptLockKey(key)), pipe.PTTL(ctx, attemptWaitKey(key)))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		zap.L().Error("redis login attempt ttl", zap.Error(err))
+		return 0, err
+	}
+
+	var retryAfter time.Duration
+	for _, ttl := range ttls {
+		// missing keys report negative ttls
+		retryAfter = max(retryAfter, ttl.Val())
+	}
+
+	return retryAfter, nil
+}
+
+// RecordFailure counts a failed attempt; the counter expires window after the first failure.
+func (r *LoginAttemptRepoImpl) RecordFailure(ctx context.Context, key types.AttemptKey, window time.Duration) (int64, error) {
+	failKey := attemptFailKey(key)
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, failKey)
+	pipe.ExpireNX(ctx, failKey, window)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		zap.L().Error("redis record login failure", zap.String("key", failKey), zap.Error(err))
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+// Delay blocks further attempts for key until delay has passed.
+func (r *LoginAttemptRepoImpl) Delay(ctx context.Context, key types.AttemptKey, delay time.Duration) error {
+	waitKey := attemptWaitKey(key)
+	err := r.client.Set(ctx, waitKey, "1", delay).Err()
+	if err != nil {
+		zap.L().Error("redis set login delay", zap.String("key", waitKey), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// Lock locks key for duration and reports whether a new lockout started.
+func (r *LoginAttemptRepoImpl) Lock(ctx context.Context, key types.AttemptKey, duration time.Duration) (bool, error) {
+	lockKey := attemptLockKey(key)
+	locked, err := r.client.SetNX(ctx, lockKey, time.Now().UTC().Unix(), duration).Result()
+	if err != nil {
+		zap.L().Error("redis set login lockout", zap.String("key", lockKey), zap.Error(err))
+		return false, err
+	}
+
+	return locked, nil
+}
+
+// Reset clears failures and delays after a successful attempt; lockouts are kept.
+func (r *LoginAttemptRepoImpl) Reset(ctx context.Context, key types.AttemptKey) error {
+	err := r.client.Del(ctx, attemptFailKey(key), attemptWaitKey(key)).Err()
+	if err != nil {
+		zap.L().Error("redis reset login failures", zap.String("kind", key.Kind), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// Unlock clears lockout, failures and delays and reports whether a lockout existed.
+func (r *LoginAttemptRepoImpl) Unlock(ctx context.Context, key types.AttemptKey) (bool, error) {
+	pipe := r.client.TxPipeline()
+	lockDel := pipe.Del(ctx, attemptLockKey(key))
+	pipe.Del(ctx, attemptFailKey(key), attemptWaitKey(key))
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		zap.L().Error("redis unlock login", zap.String("kind", key.Kind), zap.Error(err))
+		return false, err
+	}
+
+	return lockDel.Val() > 0, nil
+}
+
+// attemptFailKey builds redis key for a failed-attempt counter.
+func attemptFailKey(key types.AttemptKey) string {
+	return fmt.Sprintf("login:fail:%s:%s", key.Kind, key.Identifier)
+}
+
+// attemptWaitKey builds redis key for a progressive delay.
+func attemptWaitKey(key types.AttemptKey) string {
+	return fmt.Sprintf("login:wait:%s:%s", key.Kind, key.Identifier)
+}
+
+// attemptLockKey builds redis key for a lockout.
+func attemptLockKey(key types.AttemptKey) string {
+	return fmt.Sprintf("login:lock:%s:%s", key.Kind, key.Identifier)
+}
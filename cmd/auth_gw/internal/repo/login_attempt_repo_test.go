@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestLoginAttemptRepoLockLifecycle verifies failure counting, retry-after, lockout and unlock.
+func TestLoginAttemptRepoLockLifecycle(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	attempts := NewLoginAttemptRepo(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := context.Background()
+	userKey := types.AttemptKey{Kind: types.AttemptKindUser, Identifier: "user_all"}
+	ipKey := types.AttemptKey{Kind: types.AttemptKindIP, Identifier: "192.0.2.1"}
+
+	for i := int64(1); i <= 2; i++ {
+		failures, err := attempts.RecordFailure(ctx, userKey, time.Minute)
+		if err != nil || failures != i {
+			t.Fatalf("expected failure #%d, got %d err=%v", i, failures, err)
+		}
+	}
+	if ttl := mr.TTL("login:fail:user:user_all"); ttl != time.Minute {
+		t.Fatalf("expected failure window kept from first failure, got %s", ttl)
+	}
+
+	retryAfter, err := attempts.RetryAfter(ctx, userKey, ipKey)
+	if err != nil || retryAfter != 0 {
+		t.Fatalf("expected no block yet, got %s err=%v", retryAfter, err)
+	}
+
+	if err = attempts.Delay(ctx, ipKey, 2*time.Second); err != nil {
+		t.Fatalf("delay: %v", err)
+	}
+	locked, err := attempts.Lock(ctx, userKey, 5*time.Minute)
+	if err != nil || !locked {
+		t.Fatalf("expected new lockout, got locked=%v err=%v", locked, err)
+	}
+	locked, err = attempts.Lock(ctx, userKey, 5*time.Minute)
+	if err != nil || locked {
+		t.Fatalf("expected existing lockout not to restart, got locked=%v err=%v", locked, err)
+	}
+
+	retryAfter, err = attempts.RetryAfter(ctx, userKey, ipKey)
+	if err != nil || retryAfter != 5*time.Minute {
+		t.Fatalf("expected longest block of 5m, got %s err=%v", retryAfter, err)
+	}
+
+	// a successful login does not lift a lockout
+	if err = attempts.Reset(ctx, userKey); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if !mr.Exists("login:lock:user:user_all") {
+		t.Fatalf("expected lockout to survive reset")
+	}
+
+	wasLocked, err := attempts.Unlock(ctx, userKey)
+	if err != nil || !wasLocked {
+		t.Fatalf("expected unlock to report lockout, got %v err=%v", wasLocked, err)
+	}
+	retryAfter, err = attempts.RetryAfter(ctx, userKey)
+	if err != nil || retryAfter != 0 {
+		t.Fatalf("expected no block after unlock, got %s err=%v", retryAfter, err)
+	}
+}
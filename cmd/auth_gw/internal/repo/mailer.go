@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+
+	"go.uber.org/zap"
+)
+
+// Mailer sends plain-text email.
+type Mailer interface {
+	Send(ctx context.Context, msg types.MailMessage) error
+}
+
+// NewMailer constructs the Mailer selected by settings.Transport; unknown or empty transports log messages.
+func NewMailer(settings types.MailSettings) Mailer {
+	switch strings.ToLower(settings.Transport) {
+	case "smtp":
+		return NewSMTPMailer(settings)
+	case "file":
+		return NewFileMailer(settings.FilePath)
+	default:
+		return NewLogMailer()
+	}
+}
+
+// SMTPMailer sends email through an SMTP relay, using STARTTLS when offered.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer constructs an SMTPMailer; PLAIN authentication is used when a username is set.
+func NewSMTPMailer(settings types.MailSettings) *SMTPMailer {
+	port := settings.SMTP.Port
+	if port == 0 {
+		port = 587
+	}
+
+	mailer := &SMTPMailer{
+		addr: net.JoinHostPort(settings.SMTP.Host, strconv.Itoa(port)),
+		from: settings.From,
+	}
+	if settings.SMTP.Username != "" {
+		mailer.auth = smtp.PlainAuth("", settings.SMTP.Username, settings.SMTP.Password, settings.SMTP.Host)
+	}
+	return mailer
+}
+
+// Send delivers msg; smtp.SendMail has no context, so ctx only bounds the wait for it.
+func (m *SMTPMailer) Send(ctx context.Context, msg types.MailMessage) error {
+	body := "From: " + headerValue(m.from) + "\r\n" +
+		"To: " + headerValue(msg.To) + "\r\n" +
+		"Subject: " + headerValue(msg.Subject) + "\r\n" +
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(msg.Body, "\n", "\r\n")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(body))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			zap.L().Error("send smtp mail", zap.String("addr", m.addr), zap.Error(err))
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// headerValue drops line breaks, so a value cannot inject further headers.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// FileMailer appends each message as one JSON line to a file, for dev setups and tests.
+type FileMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileMailer constructs a FileMailer writing to path.
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{path: path}
+}
+
+// Send appends msg to the file.
+func (m *FileMailer) Send(_ context.Context, msg types.MailMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		zap.L().Error("open mail file", zap.String("path", m.path), zap.Error(err))
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("write mail file: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes messages to the log instead of sending them. The body carries live tokens, so it is for local setups only.
+type LogMailer struct{}
+
+// NewLogMailer constructs a LogMailer.
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+// Send logs msg.
+func (m *LogMailer) Send(_ context.Context, msg types.MailMessage) error {
+	zap.L().Info("mail", zap.String("to", msg.To), zap.String("subject", msg.Subject), zap.String("body", msg.Body))
+	return nil
+}
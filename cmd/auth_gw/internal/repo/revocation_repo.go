@@ -0,0 +1,167 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// RevocationChannel is the Redis pub/sub channel gateways subscribe to.
+const RevocationChannel = "auth:revocations"
+
+// RevocationRepo defines Redis revocation markers and token activity shared with api_gw.
+type RevocationRepo interface {
+	MarkTokenRevoked(ctx context.Context, jti string, expiresAt time.Time) error
+	MarkSubjectRevoked(ctx context.Context, tokenType string, subject string, revokedBefore time.Time, ttl time.Duration) error
+	IsRevoked(ctx context.Context, jti string, tokenType string, subject string, issuedAt time.Time) (bool, error)
+	PublishRevocation(ctx context.Context, event types.RevocationEvent) error
+	TokensLastSeen(ctx context.Context, jtis []string) (map[string]time.Time, error)
+}
+
+// RevocationRepoImpl implements RevocationRepo using Redis keys and pub/sub.
+type RevocationRepoImpl struct {
+	client *redis.Client
+}
+
+// NewRevocationRepo constructs a RevocationRepo implementation.
+func NewRevocationRepo(client *redis.Client) *RevocationRepoImpl {
+	return &RevocationRepoImpl{client: client}
+}
+
+// MarkTokenRevoked stores a jti marker that lives until the token would expire.
+func (r *RevocationRepoImpl) MarkTokenRevoked(ctx context.Context, jti string, expiresAt time.Time) error {
+	key := revokedTokenKey(jti)
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, key, "1", 0)
+	pipe.ExpireAt(ctx, key, expiresAt)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		zap.L().Error("redis mark token revoked", zap.String("key", key), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// MarkSubjectRevoked stores a subject cutoff; ttl should cover the longest token lifetime.
+func (r *RevocationRepoImpl) MarkSubjectRevoked(ctx context.Context, tokenType string, subject string, revokedBefore time.Time, ttl time.Duration) error {
+	key := revokedSubjectKey(tokenType, subject)
+	err := r.client.Set(ctx, key, formatSubjectCutoff(revokedBefore), ttl).Err()
+	if err != nil {
+		zap.L().Error("redis mark subject revoked", zap.String("key", key), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// IsRevoked checks the jti marker and the subject cutoff against issuedAt.
+func (r *RevocationRepoImpl) IsRevoked(ctx context.Context, jti string, tokenType string, subject string, issuedAt time.Time) (bool, error) {
+	values, err := r.client.MGet(ctx, revokedTokenKey(jti), revokedSubjectKey(tokenType, subject)).Result()
+	if err != nil {
+		zap.L().Error("redis check revocation", zap.String("jti", jti), zap.Error(err))
+		return false, err
+	}
+
+	if values[0] != nil {
+		return true, nil
+	}
+
+	return subjectCutoffCovers(values[1], issuedAt), nil
+}
+
+// PublishRevocation notifies subscribers about a new revocation.
+func (r *RevocationRepoImpl) PublishRevocation(ctx context.Context, event types.RevocationEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		zap.L().Error("marshal revocation event", zap.Error(err))
+		return err
+	}
+
+	err = r.client.Publish(ctx, RevocationChannel, payload).Err()
+	if err != nil {
+		zap.L().Error("redis publish revocation", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// TokensLastSeen reads the last_seen_at api_gw keeps in its token metadata; tokens never seen are left out.
+func (r *RevocationRepoImpl) TokensLastSeen(ctx context.Context, jtis []string) (map[string]time.Time, error) {
+	lastSeen := make(map[string]time.Time, len(jtis))
+	if len(jtis) == 0 {
+		return lastSeen, nil
+	}
+
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(jtis))
+	for i, jti := range jtis {
+		cmds[i] = pipe.HGet(ctx, gatewayTokenKey(jti), "last_seen_at")
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		zap.L().Error("redis read token last_seen_at", zap.Error(err))
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		seenAt, err := time.Parse(time.RFC3339, cmd.Val())
+		if err == nil {
+			lastSeen[jtis[i]] = seenAt
+		}
+	}
+	return lastSeen, nil
+}
+
+// revokedTokenKey builds redis key for a revoked jti.
+func revokedTokenKey(jti string) string {
+	return fmt.Sprintf("revoked:jti:%s", jti)
+}
+
+// revokedSubjectKey builds redis key for a subject revocation cutoff.
+func revokedSubjectKey(tokenType string, subject string) string {
+	return fmt.Sprintf("revoked:sub:%s:%s", tokenType, subject)
+}
+
+// gatewayTokenKey builds redis key of the api_gw token metadata of a jti.
+func gatewayTokenKey(jti string) string {
+	return fmt.Sprintf("token:%s", jti)
+}
+
+// formatSubjectCutoff writes a cutoff as unix seconds with milliseconds, so a token issued
+// right after a revocation, within the same second, is not covered by it.
+func formatSubjectCutoff(revokedBefore time.Time) string {
+	millis := revokedBefore.UTC().UnixMilli()
+	return fmt.Sprintf("%d.%03d", millis/1000, millis%1000)
+}
+
+// subjectCutoffCovers reports whether a token issued at issuedAt falls under the cutoff value.
+// Cutoffs written before millisecond precision are whole seconds and keep covering that whole second.
+func subjectCutoffCovers(raw any, issuedAt time.Time) bool {
+	value, ok := raw.(string)
+	if !ok || value == "" {
+		return false
+	}
+
+	cutoff, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		zap.L().Warn("invalid subject revocation cutoff", zap.String("value", value), zap.Error(err))
+		return false
+	}
+
+	if !strings.Contains(value, ".") {
+		return issuedAt.UTC().Unix() <= int64(cutoff)
+	}
+	return issuedAt.UTC().UnixMilli() <= int64(math.Round(cutoff*1000))
+}
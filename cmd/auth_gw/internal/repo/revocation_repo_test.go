@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestRevocationRepoSubjectCutoffMilliseconds verifies a subject cutoff covers tokens issued before it but not later ones of the same second.
+func TestRevocationRepoSubjectCutoffMilliseconds(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	revocations := NewRevocationRepo(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := context.Background()
+	revokedBefore := time.Date(2026, 10, 18, 12, 0, 0, 500*int(time.Millisecond), time.UTC)
+
+	err = revocations.MarkSubjectRevoked(ctx, "user", "1", revokedBefore, time.Hour)
+	if err != nil {
+		t.Fatalf("mark subject revoked: %v", err)
+	}
+	if value, _ := mr.Get("revoked:sub:user:1"); value != "1792324800.500" {
+		t.Fatalf("expected cutoff with milliseconds, got %q", value)
+	}
+
+	cases := []struct {
+		name     string
+		issuedAt time.Time
+		want     bool
+	}{
+		{name: "earlier second", issuedAt: revokedBefore.Add(-time.Second), want: true},
+		{name: "same second before", issuedAt: revokedBefore.Add(-200 * time.Millisecond), want: true},
+		{name: "at cutoff", issuedAt: revokedBefore, want: true},
+		{name: "same second after", issuedAt: revokedBefore.Add(200 * time.Millisecond), want: false},
+	}
+	for _, tc := range cases {
+		revoked, err := revocations.IsRevoked(ctx, "jti", "user", "1", tc.issuedAt)
+		if err != nil || revoked != tc.want {
+			t.Fatalf("%s: expected revoked=%v, got %v err=%v", tc.name, tc.want, revoked, err)
+		}
+	}
+
+	// cutoffs written before millisecond precision still apply to the whole second
+	mr.Set("revoked:sub:user:1", "1792324800")
+	revoked, err := revocations.IsRevoked(ctx, "jti", "user", "1", revokedBefore.Add(400*time.Millisecond))
+	if err != nil || !revoked {
+		t.Fatalf("expected whole-second cutoff to revoke, got %v err=%v", revoked, err)
+	}
+}
@@ -5,4 +5,159 @@ import cmt "github.com/yirez/go-gw-test/pkg/configuration_manager/types"
 type AppConfig struct {
 	JwtSigningKey   []byte
 	StandardConfigs cmt.StandardConfig
+	AuthSettings    AuthSettings
+}
+
+// AuthSettings captures auth_gw policy settings read from the auth_settings block.
+type AuthSettings struct {
+	AdminRoles         []string              `mapstructure:"admin_roles"`
+	RefreshTokenTTLSec int                   `mapstructure:"refresh_token_ttl_sec"`
+	TokenTTL           TokenTTLSettings      `mapstructure:"token_ttl"`
+	LoginProtection    LoginProtection       `mapstructure:"login_protection"`
+	PasswordPolicy     PasswordPolicy        `mapstructure:"password_policy"`
+	BcryptCost         int                   `mapstructure:"bcrypt_cost"`      // defaults to bcrypt.DefaultCost.
+	PasswordHashing    PasswordHashing       `mapstructure:"password_hashing"` // algorithm of new hashes; user hashes are upgraded at login.
+	RoleScopes         map[string][]string   `mapstructure:"role_scopes"`      // scopes granted to tokens of each role.
+	RoleInheritance    map[string][]string   `mapstructure:"role_inheritance"` // roles each role implies, e.g. admin: [user_all].
+	APIKeys            APIKeySettings        `mapstructure:"api_keys"`
+	MFA                MFASettings           `mapstructure:"mfa"`
+	Audit              AuditSettings         `mapstructure:"audit"`
+	Impersonation      ImpersonationSettings `mapstructure:"impersonation"`
+	JWT                JWTSettings           `mapstructure:"jwt"`
+	OIDC               OIDCSettings          `mapstructure:"oidc"`
+	BrowserSession     BrowserSession        `mapstructure:"browser_session"`
+	SelfService        SelfServiceSettings   `mapstructure:"self_service"`
+	Mail               MailSettings          `mapstructure:"mail"`
+}
+
+// SelfServiceSettings captures registration and password reset by email; zero values use defaults.
+type SelfServiceSettings struct {
+	RegistrationRole    string `mapstructure:"registration_role"`      // primary role of registered users; empty disables /auth/register.
+	VerifyURL           string `mapstructure:"verify_url"`             // page posting the mailed token to /auth/register/verify; ?token= is appended.
+	ResetURL            string `mapstructure:"reset_url"`              // page posting the mailed token to /auth/password/reset; ?token= is appended.
+	VerificationTTLSec  int    `mapstructure:"verification_ttl_sec"`   // lifetime of registration tokens.
+	ResetTTLSec         int    `mapstructure:"reset_ttl_sec"`          // lifetime of password reset tokens.
+	MaxEmailsPerAddress int    `mapstructure:"max_emails_per_address"` // requests per address and window before 429.
+	EmailWindowSec      int    `mapstructure:"email_window_sec"`
+}
+
+// MailSettings selects how auth_gw sends email.
+type MailSettings struct {
+	Transport string       `mapstructure:"transport"` // smtp, file or log (default); file and log are for dev and tests.
+	From      string       `mapstructure:"from"`
+	FilePath  string       `mapstructure:"file_path"` // file transport appends one JSON message per line.
+	SMTP      SMTPSettings `mapstructure:"smtp"`
+}
+
+// SMTPSettings addresses the SMTP relay; without username no authentication is used.
+type SMTPSettings struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// BrowserSession configures the cookies set by logins that ask for session_cookie.
+type BrowserSession struct {
+	Domain          string `mapstructure:"domain"`           // cookie Domain shared with api_gw; empty keeps cookies on the auth_gw host.
+	SameSite        string `mapstructure:"same_site"`        // strict (default), lax or none.
+	InsecureCookies bool   `mapstructure:"insecure_cookies"` // drops the Secure attribute, for plain-http local setups only.
+}
+
+// OIDCSettings captures federated login through external OpenID Connect providers; zero values use defaults.
+type OIDCSettings struct {
+	StateTTLSec int                     `mapstructure:"state_ttl_sec"` // how long a started login may take to reach the callback.
+	Providers   map[string]OIDCProvider `mapstructure:"providers"`     // keyed by the provider name used in /auth/oidc/{provider}/...
+}
+
+// OIDCProvider configures one OpenID Connect identity provider; endpoints come from its discovery document.
+type OIDCProvider struct {
+	Issuer       string              `mapstructure:"issuer"`        // must equal the issuer of the discovery document and ID tokens.
+	ClientID     string              `mapstructure:"client_id"`     // also the expected ID token audience.
+	ClientSecret string              `mapstructure:"client_secret"` // empty for public clients relying on PKCE alone.
+	RedirectURI  string              `mapstructure:"redirect_uri"`  // registered callback, e.g. https://gw.example/auth/oidc/corp/callback.
+	Scopes       []string            `mapstructure:"scopes"`        // defaults to openid email profile.
+	GroupsClaim  string              `mapstructure:"groups_claim"`  // ID token claim holding the user's groups; defaults to groups.
+	GroupRoles   map[string][]string `mapstructure:"group_roles"`   // local roles granted per IdP group, matched case-insensitively.
+	DefaultRoles []string            `mapstructure:"default_roles"` // granted to every user of the provider; without any role login is refused.
+}
+
+// JWTSettings binds access tokens to one deployment; unset fields are neither issued nor checked.
+type JWTSettings struct {
+	Issuer       string              `mapstructure:"issuer"`         // iss claim; tokens of other issuers are refused.
+	Audiences    map[string][]string `mapstructure:"audiences"`      // aud claim per token type; a token must share one of them.
+	ClockSkewSec int                 `mapstructure:"clock_skew_sec"` // leeway on exp, nbf and iat.
+}
+
+// ImpersonationSettings captures impersonation token limits; zero values use defaults.
+type ImpersonationSettings struct {
+	MaxTTLSec      int      `mapstructure:"max_ttl_sec"`     // lifetime of impersonation tokens.
+	ProtectedRoles []string `mapstructure:"protected_roles"` // users holding these roles cannot be impersonated; admin roles always are.
+}
+
+// AuditSettings captures auth_events retention; zero values use defaults.
+type AuditSettings struct {
+	RetentionDays      int `mapstructure:"retention_days"`       // events older than this are deleted; 0 keeps them forever.
+	CleanupIntervalSec int `mapstructure:"cleanup_interval_sec"` // how often the retention job runs.
+}
+
+// MFASettings captures TOTP multi-factor login; zero values use defaults.
+type MFASettings struct {
+	Issuer               string   `mapstructure:"issuer"`         // issuer shown by authenticator apps.
+	RequiredRoles        []string `mapstructure:"required_roles"` // users holding any of these roles must use MFA.
+	ChallengeTTLSec      int      `mapstructure:"challenge_ttl_sec"`
+	MaxChallengeAttempts int      `mapstructure:"max_challenge_attempts"` // wrong codes before a challenge is dropped.
+	RecoveryCodes        int      `mapstructure:"recovery_codes"`         // codes generated on confirmation.
+}
+
+// APIKeySettings captures API key lifetimes; zero values use defaults.
+type APIKeySettings struct {
+	DefaultTTLSec int `mapstructure:"default_ttl_sec"` // lifetime when a key is created without expires_in_sec.
+	MaxTTLSec     int `mapstructure:"max_ttl_sec"`
+}
+
+// PasswordHashing selects the algorithm of new password hashes; older hashes are upgraded at login.
+type PasswordHashing struct {
+	Algorithm string           `mapstructure:"algorithm"` // "bcrypt" (default) or "argon2id".
+	Argon2id  Argon2idSettings `mapstructure:"argon2id"`
+}
+
+// Argon2idSettings captures argon2id cost parameters; zero values use defaults.
+type Argon2idSettings struct {
+	MemoryKiB   int `mapstructure:"memory_kib"`
+	Iterations  int `mapstructure:"iterations"`
+	Parallelism int `mapstructure:"parallelism"`
+	SaltLength  int `mapstructure:"salt_length"`
+	KeyLength   int `mapstructure:"key_length"`
+}
+
+// PasswordPolicy captures rules for new passwords; zero lengths use defaults.
+type PasswordPolicy struct {
+	MinLength     int  `mapstructure:"min_length"`
+	MaxLength     int  `mapstructure:"max_length"` // bcrypt ignores input past 72 bytes, so larger values are capped.
+	RequireUpper  bool `mapstructure:"require_upper"`
+	RequireLower  bool `mapstructure:"require_lower"`
+	RequireDigit  bool `mapstructure:"require_digit"`
+	RequireSymbol bool `mapstructure:"require_symbol"`
+}
+
+// TokenTTLSettings captures access token lifetimes; a role entry wins over a token_type entry.
+type TokenTTLSettings struct {
+	DefaultSec           int            `mapstructure:"default_sec"`
+	MaxSec               int            `mapstructure:"max_sec"`
+	TokenTypes           map[string]int `mapstructure:"token_types"`
+	Roles                map[string]int `mapstructure:"roles"`
+	AllowClientExpiresIn bool           `mapstructure:"allow_client_expires_in"` // clients may request a shorter expires_in.
+}
+
+// LoginProtection captures brute-force throttling for login and service-token; zero values use defaults.
+type LoginProtection struct {
+	MaxFailures        int  `mapstructure:"max_failures"`         // failures per username/service_id before lockout.
+	IPMaxFailures      int  `mapstructure:"ip_max_failures"`      // failures per client IP before lockout.
+	FailureWindowSec   int  `mapstructure:"failure_window_sec"`   // how long failures are remembered.
+	LockoutSec         int  `mapstructure:"lockout_sec"`          // lockout duration.
+	DelayAfterFailures int  `mapstructure:"delay_after_failures"` // failures before progressive delays start.
+	BaseDelayMs        int  `mapstructure:"base_delay_ms"`        // first delay, doubled on every further failure.
+	MaxDelayMs         int  `mapstructure:"max_delay_ms"`
+	TrustForwardedFor  bool `mapstructure:"trust_forwarded_for"` // use X-Forwarded-For as client IP behind a proxy.
 }
@@ -1,16 +1,250 @@
 package types
 
+import "time"
+
 // UserRecord represents a user credential record.
 type UserRecord struct {
 	ID           int64  `gorm:"primaryKey;column:id"`
 	Username     string `gorm:"uniqueIndex;column:username"`
 	PasswordHash string `gorm:"column:password_hash"`
 	Role         string `gorm:"column:role"`
+	Disabled     bool   `gorm:"column:disabled;not null;default:false"`
+	Email        string `gorm:"column:email;not null;default:'';index:idx_user_records_email,unique,where:email <> ''"` // lower-cased; empty for users without one.
 }
 
-// ServiceRecord represents a service credential record.
+// ServiceRecord represents a service identity; its secrets live in service_secrets.
 type ServiceRecord struct {
 	ID         int64  `gorm:"primaryKey;column:id"`
-	SecretHash string `gorm:"column:secret_hash"`
+	Name       string `gorm:"column:name;not null;default:''"`
+	SecretHash string `gorm:"column:secret_hash;not null;default:''"` // legacy single secret, moved to service_secrets on first rotation.
 	Role       string `gorm:"column:role"`
+	Disabled   bool   `gorm:"column:disabled;not null;default:false"`
+}
+
+// RoleAssignment grants an additional role to a user or service next to its primary role.
+type RoleAssignment struct {
+	TokenType string `gorm:"primaryKey;column:token_type"` // user or service.
+	SubjectID int64  `gorm:"primaryKey;column:subject_id"`
+	Role      string `gorm:"primaryKey;column:role"`
+}
+
+// ServiceSecret is one of possibly several active secrets of a service, stored by bcrypt hash only.
+type ServiceSecret struct {
+	ID         int64      `gorm:"primaryKey;column:id"`
+	ServiceID  int64      `gorm:"index;column:service_id"`
+	SecretHash string     `gorm:"column:secret_hash"`
+	CreatedAt  time.Time  `gorm:"column:created_at"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at"` // nil never expires.
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`
+}
+
+// Active reports whether the secret is accepted at now.
+func (s ServiceSecret) Active(now time.Time) bool {
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+// ServiceSigningKey is an HMAC request signing key of a service. HMAC verification needs the raw secret,
+// so unlike service_secrets it is not hashed and the table must be guarded like the secrets themselves.
+type ServiceSigningKey struct {
+	ID         int64      `gorm:"primaryKey;column:id"`
+	KeyID      string     `gorm:"uniqueIndex;column:key_id"` // public id clients send as KeyId.
+	APIKey     string     `gorm:"column:api_key"`            // UUID api_gw keys token metadata and rate limits by.
+	ServiceID  int64      `gorm:"index;column:service_id"`
+	Secret     string     `gorm:"column:secret"`
+	CreatedAt  time.Time  `gorm:"column:created_at"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`
+}
+
+// APIKeyRecord is a long-lived API key of a user or service, stored by SHA-256 hash only.
+type APIKeyRecord struct {
+	ID         int64      `gorm:"primaryKey;column:id"`
+	KeyID      string     `gorm:"uniqueIndex;column:key_id"` // UUID used as api_key by api_gw and as jti by revocations.
+	Name       string     `gorm:"column:name"`
+	Prefix     string     `gorm:"uniqueIndex;column:prefix"` // visible part of the key, used for lookup.
+	KeyHash    string     `gorm:"column:key_hash"`           // hex sha256 of the whole key.
+	TokenType  string     `gorm:"column:token_type"`         // owner kind, user or service.
+	SubjectID  int64      `gorm:"index;column:subject_id"`
+	Roles      string     `gorm:"column:roles"`      // space-delimited roles, primary first.
+	Scope      string     `gorm:"column:scope"`      // space-delimited scopes.
+	RateLimit  int        `gorm:"column:rate_limit"` // requests per second per endpoint; 0 keeps route limits.
+	CreatedBy  string     `gorm:"column:created_by"`
+	CreatedAt  time.Time  `gorm:"column:created_at"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`
+}
+
+// RevokedToken represents a single revoked token (by jti) kept until its expiry.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;column:jti"`
+	Subject   string    `gorm:"column:subject"`
+	TokenType string    `gorm:"column:token_type"`
+	Reason    string    `gorm:"column:reason"`
+	RevokedBy string    `gorm:"column:revoked_by"`
+	RevokedAt time.Time `gorm:"column:revoked_at"`
+	ExpiresAt time.Time `gorm:"index;column:expires_at"`
+}
+
+// SubjectRevocation revokes every token of a subject issued at or before RevokedBefore.
+type SubjectRevocation struct {
+	TokenType     string    `gorm:"primaryKey;column:token_type"`
+	Subject       string    `gorm:"primaryKey;column:subject"`
+	RevokedBefore time.Time `gorm:"column:revoked_before"`
+	Reason        string    `gorm:"column:reason"`
+	RevokedBy     string    `gorm:"column:revoked_by"`
+}
+
+// UserMFA holds the TOTP enrollment of a user. The secret is not hashed because codes are derived from it.
+type UserMFA struct {
+	UserID       int64      `gorm:"primaryKey;autoIncrement:false;column:user_id"`
+	Secret       string     `gorm:"column:secret"`                            // base32 TOTP secret.
+	ConfirmedAt  *time.Time `gorm:"column:confirmed_at"`                      // nil while enrollment waits for its first code.
+	LastUsedStep int64      `gorm:"column:last_used_step;not null;default:0"` // last accepted TOTP time step; codes work once.
+	CreatedAt    time.Time  `gorm:"column:created_at"`
+}
+
+// MFARecoveryCode is a single-use MFA recovery code, stored by hash only.
+type MFARecoveryCode struct {
+	ID       int64      `gorm:"primaryKey;column:id"`
+	UserID   int64      `gorm:"index;column:user_id"`
+	CodeHash string     `gorm:"column:code_hash"` // hex sha256 of the normalized code.
+	UsedAt   *time.Time `gorm:"column:used_at"`
+}
+
+// MFAChallenge is the short-lived second step of a login whose password already checked out, stored by hash only.
+type MFAChallenge struct {
+	ID            int64     `gorm:"primaryKey;column:id"`
+	ChallengeHash string    `gorm:"uniqueIndex;column:challenge_hash"` // hex sha256 of the opaque mfa_token.
+	UserID        int64     `gorm:"index;column:user_id"`
+	ExpiresIn     int       `gorm:"column:expires_in"` // access token lifetime requested at login.
+	Attempts      int       `gorm:"column:attempts;not null;default:0"`
+	CreatedAt     time.Time `gorm:"column:created_at"`
+	ExpiresAt     time.Time `gorm:"column:expires_at"`
+}
+
+// RefreshToken represents one opaque refresh token of a rotation family, stored by hash only.
+type RefreshToken struct {
+	TokenHash       string     `gorm:"primaryKey;column:token_hash"` // hex sha256 of the opaque token.
+	FamilyID        string     `gorm:"index;column:family_id"`
+	Subject         string     `gorm:"column:subject"`
+	TokenType       string     `gorm:"column:token_type"`
+	AccessJTI       string     `gorm:"column:access_jti"` // access token issued together with this refresh token.
+	AccessExpiresAt time.Time  `gorm:"column:access_expires_at"`
+	CreatedAt       time.Time  `gorm:"column:created_at"`
+	ExpiresAt       time.Time  `gorm:"index;column:expires_at"`
+	RotatedAt       *time.Time `gorm:"column:rotated_at"` // set once exchanged; a second use is a reuse.
+	RevokedAt       *time.Time `gorm:"column:revoked_at"`
+}
+
+// IssuedToken records an access token handed out by auth_gw, so outstanding sessions can be listed and revoked.
+type IssuedToken struct {
+	JTI            string    `gorm:"primaryKey;column:jti"`
+	TokenType      string    `gorm:"index:idx_issued_tokens_subject;column:token_type"`
+	Subject        string    `gorm:"index:idx_issued_tokens_subject;column:subject"`
+	Grant          string    `gorm:"column:grant"`     // login, login_mfa, login_oidc, service_token, refresh, password, client_credentials or impersonation.
+	FamilyID       string    `gorm:"column:family_id"` // refresh token family the token was issued with; empty without one.
+	ActorSubject   string    `gorm:"column:act_sub"`   // act claim of impersonation tokens.
+	ActorTokenType string    `gorm:"column:act_token_type"`
+	ClientIP       string    `gorm:"column:client_ip"`
+	UserAgent      string    `gorm:"column:user_agent"`
+	IssuedAt       time.Time `gorm:"column:issued_at"`
+	ExpiresAt      time.Time `gorm:"index;column:expires_at"`
+}
+
+// UserIdentity links a local user to its subject at an OIDC provider; federated users are found by it, never by username.
+type UserIdentity struct {
+	ID          int64     `gorm:"primaryKey;column:id"`
+	Provider    string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;column:provider"`
+	Subject     string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;column:subject"` // sub claim of the provider's ID tokens.
+	UserID      int64     `gorm:"index;column:user_id"`
+	Email       string    `gorm:"column:email"`
+	CreatedAt   time.Time `gorm:"column:created_at"`
+	LastLoginAt time.Time `gorm:"column:last_login_at"`
+}
+
+// OIDCLoginState is a started OIDC login awaiting its callback, stored by hash of the state parameter only.
+type OIDCLoginState struct {
+	StateHash     string    `gorm:"primaryKey;column:state_hash"` // hex sha256 of the state parameter.
+	Provider      string    `gorm:"column:provider"`
+	Nonce         string    `gorm:"column:nonce"`                                 // expected nonce claim of the ID token.
+	CodeVerifier  string    `gorm:"column:code_verifier"`                         // PKCE verifier sent with the code exchange.
+	SessionCookie bool      `gorm:"column:session_cookie;not null;default:false"` // callback answers with session cookies.
+	CreatedAt     time.Time `gorm:"column:created_at"`
+	ExpiresAt     time.Time `gorm:"index;column:expires_at"`
+}
+
+// EmailToken purposes.
+const (
+	EmailTokenRegister      = "register"
+	EmailTokenPasswordReset = "password_reset"
+)
+
+// EmailToken is a single-use token mailed for a registration or password reset, stored by hash only.
+// Registrations are pending here until verified, so no user exists before its address is confirmed.
+type EmailToken struct {
+	TokenHash    string    `gorm:"primaryKey;column:token_hash"` // hex sha256 of the mailed token.
+	Purpose      string    `gorm:"index:idx_email_tokens_email;column:purpose"`
+	Email        string    `gorm:"index:idx_email_tokens_email;column:email"`
+	UserID       int64     `gorm:"column:user_id"`       // account of a password reset.
+	Username     string    `gorm:"column:username"`      // requested username of a registration.
+	PasswordHash string    `gorm:"column:password_hash"` // password of a registration, hashed at request time.
+	CreatedAt    time.Time `gorm:"column:created_at"`
+	ExpiresAt    time.Time `gorm:"index;column:expires_at"`
+}
+
+// Login attempt kinds tracked for brute-force protection.
+const (
+	AttemptKindUser    = "user"
+	AttemptKindService = "service"
+	AttemptKindIP      = "ip"
+	AttemptKindEmail   = "email" // registration and password reset mails per address.
+)
+
+// AttemptKey identifies one failed-attempt counter, e.g. a username or client IP.
+type AttemptKey struct {
+	Kind       string
+	Identifier string
+}
+
+// LockoutEvent audits login lockouts and unlocks.
+type LockoutEvent struct {
+	ID          int64      `gorm:"primaryKey;autoIncrement;column:id"`
+	Kind        string     `gorm:"column:kind"`
+	Identifier  string     `gorm:"index;column:identifier"`
+	Action      string     `gorm:"column:action"` // locked or unlocked.
+	Actor       string     `gorm:"column:actor"`  // system, or token_type:sub of the admin.
+	Failures    int64      `gorm:"column:failures"`
+	ClientIP    string     `gorm:"column:client_ip"`
+	LockedUntil *time.Time `gorm:"column:locked_until"`
+	CreatedAt   time.Time  `gorm:"index;column:created_at"`
+}
+
+// AuthEvent is an append-only audit record of logins, token validation, revocations and admin changes.
+type AuthEvent struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement;column:id"`
+	EventType string    `gorm:"index;column:event_type"` // e.g. login, service_token, validate, token_revoked, user_disabled.
+	Outcome   string    `gorm:"column:outcome"`          // success or failure.
+	TokenType string    `gorm:"column:token_type"`       // user or service, when the subject is known.
+	Subject   string    `gorm:"index;column:subject"`    // user/service id, or the username/service_id presented at login.
+	Actor     string    `gorm:"column:actor"`            // token_type:sub of the caller for admin and self-service changes.
+	Reason    string    `gorm:"column:reason"`
+	ClientIP  string    `gorm:"index;column:client_ip"`
+	UserAgent string    `gorm:"column:user_agent"`
+	RequestID string    `gorm:"column:request_id"`
+	CreatedAt time.Time `gorm:"index;column:created_at"`
+}
+
+// AuthEventFilter narrows an auth_events query; empty fields match everything.
+type AuthEventFilter struct {
+	EventType string
+	Outcome   string
+	TokenType string
+	Subject   string
+	Actor     string
+	ClientIP  string
+	RequestID string
+	Since     time.Time
+	Until     time.Time
+	BeforeID  int64 // keyset cursor: only events with a smaller id.
+	Limit     int
 }
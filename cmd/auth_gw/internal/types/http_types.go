@@ -2,34 +2,453 @@ package types
 
 // LoginRequest captures user login payload.
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	ExpiresIn     int    `json:"expires_in,omitempty"`     // optional shorter access token lifetime in seconds.
+	SessionCookie bool   `json:"session_cookie,omitempty"` // return the token as HttpOnly session cookie instead of in the body.
 }
 
 // LoginResponse captures user login response.
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token         string   `json:"token,omitempty"` // empty when the token went into the session cookie.
+	ExpiresIn     int64    `json:"expires_in"`
+	RefreshToken  string   `json:"refresh_token,omitempty"`  // not issued to OIDC logins and session cookies.
+	CSRFToken     string   `json:"csrf_token,omitempty"`     // send as X-CSRF-Token on unsafe requests made with the session cookie.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // set once, when this login confirmed an MFA enrollment.
+}
+
+// MFAChallengeResponse is returned by login instead of tokens when the user has to pass MFA.
+type MFAChallengeResponse struct {
+	MFARequired bool                   `json:"mfa_required"`
+	MFAToken    string                 `json:"mfa_token"` // single-use, sent to /auth/login/mfa.
+	ExpiresIn   int64                  `json:"expires_in"`
+	Enrollment  *MFAEnrollmentResponse `json:"enrollment,omitempty"` // set when a role requires MFA the user has not enrolled yet.
+}
+
+// LoginMFARequest completes an MFA login with either a TOTP code or a recovery code.
+type LoginMFARequest struct {
+	MFAToken      string `json:"mfa_token"`
+	Code          string `json:"code"`
+	RecoveryCode  string `json:"recovery_code"`
+	SessionCookie bool   `json:"session_cookie,omitempty"` // return the token as HttpOnly session cookie instead of in the body.
+}
+
+// MFACodeRequest carries either a TOTP code or a recovery code.
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAEnrollmentResponse returns the TOTP secret of a pending enrollment.
+type MFAEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI for QR codes.
+}
+
+// MFAConfirmResponse returns the recovery codes of a confirmed enrollment; they are shown only once.
+type MFAConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAStatusResponse describes the MFA state of the calling user.
+type MFAStatusResponse struct {
+	Enabled           bool  `json:"enabled"`
+	Pending           bool  `json:"pending"`  // enrolled but not confirmed with a first code.
+	Required          bool  `json:"required"` // one of the user's roles requires MFA.
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
 }
 
 // ServiceTokenRequest captures service-to-service login payload.
 type ServiceTokenRequest struct {
 	ServiceID string `json:"service_id"`
 	Secret    string `json:"secret"`
+	ExpiresIn int    `json:"expires_in,omitempty"` // optional shorter access token lifetime in seconds.
 }
 
 // ServiceTokenResponse captures service token response.
 type ServiceTokenResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
 }
 
-// ValidateRequest captures token validation payload.
+// RefreshRequest captures refresh token exchange payload.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshResponse captures a rotated token pair.
+type RefreshResponse struct {
+	Token        string `json:"token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutRequest captures logout payload.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"` // optional when a session cookie is sent.
+}
+
+// LogoutResponse reports the invalidated refresh token family.
+type LogoutResponse struct {
+	Status string `json:"status"`
+}
+
+// ValidateRequest captures token validation payload; exactly one of token, api_key or signature is required.
 type ValidateRequest struct {
-	Token string `json:"token"`
+	Token     string               `json:"token"`
+	APIKey    string               `json:"api_key"` // long-lived key from X-API-Key.
+	Signature *SignatureValidation `json:"signature,omitempty"`
+}
+
+// SignatureValidation carries a signed request for verification; the caller has already checked timestamp and nonce.
+type SignatureValidation struct {
+	KeyID        string `json:"key_id"`
+	StringToSign string `json:"string_to_sign"` // canonical request rebuilt by the caller.
+	Signature    string `json:"signature"`      // hex HMAC-SHA256.
 }
 
 // ValidateResponse captures token metadata for gateway checks.
 type ValidateResponse struct {
-	APIKey    string `json:"api_key"` // UUID from JWT jti.
+	APIKey    string      `json:"api_key"` // UUID from JWT jti.
+	Subject   string      `json:"sub"`
+	TokenType string      `json:"token_type"`
+	Role      string      `json:"role"`  // primary role.
+	Roles     []string    `json:"roles"` // effective roles: primary, assigned and inherited.
+	Scope     string      `json:"scope"` // space-delimited scopes from the JWT scope claim.
+	IssuedAt  string      `json:"issued_at"`
+	ExpiresAt string      `json:"expires_at"`
+	RateLimit int         `json:"rate_limit,omitempty"` // per-key limit of API keys; 0 keeps route limits.
+	Actor     *ActorClaim `json:"act,omitempty"`        // set on impersonation tokens.
+}
+
+// ActorClaim is the RFC 8693 "act" claim: the principal acting on behalf of the token subject.
+type ActorClaim struct {
+	Subject   string `json:"sub"`
+	TokenType string `json:"token_type"`
+}
+
+// RevokeRequest captures token revocation payload; exactly one of token, jti or subject is required.
+type RevokeRequest struct {
+	Token     string `json:"token"`
+	JTI       string `json:"jti"`
+	Subject   string `json:"subject"`
+	TokenType string `json:"token_type"` // subject token type, defaults to user.
+	Reason    string `json:"reason"`
+}
+
+// RevokeResponse reports what was revoked.
+type RevokeResponse struct {
+	Revoked       string `json:"revoked"` // token or subject.
+	JTI           string `json:"jti,omitempty"`
+	Subject       string `json:"subject,omitempty"`
+	TokenType     string `json:"token_type,omitempty"`
+	RevokedBefore string `json:"revoked_before,omitempty"`
+}
+
+// RevocationEvent is published on Redis pub/sub so gateways can drop cached state.
+type RevocationEvent struct {
+	Type          string `json:"type"` // token or subject.
+	JTI           string `json:"jti,omitempty"`
+	Subject       string `json:"subject,omitempty"`
+	TokenType     string `json:"token_type,omitempty"`
+	RevokedBefore int64  `json:"revoked_before,omitempty"`
+	ExpiresAt     int64  `json:"expires_at,omitempty"`
+}
+
+// UnlockRequest captures an admin unlock of a username, service_id or client IP.
+type UnlockRequest struct {
+	Kind       string `json:"kind"` // user, service or ip.
+	Identifier string `json:"identifier"`
+}
+
+// UnlockResponse reports whether a lockout was lifted.
+type UnlockResponse struct {
+	Kind       string `json:"kind"`
+	Identifier string `json:"identifier"`
+	WasLocked  bool   `json:"was_locked"`
+}
+
+// CreateUserRequest captures admin user creation payload.
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// UserResponse exposes a user record without its password hash.
+type UserResponse struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+// UsersResponse lists users.
+type UsersResponse struct {
+	Users []UserResponse `json:"users"`
+}
+
+// DisableRequest captures optional flags for disabling a user or service; the body may be empty.
+type DisableRequest struct {
+	RevokeTokens bool   `json:"revoke_tokens"`
+	Reason       string `json:"reason"`
+}
+
+// ChangePasswordRequest captures self-service password change payload.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// StatusResponse reports the outcome of a state-changing call.
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// RegisterRequest captures a self-service registration.
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// EmailTokenRequest carries a token mailed by /auth/register.
+type EmailTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// ForgotPasswordRequest asks for a password reset mail.
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest sets a new password with a token mailed by /auth/password/forgot.
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// MailMessage is a plain-text email.
+type MailMessage struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// CreateServiceRequest captures admin service registration payload.
+type CreateServiceRequest struct {
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	ExpiresInSec int64  `json:"expires_in_sec"` // secret lifetime; 0 never expires.
+}
+
+// RotateServiceSecretRequest captures a new service secret; existing secrets expire after retire_existing_in_sec when set.
+type RotateServiceSecretRequest struct {
+	ExpiresInSec        int64  `json:"expires_in_sec"` // new secret lifetime; 0 never expires.
+	RetireExistingInSec *int64 `json:"retire_existing_in_sec"`
+}
+
+// ServiceCredentialsResponse returns a generated secret; it is shown only once.
+type ServiceCredentialsResponse struct {
+	ServiceID string `json:"service_id"`
+	Name      string `json:"name"`
 	Role      string `json:"role"`
+	SecretID  int64  `json:"secret_id"`
+	Secret    string `json:"secret"`
 	ExpiresAt string `json:"expires_at"`
 }
+
+// ServiceSecretResponse exposes secret metadata without the hash.
+type ServiceSecretResponse struct {
+	ID         int64  `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	Active     bool   `json:"active"`
+}
+
+// CreateSigningKeyRequest captures an optional signing key lifetime; the body may be empty.
+type CreateSigningKeyRequest struct {
+	ExpiresInSec int64 `json:"expires_in_sec"` // 0 uses auth_settings.api_keys.default_ttl_sec.
+}
+
+// SigningKeyCredentialsResponse returns a generated signing key; the secret is shown only once.
+type SigningKeyCredentialsResponse struct {
+	ServiceID string `json:"service_id"`
+	KeyID     string `json:"key_id"`
+	Secret    string `json:"secret"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// SigningKeyResponse exposes signing key metadata without the secret.
+type SigningKeyResponse struct {
+	KeyID      string `json:"key_id"`
+	CreatedAt  string `json:"created_at"`
+	ExpiresAt  string `json:"expires_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	Active     bool   `json:"active"`
+}
+
+// ServiceResponse exposes a service and its secret metadata.
+type ServiceResponse struct {
+	ID           int64                   `json:"id"`
+	Name         string                  `json:"name"`
+	Role         string                  `json:"role"`
+	Disabled     bool                    `json:"disabled"`
+	LegacySecret bool                    `json:"legacy_secret"` // single secret from service_records still in use.
+	Secrets      []ServiceSecretResponse `json:"secrets"`
+	SigningKeys  []SigningKeyResponse    `json:"signing_keys"`
+}
+
+// ServicesResponse lists services.
+type ServicesResponse struct {
+	Services []ServiceResponse `json:"services"`
+}
+
+// OAuthTokenResponse captures an RFC 6749 access token response.
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// OAuthErrorResponse captures an RFC 6749 token error response.
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// IntrospectionResponse captures an RFC 7662 token introspection response; inactive tokens only carry active.
+type IntrospectionResponse struct {
+	Active      bool        `json:"active"`
+	Subject     string      `json:"sub,omitempty"`
+	Scope       string      `json:"scope,omitempty"`
+	ExpiresAt   int64       `json:"exp,omitempty"`
+	IssuedAt    int64       `json:"iat,omitempty"`
+	JTI         string      `json:"jti,omitempty"`
+	TokenType   string      `json:"token_type,omitempty"`
+	ClientID    string      `json:"client_id,omitempty"`
+	SubjectType string      `json:"subject_type,omitempty"` // user or service, the token_type claim of the JWT.
+	Role        string      `json:"role,omitempty"`
+	Roles       []string    `json:"roles,omitempty"`
+	Actor       *ActorClaim `json:"act,omitempty"` // RFC 8693 actor of impersonation tokens.
+}
+
+// RolesRequest replaces the additional roles of a user or service.
+type RolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// RolesResponse describes the roles of a user or service.
+type RolesResponse struct {
+	ID             int64    `json:"id"`
+	Role           string   `json:"role"`            // primary role.
+	Roles          []string `json:"roles"`           // additional assigned roles.
+	EffectiveRoles []string `json:"effective_roles"` // primary, assigned and inherited roles.
+}
+
+// CreateAPIKeyRequest captures admin API key creation payload.
+type CreateAPIKeyRequest struct {
+	Name         string   `json:"name"`
+	TokenType    string   `json:"token_type"` // owner kind, user (default) or service.
+	SubjectID    int64    `json:"subject_id"`
+	Roles        []string `json:"roles"` // subset of the owner's effective roles; empty takes all of them.
+	Scope        string   `json:"scope"` // space-delimited subset of the roles' scopes; empty takes all of them.
+	RateLimit    int      `json:"rate_limit"`
+	ExpiresInSec int64    `json:"expires_in_sec"` // 0 uses auth_settings.api_keys.default_ttl_sec.
+}
+
+// APIKeyResponse exposes API key metadata without the key or its hash.
+type APIKeyResponse struct {
+	ID         int64    `json:"id"`
+	APIKey     string   `json:"api_key"` // UUID api_gw keys token metadata and rate limits by.
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	TokenType  string   `json:"token_type"`
+	SubjectID  int64    `json:"subject_id"`
+	Roles      []string `json:"roles"`
+	Scope      string   `json:"scope"`
+	RateLimit  int      `json:"rate_limit"`
+	CreatedBy  string   `json:"created_by"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	Active     bool     `json:"active"`
+}
+
+// APIKeyCreatedResponse returns a generated API key; it is shown only once.
+type APIKeyCreatedResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+// APIKeysResponse lists API keys.
+type APIKeysResponse struct {
+	APIKeys []APIKeyResponse `json:"api_keys"`
+}
+
+// AuthEventResponse exposes one auth_events record.
+type AuthEventResponse struct {
+	ID        int64  `json:"id"`
+	EventType string `json:"event_type"`
+	Outcome   string `json:"outcome"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	Actor     string `json:"actor,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	ClientIP  string `json:"client_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// AuthEventsResponse is one page of auth events, newest first.
+type AuthEventsResponse struct {
+	Events       []AuthEventResponse `json:"events"`
+	NextBeforeID int64               `json:"next_before_id,omitempty"` // pass as before_id for the next page; absent on the last page.
+}
+
+// ImpersonateRequest asks for a short-lived token of a user on behalf of the caller.
+type ImpersonateRequest struct {
+	UserID    int64  `json:"user_id"`
+	Reason    string `json:"reason"`               // e.g. the support ticket; recorded in auth_events.
+	ExpiresIn int64  `json:"expires_in,omitempty"` // optional shorter lifetime in seconds.
+}
+
+// ImpersonateResponse returns an impersonation token; it has no refresh token.
+type ImpersonateResponse struct {
+	Token     string     `json:"token"`
+	ExpiresIn int64      `json:"expires_in"`
+	Subject   string     `json:"sub"`
+	Actor     ActorClaim `json:"act"`
+}
+
+// SessionResponse exposes one outstanding access token of a subject.
+type SessionResponse struct {
+	JTI        string      `json:"jti"` // also the api_key api_gw keys token metadata by.
+	TokenType  string      `json:"token_type"`
+	Subject    string      `json:"sub"`
+	Grant      string      `json:"grant"`
+	Actor      *ActorClaim `json:"act,omitempty"` // set on impersonation tokens.
+	ClientIP   string      `json:"client_ip,omitempty"`
+	UserAgent  string      `json:"user_agent,omitempty"`
+	IssuedAt   string      `json:"issued_at"`
+	ExpiresAt  string      `json:"expires_at"`
+	LastSeenAt string      `json:"last_seen_at,omitempty"` // last request through api_gw, at minute resolution.
+	Current    bool        `json:"current"`                // the token of this request.
+}
+
+// SessionsResponse lists outstanding access tokens, newest first.
+type SessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// SessionRevokeResponse confirms a revoked session.
+type SessionRevokeResponse struct {
+	Revoked string `json:"revoked"`
+	JTI     string `json:"jti"`
+}
@@ -0,0 +1,444 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeyPrefix marks API keys so they are recognizable in configs and secret scanners.
+	apiKeyPrefix = "gwk_"
+	// apiKeyTouchInterval limits last_used_at writes for busy API keys.
+	apiKeyTouchInterval = time.Minute
+
+	defaultAPIKeyTTL    = 90 * 24 * time.Hour
+	defaultAPIKeyMaxTTL = 365 * 24 * time.Hour
+)
+
+var (
+	errInvalidAPIKey = errors.New("invalid api key")
+	errAPIKeyExpired = errors.New("api key expired")
+)
+
+// CreateAPIKey issues a named API key for a user or service.
+// @Summary Create API key
+// @Description Issues a long-lived API key for a user or service, sent to api_gw in X-API-Key. Roles and scope default to everything the owner holds and may only narrow it. The key is shown only once. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.CreateAPIKeyRequest true "API key payload"
+// @Success 201 {object} types.APIKeyCreatedResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/api-keys [post]
+func (u *AuthUseCaseImpl) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req types.CreateAPIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode create api key request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.TokenType == "" {
+		req.TokenType = "user"
+	}
+	if req.Name == "" || req.SubjectID <= 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "name and subject_id are required"})
+		return
+	}
+	if req.TokenType != "user" && req.TokenType != "service" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid token_type"})
+		return
+	}
+	if req.RateLimit < 0 || req.ExpiresInSec < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "rate_limit and expires_in_sec must not be negative"})
+		return
+	}
+
+	ttl, err := u.apiKeyTTL(req.ExpiresInSec)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	ownerRoles, err := u.currentRoles(ctx, req.TokenType, fmt.Sprint(req.SubjectID))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "owner not found"})
+		return
+	case errors.Is(err, errUserDisabled), errors.Is(err, errServiceDisabled):
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "owner is disabled"})
+		return
+	case err != nil:
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create api key failed"})
+		return
+	}
+
+	roles, err := narrowRoles(ownerRoles, req.Roles)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	scope, err := u.narrowScope(roles, req.Scope)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		zap.L().Error("generate api key", zap.Error(err))
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create api key failed"})
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	record, err := u.repo.CreateAPIKey(ctx, types.APIKeyRecord{
+		KeyID:     uuid.NewString(),
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(key),
+		TokenType: req.TokenType,
+		SubjectID: req.SubjectID,
+		Roles:     strings.Join(roles, " "),
+		Scope:     scope,
+		RateLimit: req.RateLimit,
+		CreatedBy: principalRef(principal),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create api key failed"})
+		return
+	}
+
+	zap.L().Info("api key created",
+		zap.Int64("id", record.ID),
+		zap.String("prefix", record.Prefix),
+		zap.String("token_type", record.TokenType),
+		zap.Int64("subject_id", record.SubjectID),
+		zap.Time("expires_at", record.ExpiresAt),
+		zap.String("by", record.CreatedBy),
+	)
+	u.audit(ctx, types.AuthEvent{
+		EventType: "api_key_created",
+		Outcome:   auditOutcomeSuccess,
+		TokenType: record.TokenType,
+		Subject:   fmt.Sprint(record.SubjectID),
+		Actor:     record.CreatedBy,
+		Reason:    "prefix " + record.Prefix,
+	})
+	utils.WriteJSON(w, http.StatusCreated, types.APIKeyCreatedResponse{APIKeyResponse: apiKeyResponse(record, now), Key: key})
+}
+
+// ListAPIKeys lists API keys without the keys themselves.
+// @Summary List API keys
+// @Description Lists API keys with owner, roles, scope, rate limit, expiry and last use. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} types.APIKeysResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/api-keys [get]
+func (u *AuthUseCaseImpl) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if _, ok := u.requireAdmin(w, r); !ok {
+		return
+	}
+
+	records, err := u.repo.ListAPIKeys(r.Context())
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "list api keys failed"})
+		return
+	}
+
+	now := time.Now().UTC()
+	keys := make([]types.APIKeyResponse, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, apiKeyResponse(record, now))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.APIKeysResponse{APIKeys: keys})
+}
+
+// DeleteAPIKey deletes an API key and revokes it at the gateways.
+// @Summary Delete API key
+// @Description Deletes an API key. Its api_key is revoked as well, so api_gw drops cached validations right away. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/api-keys/{id} [delete]
+func (u *AuthUseCaseImpl) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := idFromPath(w, r, "id", "invalid api key id")
+	if !ok {
+		return
+	}
+
+	record, err := u.repo.DeleteAPIKey(ctx, id)
+	if !writeUpdateError(w, err, "api key not found", "delete api key failed") {
+		return
+	}
+
+	if time.Now().Before(record.ExpiresAt) {
+		err = u.revokeTokenCore(ctx, types.RevokedToken{
+			JTI:       record.KeyID,
+			Subject:   fmt.Sprint(record.SubjectID),
+			TokenType: record.TokenType,
+			Reason:    "api key deleted",
+			RevokedBy: principalRef(principal),
+			ExpiresAt: record.ExpiresAt,
+		})
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation failed"})
+			return
+		}
+	}
+
+	zap.L().Info("api key deleted", zap.Int64("id", record.ID), zap.String("prefix", record.Prefix), zap.String("by", principalRef(principal)))
+	u.auditChange(r.Context(), "api_key_deleted", principal, record.TokenType, record.SubjectID, "prefix "+record.Prefix)
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "deleted"})
+}
+
+// validateAPIKeyCore verifies an API key and maps it to the same metadata as a validated JWT.
+// The key never holds more than its owner currently does, so disabling the owner or removing a role applies right away.
+func (u *AuthUseCaseImpl) validateAPIKeyCore(ctx context.Context, key string) (types.ValidateResponse, error) {
+	prefix, ok := apiKeyLookupPrefix(key)
+	if !ok {
+		return types.ValidateResponse{}, errInvalidAPIKey
+	}
+
+	record, err := u.repo.FindAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return types.ValidateResponse{}, errInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(record.KeyHash)) != 1 {
+		zap.L().Warn("api key hash mismatch", zap.String("prefix", prefix))
+		return types.ValidateResponse{}, errInvalidAPIKey
+	}
+
+	now := time.Now().UTC()
+	if !now.Before(record.ExpiresAt) {
+		return types.ValidateResponse{}, errAPIKeyExpired
+	}
+
+	subject := fmt.Sprint(record.SubjectID)
+	ownerRoles, err := u.currentRoles(ctx, record.TokenType, subject)
+	if err != nil {
+		zap.L().Warn("api key owner refused", zap.String("prefix", prefix), zap.String("sub", subject), zap.Error(err))
+		return types.ValidateResponse{}, errInvalidAPIKey
+	}
+
+	roles := make([]string, 0)
+	for _, role := range strings.Fields(record.Roles) {
+		if slices.Contains(ownerRoles, role) {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		zap.L().Warn("api key owner lost every key role", zap.String("prefix", prefix), zap.String("sub", subject))
+		return types.ValidateResponse{}, errInvalidAPIKey
+	}
+
+	granted := u.scopesFor(roles)
+	scopes := make([]string, 0)
+	for _, scope := range strings.Fields(record.Scope) {
+		if slices.Contains(granted, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	revoked, err := u.revocations.IsRevoked(ctx, record.KeyID, record.TokenType, subject, record.CreatedAt)
+	if err != nil {
+		return types.ValidateResponse{}, errors.New("revocation check failed")
+	}
+	if revoked {
+		zap.L().Warn("revoked api key presented", zap.String("prefix", prefix), zap.String("sub", subject))
+		return types.ValidateResponse{}, errTokenRevoked
+	}
+
+	u.touchAPIKey(ctx, record, now)
+	return types.ValidateResponse{
+		APIKey:    record.KeyID,
+		Subject:   subject,
+		TokenType: record.TokenType,
+		Role:      roles[0],
+		Roles:     roles,
+		Scope:     strings.Join(scopes, " "),
+		IssuedAt:  record.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ExpiresAt: record.ExpiresAt.UTC().Format(time.RFC3339),
+		RateLimit: record.RateLimit,
+	}, nil
+}
+
+// touchAPIKey records key use, at most once per apiKeyTouchInterval; failures are only logged.
+func (u *AuthUseCaseImpl) touchAPIKey(ctx context.Context, record types.APIKeyRecord, now time.Time) {
+	if record.LastUsedAt != nil && now.Sub(*record.LastUsedAt) < apiKeyTouchInterval {
+		return
+	}
+
+	err := u.repo.TouchAPIKey(ctx, record.ID, now)
+	if err != nil {
+		zap.L().Warn("touch api key", zap.Int64("id", record.ID), zap.Error(err))
+	}
+}
+
+// apiKeyTTL resolves the lifetime of a new key from the request and auth_settings.api_keys.
+func (u *AuthUseCaseImpl) apiKeyTTL(expiresInSec int64) (time.Duration, error) {
+	maxTTL := secondsOrDefault(u.settings.APIKeys.MaxTTLSec, defaultAPIKeyMaxTTL)
+	ttl := min(secondsOrDefault(u.settings.APIKeys.DefaultTTLSec, defaultAPIKeyTTL), maxTTL)
+	if expiresInSec > 0 {
+		ttl = time.Duration(expiresInSec) * time.Second
+	}
+
+	if ttl > maxTTL {
+		return 0, fmt.Errorf("expires_in_sec must be at most %d", int64(maxTTL.Seconds()))
+	}
+	return ttl, nil
+}
+
+// narrowScope returns the requested scopes, or every scope of roles when none are requested.
+func (u *AuthUseCaseImpl) narrowScope(roles []string, requested string) (string, error) {
+	granted := u.scopesFor(roles)
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(granted, " "), nil
+	}
+
+	scopes := make([]string, 0)
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(granted, scope) {
+			return "", fmt.Errorf("scope %q is not granted", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// narrowRoles returns the requested roles in the owner's order, or every owner role when none are requested.
+func narrowRoles(ownerRoles []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return ownerRoles, nil
+	}
+
+	normalized, err := normalizeRoles(requested)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]string, 0, len(normalized))
+	for _, role := range ownerRoles {
+		if slices.Contains(normalized, role) {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) != len(normalized) {
+		return nil, errors.New("roles must be held by the owner")
+	}
+	return roles, nil
+}
+
+// newAPIKey generates a key of the form gwk_<12 hex>_<secret> and returns it with its visible prefix.
+func newAPIKey() (string, string, error) {
+	buf := make([]byte, 6)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := apiKeyPrefix + hex.EncodeToString(buf)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// apiKeyLookupPrefix extracts the visible prefix of a well-formed API key.
+func apiKeyLookupPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+
+	// the hex part has no underscore, the base64url secret may
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", false
+	}
+	return apiKeyPrefix + id, true
+}
+
+// hashAPIKey returns the stored form of an API key.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyResponse maps API key metadata to its API form.
+func apiKeyResponse(record types.APIKeyRecord, now time.Time) types.APIKeyResponse {
+	resp := types.APIKeyResponse{
+		ID:        record.ID,
+		APIKey:    record.KeyID,
+		Name:      record.Name,
+		Prefix:    record.Prefix,
+		TokenType: record.TokenType,
+		SubjectID: record.SubjectID,
+		Roles:     strings.Fields(record.Roles),
+		Scope:     record.Scope,
+		RateLimit: record.RateLimit,
+		CreatedBy: record.CreatedBy,
+		CreatedAt: record.CreatedAt.UTC().Format(time.RFC3339),
+		ExpiresAt: record.ExpiresAt.UTC().Format(time.RFC3339),
+		Active:    now.Before(record.ExpiresAt),
+	}
+	if record.LastUsedAt != nil {
+		resp.LastUsedAt = record.LastUsedAt.UTC().Format(time.RFC3339)
+	}
+	return resp
+}
@@ -0,0 +1,242 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+
+	defaultAuditPageSize        = 50
+	maxAuditPageSize            = 500
+	defaultAuditCleanupInterval = time.Hour
+	// auditUserAgentMaxLength keeps oversized User-Agent headers out of auth_events.
+	auditUserAgentMaxLength = 512
+)
+
+// auditSource is the request context recorded with every auth event.
+type auditSource struct {
+	clientIP  string
+	userAgent string
+	requestID string
+}
+
+// AuditMiddleware keeps client IP, user agent and request id in the context, so auth events deep in a flow can record them.
+// It must run after the request id middleware.
+func (u *AuthUseCaseImpl) AuditMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userAgent := r.UserAgent()
+			if len(userAgent) > auditUserAgentMaxLength {
+				userAgent = userAgent[:auditUserAgentMaxLength]
+			}
+
+			ctx := context.WithValue(r.Context(), ctxKeyAuditSource, auditSource{
+				clientIP:  clientIP(r, u.protection.TrustForwardedFor),
+				userAgent: userAgent,
+				requestID: r.Header.Get("X-Request-Id"),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ListAuthEvents returns auth_events newest first, filtered by query parameters.
+// @Summary List auth events
+// @Description Lists audit records of logins, service tokens, validation failures, revocations and admin changes, newest first. Pages are keyset based: pass next_before_id as before_id to get older events. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param event_type query string false "Event type, e.g. login or user_disabled"
+// @Param outcome query string false "success or failure"
+// @Param token_type query string false "user or service"
+// @Param subject query string false "User/service id, or the username/service_id presented at login"
+// @Param actor query string false "token_type:sub of the caller"
+// @Param client_ip query string false "Client IP"
+// @Param request_id query string false "X-Request-Id of the request"
+// @Param since query string false "RFC3339 lower bound of created_at (inclusive)"
+// @Param until query string false "RFC3339 upper bound of created_at (exclusive)"
+// @Param before_id query int false "Only events with a smaller id"
+// @Param limit query int false "Page size, default 50, max 500"
+// @Success 200 {object} types.AuthEventsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/audit-events [get]
+func (u *AuthUseCaseImpl) ListAuthEvents(w http.ResponseWriter, r *http.Request) {
+	_, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := authEventFilterFromQuery(r.URL.Query())
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// one extra row tells whether an older page exists
+	pageSize := filter.Limit
+	filter.Limit++
+	events, err := u.repo.ListAuthEvents(r.Context(), filter)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "list auth events failed"})
+		return
+	}
+
+	resp := types.AuthEventsResponse{Events: make([]types.AuthEventResponse, 0, min(len(events), pageSize))}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		resp.NextBeforeID = events[pageSize-1].ID
+	}
+	for _, event := range events {
+		resp.Events = append(resp.Events, authEventResponse(event))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// RunAuditRetention deletes auth events older than audit.retention_days until ctx is done.
+// It returns right away when retention is disabled.
+func (u *AuthUseCaseImpl) RunAuditRetention(ctx context.Context) {
+	if u.settings.Audit.RetentionDays <= 0 {
+		return
+	}
+
+	interval := time.Duration(u.settings.Audit.CleanupIntervalSec) * time.Second
+	if interval <= 0 {
+		interval = defaultAuditCleanupInterval
+	}
+
+	for {
+		_, _ = u.pruneAuthEvents(ctx, time.Now().UTC())
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// pruneAuthEvents deletes auth events that fell out of the retention window ending at now.
+func (u *AuthUseCaseImpl) pruneAuthEvents(ctx context.Context, now time.Time) (int64, error) {
+	cutoff := now.AddDate(0, 0, -u.settings.Audit.RetentionDays)
+	deleted, err := u.repo.DeleteAuthEventsBefore(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	if deleted > 0 {
+		zap.L().Info("auth events pruned", zap.Int64("deleted", deleted), zap.Time("cutoff", cutoff))
+	}
+	return deleted, nil
+}
+
+// audit appends an auth event with the request context of ctx; failures are logged but never block the flow.
+func (u *AuthUseCaseImpl) audit(ctx context.Context, event types.AuthEvent) {
+	if source, ok := ctx.Value(ctxKeyAuditSource).(auditSource); ok {
+		event.ClientIP = source.clientIP
+		event.UserAgent = source.userAgent
+		event.RequestID = source.requestID
+	}
+	event.CreatedAt = time.Now().UTC()
+
+	err := u.repo.SaveAuthEvent(ctx, event)
+	if err != nil {
+		zap.L().Error("auth event write failed",
+			zap.String("event_type", event.EventType),
+			zap.String("outcome", event.Outcome),
+			zap.String("subject", event.Subject),
+			zap.String("request_id", event.RequestID),
+			zap.Error(err),
+		)
+	}
+}
+
+// auditChange records a successful admin or self-service change of a user or service.
+func (u *AuthUseCaseImpl) auditChange(ctx context.Context, eventType string, actor types.ValidateResponse, tokenType string, subject int64, reason string) {
+	u.audit(ctx, types.AuthEvent{
+		EventType: eventType,
+		Outcome:   auditOutcomeSuccess,
+		TokenType: tokenType,
+		Subject:   fmt.Sprint(subject),
+		Actor:     principalRef(actor),
+		Reason:    reason,
+	})
+}
+
+// authEventFilterFromQuery parses ListAuthEvents query parameters.
+func authEventFilterFromQuery(query url.Values) (types.AuthEventFilter, error) {
+	filter := types.AuthEventFilter{
+		EventType: query.Get("event_type"),
+		Outcome:   query.Get("outcome"),
+		TokenType: query.Get("token_type"),
+		Subject:   query.Get("subject"),
+		Actor:     query.Get("actor"),
+		ClientIP:  query.Get("client_ip"),
+		RequestID: query.Get("request_id"),
+		Limit:     defaultAuditPageSize,
+	}
+
+	var err error
+	if raw := query.Get("since"); raw != "" {
+		filter.Since, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return types.AuthEventFilter{}, errors.New("since must be an RFC3339 time")
+		}
+	}
+	if raw := query.Get("until"); raw != "" {
+		filter.Until, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return types.AuthEventFilter{}, errors.New("until must be an RFC3339 time")
+		}
+	}
+	if raw := query.Get("before_id"); raw != "" {
+		filter.BeforeID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || filter.BeforeID <= 0 {
+			return types.AuthEventFilter{}, errors.New("invalid before_id")
+		}
+	}
+	if raw := query.Get("limit"); raw != "" {
+		filter.Limit, err = strconv.Atoi(raw)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxAuditPageSize {
+			return types.AuthEventFilter{}, fmt.Errorf("limit must be between 1 and %d", maxAuditPageSize)
+		}
+	}
+
+	return filter, nil
+}
+
+// authEventResponse maps an auth event to its API form.
+func authEventResponse(event types.AuthEvent) types.AuthEventResponse {
+	return types.AuthEventResponse{
+		ID:        event.ID,
+		EventType: event.EventType,
+		Outcome:   event.Outcome,
+		TokenType: event.TokenType,
+		Subject:   event.Subject,
+		Actor:     event.Actor,
+		Reason:    event.Reason,
+		ClientIP:  event.ClientIP,
+		UserAgent: event.UserAgent,
+		RequestID: event.RequestID,
+		CreatedAt: event.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
@@ -7,8 +7,12 @@ import (
 	"fmt"
 	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
 	"github.com/yirez/go-gw-test/pkg/rest_qol"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/repo"
@@ -21,25 +25,101 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+type contextKey string
+
+const (
+	ctxKeyPrincipal   contextKey = "principal"
+	ctxKeyAuditSource contextKey = "audit_source"
+)
+
+const (
+	defaultAccessTokenTTL  = time.Hour
+	defaultRefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// Reasons access tokens are refused for, in logs, auth_events and auth_token_rejections_total.
+const (
+	tokenRejectMalformed   = "malformed"
+	tokenRejectSignature   = "invalid_signature"
+	tokenRejectExpired     = "expired"
+	tokenRejectNotYetValid = "not_yet_valid"
+	tokenRejectIssuer      = "invalid_issuer"
+	tokenRejectAudience    = "invalid_audience"
+	tokenRejectClaims      = "invalid_claims"
+	tokenRejectRevoked     = "revoked"
+)
+
+var (
+	errTokenRevoked       = errors.New("token revoked")
+	errInvalidCredentials = errors.New("invalid credentials")
+	errUserDisabled       = errors.New("user disabled")
+	errServiceDisabled    = errors.New("service disabled")
+)
+
 // AuthUseCaseImpl implements auth flows and HTTP handlers.
 type AuthUseCaseImpl struct {
-	repo     repo.AuthRepo
-	jwtKey   []byte
-	tokenTTL time.Duration
+	repo        repo.AuthRepo
+	revocations repo.RevocationRepo
+	attempts    repo.LoginAttemptRepo
+	jwtKey      []byte
+	defaultTTL  time.Duration
+	maxTTL      time.Duration
+	refreshTTL  time.Duration
+	settings    types.AuthSettings
+	protection  types.LoginProtection
+	metrics     loginMetrics
+	passwords   *passwordHashing
+	oidc        map[string]*oidcProvider
+	mailer      repo.Mailer
+	outbox      sync.WaitGroup // mails still being sent in the background.
+}
+
+// issuedToken carries a signed access token and the claims needed to track it.
+type issuedToken struct {
+	token     string
+	jti       string
+	scope     string
+	ttl       time.Duration
+	expiresAt time.Time
 }
 
 // NewAuthUseCase constructs an AuthUseCase implementation.
-func NewAuthUseCase(authRepo repo.AuthRepo, jwtKey []byte, tokenTTL time.Duration) *AuthUseCaseImpl {
+func NewAuthUseCase(authRepo repo.AuthRepo, revocationRepo repo.RevocationRepo, attemptRepo repo.LoginAttemptRepo, jwtKey []byte, settings types.AuthSettings) *AuthUseCaseImpl {
+	refreshTTL := secondsOrDefault(settings.RefreshTokenTTLSec, defaultRefreshTokenTTL)
+	defaultTTL := secondsOrDefault(settings.TokenTTL.DefaultSec, defaultAccessTokenTTL)
+
+	// without an explicit max, the longest configured lifetime is the cap
+	maxTTL := time.Duration(settings.TokenTTL.MaxSec) * time.Second
+	if maxTTL <= 0 {
+		maxTTL = defaultTTL
+		for _, sec := range settings.TokenTTL.TokenTypes {
+			maxTTL = max(maxTTL, time.Duration(sec)*time.Second)
+		}
+		for _, sec := range settings.TokenTTL.Roles {
+			maxTTL = max(maxTTL, time.Duration(sec)*time.Second)
+		}
+	}
+
 	return &AuthUseCaseImpl{
-		repo:     authRepo,
-		jwtKey:   jwtKey,
-		tokenTTL: tokenTTL,
+		repo:        authRepo,
+		revocations: revocationRepo,
+		attempts:    attemptRepo,
+		jwtKey:      jwtKey,
+		defaultTTL:  defaultTTL,
+		maxTTL:      maxTTL,
+		refreshTTL:  refreshTTL,
+		settings:    settings,
+		protection:  loginProtectionWithDefaults(settings.LoginProtection),
+		metrics:     newLoginMetrics(),
+		passwords:   newPasswordHashing(settings),
+		oidc:        newOIDCProviders(settings.OIDC),
+		mailer:      repo.NewMailer(settings.Mail),
 	}
 }
 
 // Login authenticates a user and issues a token.
 // @Summary Login
-// @Description Authenticates user credentials and returns a signed JWT.
+// @Description Authenticates user credentials and returns a signed JWT plus an opaque refresh token. With session_cookie the JWT is set as HttpOnly gw_session cookie instead, the body carries the csrf_token and no refresh token is issued. Users with MFA, or whose roles require it, get a types.MFAChallengeResponse instead and finish at /auth/login/mfa.
 // @Tags auth-gw
 // @Accept json
 // @Produce json
@@ -47,6 +127,7 @@ func NewAuthUseCase(authRepo repo.AuthRepo, jwtKey []byte, tokenTTL time.Duratio
 // @Success 200 {object} types.LoginResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
 // @Router /auth/login [post]
 func (u *AuthUseCaseImpl) Login(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -64,18 +145,42 @@ func (u *AuthUseCaseImpl) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := u.loginCore(ctx, req)
+	if req.ExpiresIn < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_in"})
+		return
+	}
+
+	ip := clientIP(r, u.protection.TrustForwardedFor)
+	keys := []types.AttemptKey{
+		{Kind: types.AttemptKindUser, Identifier: req.Username},
+		{Kind: types.AttemptKindIP, Identifier: ip},
+	}
+	if !u.allowLoginAttempt(ctx, w, "login", keys) {
+		return
+	}
+
+	resp, challenge, err := u.loginCore(ctx, req)
 	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			u.recordLoginFailure(ctx, "login", keys, ip)
+		} else {
+			u.recordLoginRefused(ctx, "login", keys[0], err)
+		}
 		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, resp)
+	u.recordLoginSuccess(ctx, "login", keys[0])
+	if challenge != nil {
+		utils.WriteJSON(w, http.StatusOK, challenge)
+		return
+	}
+	u.writeLoginResponse(w, resp, req.SessionCookie)
 }
 
 // ServiceToken authenticates a service and issues a token.
 // @Summary Service token
-// @Description Authenticates service credentials and returns a signed JWT.
+// @Description Authenticates service credentials and returns a signed JWT plus an opaque refresh token.
 // @Tags auth-gw
 // @Accept json
 // @Produce json
@@ -83,6 +188,7 @@ func (u *AuthUseCaseImpl) Login(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} types.ServiceTokenResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
 // @Router /auth/service-token [post]
 func (u *AuthUseCaseImpl) ServiceToken(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -100,37 +206,38 @@ func (u *AuthUseCaseImpl) ServiceToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	serviceID, err := parseServiceID(req.ServiceID)
-	if err != nil {
-		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	if req.ExpiresIn < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_in"})
 		return
 	}
 
-	service, err := u.repo.FindServiceByID(ctx, serviceID)
-	if err != nil {
-		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
-		return
+	ip := clientIP(r, u.protection.TrustForwardedFor)
+	keys := []types.AttemptKey{
+		{Kind: types.AttemptKindService, Identifier: req.ServiceID},
+		{Kind: types.AttemptKindIP, Identifier: ip},
 	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(service.SecretHash), []byte(req.Secret))
-	if err != nil {
-		zap.L().Error("compare service secret failed", zap.Error(err))
-		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	if !u.allowLoginAttempt(ctx, w, "service_token", keys) {
 		return
 	}
 
-	token, err := u.issueToken("service", fmt.Sprint(service.ID), service.Role)
+	resp, err := u.serviceTokenCore(ctx, req)
 	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			u.recordLoginFailure(ctx, "service_token", keys, ip)
+		} else {
+			u.recordLoginRefused(ctx, "service_token", keys[0], err)
+		}
 		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, types.ServiceTokenResponse{Token: token})
+	u.recordLoginSuccess(ctx, "service_token", keys[0])
+	utils.WriteJSON(w, http.StatusOK, resp)
 }
 
 // Validate validates a token and returns metadata for api_gw.
 // @Summary Validate token
-// @Description Validates a JWT and returns api_key/role/expiry metadata.
+// @Description Validates a JWT, an API key or a request signature and returns api_key/subject/token_type/role/scope/expiry metadata; API keys also carry their rate_limit.
 // @Tags auth-gw
 // @Accept json
 // @Produce json
@@ -149,13 +256,33 @@ func (u *AuthUseCaseImpl) Validate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Token == "" {
+	signature := ""
+	if req.Signature != nil {
+		signature = req.Signature.KeyID
+	}
+
+	var resp types.ValidateResponse
+	var kind string
+	switch {
+	case countNonEmpty(req.Token, req.APIKey, signature) > 1:
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "token, api_key and signature are mutually exclusive"})
+		return
+	case signature != "":
+		kind = "signature " + signature
+		resp, err = u.validateSignatureCore(ctx, *req.Signature)
+	case req.APIKey != "":
+		kind = "api_key"
+		resp, err = u.validateAPIKeyCore(ctx, req.APIKey)
+	case req.Token != "":
+		kind = "token"
+		resp, err = u.validateTokenCore(ctx, req.Token)
+	default:
 		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 		return
 	}
-
-	resp, err := u.validateTokenCore(ctx, req.Token)
 	if err != nil {
+		// api_gw caches successful validations, so only failures are worth an event
+		u.audit(ctx, types.AuthEvent{EventType: "validate", Outcome: auditOutcomeFailure, Reason: kind + ": " + err.Error()})
 		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 		return
 	}
@@ -178,106 +305,399 @@ func (u *AuthUseCaseImpl) AuthMiddleware() mux.MiddlewareFunc {
 			}
 
 			token, err := rest_qol.BearerTokenFromRequest(r)
+			if errors.Is(err, rest_qol.ErrCSRFTokenInvalid) {
+				zap.L().Warn("session cookie without valid csrf token", zap.String("method", r.Method), zap.String("path", r.URL.Path))
+				utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "invalid csrf token"})
+				return
+			}
 			if err != nil {
 				zap.L().Error("auth middleware bearer token", zap.Error(err))
 				utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 				return
 			}
 
-			_, err = u.validateTokenCore(r.Context(), token)
+			principal, err := u.validateTokenCore(r.Context(), token)
 			if err != nil {
 				zap.L().Error("auth middleware validate token", zap.Error(err))
 				utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), ctxKeyPrincipal, principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-// loginCore validates user credentials and issues user token.
-func (u *AuthUseCaseImpl) loginCore(ctx context.Context, req types.LoginRequest) (types.LoginResponse, error) {
-	user, err := u.repo.FindUserByUsername(ctx, req.Username)
+// loginCore validates user credentials and issues user token, without refresh token for session cookies.
+// Users with MFA get a challenge instead, completed through /auth/login/mfa.
+func (u *AuthUseCaseImpl) loginCore(ctx context.Context, req types.LoginRequest) (types.LoginResponse, *types.MFAChallengeResponse, error) {
+	user, err := u.authenticateUser(ctx, req.Username, req.Password)
 	if err != nil {
-		return types.LoginResponse{}, errors.New("invalid credentials")
+		return types.LoginResponse{}, nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+	roles, err := u.subjectRoles(ctx, "user", user.ID, user.Role)
 	if err != nil {
-		zap.L().Error("compare user password", zap.Error(err))
-		return types.LoginResponse{}, errors.New("invalid credentials")
+		return types.LoginResponse{}, nil, err
 	}
 
-	token, err := u.issueToken("user", fmt.Sprint(user.ID), user.Role)
+	challenge, err := u.mfaChallengeFor(ctx, user, roles, req.ExpiresIn)
+	if err != nil || challenge != nil {
+		return types.LoginResponse{}, challenge, err
+	}
+
+	requested := time.Duration(req.ExpiresIn) * time.Second
+	if req.SessionCookie {
+		access, err := u.issueAccessSession(ctx, "login", fmt.Sprint(user.ID), roles, requested, time.Time{})
+		if err != nil {
+			return types.LoginResponse{}, nil, err
+		}
+		return types.LoginResponse{Token: access.token, ExpiresIn: int64(access.ttl.Seconds())}, nil, nil
+	}
+
+	access, refreshToken, err := u.issueSession(ctx, "login", "user", fmt.Sprint(user.ID), roles, requested, "", "")
+	if err != nil {
+		return types.LoginResponse{}, nil, err
+	}
+
+	return types.LoginResponse{
+		Token:        access.token,
+		ExpiresIn:    int64(access.ttl.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil, nil
+}
+
+// serviceTokenCore validates service credentials and issues service token.
+func (u *AuthUseCaseImpl) serviceTokenCore(ctx context.Context, req types.ServiceTokenRequest) (types.ServiceTokenResponse, error) {
+	service, err := u.authenticateService(ctx, req.ServiceID, req.Secret)
+	if err != nil {
+		return types.ServiceTokenResponse{}, err
+	}
+
+	roles, err := u.subjectRoles(ctx, "service", service.ID, service.Role)
+	if err != nil {
+		return types.ServiceTokenResponse{}, err
+	}
+
+	requested := time.Duration(req.ExpiresIn) * time.Second
+	access, refreshToken, err := u.issueSession(ctx, "service_token", "service", fmt.Sprint(service.ID), roles, requested, "", "")
+	if err != nil {
+		return types.ServiceTokenResponse{}, err
+	}
+
+	return types.ServiceTokenResponse{
+		Token:        access.token,
+		ExpiresIn:    int64(access.ttl.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// authenticateUser verifies a username and password and refuses disabled users.
+// A matching hash in an outdated algorithm or with outdated parameters is replaced by a current one.
+func (u *AuthUseCaseImpl) authenticateUser(ctx context.Context, username string, password string) (types.UserRecord, error) {
+	user, err := u.repo.FindUserByUsername(ctx, username)
+	if err != nil {
+		// same hashing cost as a wrong password, so response time does not reveal unknown usernames
+		u.passwords.VerifyDummy(password)
+		return types.UserRecord{}, errInvalidCredentials
+	}
+
+	ok, needsRehash, err := u.passwords.Verify(user.PasswordHash, password)
+	if err != nil || !ok {
+		zap.L().Error("compare user password", zap.Int64("user_id", user.ID), zap.Error(err))
+		return types.UserRecord{}, errInvalidCredentials
+	}
+
+	// checked after the password so the response does not reveal disabled accounts to guessers
+	if user.Disabled {
+		zap.L().Warn("disabled user login refused", zap.Int64("user_id", user.ID))
+		return types.UserRecord{}, errUserDisabled
+	}
+
+	if needsRehash {
+		u.rehashPassword(ctx, user.ID, password)
+	}
+
+	return user, nil
+}
+
+// authenticateService verifies a service id and secret, records secret use and refuses disabled services.
+func (u *AuthUseCaseImpl) authenticateService(ctx context.Context, rawID string, secret string) (types.ServiceRecord, error) {
+	service, err := u.findServiceByRawID(ctx, rawID)
+	if err != nil {
+		// same bcrypt cost as a wrong secret, so response time does not reveal unknown services
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(secret))
+		return types.ServiceRecord{}, errInvalidCredentials
+	}
+
+	matched, err := u.matchServiceSecret(ctx, service, secret)
+	if err != nil {
+		return types.ServiceRecord{}, err
+	}
+
+	// checked after the secret so the response does not reveal disabled services to guessers
+	if service.Disabled {
+		zap.L().Warn("disabled service token refused", zap.Int64("service_id", service.ID))
+		return types.ServiceRecord{}, errServiceDisabled
+	}
+
+	u.touchServiceSecret(ctx, matched)
+	return service, nil
+}
+
+// findServiceByRawID parses a request service id and loads the service record.
+func (u *AuthUseCaseImpl) findServiceByRawID(ctx context.Context, rawID string) (types.ServiceRecord, error) {
+	serviceID, err := parseServiceID(rawID)
 	if err != nil {
-		return types.LoginResponse{}, err
+		return types.ServiceRecord{}, err
 	}
 
-	return types.LoginResponse{Token: token}, nil
+	return u.repo.FindServiceByID(ctx, serviceID)
 }
 
-// validateTokenCore verifies JWT signature and extracts gateway metadata fields.
+// validateTokenCore verifies JWT signature, rejects revoked tokens and extracts gateway metadata fields.
 func (u *AuthUseCaseImpl) validateTokenCore(ctx context.Context, token string) (types.ValidateResponse, error) {
-	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if token.Method != jwt.SigningMethodHS256 {
-			return nil, fmt.Errorf("unexpected signing method")
+	resp, err := u.parseTokenClaims(token)
+	if err != nil {
+		return types.ValidateResponse{}, err
+	}
+
+	issuedAt, err := time.Parse(time.RFC3339, resp.IssuedAt)
+	if err != nil {
+		issuedAt = time.Time{}
+	}
+
+	revoked, err := u.revocations.IsRevoked(ctx, resp.APIKey, resp.TokenType, resp.Subject, issuedAt)
+	if err != nil {
+		return types.ValidateResponse{}, errors.New("revocation check failed")
+	}
+	if revoked {
+		zap.L().Warn("revoked token presented", zap.String("jti", resp.APIKey), zap.String("sub", resp.Subject))
+		u.metrics.tokenRejections.WithLabelValues(tokenRejectRevoked).Inc()
+		return types.ValidateResponse{}, errTokenRevoked
+	}
+
+	// revoking the actor also ends the tokens it minted while impersonating
+	if resp.Actor != nil {
+		revoked, err = u.revocations.IsRevoked(ctx, resp.APIKey, resp.Actor.TokenType, resp.Actor.Subject, issuedAt)
+		if err != nil {
+			return types.ValidateResponse{}, errors.New("revocation check failed")
+		}
+		if revoked {
+			zap.L().Warn("impersonation token of revoked actor presented", zap.String("jti", resp.APIKey), zap.String("act_sub", resp.Actor.Subject))
+			u.metrics.tokenRejections.WithLabelValues(tokenRejectRevoked).Inc()
+			return types.ValidateResponse{}, errTokenRevoked
 		}
+	}
+
+	return resp, nil
+}
 
+// parseTokenClaims verifies JWT signature, expiry, not-before, issuer and audience and maps claims without revocation checks.
+func (u *AuthUseCaseImpl) parseTokenClaims(token string) (types.ValidateResponse, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Duration(u.settings.JWT.ClockSkewSec) * time.Second),
+	}
+	if u.settings.JWT.Issuer != "" {
+		options = append(options, jwt.WithIssuer(u.settings.JWT.Issuer))
+	}
+
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		return u.jwtKey, nil
-	})
+	}, options...)
 	if err != nil {
-		zap.L().Error("validate jwt", zap.Error(err))
-		return types.ValidateResponse{}, errors.New("invalid token")
+		return types.ValidateResponse{}, u.rejectToken(jwtRejectReason(err), zap.Error(err))
 	}
 
 	claims, ok := parsed.Claims.(jwt.MapClaims)
 	if !ok {
-		return types.ValidateResponse{}, errors.New("invalid token")
+		return types.ValidateResponse{}, u.rejectToken(tokenRejectClaims)
 	}
 
 	apiKey, err := parseAPIKey(claims["jti"])
 	if err != nil {
-		return types.ValidateResponse{}, errors.New("invalid token")
+		return types.ValidateResponse{}, u.rejectToken(tokenRejectClaims, zap.String("claim", "jti"))
 	}
 
 	role, ok := claims["role"].(string)
 	if !ok || role == "" {
-		return types.ValidateResponse{}, errors.New("invalid token")
+		return types.ValidateResponse{}, u.rejectToken(tokenRejectClaims, zap.String("claim", "role"))
 	}
 
 	expiresAt, err := parseExpiry(claims["exp"])
 	if err != nil {
-		return types.ValidateResponse{}, errors.New("invalid token")
+		return types.ValidateResponse{}, u.rejectToken(tokenRejectClaims, zap.String("claim", "exp"))
+	}
+
+	subject, _ := claims["sub"].(string)
+	tokenType, _ := claims["token_type"].(string)
+
+	// audiences are configured per token type, so aud is checked once token_type is known
+	if expected := u.audiencesFor(tokenType); len(expected) > 0 {
+		audience, _ := claims.GetAudience()
+		if !slices.ContainsFunc(audience, func(aud string) bool { return slices.Contains(expected, aud) }) {
+			return types.ValidateResponse{}, u.rejectToken(tokenRejectAudience, zap.Strings("aud", audience), zap.String("token_type", tokenType))
+		}
+	}
+
+	// tokens issued before roles and role_scopes existed get the primary role's current inheritance and scopes
+	roles := parseRolesClaim(claims["roles"])
+	if len(roles) == 0 {
+		roles = u.effectiveRoles(role, nil)
+	}
+	scope, ok := claims["scope"].(string)
+	if !ok {
+		scope = strings.Join(u.scopesFor(roles), " ")
+	}
+
+	var issuedAt string
+	if iat, err := parseUnixClaim(claims["iat"]); err == nil {
+		issuedAt = iat.Format(time.RFC3339Nano)
 	}
 
 	return types.ValidateResponse{
 		APIKey:    apiKey,
+		Subject:   subject,
+		TokenType: tokenType,
 		Role:      role,
+		Roles:     roles,
+		Scope:     scope,
+		IssuedAt:  issuedAt,
 		ExpiresAt: expiresAt,
+		Actor:     parseActorClaim(claims["act"]),
 	}, nil
 }
 
+// tokenTTLFor resolves the access token lifetime: role over token_type over default, capped by max.
+// roles are the effective roles; the shortest configured role lifetime wins, so an assigned admin role is not outlived.
+// A positive requested lifetime only applies when clients may shorten it and it is shorter.
+func (u *AuthUseCaseImpl) tokenTTLFor(tokenType string, roles []string, requested time.Duration) time.Duration {
+	ttl := u.defaultTTL
+	// viper lower-cases map keys
+	if sec, ok := u.settings.TokenTTL.TokenTypes[strings.ToLower(tokenType)]; ok && sec > 0 {
+		ttl = time.Duration(sec) * time.Second
+	}
+	var roleTTL time.Duration
+	for _, role := range roles {
+		if sec, ok := u.settings.TokenTTL.Roles[strings.ToLower(role)]; ok && sec > 0 {
+			if roleTTL == 0 || time.Duration(sec)*time.Second < roleTTL {
+				roleTTL = time.Duration(sec) * time.Second
+			}
+		}
+	}
+	if roleTTL > 0 {
+		ttl = roleTTL
+	}
+
+	ttl = min(ttl, u.maxTTL)
+	if u.settings.TokenTTL.AllowClientExpiresIn && requested > 0 && requested < ttl {
+		ttl = requested
+	}
+
+	return ttl
+}
+
 // issueToken creates a signed JWT with a UUID api_key in jti claim.
-func (u *AuthUseCaseImpl) issueToken(tokenType string, subject string, role string) (string, error) {
-	expiresAt := time.Now().UTC().Add(u.tokenTTL)
+// roles are the effective roles with the primary role first, which also goes into the role claim.
+func (u *AuthUseCaseImpl) issueToken(tokenType string, subject string, roles []string, ttl time.Duration) (issuedToken, error) {
+	return u.issueActingToken(tokenType, subject, roles, ttl, nil)
+}
+
+// issueActingToken signs a token like issueToken; a non-nil actor adds the RFC 8693 act claim.
+func (u *AuthUseCaseImpl) issueActingToken(tokenType string, subject string, roles []string, ttl time.Duration, actor *types.ActorClaim) (issuedToken, error) {
+	return u.issueTokenAfter(tokenType, subject, roles, ttl, actor, time.Time{})
+}
+
+// issueTokenAfter signs a token like issueActingToken whose iat lies after notBefore, e.g. a subject cutoff
+// written just before, so the cutoff cannot cover it even within the same millisecond.
+func (u *AuthUseCaseImpl) issueTokenAfter(tokenType string, subject string, roles []string, ttl time.Duration, actor *types.ActorClaim, notBefore time.Time) (issuedToken, error) {
+	if len(roles) == 0 {
+		return issuedToken{}, errors.New("token without roles")
+	}
+
+	now := time.Now().UTC()
+	issuedAt := now
+	if after := notBefore.UTC().Truncate(time.Millisecond).Add(time.Millisecond); !notBefore.IsZero() && issuedAt.Before(after) {
+		issuedAt = after
+	}
+	expiresAt := now.Add(ttl)
+	jti := uuid.NewString()
+	scope := strings.Join(u.scopesFor(roles), " ")
+	// iat carries milliseconds, so a subject cutoff does not cover tokens issued later in the same second
 	claims := jwt.MapClaims{
 		"sub":        subject,
-		"jti":        uuid.NewString(),
-		"role":       role,
+		"jti":        jti,
+		"role":       roles[0],
+		"roles":      roles,
 		"token_type": tokenType,
 		"exp":        expiresAt.Unix(),
-		"iat":        time.Now().UTC().Unix(),
+		"iat":        float64(issuedAt.UnixMilli()) / 1000,
+		"nbf":        now.Unix(),
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if u.settings.JWT.Issuer != "" {
+		claims["iss"] = u.settings.JWT.Issuer
+	}
+	if audience := u.audiencesFor(tokenType); len(audience) > 0 {
+		claims["aud"] = audience
+	}
+	if actor != nil {
+		claims["act"] = map[string]string{"sub": actor.Subject, "token_type": actor.TokenType}
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signed, err := token.SignedString(u.jwtKey)
 	if err != nil {
 		zap.L().Error("sign jwt", zap.Error(err))
-		return "", err
+		return issuedToken{}, err
+	}
+
+	return issuedToken{token: signed, jti: jti, scope: scope, ttl: ttl, expiresAt: time.Unix(expiresAt.Unix(), 0).UTC()}, nil
+}
+
+// secondsOrDefault converts a seconds setting into a duration, falling back when unset.
+func secondsOrDefault(sec int, fallback time.Duration) time.Duration {
+	if sec <= 0 {
+		return fallback
 	}
+	return time.Duration(sec) * time.Second
+}
 
-	return signed, nil
+// audiencesFor returns the configured aud values of a token type.
+func (u *AuthUseCaseImpl) audiencesFor(tokenType string) []string {
+	// viper lower-cases map keys
+	return u.settings.JWT.Audiences[strings.ToLower(tokenType)]
+}
+
+// rejectToken logs and counts a refused access token; the returned error names the reason for logs and auth_events only.
+func (u *AuthUseCaseImpl) rejectToken(reason string, fields ...zap.Field) error {
+	zap.L().Warn("access token rejected", append([]zap.Field{zap.String("reason", reason)}, fields...)...)
+	u.metrics.tokenRejections.WithLabelValues(reason).Inc()
+	return fmt.Errorf("invalid token: %s", reason)
+}
+
+// jwtRejectReason maps a jwt parse error to a rejection reason.
+func jwtRejectReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return tokenRejectMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return tokenRejectSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return tokenRejectExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return tokenRejectNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return tokenRejectIssuer
+	default:
+		return tokenRejectClaims
+	}
 }
 
 // parseExpiry converts JWT exp claim into RFC3339 UTC format.
@@ -286,22 +706,63 @@ func parseExpiry(expClaim any) (string, error) {
 		return "", errors.New("missing exp")
 	}
 
-	switch value := expClaim.(type) {
+	expiresAt, err := parseUnixClaim(expClaim)
+	if err != nil {
+		return "", errors.New("invalid exp")
+	}
+
+	return expiresAt.Format(time.RFC3339), nil
+}
+
+// parseUnixClaim converts a numeric JWT date claim into UTC time, keeping milliseconds of fractional values.
+func parseUnixClaim(claim any) (time.Time, error) {
+	switch value := claim.(type) {
 	case float64:
-		return time.Unix(int64(value), 0).UTC().Format(time.RFC3339), nil
+		return time.UnixMilli(int64(math.Round(value * 1000))).UTC(), nil
 	case int64:
-		return time.Unix(value, 0).UTC().Format(time.RFC3339), nil
+		return time.Unix(value, 0).UTC(), nil
 	case json.Number:
-		parsed, err := value.Int64()
+		parsed, err := value.Float64()
 		if err != nil {
-			return "", err
+			return time.Time{}, err
 		}
-		return time.Unix(parsed, 0).UTC().Format(time.RFC3339), nil
+		return time.UnixMilli(int64(math.Round(parsed * 1000))).UTC(), nil
 	default:
-		return "", errors.New("invalid exp")
+		return time.Time{}, errors.New("invalid numeric date claim")
 	}
 }
 
+// parseRolesClaim converts the JWT roles claim into role names, skipping non-string entries.
+func parseRolesClaim(claim any) []string {
+	values, ok := claim.([]any)
+	if !ok {
+		return nil
+	}
+
+	roles := make([]string, 0, len(values))
+	for _, value := range values {
+		if role, ok := value.(string); ok && role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// parseActorClaim reads the act claim of impersonation tokens; nil when absent or incomplete.
+func parseActorClaim(claim any) *types.ActorClaim {
+	values, ok := claim.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	subject, _ := values["sub"].(string)
+	tokenType, _ := values["token_type"].(string)
+	if subject == "" || tokenType == "" {
+		return nil
+	}
+	return &types.ActorClaim{Subject: subject, TokenType: tokenType}
+}
+
 // parseAPIKey validates jti claim as UUID and returns it.
 func parseAPIKey(jtiClaim any) (string, error) {
 	jti, ok := jtiClaim.(string)
@@ -341,8 +802,14 @@ func isPublicRoute(method string, path string) bool {
 		return true
 	}
 
+	// OIDC logins are started and finished by browser redirects, which carry no token
+	if strings.HasPrefix(path, "/auth/oidc/") {
+		return true
+	}
+
 	switch path {
-	case "/healthz", "/readyz", "/metrics", "/auth/login", "/auth/service-token":
+	case "/healthz", "/readyz", "/metrics", "/auth/login", "/auth/login/mfa", "/auth/service-token", "/auth/refresh", "/auth/logout", "/oauth/token",
+		"/auth/register", "/auth/register/verify", "/auth/password/forgot", "/auth/password/reset":
 		return true
 	default:
 		return false
@@ -2,16 +2,34 @@ package usecase
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/repo"
 	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/pkg/rest_qol"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type fakeAuthRepo struct {
@@ -20,6 +38,24 @@ type fakeAuthRepo struct {
 
 	service    types.ServiceRecord
 	serviceErr error
+
+	revokedTokens      []types.RevokedToken
+	subjectRevocations []types.SubjectRevocation
+	refreshTokens      map[string]types.RefreshToken
+	lockoutEvents      []types.LockoutEvent
+	createdUsers       []types.UserRecord
+	serviceSecrets     []types.ServiceSecret
+	roleAssignments    map[string][]string
+	apiKeys            []types.APIKeyRecord
+	signingKeys        []types.ServiceSigningKey
+	userMFA            map[int64]types.UserMFA
+	recoveryCodes      []types.MFARecoveryCode
+	mfaChallenges      []types.MFAChallenge
+	authEvents         []types.AuthEvent
+	issuedTokens       []types.IssuedToken
+	oidcStates         map[string]types.OIDCLoginState
+	identities         []types.UserIdentity
+	emailTokens        map[string]types.EmailToken
 }
 
 // FindUserByUsername returns configured fake user data.
@@ -27,11 +63,758 @@ func (f *fakeAuthRepo) FindUserByUsername(ctx context.Context, username string)
 	return f.user, f.userErr
 }
 
+// FindUserByID returns configured fake user data.
+func (f *fakeAuthRepo) FindUserByID(ctx context.Context, userID int64) (types.UserRecord, error) {
+	return f.user, f.userErr
+}
+
+// ListUsers returns the configured and created fake users.
+func (f *fakeAuthRepo) ListUsers(ctx context.Context) ([]types.UserRecord, error) {
+	return append([]types.UserRecord{f.user}, f.createdUsers...), nil
+}
+
+// FindUserByEmail returns the configured fake user when its email matches.
+func (f *fakeAuthRepo) FindUserByEmail(ctx context.Context, email string) (types.UserRecord, error) {
+	if f.user.Email == "" || f.user.Email != email {
+		return types.UserRecord{}, gorm.ErrRecordNotFound
+	}
+	return f.user, nil
+}
+
+// CreateUser records the fake user, refusing the configured username and email.
+func (f *fakeAuthRepo) CreateUser(ctx context.Context, record types.UserRecord) (types.UserRecord, error) {
+	if record.Username == f.user.Username {
+		return types.UserRecord{}, repo.ErrUsernameTaken
+	}
+	if record.Email != "" && record.Email == f.user.Email {
+		return types.UserRecord{}, repo.ErrEmailTaken
+	}
+	record.ID = int64(100 + len(f.createdUsers))
+	f.createdUsers = append(f.createdUsers, record)
+	return record, nil
+}
+
+// SetUserDisabled updates the configured fake user.
+func (f *fakeAuthRepo) SetUserDisabled(ctx context.Context, userID int64, disabled bool) error {
+	if userID != f.user.ID {
+		return gorm.ErrRecordNotFound
+	}
+	f.user.Disabled = disabled
+	return nil
+}
+
+// UpdateUserPassword updates the configured fake user.
+func (f *fakeAuthRepo) UpdateUserPassword(ctx context.Context, userID int64, passwordHash string) error {
+	if userID != f.user.ID {
+		return gorm.ErrRecordNotFound
+	}
+	f.user.PasswordHash = passwordHash
+	return nil
+}
+
+// DeleteUser forgets the configured fake user.
+func (f *fakeAuthRepo) DeleteUser(ctx context.Context, userID int64) error {
+	if userID != f.user.ID {
+		return gorm.ErrRecordNotFound
+	}
+	f.user = types.UserRecord{}
+	f.userErr = gorm.ErrRecordNotFound
+	return nil
+}
+
 // FindServiceByID returns configured fake service data.
 func (f *fakeAuthRepo) FindServiceByID(ctx context.Context, serviceID int64) (types.ServiceRecord, error) {
 	return f.service, f.serviceErr
 }
 
+// ListServices returns the configured fake service.
+func (f *fakeAuthRepo) ListServices(ctx context.Context) ([]types.ServiceRecord, error) {
+	return []types.ServiceRecord{f.service}, nil
+}
+
+// CreateService records the fake secret for a new service.
+func (f *fakeAuthRepo) CreateService(ctx context.Context, record types.ServiceRecord, secret types.ServiceSecret) (types.ServiceRecord, types.ServiceSecret, error) {
+	record.ID = 100
+	secret.ServiceID = record.ID
+	secret.ID = int64(len(f.serviceSecrets) + 1)
+	f.serviceSecrets = append(f.serviceSecrets, secret)
+	return record, secret, nil
+}
+
+// SetServiceDisabled updates the configured fake service.
+func (f *fakeAuthRepo) SetServiceDisabled(ctx context.Context, serviceID int64, disabled bool) error {
+	if serviceID != f.service.ID {
+		return gorm.ErrRecordNotFound
+	}
+	f.service.Disabled = disabled
+	return nil
+}
+
+// ListServiceSecrets returns fake secrets of a service.
+func (f *fakeAuthRepo) ListServiceSecrets(ctx context.Context, serviceID int64) ([]types.ServiceSecret, error) {
+	var secrets []types.ServiceSecret
+	for _, secret := range f.serviceSecrets {
+		if secret.ServiceID == serviceID {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+// AddServiceSecret moves the legacy hash, retires existing fake secrets and stores the new one like the GORM repo.
+func (f *fakeAuthRepo) AddServiceSecret(ctx context.Context, secret types.ServiceSecret, retireAt *time.Time) (types.ServiceSecret, error) {
+	if secret.ServiceID != f.service.ID {
+		return types.ServiceSecret{}, gorm.ErrRecordNotFound
+	}
+	if f.service.SecretHash != "" {
+		f.serviceSecrets = append(f.serviceSecrets, types.ServiceSecret{ID: int64(len(f.serviceSecrets) + 1), ServiceID: f.service.ID, SecretHash: f.service.SecretHash})
+		f.service.SecretHash = ""
+	}
+	for i, existing := range f.serviceSecrets {
+		if retireAt != nil && existing.ServiceID == secret.ServiceID && (existing.ExpiresAt == nil || existing.ExpiresAt.After(*retireAt)) {
+			f.serviceSecrets[i].ExpiresAt = retireAt
+		}
+	}
+	secret.ID = int64(len(f.serviceSecrets) + 1)
+	f.serviceSecrets = append(f.serviceSecrets, secret)
+	return secret, nil
+}
+
+// DeleteServiceSecret removes a fake secret.
+func (f *fakeAuthRepo) DeleteServiceSecret(ctx context.Context, serviceID int64, secretID int64) error {
+	for i, secret := range f.serviceSecrets {
+		if secret.ID == secretID && secret.ServiceID == serviceID {
+			f.serviceSecrets = append(f.serviceSecrets[:i], f.serviceSecrets[i+1:]...)
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// TouchServiceSecret records fake secret use.
+func (f *fakeAuthRepo) TouchServiceSecret(ctx context.Context, secretID int64, usedAt time.Time) error {
+	for i, secret := range f.serviceSecrets {
+		if secret.ID == secretID {
+			f.serviceSecrets[i].LastUsedAt = &usedAt
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// ListRoleAssignments returns fake assigned roles.
+func (f *fakeAuthRepo) ListRoleAssignments(ctx context.Context, tokenType string, subjectID int64) ([]string, error) {
+	return f.roleAssignments[fmt.Sprintf("%s:%d", tokenType, subjectID)], nil
+}
+
+// SetRoleAssignments stores fake assigned roles for the configured user or service.
+func (f *fakeAuthRepo) SetRoleAssignments(ctx context.Context, tokenType string, subjectID int64, roles []string) error {
+	if (tokenType == "user" && f.user.ID != subjectID) || (tokenType == "service" && f.service.ID != subjectID) {
+		return gorm.ErrRecordNotFound
+	}
+	if f.roleAssignments == nil {
+		f.roleAssignments = make(map[string][]string)
+	}
+	f.roleAssignments[fmt.Sprintf("%s:%d", tokenType, subjectID)] = roles
+	return nil
+}
+
+// CreateAPIKey records the fake API key.
+func (f *fakeAuthRepo) CreateAPIKey(ctx context.Context, record types.APIKeyRecord) (types.APIKeyRecord, error) {
+	record.ID = int64(len(f.apiKeys) + 1)
+	f.apiKeys = append(f.apiKeys, record)
+	return record, nil
+}
+
+// ListAPIKeys returns the fake API keys.
+func (f *fakeAuthRepo) ListAPIKeys(ctx context.Context) ([]types.APIKeyRecord, error) {
+	return f.apiKeys, nil
+}
+
+// FindAPIKeyByPrefix returns the fake API key with prefix.
+func (f *fakeAuthRepo) FindAPIKeyByPrefix(ctx context.Context, prefix string) (types.APIKeyRecord, error) {
+	for _, record := range f.apiKeys {
+		if record.Prefix == prefix {
+			return record, nil
+		}
+	}
+	return types.APIKeyRecord{}, gorm.ErrRecordNotFound
+}
+
+// DeleteAPIKey removes a fake API key.
+func (f *fakeAuthRepo) DeleteAPIKey(ctx context.Context, id int64) (types.APIKeyRecord, error) {
+	for i, record := range f.apiKeys {
+		if record.ID == id {
+			f.apiKeys = append(f.apiKeys[:i], f.apiKeys[i+1:]...)
+			return record, nil
+		}
+	}
+	return types.APIKeyRecord{}, gorm.ErrRecordNotFound
+}
+
+// TouchAPIKey records fake API key use.
+func (f *fakeAuthRepo) TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error {
+	for i, record := range f.apiKeys {
+		if record.ID == id {
+			f.apiKeys[i].LastUsedAt = &usedAt
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// CreateSigningKey records the fake signing key.
+func (f *fakeAuthRepo) CreateSigningKey(ctx context.Context, key types.ServiceSigningKey) (types.ServiceSigningKey, error) {
+	if f.serviceErr != nil {
+		return types.ServiceSigningKey{}, f.serviceErr
+	}
+	key.ID = int64(len(f.signingKeys) + 1)
+	f.signingKeys = append(f.signingKeys, key)
+	return key, nil
+}
+
+// ListSigningKeys returns the fake signing keys of a service.
+func (f *fakeAuthRepo) ListSigningKeys(ctx context.Context, serviceID int64) ([]types.ServiceSigningKey, error) {
+	keys := make([]types.ServiceSigningKey, 0)
+	for _, key := range f.signingKeys {
+		if key.ServiceID == serviceID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// FindSigningKey returns the fake signing key with keyID.
+func (f *fakeAuthRepo) FindSigningKey(ctx context.Context, keyID string) (types.ServiceSigningKey, error) {
+	for _, key := range f.signingKeys {
+		if key.KeyID == keyID {
+			return key, nil
+		}
+	}
+	return types.ServiceSigningKey{}, gorm.ErrRecordNotFound
+}
+
+// DeleteSigningKey removes a fake signing key.
+func (f *fakeAuthRepo) DeleteSigningKey(ctx context.Context, serviceID int64, keyID string) (types.ServiceSigningKey, error) {
+	for i, key := range f.signingKeys {
+		if key.KeyID == keyID && key.ServiceID == serviceID {
+			f.signingKeys = append(f.signingKeys[:i], f.signingKeys[i+1:]...)
+			return key, nil
+		}
+	}
+	return types.ServiceSigningKey{}, gorm.ErrRecordNotFound
+}
+
+// TouchSigningKey records fake signing key use.
+func (f *fakeAuthRepo) TouchSigningKey(ctx context.Context, id int64, usedAt time.Time) error {
+	for i, key := range f.signingKeys {
+		if key.ID == id {
+			f.signingKeys[i].LastUsedAt = &usedAt
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// FindUserMFA returns the fake enrollment of a user.
+func (f *fakeAuthRepo) FindUserMFA(ctx context.Context, userID int64) (types.UserMFA, error) {
+	mfa, ok := f.userMFA[userID]
+	if !ok {
+		return types.UserMFA{}, gorm.ErrRecordNotFound
+	}
+	return mfa, nil
+}
+
+// SaveUserMFA stores a fake pending enrollment.
+func (f *fakeAuthRepo) SaveUserMFA(ctx context.Context, mfa types.UserMFA) error {
+	if f.userMFA == nil {
+		f.userMFA = make(map[int64]types.UserMFA)
+	}
+	f.userMFA[mfa.UserID] = mfa
+	return nil
+}
+
+// ConfirmUserMFA confirms a fake pending enrollment and replaces the recovery codes.
+func (f *fakeAuthRepo) ConfirmUserMFA(ctx context.Context, userID int64, confirmedAt time.Time, step int64, codeHashes []string) error {
+	mfa, ok := f.userMFA[userID]
+	if !ok || mfa.ConfirmedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	mfa.ConfirmedAt = &confirmedAt
+	mfa.LastUsedStep = step
+	f.userMFA[userID] = mfa
+
+	f.recoveryCodes = slices.DeleteFunc(f.recoveryCodes, func(code types.MFARecoveryCode) bool { return code.UserID == userID })
+	for _, hash := range codeHashes {
+		f.recoveryCodes = append(f.recoveryCodes, types.MFARecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	return nil
+}
+
+// UseTOTPStep records a fake accepted step.
+func (f *fakeAuthRepo) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	mfa, ok := f.userMFA[userID]
+	if !ok || mfa.LastUsedStep >= step {
+		return false, nil
+	}
+	mfa.LastUsedStep = step
+	f.userMFA[userID] = mfa
+	return true, nil
+}
+
+// UseRecoveryCode marks a fake recovery code as used.
+func (f *fakeAuthRepo) UseRecoveryCode(ctx context.Context, userID int64, codeHash string, usedAt time.Time) (bool, error) {
+	for i, code := range f.recoveryCodes {
+		if code.UserID == userID && code.CodeHash == codeHash && code.UsedAt == nil {
+			f.recoveryCodes[i].UsedAt = &usedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CountRecoveryCodes counts unused fake recovery codes.
+func (f *fakeAuthRepo) CountRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	for _, code := range f.recoveryCodes {
+		if code.UserID == userID && code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteUserMFA removes fake MFA state of a user.
+func (f *fakeAuthRepo) DeleteUserMFA(ctx context.Context, userID int64) error {
+	if _, ok := f.userMFA[userID]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	delete(f.userMFA, userID)
+	f.recoveryCodes = slices.DeleteFunc(f.recoveryCodes, func(code types.MFARecoveryCode) bool { return code.UserID == userID })
+	f.mfaChallenges = slices.DeleteFunc(f.mfaChallenges, func(challenge types.MFAChallenge) bool { return challenge.UserID == userID })
+	return nil
+}
+
+// SaveMFAChallenge records a fake challenge.
+func (f *fakeAuthRepo) SaveMFAChallenge(ctx context.Context, challenge types.MFAChallenge) (types.MFAChallenge, error) {
+	challenge.ID = int64(len(f.mfaChallenges) + 1)
+	f.mfaChallenges = append(f.mfaChallenges, challenge)
+	return challenge, nil
+}
+
+// FindMFAChallenge returns the fake challenge with hash.
+func (f *fakeAuthRepo) FindMFAChallenge(ctx context.Context, challengeHash string) (types.MFAChallenge, error) {
+	for _, challenge := range f.mfaChallenges {
+		if challenge.ChallengeHash == challengeHash {
+			return challenge, nil
+		}
+	}
+	return types.MFAChallenge{}, gorm.ErrRecordNotFound
+}
+
+// IncrementMFAChallengeAttempts counts a wrong code against a fake challenge.
+func (f *fakeAuthRepo) IncrementMFAChallengeAttempts(ctx context.Context, id int64) error {
+	for i, challenge := range f.mfaChallenges {
+		if challenge.ID == id {
+			f.mfaChallenges[i].Attempts++
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// DeleteMFAChallenge removes a fake challenge.
+func (f *fakeAuthRepo) DeleteMFAChallenge(ctx context.Context, id int64) (bool, error) {
+	before := len(f.mfaChallenges)
+	f.mfaChallenges = slices.DeleteFunc(f.mfaChallenges, func(challenge types.MFAChallenge) bool { return challenge.ID == id })
+	return len(f.mfaChallenges) < before, nil
+}
+
+// SaveRevokedToken records the fake revocation.
+func (f *fakeAuthRepo) SaveRevokedToken(ctx context.Context, record types.RevokedToken) error {
+	f.revokedTokens = append(f.revokedTokens, record)
+	return nil
+}
+
+// SaveSubjectRevocation records the fake subject cutoff.
+func (f *fakeAuthRepo) SaveSubjectRevocation(ctx context.Context, record types.SubjectRevocation) error {
+	f.subjectRevocations = append(f.subjectRevocations, record)
+	return nil
+}
+
+// ListRevokedTokens returns recorded fake revocations.
+func (f *fakeAuthRepo) ListRevokedTokens(ctx context.Context, expiresAfter time.Time) ([]types.RevokedToken, error) {
+	return f.revokedTokens, nil
+}
+
+// ListSubjectRevocations returns recorded fake subject cutoffs.
+func (f *fakeAuthRepo) ListSubjectRevocations(ctx context.Context, revokedAfter time.Time) ([]types.SubjectRevocation, error) {
+	return f.subjectRevocations, nil
+}
+
+// SaveRefreshToken stores the fake refresh token record.
+func (f *fakeAuthRepo) SaveRefreshToken(ctx context.Context, record types.RefreshToken) error {
+	if f.refreshTokens == nil {
+		f.refreshTokens = map[string]types.RefreshToken{}
+	}
+	f.refreshTokens[record.TokenHash] = record
+	return nil
+}
+
+// FindRefreshToken returns the fake refresh token record.
+func (f *fakeAuthRepo) FindRefreshToken(ctx context.Context, tokenHash string) (types.RefreshToken, error) {
+	record, ok := f.refreshTokens[tokenHash]
+	if !ok {
+		return types.RefreshToken{}, errors.New("not found")
+	}
+	return record, nil
+}
+
+// RotateRefreshToken marks the fake record rotated and stores next.
+func (f *fakeAuthRepo) RotateRefreshToken(ctx context.Context, tokenHash string, next types.RefreshToken) error {
+	record, ok := f.refreshTokens[tokenHash]
+	if !ok || record.RotatedAt != nil || record.RevokedAt != nil {
+		return repo.ErrRefreshTokenReused
+	}
+	record.RotatedAt = &next.CreatedAt
+	f.refreshTokens[tokenHash] = record
+	return f.SaveRefreshToken(ctx, next)
+}
+
+// RevokeRefreshFamily revokes fake family records.
+func (f *fakeAuthRepo) RevokeRefreshFamily(ctx context.Context, familyID string, revokedAt time.Time) ([]types.RefreshToken, error) {
+	var family []types.RefreshToken
+	for hash, record := range f.refreshTokens {
+		if record.FamilyID != familyID {
+			continue
+		}
+		if record.RevokedAt == nil {
+			record.RevokedAt = &revokedAt
+			f.refreshTokens[hash] = record
+		}
+		family = append(family, record)
+	}
+	return family, nil
+}
+
+// SaveIssuedToken records the fake issued token.
+func (f *fakeAuthRepo) SaveIssuedToken(ctx context.Context, record types.IssuedToken) error {
+	f.issuedTokens = append(f.issuedTokens, record)
+	return nil
+}
+
+// FindIssuedToken returns the fake issued token with the jti.
+func (f *fakeAuthRepo) FindIssuedToken(ctx context.Context, jti string) (types.IssuedToken, error) {
+	for _, record := range f.issuedTokens {
+		if record.JTI == jti {
+			return record, nil
+		}
+	}
+	return types.IssuedToken{}, gorm.ErrRecordNotFound
+}
+
+// ListIssuedTokens returns the fake subject's tokens expiring after expiresAfter, newest first.
+func (f *fakeAuthRepo) ListIssuedTokens(ctx context.Context, tokenType string, subject string, expiresAfter time.Time) ([]types.IssuedToken, error) {
+	var records []types.IssuedToken
+	for i := len(f.issuedTokens) - 1; i >= 0; i-- {
+		record := f.issuedTokens[i]
+		if record.TokenType == tokenType && record.Subject == subject && record.ExpiresAt.After(expiresAfter) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// DeleteIssuedTokensExpiredBefore drops fake issued tokens expired before cutoff.
+func (f *fakeAuthRepo) DeleteIssuedTokensExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	kept := f.issuedTokens[:0]
+	for _, record := range f.issuedTokens {
+		if !record.ExpiresAt.Before(cutoff) {
+			kept = append(kept, record)
+		}
+	}
+	deleted := int64(len(f.issuedTokens) - len(kept))
+	f.issuedTokens = kept
+	return deleted, nil
+}
+
+// SaveOIDCLoginState records the fake started OIDC login.
+func (f *fakeAuthRepo) SaveOIDCLoginState(ctx context.Context, state types.OIDCLoginState) error {
+	if f.oidcStates == nil {
+		f.oidcStates = make(map[string]types.OIDCLoginState)
+	}
+	f.oidcStates[state.StateHash] = state
+	return nil
+}
+
+// ConsumeOIDCLoginState returns and forgets the fake started OIDC login.
+func (f *fakeAuthRepo) ConsumeOIDCLoginState(ctx context.Context, stateHash string) (types.OIDCLoginState, error) {
+	state, ok := f.oidcStates[stateHash]
+	if !ok {
+		return types.OIDCLoginState{}, gorm.ErrRecordNotFound
+	}
+	delete(f.oidcStates, stateHash)
+	return state, nil
+}
+
+// DeleteOIDCLoginStatesExpiredBefore drops fake OIDC logins that expired before cutoff.
+func (f *fakeAuthRepo) DeleteOIDCLoginStatesExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	var deleted int64
+	for hash, state := range f.oidcStates {
+		if state.ExpiresAt.Before(cutoff) {
+			delete(f.oidcStates, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// SyncFederatedUser links or updates the fake federated user, which becomes the configured user.
+func (f *fakeAuthRepo) SyncFederatedUser(ctx context.Context, identity types.UserIdentity, username string, roles []string) (types.UserRecord, []string, error) {
+	if f.roleAssignments == nil {
+		f.roleAssignments = make(map[string][]string)
+	}
+	for i, linked := range f.identities {
+		if linked.Provider == identity.Provider && linked.Subject == identity.Subject {
+			f.identities[i].Email, f.identities[i].LastLoginAt = identity.Email, identity.LastLoginAt
+			previous := append([]string{f.user.Role}, f.roleAssignments[fmt.Sprintf("user:%d", f.user.ID)]...)
+			f.user.Role = roles[0]
+			f.roleAssignments[fmt.Sprintf("user:%d", f.user.ID)] = roles[1:]
+			return f.user, previous, nil
+		}
+	}
+	if username == f.user.Username {
+		return types.UserRecord{}, nil, repo.ErrUsernameTaken
+	}
+
+	f.user = types.UserRecord{ID: int64(100 + len(f.identities)), Username: username, Role: roles[0]}
+	identity.UserID = f.user.ID
+	f.identities = append(f.identities, identity)
+	f.roleAssignments[fmt.Sprintf("user:%d", f.user.ID)] = roles[1:]
+	return f.user, nil, nil
+}
+
+// SaveEmailToken stores the fake mailed token.
+func (f *fakeAuthRepo) SaveEmailToken(ctx context.Context, token types.EmailToken) error {
+	if f.emailTokens == nil {
+		f.emailTokens = make(map[string]types.EmailToken)
+	}
+	f.emailTokens[token.TokenHash] = token
+	return nil
+}
+
+// ConsumeEmailToken returns and forgets the fake mailed token of purpose.
+func (f *fakeAuthRepo) ConsumeEmailToken(ctx context.Context, tokenHash string, purpose string) (types.EmailToken, error) {
+	token, ok := f.emailTokens[tokenHash]
+	if !ok || token.Purpose != purpose {
+		return types.EmailToken{}, gorm.ErrRecordNotFound
+	}
+	delete(f.emailTokens, tokenHash)
+	return token, nil
+}
+
+// DeleteEmailTokens drops the fake tokens of purpose mailed to email.
+func (f *fakeAuthRepo) DeleteEmailTokens(ctx context.Context, purpose string, email string) (int64, error) {
+	var deleted int64
+	for hash, token := range f.emailTokens {
+		if token.Purpose == purpose && token.Email == email {
+			delete(f.emailTokens, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteEmailTokensExpiredBefore drops fake mailed tokens that expired before cutoff.
+func (f *fakeAuthRepo) DeleteEmailTokensExpiredBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	var deleted int64
+	for hash, token := range f.emailTokens {
+		if token.ExpiresAt.Before(cutoff) {
+			delete(f.emailTokens, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// SaveLockoutEvent records the fake lockout audit event.
+func (f *fakeAuthRepo) SaveLockoutEvent(ctx context.Context, event types.LockoutEvent) error {
+	f.lockoutEvents = append(f.lockoutEvents, event)
+	return nil
+}
+
+// SaveAuthEvent records the fake auth event with the next id.
+func (f *fakeAuthRepo) SaveAuthEvent(ctx context.Context, event types.AuthEvent) error {
+	event.ID = int64(len(f.authEvents) + 1)
+	f.authEvents = append(f.authEvents, event)
+	return nil
+}
+
+// ListAuthEvents filters fake auth events by type, outcome, subject and cursor, newest first.
+func (f *fakeAuthRepo) ListAuthEvents(ctx context.Context, filter types.AuthEventFilter) ([]types.AuthEvent, error) {
+	var events []types.AuthEvent
+	for i := len(f.authEvents) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		event := f.authEvents[i]
+		switch {
+		case filter.EventType != "" && event.EventType != filter.EventType,
+			filter.Outcome != "" && event.Outcome != filter.Outcome,
+			filter.Subject != "" && event.Subject != filter.Subject,
+			filter.BeforeID > 0 && event.ID >= filter.BeforeID:
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// DeleteAuthEventsBefore drops fake auth events created before cutoff.
+func (f *fakeAuthRepo) DeleteAuthEventsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	kept := f.authEvents[:0]
+	for _, event := range f.authEvents {
+		if !event.CreatedAt.Before(cutoff) {
+			kept = append(kept, event)
+		}
+	}
+	deleted := int64(len(f.authEvents) - len(kept))
+	f.authEvents = kept
+	return deleted, nil
+}
+
+type fakeRevocationRepo struct {
+	tokens        map[string]time.Time
+	subjects      map[string]time.Time
+	subjectExpiry map[string]time.Time
+	elapsed       time.Duration // moves the fake clock forward for marker expiry.
+	events        []types.RevocationEvent
+	lastSeen      map[string]time.Time
+}
+
+// newFakeRevocationRepo builds an empty in-memory revocation repo.
+func newFakeRevocationRepo() *fakeRevocationRepo {
+	return &fakeRevocationRepo{
+		tokens:        map[string]time.Time{},
+		subjects:      map[string]time.Time{},
+		subjectExpiry: map[string]time.Time{},
+	}
+}
+
+// MarkTokenRevoked stores the fake jti marker.
+func (f *fakeRevocationRepo) MarkTokenRevoked(ctx context.Context, jti string, expiresAt time.Time) error {
+	f.tokens[jti] = expiresAt
+	return nil
+}
+
+// MarkSubjectRevoked stores the fake subject cutoff, which expires after ttl like the Redis key.
+func (f *fakeRevocationRepo) MarkSubjectRevoked(ctx context.Context, tokenType string, subject string, revokedBefore time.Time, ttl time.Duration) error {
+	f.subjects[tokenType+":"+subject] = revokedBefore
+	f.subjectExpiry[tokenType+":"+subject] = time.Now().Add(ttl)
+	return nil
+}
+
+// IsRevoked checks fake markers the same way the Redis repo does.
+func (f *fakeRevocationRepo) IsRevoked(ctx context.Context, jti string, tokenType string, subject string, issuedAt time.Time) (bool, error) {
+	if _, ok := f.tokens[jti]; ok {
+		return true, nil
+	}
+	cutoff, ok := f.subjects[tokenType+":"+subject]
+	if expiry, set := f.subjectExpiry[tokenType+":"+subject]; set && !time.Now().Add(f.elapsed).Before(expiry) {
+		return false, nil
+	}
+	return ok && issuedAt.UnixMilli() <= cutoff.UnixMilli(), nil
+}
+
+// TokensLastSeen returns the fake last seen times of the jtis that have one.
+func (f *fakeRevocationRepo) TokensLastSeen(ctx context.Context, jtis []string) (map[string]time.Time, error) {
+	lastSeen := map[string]time.Time{}
+	for _, jti := range jtis {
+		if seenAt, ok := f.lastSeen[jti]; ok {
+			lastSeen[jti] = seenAt
+		}
+	}
+	return lastSeen, nil
+}
+
+// PublishRevocation records the fake event.
+func (f *fakeRevocationRepo) PublishRevocation(ctx context.Context, event types.RevocationEvent) error {
+	f.events = append(f.events, event)
+	return nil
+}
+
+type fakeLoginAttemptRepo struct {
+	failures map[types.AttemptKey]int64
+	blocked  map[types.AttemptKey]time.Duration
+	locked   map[types.AttemptKey]bool
+}
+
+// newFakeLoginAttemptRepo builds an empty in-memory attempt repo without expiry.
+func newFakeLoginAttemptRepo() *fakeLoginAttemptRepo {
+	return &fakeLoginAttemptRepo{
+		failures: map[types.AttemptKey]int64{},
+		blocked:  map[types.AttemptKey]time.Duration{},
+		locked:   map[types.AttemptKey]bool{},
+	}
+}
+
+// RetryAfter returns the longest fake block among keys.
+func (f *fakeLoginAttemptRepo) RetryAfter(ctx context.Context, keys ...types.AttemptKey) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, key := range keys {
+		retryAfter = max(retryAfter, f.blocked[key])
+	}
+	return retryAfter, nil
+}
+
+// RecordFailure counts a fake failure.
+func (f *fakeLoginAttemptRepo) RecordFailure(ctx context.Context, key types.AttemptKey, window time.Duration) (int64, error) {
+	f.failures[key]++
+	return f.failures[key], nil
+}
+
+// Delay stores a fake delay.
+func (f *fakeLoginAttemptRepo) Delay(ctx context.Context, key types.AttemptKey, delay time.Duration) error {
+	f.blocked[key] = delay
+	return nil
+}
+
+// Lock stores a fake lockout.
+func (f *fakeLoginAttemptRepo) Lock(ctx context.Context, key types.AttemptKey, duration time.Duration) (bool, error) {
+	if f.locked[key] {
+		return false, nil
+	}
+	f.locked[key] = true
+	f.blocked[key] = duration
+	return true, nil
+}
+
+// Reset clears fake failures.
+func (f *fakeLoginAttemptRepo) Reset(ctx context.Context, key types.AttemptKey) error {
+	delete(f.failures, key)
+	if !f.locked[key] {
+		delete(f.blocked, key)
+	}
+	return nil
+}
+
+// Unlock clears every fake state of key.
+func (f *fakeLoginAttemptRepo) Unlock(ctx context.Context, key types.AttemptKey) (bool, error) {
+	wasLocked := f.locked[key]
+	delete(f.failures, key)
+	delete(f.blocked, key)
+	delete(f.locked, key)
+	return wasLocked, nil
+}
+
+// newTestAuthUseCase builds a usecase with in-memory fakes and the "admin" role configured.
+func newTestAuthUseCase(authRepo *fakeAuthRepo) *AuthUseCaseImpl {
+	return NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		AdminRoles: []string{"admin"},
+		RoleScopes: map[string][]string{
+			"user_all": {"users:read", "orders:read", "orders:write"},
+			"users_gw": {"users:read"},
+		},
+	})
+}
+
 // TestAuthUseCaseLoginSuccess verifies login returns token with valid credentials.
 func TestAuthUseCaseLoginSuccess(t *testing.T) {
 	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.DefaultCost)
@@ -39,14 +822,14 @@ func TestAuthUseCaseLoginSuccess(t *testing.T) {
 		t.Fatalf("generate password hash: %v", err)
 	}
 
-	u := NewAuthUseCase(&fakeAuthRepo{
+	u := newTestAuthUseCase(&fakeAuthRepo{
 		user: types.UserRecord{
 			ID:           1,
 			Username:     "user_all",
 			PasswordHash: string(hash),
 			Role:         "user_all",
 		},
-	}, []byte("test-secret"), time.Hour)
+	})
 
 	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username":"user_all","password":"123"}`))
 	rr := httptest.NewRecorder()
@@ -68,7 +851,7 @@ func TestAuthUseCaseLoginSuccess(t *testing.T) {
 
 // TestAuthUseCaseLoginInvalidBody verifies malformed requests are rejected.
 func TestAuthUseCaseLoginInvalidBody(t *testing.T) {
-	u := NewAuthUseCase(&fakeAuthRepo{}, []byte("test-secret"), time.Hour)
+	u := newTestAuthUseCase(&fakeAuthRepo{})
 	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{`))
 	rr := httptest.NewRecorder()
 
@@ -81,11 +864,12 @@ func TestAuthUseCaseLoginInvalidBody(t *testing.T) {
 
 // TestAuthUseCaseValidateSuccess verifies validate endpoint returns api key metadata.
 func TestAuthUseCaseValidateSuccess(t *testing.T) {
-	u := NewAuthUseCase(&fakeAuthRepo{}, []byte("test-secret"), time.Hour)
-	token, err := u.issueToken("user", "1", "user_all")
+	u := newTestAuthUseCase(&fakeAuthRepo{})
+	issued, err := u.issueToken("user", "1", []string{"user_all"}, time.Hour)
 	if err != nil {
 		t.Fatalf("issue token: %v", err)
 	}
+	token := issued.token
 
 	req := httptest.NewRequest(http.MethodPost, "/auth/validate", strings.NewReader(`{"token":"`+token+`"}`))
 	rr := httptest.NewRecorder()
@@ -103,11 +887,17 @@ func TestAuthUseCaseValidateSuccess(t *testing.T) {
 	if resp.APIKey == "" || resp.Role == "" || resp.ExpiresAt == "" {
 		t.Fatalf("expected complete validate response, got %#v", resp)
 	}
+	if resp.Subject != "1" || resp.TokenType != "user" {
+		t.Fatalf("expected sub/token_type claims in validate response, got %#v", resp)
+	}
+	if resp.Scope != "users:read orders:read orders:write" {
+		t.Fatalf("expected role scopes in validate response, got %q", resp.Scope)
+	}
 }
 
 // TestAuthUseCaseAuthMiddleware verifies protected routes require bearer token.
 func TestAuthUseCaseAuthMiddleware(t *testing.T) {
-	u := NewAuthUseCase(&fakeAuthRepo{}, []byte("test-secret"), time.Hour)
+	u := newTestAuthUseCase(&fakeAuthRepo{})
 	mw := u.AuthMiddleware()
 
 	protectedReq := httptest.NewRequest(http.MethodGet, "/auth/validate", nil)
@@ -129,3 +919,2019 @@ func TestAuthUseCaseAuthMiddleware(t *testing.T) {
 		t.Fatalf("expected public route to pass middleware, got %d", publicRR.Code)
 	}
 }
+
+// serveRevoke runs Revoke behind AuthMiddleware with the given bearer token.
+func serveRevoke(u *AuthUseCaseImpl, bearer string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/revoke", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	rr := httptest.NewRecorder()
+	u.AuthMiddleware()(http.HandlerFunc(u.Revoke)).ServeHTTP(rr, req)
+	return rr
+}
+
+// TestAuthUseCaseRevokeTokenFailsValidate verifies a revoked token no longer validates.
+func TestAuthUseCaseRevokeTokenFailsValidate(t *testing.T) {
+	authRepo := &fakeAuthRepo{}
+	u := newTestAuthUseCase(authRepo)
+	issued, err := u.issueToken("user", "1", []string{"user_all"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	token := issued.token
+
+	rr := serveRevoke(u, token, `{"token":"`+token+`","reason":"logout"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if len(authRepo.revokedTokens) != 1 || authRepo.revokedTokens[0].RevokedBy != "user:1" {
+		t.Fatalf("expected persisted revocation by user:1, got %#v", authRepo.revokedTokens)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/validate", strings.NewReader(`{"token":"`+token+`"}`))
+	validateRR := httptest.NewRecorder()
+	u.Validate(validateRR, req)
+
+	if validateRR.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked token to be rejected, got %d", validateRR.Code)
+	}
+}
+
+// TestAuthUseCaseRevokeSubjectRequiresAdmin verifies only admins revoke other subjects.
+func TestAuthUseCaseRevokeSubjectRequiresAdmin(t *testing.T) {
+	authRepo := &fakeAuthRepo{}
+	u := newTestAuthUseCase(authRepo)
+
+	userIssued, err := u.issueToken("user", "1", []string{"user_all"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue user token: %v", err)
+	}
+	userToken := userIssued.token
+	rr := serveRevoke(u, userToken, `{"subject":"2"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 for non-admin, got %d", rr.Code)
+	}
+
+	adminIssued, err := u.issueToken("user", "4", []string{"admin"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue admin token: %v", err)
+	}
+	adminToken := adminIssued.token
+	rr = serveRevoke(u, adminToken, `{"subject":"2","reason":"compromised"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for admin, got %d", rr.Code)
+	}
+	if len(authRepo.subjectRevocations) != 1 || authRepo.subjectRevocations[0].Subject != "2" {
+		t.Fatalf("expected subject 2 revocation, got %#v", authRepo.subjectRevocations)
+	}
+}
+
+// postJSON runs a handler with a JSON body and returns the recorder.
+func postJSON(handler http.HandlerFunc, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+// TestAuthUseCaseRefreshRotationAndReuse verifies refresh rotates tokens and reuse revokes the family.
+func TestAuthUseCaseRefreshRotationAndReuse(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	u := newTestAuthUseCase(authRepo)
+
+	rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+	var login types.LoginResponse
+	if err = json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+	if login.RefreshToken == "" {
+		t.Fatalf("expected refresh token on login")
+	}
+
+	rr = postJSON(u.Refresh, "/auth/refresh", `{"refresh_token":"`+login.RefreshToken+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on refresh, got %d", rr.Code)
+	}
+	var refreshed types.RefreshResponse
+	if err = json.NewDecoder(rr.Body).Decode(&refreshed); err != nil {
+		t.Fatalf("decode refresh response: %v", err)
+	}
+	if refreshed.Token == "" || refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+		t.Fatalf("expected rotated token pair, got %#v", refreshed)
+	}
+
+	rr = postJSON(u.Refresh, "/auth/refresh", `{"refresh_token":"`+login.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected reused refresh token to be rejected, got %d", rr.Code)
+	}
+
+	rr = postJSON(u.Refresh, "/auth/refresh", `{"refresh_token":"`+refreshed.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected family to be revoked after reuse, got %d", rr.Code)
+	}
+	if _, err = u.validateTokenCore(context.Background(), refreshed.Token); err == nil {
+		t.Fatalf("expected access token of revoked family to be rejected")
+	}
+}
+
+// TestAuthUseCaseLogoutRevokesFamily verifies logout invalidates refresh and access tokens of the family.
+func TestAuthUseCaseLogoutRevokesFamily(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate service secret hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		service: types.ServiceRecord{ID: 2, SecretHash: string(hash), Role: "users_gw"},
+	}
+	u := newTestAuthUseCase(authRepo)
+
+	rr := postJSON(u.ServiceToken, "/auth/service-token", `{"service_id":"2","secret":"123"}`)
+	var issued types.ServiceTokenResponse
+	if err = json.NewDecoder(rr.Body).Decode(&issued); err != nil {
+		t.Fatalf("decode service token response: %v", err)
+	}
+
+	rr = postJSON(u.Logout, "/auth/logout", `{"refresh_token":"`+issued.RefreshToken+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on logout, got %d", rr.Code)
+	}
+
+	rr = postJSON(u.Refresh, "/auth/refresh", `{"refresh_token":"`+issued.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected refresh after logout to be rejected, got %d", rr.Code)
+	}
+	if _, err = u.validateTokenCore(context.Background(), issued.Token); err == nil {
+		t.Fatalf("expected access token to be revoked on logout")
+	}
+}
+
+// TestAuthUseCaseLoginRightAfterSubjectRevocation verifies a login right after a subject revocation yields a usable token.
+func TestAuthUseCaseLoginRightAfterSubjectRevocation(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	u := newTestAuthUseCase(&fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	})
+	ctx := context.Background()
+
+	// start early in a second, so the older token, the revocation and the login all share it
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second + 20*time.Millisecond)))
+	before, err := u.issueToken("user", "1", []string{"user_all"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	revokedAt := time.Now().UTC()
+	err = u.revokeSubjectCore(ctx, types.SubjectRevocation{TokenType: "user", Subject: "1", RevokedBefore: revokedAt, Reason: "password reset", RevokedBy: "user:1"})
+	if err != nil {
+		t.Fatalf("revoke subject: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+	var login types.LoginResponse
+	if err = json.NewDecoder(rr.Body).Decode(&login); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected login status 200, got %d err=%v", rr.Code, err)
+	}
+	principal, err := u.validateTokenCore(ctx, login.Token)
+	if err != nil {
+		t.Fatalf("expected token issued after the revocation to validate, got %v", err)
+	}
+	issuedAt, _ := time.Parse(time.RFC3339, principal.IssuedAt)
+	if issuedAt.Unix() != revokedAt.Unix() {
+		t.Fatalf("expected login in the revocation's second, got %s vs %s", issuedAt, revokedAt)
+	}
+	if _, err = u.validateTokenCore(ctx, before.token); err == nil {
+		t.Fatal("expected token issued before the revocation to be rejected")
+	}
+}
+
+// TestAuthUseCaseTokenTTLFor verifies role over token_type over default resolution, the shortest role across effective roles, max cap and client shortening.
+func TestAuthUseCaseTokenTTLFor(t *testing.T) {
+	u := NewAuthUseCase(&fakeAuthRepo{}, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		TokenTTL: types.TokenTTLSettings{
+			DefaultSec:           3600,
+			MaxSec:               7200,
+			TokenTypes:           map[string]int{"service": 14400},
+			Roles:                map[string]int{"admin": 900},
+			AllowClientExpiresIn: true,
+		},
+	})
+
+	cases := []struct {
+		name      string
+		tokenType string
+		roles     []string
+		requested time.Duration
+		want      time.Duration
+	}{
+		{name: "default", tokenType: "user", roles: []string{"user_all"}, want: time.Hour},
+		{name: "token type capped by max", tokenType: "service", roles: []string{"users_gw"}, want: 2 * time.Hour},
+		{name: "role wins", tokenType: "user", roles: []string{"admin"}, want: 15 * time.Minute},
+		{name: "assigned admin role wins over primary", tokenType: "user", roles: []string{"user_all", "admin"}, want: 15 * time.Minute},
+		{name: "client shorter", tokenType: "user", roles: []string{"user_all"}, requested: 5 * time.Minute, want: 5 * time.Minute},
+		{name: "client longer ignored", tokenType: "user", roles: []string{"admin"}, requested: time.Hour, want: 15 * time.Minute},
+	}
+
+	for _, tc := range cases {
+		if got := u.tokenTTLFor(tc.tokenType, tc.roles, tc.requested); got != tc.want {
+			t.Fatalf("%s: expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+// TestAuthUseCaseLoginExpiresIn verifies a requested expires_in shortens the issued token only when allowed.
+func TestAuthUseCaseLoginExpiresIn(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+
+	for _, allow := range []bool{true, false} {
+		u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+			TokenTTL: types.TokenTTLSettings{DefaultSec: 3600, AllowClientExpiresIn: allow},
+		})
+
+		rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123","expires_in":300}`)
+		var resp types.LoginResponse
+		if err = json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode login response: %v", err)
+		}
+
+		want := int64(3600)
+		if allow {
+			want = 300
+		}
+		if resp.ExpiresIn != want {
+			t.Fatalf("allow=%v: expected expires_in %d, got %d", allow, want, resp.ExpiresIn)
+		}
+
+		claims, err := u.parseTokenClaims(resp.Token)
+		if err != nil {
+			t.Fatalf("parse issued token: %v", err)
+		}
+		expiresAt, _ := time.Parse(time.RFC3339, claims.ExpiresAt)
+		if remaining := time.Until(expiresAt); remaining > time.Duration(want)*time.Second || remaining < time.Duration(want-5)*time.Second {
+			t.Fatalf("allow=%v: expected exp about %ds ahead, got %s", allow, want, remaining)
+		}
+	}
+
+	u := newTestAuthUseCase(authRepo)
+	rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123","expires_in":-1}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for negative expires_in, got %d", rr.Code)
+	}
+}
+
+// TestAuthUseCaseLoginLockoutAndUnlock verifies delays, lockout of unknown and known usernames, and admin unlock.
+func TestAuthUseCaseLoginLockoutAndUnlock(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	attempts := newFakeLoginAttemptRepo()
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), attempts, []byte("test-secret"), types.AuthSettings{
+		AdminRoles:      []string{"admin"},
+		LoginProtection: types.LoginProtection{MaxFailures: 3, DelayAfterFailures: 2, BaseDelayMs: 2000},
+	})
+	userKey := types.AttemptKey{Kind: types.AttemptKindUser, Identifier: "user_all"}
+
+	rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"wrong"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 on first failure, got %d", rr.Code)
+	}
+	rr = postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"wrong"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 on second failure, got %d", rr.Code)
+	}
+
+	rr = postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected delayed attempt with Retry-After 2, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	// let the username and client ip delays pass and fail once more to reach the lockout threshold
+	clear(attempts.blocked)
+	postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"wrong"}`)
+	if !attempts.locked[userKey] {
+		t.Fatalf("expected username to be locked after max failures")
+	}
+	if len(authRepo.lockoutEvents) != 1 || authRepo.lockoutEvents[0].Action != "locked" {
+		t.Fatalf("expected locked audit event, got %#v", authRepo.lockoutEvents)
+	}
+
+	adminIssued, err := u.issueToken("user", "4", []string{"admin"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue admin token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/auth/admin/unlock", strings.NewReader(`{"kind":"user","identifier":"user_all"}`))
+	req.Header.Set("Authorization", "Bearer "+adminIssued.token)
+	rr = httptest.NewRecorder()
+	u.AuthMiddleware()(http.HandlerFunc(u.Unlock)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on unlock, got %d", rr.Code)
+	}
+	if len(authRepo.lockoutEvents) != 2 || authRepo.lockoutEvents[1].Action != "unlocked" || authRepo.lockoutEvents[1].Actor != "user:4" {
+		t.Fatalf("expected unlocked audit event by user:4, got %#v", authRepo.lockoutEvents)
+	}
+
+	if attempts.locked[userKey] {
+		t.Fatalf("expected username lockout to be cleared")
+	}
+
+	// the client ip delay is independent of the username unlock; let it pass
+	clear(attempts.blocked)
+	rr = postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login to work after unlock, got %d", rr.Code)
+	}
+}
+
+// TestAuthUseCaseLoginUnknownUserCountsFailure verifies unknown usernames are throttled like known ones.
+func TestAuthUseCaseLoginUnknownUserCountsFailure(t *testing.T) {
+	attempts := newFakeLoginAttemptRepo()
+	u := NewAuthUseCase(&fakeAuthRepo{userErr: errors.New("not found")}, newFakeRevocationRepo(), attempts, []byte("test-secret"), types.AuthSettings{})
+
+	rr := postJSON(u.Login, "/auth/login", `{"username":"ghost","password":"x"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+	if attempts.failures[types.AttemptKey{Kind: types.AttemptKindUser, Identifier: "ghost"}] != 1 {
+		t.Fatalf("expected failure to be counted for unknown username")
+	}
+	if attempts.failures[types.AttemptKey{Kind: types.AttemptKindIP, Identifier: "192.0.2.1"}] != 1 {
+		t.Fatalf("expected failure to be counted for client ip, got %#v", attempts.failures)
+	}
+}
+
+// serveAsUser runs handler behind AuthMiddleware with a token for the given user and role.
+func serveAsUser(t *testing.T, u *AuthUseCaseImpl, handler http.HandlerFunc, req *http.Request, subject string, role string) *httptest.ResponseRecorder {
+	t.Helper()
+	issued, err := u.issueToken("user", subject, []string{role}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+issued.token)
+	rr := httptest.NewRecorder()
+	u.AuthMiddleware()(handler).ServeHTTP(rr, req)
+	return rr
+}
+
+// TestAuthUseCaseCreateUser verifies admin-only creation, the password policy and duplicate usernames.
+func TestAuthUseCaseCreateUser(t *testing.T) {
+	authRepo := &fakeAuthRepo{user: types.UserRecord{ID: 1, Username: "user_all", Role: "user_all"}}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		AdminRoles:     []string{"admin"},
+		PasswordPolicy: types.PasswordPolicy{MinLength: 10, RequireDigit: true},
+		BcryptCost:     bcrypt.MinCost,
+	})
+	newRequest := func(body string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/auth/admin/users", strings.NewReader(body))
+	}
+
+	rr := serveAsUser(t, u, u.CreateUser, newRequest(`{"username":"bob","password":"correct-horse-1","role":"user_all"}`), "1", "user_all")
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 for non-admin, got %d", rr.Code)
+	}
+
+	rr = serveAsUser(t, u, u.CreateUser, newRequest(`{"username":"bob","password":"correct-horse","role":"user_all"}`), "4", "admin")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for password without digit, got %d", rr.Code)
+	}
+
+	rr = serveAsUser(t, u, u.CreateUser, newRequest(`{"username":"user_all","password":"correct-horse-1","role":"user_all"}`), "4", "admin")
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 for duplicate username, got %d", rr.Code)
+	}
+
+	rr = serveAsUser(t, u, u.CreateUser, newRequest(`{"username":"bob","password":"correct-horse-1","role":"user_all"}`), "4", "admin")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "password") {
+		t.Fatalf("expected response without password fields, got %s", rr.Body.String())
+	}
+	if len(authRepo.createdUsers) != 1 {
+		t.Fatalf("expected one created user, got %#v", authRepo.createdUsers)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(authRepo.createdUsers[0].PasswordHash), []byte("correct-horse-1"))
+	if err != nil {
+		t.Fatalf("expected stored bcrypt hash of the password: %v", err)
+	}
+}
+
+// TestAuthUseCaseDisableUserRefusesLogin verifies disabled users cannot log in or refresh and tokens are optionally revoked.
+func TestAuthUseCaseDisableUserRefusesLogin(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	attempts := newFakeLoginAttemptRepo()
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), attempts, []byte("test-secret"), types.AuthSettings{AdminRoles: []string{"admin"}})
+
+	rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+	var login types.LoginResponse
+	if err = json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/auth/admin/users/1/disable", strings.NewReader(`{"revoke_tokens":true,"reason":"left company"}`)), map[string]string{"id": "1"})
+	rr = serveAsUser(t, u, u.DisableUser, req, "4", "admin")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on disable, got %d", rr.Code)
+	}
+	if !authRepo.user.Disabled {
+		t.Fatalf("expected user to be disabled")
+	}
+	if len(authRepo.subjectRevocations) != 1 || authRepo.subjectRevocations[0].Subject != "1" || authRepo.subjectRevocations[0].RevokedBy != "user:4" {
+		t.Fatalf("expected subject revocation of user 1 by user:4, got %#v", authRepo.subjectRevocations)
+	}
+
+	rr = postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for disabled user, got %d", rr.Code)
+	}
+	if len(attempts.failures) != 0 {
+		t.Fatalf("expected disabled login not to count as a failed attempt, got %#v", attempts.failures)
+	}
+
+	// a refresh token issued before the disable must not work even without the subject cutoff
+	authRepo.subjectRevocations = nil
+	u.revocations = newFakeRevocationRepo()
+	rr = postJSON(u.Refresh, "/auth/refresh", `{"refresh_token":"`+login.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 on refresh of disabled user, got %d", rr.Code)
+	}
+
+	req = mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/auth/admin/users/4/disable", nil), map[string]string{"id": "4"})
+	rr = serveAsUser(t, u, u.DisableUser, req, "4", "admin")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 when disabling own user, got %d", rr.Code)
+	}
+}
+
+// TestAuthUseCaseChangePassword verifies current-password verification and the password policy.
+func TestAuthUseCaseChangePassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("old-password-1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		PasswordPolicy: types.PasswordPolicy{RequireUpper: true},
+		BcryptCost:     bcrypt.MinCost,
+	})
+	newRequest := func(body string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/auth/password", strings.NewReader(body))
+	}
+
+	rr := serveAsUser(t, u, u.ChangePassword, newRequest(`{"current_password":"wrong","new_password":"New-password-1"}`), "1", "user_all")
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for wrong current password, got %d", rr.Code)
+	}
+
+	rr = serveAsUser(t, u, u.ChangePassword, newRequest(`{"current_password":"old-password-1","new_password":"new-password-1"}`), "1", "user_all")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for password without uppercase letter, got %d", rr.Code)
+	}
+
+	rr = serveAsUser(t, u, u.ChangePassword, newRequest(`{"current_password":"old-password-1","new_password":"New-password-1"}`), "1", "user_all")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	rr = postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"New-password-1"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login with new password, got %d", rr.Code)
+	}
+}
+
+// rotateServiceSecret runs RotateServiceSecret for service 2 as an admin and returns the generated secret.
+func rotateServiceSecret(t *testing.T, u *AuthUseCaseImpl, body string) types.ServiceCredentialsResponse {
+	t.Helper()
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/auth/admin/services/2/secrets", strings.NewReader(body)), map[string]string{"id": "2"})
+	rr := serveAsUser(t, u, u.RotateServiceSecret, req, "4", "admin")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201 on rotation, got %d", rr.Code)
+	}
+
+	var creds types.ServiceCredentialsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&creds); err != nil {
+		t.Fatalf("decode rotation response: %v", err)
+	}
+	return creds
+}
+
+// TestAuthUseCaseServiceSecretRotationOverlap verifies old and new secrets overlap until the old ones are retired.
+func TestAuthUseCaseServiceSecretRotationOverlap(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate service secret hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		service: types.ServiceRecord{ID: 2, Name: "users_gw", SecretHash: string(hash), Role: "users_gw"},
+	}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		AdminRoles: []string{"admin"},
+		BcryptCost: bcrypt.MinCost,
+	})
+	serviceToken := func(secret string) int {
+		return postJSON(u.ServiceToken, "/auth/service-token", `{"service_id":"2","secret":"`+secret+`"}`).Code
+	}
+
+	first := rotateServiceSecret(t, u, `{"retire_existing_in_sec":3600}`)
+	if first.Secret == "" || first.ServiceID != "2" {
+		t.Fatalf("expected generated secret for service 2, got %#v", first)
+	}
+	if authRepo.service.SecretHash != "" || len(authRepo.serviceSecrets) != 2 {
+		t.Fatalf("expected legacy secret to move into service_secrets, got %#v", authRepo.serviceSecrets)
+	}
+	if serviceToken("123") != http.StatusOK || serviceToken(first.Secret) != http.StatusOK {
+		t.Fatalf("expected old and new secrets to work during the overlap")
+	}
+	if authRepo.serviceSecrets[1].LastUsedAt == nil {
+		t.Fatalf("expected last use of the new secret to be recorded")
+	}
+
+	second := rotateServiceSecret(t, u, `{"retire_existing_in_sec":0}`)
+	if serviceToken("123") != http.StatusUnauthorized || serviceToken(first.Secret) != http.StatusUnauthorized {
+		t.Fatalf("expected retired secrets to be rejected")
+	}
+	if serviceToken(second.Secret) != http.StatusOK {
+		t.Fatalf("expected newest secret to work")
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/auth/admin/services/2/disable", nil), map[string]string{"id": "2"})
+	rr := serveAsUser(t, u, u.DisableService, req, "4", "admin")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on disable, got %d", rr.Code)
+	}
+	if serviceToken(second.Secret) != http.StatusUnauthorized {
+		t.Fatalf("expected disabled service to be refused")
+	}
+}
+
+// postForm sends a form-encoded request to the OAuth token endpoint, optionally with HTTP Basic client credentials.
+func postForm(u *AuthUseCaseImpl, form url.Values, clientID string, clientSecret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+	rr := httptest.NewRecorder()
+	u.OAuthToken(rr, req)
+	return rr
+}
+
+// TestAuthUseCaseOAuthClientCredentials verifies client_credentials with Basic and body client auth and RFC 6749 errors.
+func TestAuthUseCaseOAuthClientCredentials(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate service secret hash: %v", err)
+	}
+	u := newTestAuthUseCase(&fakeAuthRepo{
+		service: types.ServiceRecord{ID: 2, Name: "users_gw", SecretHash: string(hash), Role: "users_gw"},
+	})
+
+	rr := postForm(u, url.Values{"grant_type": {"client_credentials"}}, "2", "123")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 with Basic client auth, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected Cache-Control no-store, got %q", rr.Header().Get("Cache-Control"))
+	}
+	var resp types.OAuthTokenResponse
+	if err = json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode token response: %v", err)
+	}
+	if resp.AccessToken == "" || resp.TokenType != "Bearer" || resp.ExpiresIn <= 0 || resp.Scope != "users:read" {
+		t.Fatalf("unexpected token response %#v", resp)
+	}
+	if resp.RefreshToken != "" {
+		t.Fatalf("expected no refresh token for client_credentials")
+	}
+	validated, err := u.validateTokenCore(context.Background(), resp.AccessToken)
+	if err != nil || validated.TokenType != "service" || validated.Subject != "2" {
+		t.Fatalf("expected service token for service 2, got %#v (%v)", validated, err)
+	}
+
+	rr = postForm(u, url.Values{"grant_type": {"client_credentials"}, "client_id": {"2"}, "client_secret": {"123"}}, "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 with body client auth, got %d", rr.Code)
+	}
+
+	cases := []struct {
+		name   string
+		form   url.Values
+		id     string
+		secret string
+		status int
+		code   string
+	}{
+		{"wrong secret", url.Values{"grant_type": {"client_credentials"}}, "2", "wrong", http.StatusUnauthorized, "invalid_client"},
+		{"missing client", url.Values{"grant_type": {"client_credentials"}}, "", "", http.StatusUnauthorized, "invalid_client"},
+		{"two client auth methods", url.Values{"grant_type": {"client_credentials"}, "client_id": {"2"}}, "2", "123", http.StatusBadRequest, "invalid_request"},
+		{"missing grant type", url.Values{}, "2", "123", http.StatusBadRequest, "invalid_request"},
+		{"unsupported grant type", url.Values{"grant_type": {"authorization_code"}}, "2", "123", http.StatusBadRequest, "unsupported_grant_type"},
+		{"scope not granted", url.Values{"grant_type": {"client_credentials"}, "scope": {"users:read orders:write"}}, "2", "123", http.StatusBadRequest, "invalid_scope"},
+	}
+	for _, tc := range cases {
+		rr = postForm(u, tc.form, tc.id, tc.secret)
+		var oauthErr types.OAuthErrorResponse
+		_ = json.NewDecoder(rr.Body).Decode(&oauthErr)
+		if rr.Code != tc.status || oauthErr.Error != tc.code {
+			t.Fatalf("%s: expected %d %s, got %d %s", tc.name, tc.status, tc.code, rr.Code, oauthErr.Error)
+		}
+		if tc.status == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("%s: expected WWW-Authenticate challenge", tc.name)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(`{"grant_type":"client_credentials"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	u.OAuthToken(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected JSON body to be rejected, got %d", rr.Code)
+	}
+}
+
+// TestAuthUseCaseOAuthPasswordAndRefresh verifies the password grant issues a token pair the refresh_token grant rotates.
+func TestAuthUseCaseOAuthPasswordAndRefresh(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	u := newTestAuthUseCase(&fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	})
+
+	rr := postForm(u, url.Values{"grant_type": {"password"}, "username": {"user_all"}, "password": {"wrong"}}, "", "")
+	var oauthErr types.OAuthErrorResponse
+	_ = json.NewDecoder(rr.Body).Decode(&oauthErr)
+	if rr.Code != http.StatusBadRequest || oauthErr.Error != "invalid_grant" {
+		t.Fatalf("expected invalid_grant for wrong password, got %d %s", rr.Code, oauthErr.Error)
+	}
+
+	rr = postForm(u, url.Values{"grant_type": {"password"}, "username": {"user_all"}, "password": {"123"}}, "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on password grant, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var issued types.OAuthTokenResponse
+	if err = json.NewDecoder(rr.Body).Decode(&issued); err != nil {
+		t.Fatalf("decode token response: %v", err)
+	}
+	if issued.AccessToken == "" || issued.RefreshToken == "" || issued.Scope != "users:read orders:read orders:write" {
+		t.Fatalf("unexpected password grant response %#v", issued)
+	}
+
+	rr = postForm(u, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {issued.RefreshToken}}, "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on refresh_token grant, got %d", rr.Code)
+	}
+	var refreshed types.OAuthTokenResponse
+	if err = json.NewDecoder(rr.Body).Decode(&refreshed); err != nil {
+		t.Fatalf("decode refresh response: %v", err)
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == issued.RefreshToken || refreshed.Scope != issued.Scope {
+		t.Fatalf("expected rotated token pair, got %#v", refreshed)
+	}
+
+	// the JSON endpoint accepts refresh tokens from the OAuth endpoint and rejects the rotated one
+	rr = postJSON(u.Refresh, "/auth/refresh", `{"refresh_token":"`+issued.RefreshToken+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected rotated refresh token to be rejected, got %d", rr.Code)
+	}
+	rr = postForm(u, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshed.RefreshToken}}, "", "")
+	oauthErr = types.OAuthErrorResponse{}
+	_ = json.NewDecoder(rr.Body).Decode(&oauthErr)
+	if rr.Code != http.StatusBadRequest || oauthErr.Error != "invalid_grant" {
+		t.Fatalf("expected family revoked after reuse, got %d %s", rr.Code, oauthErr.Error)
+	}
+}
+
+// TestAuthUseCaseOAuthIntrospect verifies service-only introspection, active claims and revoked tokens reported inactive.
+func TestAuthUseCaseOAuthIntrospect(t *testing.T) {
+	u := newTestAuthUseCase(&fakeAuthRepo{})
+	caller, err := u.issueToken("service", "1", []string{"api_gw"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue caller token: %v", err)
+	}
+	target, err := u.issueToken("user", "3", []string{"user_all"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue target token: %v", err)
+	}
+	introspect := func(bearer string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rr := httptest.NewRecorder()
+		u.AuthMiddleware()(http.HandlerFunc(u.OAuthIntrospect)).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := introspect(caller.token, target.token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp types.IntrospectionResponse
+	if err = json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode introspection response: %v", err)
+	}
+	if !resp.Active || resp.Subject != "3" || resp.JTI != target.jti || resp.SubjectType != "user" || resp.Scope != "users:read orders:read orders:write" {
+		t.Fatalf("unexpected introspection response %#v", resp)
+	}
+	if resp.ExpiresAt != target.expiresAt.Unix() || resp.IssuedAt == 0 || resp.ClientID != "" {
+		t.Fatalf("unexpected introspection times or client %#v", resp)
+	}
+
+	rr = introspect(target.token, caller.token)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected user token caller to be forbidden, got %d", rr.Code)
+	}
+
+	rr = introspect(caller.token, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected missing token to be rejected, got %d", rr.Code)
+	}
+
+	rr = serveRevoke(u, target.token, `{"token":"`+target.token+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on revoke, got %d", rr.Code)
+	}
+	rr = introspect(caller.token, target.token)
+	resp = types.IntrospectionResponse{}
+	if err = json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode introspection response: %v", err)
+	}
+	if rr.Code != http.StatusOK || resp.Active || resp.Subject != "" {
+		t.Fatalf("expected revoked token to be inactive without claims, got %d %#v", rr.Code, resp)
+	}
+}
+
+// TestAuthUseCaseRoleAssignmentsAndInheritance verifies assigned and inherited roles reach the roles and scope claims.
+func TestAuthUseCaseRoleAssignmentsAndInheritance(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 2, Username: "user_users", PasswordHash: string(hash), Role: "user_users"},
+	}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		AdminRoles: []string{"admin"},
+		RoleScopes: map[string][]string{
+			"user_users":  {"users:read"},
+			"user_orders": {"orders:read", "orders:write"},
+		},
+		RoleInheritance: map[string][]string{
+			"admin":    {"user_all"},
+			"user_all": {"user_users", "user_orders"},
+		},
+	})
+
+	if got := u.effectiveRoles("admin", nil); !slices.Equal(got, []string{"admin", "user_all", "user_users", "user_orders"}) {
+		t.Fatalf("unexpected inherited roles %#v", got)
+	}
+
+	setRoles := func(callerRoles []string, body string) *httptest.ResponseRecorder {
+		issued, err := u.issueToken("user", "4", callerRoles, time.Hour)
+		if err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/auth/admin/users/2/roles", strings.NewReader(body)), map[string]string{"id": "2"})
+		req.Header.Set("Authorization", "Bearer "+issued.token)
+		rr := httptest.NewRecorder()
+		u.AuthMiddleware()(http.HandlerFunc(u.SetUserRoles)).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := setRoles([]string{"user_all", "user_users", "user_orders"}, `{"roles":["user_orders"]}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected non-admin to be forbidden, got %d", rr.Code)
+	}
+	if rr := setRoles([]string{"ops", "admin"}, `{"roles":["bad role"]}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid role name to be rejected, got %d", rr.Code)
+	}
+
+	// admin only as an assigned role still grants admin access
+	rr := setRoles([]string{"ops", "admin"}, `{"roles":["user_orders"," user_orders "]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rolesResp types.RolesResponse
+	if err = json.NewDecoder(rr.Body).Decode(&rolesResp); err != nil {
+		t.Fatalf("decode roles response: %v", err)
+	}
+	if rolesResp.Role != "user_users" || !slices.Equal(rolesResp.Roles, []string{"user_orders"}) || !slices.Equal(rolesResp.EffectiveRoles, []string{"user_users", "user_orders"}) {
+		t.Fatalf("unexpected roles response %#v", rolesResp)
+	}
+
+	rr = postJSON(u.Login, "/auth/login", `{"username":"user_users","password":"123"}`)
+	var login types.LoginResponse
+	if err = json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+	validated, err := u.validateTokenCore(context.Background(), login.Token)
+	if err != nil {
+		t.Fatalf("validate token: %v", err)
+	}
+	if validated.Role != "user_users" || !slices.Equal(validated.Roles, []string{"user_users", "user_orders"}) {
+		t.Fatalf("unexpected role claims %#v", validated)
+	}
+	if validated.Scope != "users:read orders:read orders:write" {
+		t.Fatalf("expected scopes of every role, got %q", validated.Scope)
+	}
+}
+
+// TestAuthUseCaseAPIKeyLifecycle verifies API keys are created narrowed to their owner, validate like tokens and stop working once deleted.
+func TestAuthUseCaseAPIKeyLifecycle(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", Role: "user_all"},
+	}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		AdminRoles: []string{"admin"},
+		RoleScopes: map[string][]string{
+			"user_all": {"users:read", "orders:read", "orders:write"},
+		},
+		APIKeys: types.APIKeySettings{MaxTTLSec: 86400},
+	})
+	createKey := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/admin/api-keys", strings.NewReader(body))
+		return serveAsUser(t, u, u.CreateAPIKey, req, "4", "admin")
+	}
+
+	if rr := createKey(`{"name":"batch","subject_id":1,"roles":["admin"]}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected role the owner does not hold to be rejected, got %d", rr.Code)
+	}
+	if rr := createKey(`{"name":"batch","subject_id":1,"scope":"users:write"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected scope the roles do not grant to be rejected, got %d", rr.Code)
+	}
+	if rr := createKey(`{"name":"batch","subject_id":1,"expires_in_sec":86401}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected lifetime above max_ttl_sec to be rejected, got %d", rr.Code)
+	}
+
+	rr := createKey(`{"name":"batch","subject_id":1,"scope":"orders:read","rate_limit":3}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created types.APIKeyCreatedResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("decode api key response: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, created.Prefix+"_") || !strings.HasPrefix(created.Prefix, apiKeyPrefix) {
+		t.Fatalf("expected key to start with its visible prefix, got %#v", created)
+	}
+	if len(authRepo.apiKeys) != 1 || strings.Contains(authRepo.apiKeys[0].KeyHash, created.Key) || authRepo.apiKeys[0].KeyHash != hashAPIKey(created.Key) {
+		t.Fatalf("expected only the key hash to be stored, got %#v", authRepo.apiKeys)
+	}
+
+	validate := func(body string) *httptest.ResponseRecorder {
+		return postJSON(u.Validate, "/auth/validate", body)
+	}
+	rr = validate(`{"api_key":"` + created.Key + `"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected api key to validate, got %d", rr.Code)
+	}
+	var resp types.ValidateResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode validate response: %v", err)
+	}
+	if resp.APIKey != created.APIKey || resp.Subject != "1" || resp.TokenType != "user" || resp.Role != "user_all" {
+		t.Fatalf("expected api key mapped onto token metadata, got %#v", resp)
+	}
+	if resp.Scope != "orders:read" || resp.RateLimit != 3 || resp.ExpiresAt != created.ExpiresAt {
+		t.Fatalf("expected narrowed scope, rate limit and expiry, got %#v", resp)
+	}
+	if authRepo.apiKeys[0].LastUsedAt == nil {
+		t.Fatalf("expected last use to be recorded")
+	}
+
+	if rr = validate(`{"api_key":"` + created.Key + `x"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected tampered key to be rejected, got %d", rr.Code)
+	}
+	if rr = validate(`{"api_key":"` + created.Key + `","token":"x"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected token and api_key together to be rejected, got %d", rr.Code)
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/auth/admin/api-keys/1", nil), map[string]string{"id": "1"})
+	if rr = serveAsUser(t, u, u.DeleteAPIKey, req, "4", "admin"); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on delete, got %d", rr.Code)
+	}
+	if len(authRepo.revokedTokens) != 1 || authRepo.revokedTokens[0].JTI != created.APIKey {
+		t.Fatalf("expected deleted key to be revoked for gateways, got %#v", authRepo.revokedTokens)
+	}
+	if rr = validate(`{"api_key":"` + created.Key + `"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected deleted key to be rejected, got %d", rr.Code)
+	}
+}
+
+// TestAuthUseCaseSubjectRevocationCoversAPIKeys verifies a subject cutoff is kept as long as the API keys it covers can live.
+func TestAuthUseCaseSubjectRevocationCoversAPIKeys(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", Role: "user_all"},
+	}
+	revocations := newFakeRevocationRepo()
+	u := NewAuthUseCase(authRepo, revocations, newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		AdminRoles: []string{"admin"},
+		RoleScopes: map[string][]string{"user_all": {"orders:read"}},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/admin/api-keys", strings.NewReader(`{"name":"batch","subject_id":1,"expires_in_sec":25920000}`))
+	rr := serveAsUser(t, u, u.CreateAPIKey, req, "4", "admin")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created types.APIKeyCreatedResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("decode api key response: %v", err)
+	}
+
+	err := u.revokeSubjectCore(context.Background(), types.SubjectRevocation{
+		TokenType:     "user",
+		Subject:       "1",
+		RevokedBefore: time.Now().UTC(),
+		Reason:        "compromised",
+		RevokedBy:     "user:4",
+	})
+	if err != nil {
+		t.Fatalf("revoke subject: %v", err)
+	}
+
+	// well past every access and refresh token, still within the key's 300 days
+	revocations.elapsed = max(u.refreshTTL, u.maxTTL) + 24*time.Hour
+	if rr = postJSON(u.Validate, "/auth/validate", `{"api_key":"`+created.Key+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected api key created before the cutoff to stay revoked, got %d", rr.Code)
+	}
+}
+
+// TestAuthUseCaseSigningKeyValidation verifies signed requests validate as the service and stop working for disabled services or deleted keys.
+func TestAuthUseCaseSigningKeyValidation(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		user:    types.UserRecord{ID: 4, Username: "admin", Role: "admin"},
+		service: types.ServiceRecord{ID: 2, Name: "users_gw", Role: "users_gw"},
+	}
+	u := newTestAuthUseCase(authRepo)
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/auth/admin/services/2/signing-keys", nil), map[string]string{"id": "2"})
+	rr := serveAsUser(t, u, u.CreateSigningKey, req, "4", "admin")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created types.SigningKeyCredentialsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("decode signing key response: %v", err)
+	}
+	if !strings.HasPrefix(created.KeyID, signingKeyPrefix) || created.Secret == "" || created.ServiceID != "2" {
+		t.Fatalf("expected generated key id and secret, got %#v", created)
+	}
+
+	canonical := rest_qol.CanonicalRequest(http.MethodGet, "/api/v1/users/1", "", "1700000000", "abc", "e3b0")
+	validate := func(signature string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(types.ValidateRequest{Signature: &types.SignatureValidation{
+			KeyID:        created.KeyID,
+			StringToSign: canonical,
+			Signature:    signature,
+		}})
+		if err != nil {
+			t.Fatalf("marshal validate request: %v", err)
+		}
+		return postJSON(u.Validate, "/auth/validate", string(body))
+	}
+
+	rr = validate(rest_qol.ComputeSignature([]byte(created.Secret), canonical))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected signature to validate, got %d", rr.Code)
+	}
+	var resp types.ValidateResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode validate response: %v", err)
+	}
+	if resp.APIKey != authRepo.signingKeys[0].APIKey || resp.Subject != "2" || resp.TokenType != "service" || resp.Role != "users_gw" || resp.Scope != "users:read" {
+		t.Fatalf("expected signing key mapped onto service metadata, got %#v", resp)
+	}
+	if resp.ExpiresAt != created.ExpiresAt {
+		t.Fatalf("expected metadata to expire with the key, got %q want %q", resp.ExpiresAt, created.ExpiresAt)
+	}
+
+	if rr = validate(rest_qol.ComputeSignature([]byte("wrong"), canonical)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected signature with another secret to be rejected, got %d", rr.Code)
+	}
+	if rr = postJSON(u.Validate, "/auth/validate", `{"token":"x","signature":{"key_id":"`+created.KeyID+`"}}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected token and signature together to be rejected, got %d", rr.Code)
+	}
+
+	authRepo.service.Disabled = true
+	if rr = validate(rest_qol.ComputeSignature([]byte(created.Secret), canonical)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected disabled service to be refused, got %d", rr.Code)
+	}
+	authRepo.service.Disabled = false
+
+	req = mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/auth/admin/services/2/signing-keys/"+created.KeyID, nil), map[string]string{"id": "2", "key_id": created.KeyID})
+	if rr = serveAsUser(t, u, u.DeleteSigningKey, req, "4", "admin"); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on delete, got %d", rr.Code)
+	}
+	if rr = validate(rest_qol.ComputeSignature([]byte(created.Secret), canonical)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected deleted key to be rejected, got %d", rr.Code)
+	}
+}
+
+// TestTOTPCodeRFC6238Vectors checks totpCode against the SHA1 vectors of RFC 6238 appendix B, truncated to six digits.
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := totpCode(secret, unix/totpPeriodSec)
+		if err != nil {
+			t.Fatalf("totp code: %v", err)
+		}
+		if got != want {
+			t.Fatalf("time %d: expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+// TestAuthUseCaseMFALogin verifies required MFA enrolls at login, codes and recovery codes work once and the password grant is refused.
+func TestAuthUseCaseMFALogin(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		MFA: types.MFASettings{RequiredRoles: []string{"user_all"}, RecoveryCodes: 2, MaxChallengeAttempts: 2},
+	})
+	login := func() types.MFAChallengeResponse {
+		t.Helper()
+		rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+		var challenge types.MFAChallengeResponse
+		if err := json.NewDecoder(rr.Body).Decode(&challenge); err != nil {
+			t.Fatalf("decode login response: %v", err)
+		}
+		if rr.Code != http.StatusOK || !challenge.MFARequired || challenge.MFAToken == "" {
+			t.Fatalf("expected mfa challenge, got %d %#v", rr.Code, challenge)
+		}
+		return challenge
+	}
+	completeMFA := func(challenge types.MFAChallengeResponse, field string, code string) *httptest.ResponseRecorder {
+		return postJSON(u.LoginMFA, "/auth/login/mfa", `{"mfa_token":"`+challenge.MFAToken+`","`+field+`":"`+code+`"}`)
+	}
+	step := time.Now().Unix() / totpPeriodSec
+
+	challenge := login()
+	if challenge.Enrollment == nil || !strings.HasPrefix(challenge.Enrollment.ProvisioningURI, "otpauth://totp/go-gw-test:user_all?") {
+		t.Fatalf("expected required enrollment to start at login, got %#v", challenge.Enrollment)
+	}
+	secret := challenge.Enrollment.Secret
+	code, err := totpCode(secret, step)
+	if err != nil {
+		t.Fatalf("totp code: %v", err)
+	}
+
+	rr := completeMFA(challenge, "code", code)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected first code to confirm enrollment and log in, got %d", rr.Code)
+	}
+	var resp types.LoginResponse
+	if err = json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode mfa login response: %v", err)
+	}
+	if resp.Token == "" || len(resp.RecoveryCodes) != 2 {
+		t.Fatalf("expected tokens and recovery codes, got %#v", resp)
+	}
+	if rr = completeMFA(challenge, "code", code); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected used challenge to be rejected, got %d", rr.Code)
+	}
+
+	form := url.Values{"grant_type": {"password"}, "username": {"user_all"}, "password": {"123"}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	u.OAuthToken(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_grant") {
+		t.Fatalf("expected password grant to be refused for mfa users, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	challenge = login()
+	if challenge.Enrollment != nil {
+		t.Fatalf("expected no enrollment once confirmed, got %#v", challenge.Enrollment)
+	}
+	if rr = completeMFA(challenge, "code", code); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed code to be rejected, got %d", rr.Code)
+	}
+	next, err := totpCode(secret, step+1)
+	if err != nil {
+		t.Fatalf("totp code: %v", err)
+	}
+	if rr = completeMFA(challenge, "code", next); rr.Code != http.StatusOK {
+		t.Fatalf("expected code of the next step to be accepted, got %d", rr.Code)
+	}
+
+	challenge = login()
+	if rr = completeMFA(challenge, "recovery_code", strings.ToUpper(resp.RecoveryCodes[0])); rr.Code != http.StatusOK {
+		t.Fatalf("expected recovery code to be accepted, got %d", rr.Code)
+	}
+	challenge = login()
+	if rr = completeMFA(challenge, "recovery_code", resp.RecoveryCodes[0]); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected used recovery code to be rejected, got %d", rr.Code)
+	}
+	if rr = completeMFA(challenge, "code", "000000"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected wrong code to be rejected, got %d", rr.Code)
+	}
+	if rr = completeMFA(challenge, "recovery_code", resp.RecoveryCodes[1]); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected challenge to be dropped after max attempts, got %d", rr.Code)
+	}
+}
+
+// TestPasswordHashingArgon2id verifies argon2id hashes round-trip and report outdated parameters.
+func TestPasswordHashingArgon2id(t *testing.T) {
+	small := types.Argon2idSettings{MemoryKiB: 1024, Iterations: 1, Parallelism: 1}
+	p := newPasswordHashing(types.AuthSettings{
+		BcryptCost:      bcrypt.MinCost,
+		PasswordHashing: types.PasswordHashing{Algorithm: "argon2id", Argon2id: small},
+	})
+
+	hash, err := p.Hash("correct-horse-1")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("expected argon2id hash carrying its parameters, got %q", hash)
+	}
+
+	ok, needsRehash, err := p.Verify(hash, "correct-horse-1")
+	if err != nil || !ok || needsRehash {
+		t.Fatalf("expected current hash to verify without rehash, got ok=%v rehash=%v err=%v", ok, needsRehash, err)
+	}
+	if ok, _, _ = p.Verify(hash, "wrong-horse-1"); ok {
+		t.Fatalf("expected wrong password to fail")
+	}
+
+	small.Iterations = 2
+	tuned := newPasswordHashing(types.AuthSettings{
+		PasswordHashing: types.PasswordHashing{Algorithm: "argon2id", Argon2id: small},
+	})
+	ok, needsRehash, err = tuned.Verify(hash, "correct-horse-1")
+	if err != nil || !ok || !needsRehash {
+		t.Fatalf("expected hash with old parameters to verify and need rehash, got ok=%v rehash=%v err=%v", ok, needsRehash, err)
+	}
+
+	if _, _, err = p.Verify("plain-text", "plain-text"); !errors.Is(err, errUnknownPasswordHash) {
+		t.Fatalf("expected unknown hash format error, got %v", err)
+	}
+}
+
+// TestAuthUseCaseLoginRehashesPassword verifies a bcrypt hash is upgraded to argon2id on successful login.
+func TestAuthUseCaseLoginRehashesPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		PasswordHashing: types.PasswordHashing{
+			Algorithm: "argon2id",
+			Argon2id:  types.Argon2idSettings{MemoryKiB: 1024, Iterations: 1, Parallelism: 1},
+		},
+	})
+
+	rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"wrong"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 for wrong password, got %d", rr.Code)
+	}
+	if authRepo.user.PasswordHash != string(hash) {
+		t.Fatalf("expected failed login to keep the stored hash")
+	}
+
+	rr = postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if !strings.HasPrefix(authRepo.user.PasswordHash, "$argon2id$") {
+		t.Fatalf("expected stored hash to be upgraded to argon2id, got %q", authRepo.user.PasswordHash)
+	}
+
+	rr = postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login with upgraded hash, got %d", rr.Code)
+	}
+}
+
+// TestAuthUseCaseAuthEvents verifies login events carry request context and the admin query filters and pages them.
+func TestAuthUseCaseAuthEvents(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		AdminRoles: []string{"admin"},
+		Audit:      types.AuditSettings{RetentionDays: 30},
+	})
+	login := func(password string) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username":"user_all","password":"`+password+`"}`))
+		req.RemoteAddr = "10.0.0.7:51000"
+		req.Header.Set("User-Agent", "audit-test")
+		req.Header.Set("X-Request-Id", "req-"+password)
+		u.AuditMiddleware()(http.HandlerFunc(u.Login)).ServeHTTP(httptest.NewRecorder(), req)
+	}
+	login("wrong")
+	login("123")
+	login("123")
+
+	if len(authRepo.authEvents) != 3 {
+		t.Fatalf("expected 3 auth events, got %#v", authRepo.authEvents)
+	}
+	failure := authRepo.authEvents[0]
+	if failure.EventType != "login" || failure.Outcome != auditOutcomeFailure || failure.Subject != "user_all" || failure.Reason != "invalid credentials" {
+		t.Fatalf("unexpected failure event %#v", failure)
+	}
+	if failure.ClientIP != "10.0.0.7" || failure.UserAgent != "audit-test" || failure.RequestID != "req-wrong" {
+		t.Fatalf("expected request context on event, got %#v", failure)
+	}
+
+	list := func(query string, role string) (*httptest.ResponseRecorder, types.AuthEventsResponse) {
+		t.Helper()
+		rr := serveAsUser(t, u, u.ListAuthEvents, httptest.NewRequest(http.MethodGet, "/auth/admin/audit-events?"+query, nil), "4", role)
+		var resp types.AuthEventsResponse
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode auth events: %v", err)
+			}
+		}
+		return rr, resp
+	}
+
+	if rr, _ := list("", "user_all"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 for non-admin, got %d", rr.Code)
+	}
+	if rr, _ := list("limit=0", "admin"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid limit, got %d", rr.Code)
+	}
+
+	_, page := list("subject=user_all&outcome=success&limit=1", "admin")
+	if len(page.Events) != 1 || page.Events[0].ID != 3 || page.NextBeforeID != 3 {
+		t.Fatalf("expected newest success and a cursor, got %#v", page)
+	}
+	_, page = list("subject=user_all&outcome=success&limit=1&before_id=3", "admin")
+	if len(page.Events) != 1 || page.Events[0].ID != 2 || page.NextBeforeID != 0 {
+		t.Fatalf("expected last page with the older success, got %#v", page)
+	}
+
+	deleted, err := u.pruneAuthEvents(context.Background(), time.Now().UTC().AddDate(0, 0, 31))
+	if err != nil || deleted != 3 || len(authRepo.authEvents) != 0 {
+		t.Fatalf("expected events past retention to be pruned, got deleted=%d err=%v", deleted, err)
+	}
+}
+
+// TestAuthUseCaseImpersonation verifies impersonation tokens carry the act claim, refuse protected targets,
+// cannot change credentials and die with the actor's subject revocation.
+func TestAuthUseCaseImpersonation(t *testing.T) {
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 2, Username: "user_all", Role: "user_all"},
+	}
+	revocations := newFakeRevocationRepo()
+	u := NewAuthUseCase(authRepo, revocations, newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		AdminRoles: []string{"admin"},
+		RoleScopes: map[string][]string{
+			"user_all": {"users:read"},
+			"support":  {"impersonate"},
+		},
+		Impersonation: types.ImpersonationSettings{MaxTTLSec: 600, ProtectedRoles: []string{"billing"}},
+	})
+	impersonate := func(body string, role string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/impersonate", strings.NewReader(body))
+		return serveAsUser(t, u, u.Impersonate, req, "7", role)
+	}
+
+	if rr := impersonate(`{"user_id":2,"reason":"ticket 42"}`, "user_all"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 without impersonate scope, got %d", rr.Code)
+	}
+	if rr := impersonate(`{"user_id":2}`, "support"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without reason, got %d", rr.Code)
+	}
+	if rr := impersonate(`{"user_id":7,"reason":"ticket 42"}`, "support"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for self impersonation, got %d", rr.Code)
+	}
+
+	authRepo.user.Role = "billing"
+	if rr := impersonate(`{"user_id":2,"reason":"ticket 42"}`, "support"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 for protected role, got %d", rr.Code)
+	}
+	authRepo.user.Role = "admin"
+	if rr := impersonate(`{"user_id":2,"reason":"ticket 42"}`, "support"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 for admin target, got %d", rr.Code)
+	}
+	authRepo.user.Role = "user_all"
+
+	rr := impersonate(`{"user_id":2,"reason":"ticket 42","expires_in":3600}`, "support")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var issued types.ImpersonateResponse
+	if err := json.NewDecoder(rr.Body).Decode(&issued); err != nil {
+		t.Fatalf("decode impersonate response: %v", err)
+	}
+	if issued.ExpiresIn != 600 || issued.Subject != "2" || issued.Actor != (types.ActorClaim{Subject: "7", TokenType: "user"}) {
+		t.Fatalf("unexpected impersonate response %#v", issued)
+	}
+	if len(authRepo.authEvents) == 0 || authRepo.authEvents[len(authRepo.authEvents)-1].EventType != "impersonation_started" {
+		t.Fatalf("expected impersonation_started audit event, got %#v", authRepo.authEvents)
+	}
+
+	validate := func() types.ValidateResponse {
+		rr := postJSON(u.Validate, "/auth/validate", `{"token":"`+issued.Token+`"}`)
+		if rr.Code != http.StatusOK {
+			return types.ValidateResponse{}
+		}
+		var resp types.ValidateResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode validate response: %v", err)
+		}
+		return resp
+	}
+	resp := validate()
+	if resp.Subject != "2" || resp.Scope != "users:read" || resp.Actor == nil || resp.Actor.Subject != "7" {
+		t.Fatalf("expected target identity with act claim, got %#v", resp)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/password", strings.NewReader(`{"current_password":"x","new_password":"New-password-1"}`))
+	req.Header.Set("Authorization", "Bearer "+issued.Token)
+	rr = httptest.NewRecorder()
+	u.AuthMiddleware()(http.HandlerFunc(u.ChangePassword)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 changing password while impersonating, got %d", rr.Code)
+	}
+
+	revocations.subjects["user:7"] = time.Now().Add(time.Second)
+	if resp = validate(); resp.APIKey != "" {
+		t.Fatalf("expected impersonation token to be revoked with its actor, got %#v", resp)
+	}
+}
+
+// TestAuthUseCaseSessions verifies issued tokens are listed with activity and can be revoked by their owner or an admin.
+func TestAuthUseCaseSessions(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	u := newTestAuthUseCase(authRepo)
+	revocations := u.revocations.(*fakeRevocationRepo)
+
+	login := func() types.LoginResponse {
+		rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected login status 200, got %d", rr.Code)
+		}
+		var resp types.LoginResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode login response: %v", err)
+		}
+		return resp
+	}
+	first, second := login(), login()
+	if len(authRepo.issuedTokens) != 2 || authRepo.issuedTokens[0].Grant != "login" {
+		t.Fatalf("expected two recorded login tokens, got %#v", authRepo.issuedTokens)
+	}
+	firstJTI := authRepo.issuedTokens[0].JTI
+	revocations.lastSeen = map[string]time.Time{firstJTI: time.Now().UTC()}
+
+	serve := func(handler http.HandlerFunc, req *http.Request, token string) *httptest.ResponseRecorder {
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		u.AuthMiddleware()(handler).ServeHTTP(rr, req)
+		return rr
+	}
+	listOwn := func() []types.SessionResponse {
+		rr := serve(u.ListSessions, httptest.NewRequest(http.MethodGet, "/auth/sessions", nil), second.Token)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected list status 200, got %d", rr.Code)
+		}
+		var resp types.SessionsResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode sessions: %v", err)
+		}
+		return resp.Sessions
+	}
+	revokeOwn := func(jti string) *httptest.ResponseRecorder {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/auth/sessions/"+jti, nil), map[string]string{"jti": jti})
+		return serve(u.RevokeSession, req, second.Token)
+	}
+
+	sessions := listOwn()
+	if len(sessions) != 2 || !sessions[0].Current || sessions[1].Current {
+		t.Fatalf("expected newest session to be current, got %#v", sessions)
+	}
+	if sessions[1].JTI != firstJTI || sessions[1].LastSeenAt == "" || sessions[0].LastSeenAt != "" {
+		t.Fatalf("expected last_seen_at only on the first session, got %#v", sessions)
+	}
+
+	otherJTI := uuid.NewString()
+	authRepo.issuedTokens = append(authRepo.issuedTokens, types.IssuedToken{
+		JTI: otherJTI, TokenType: "user", Subject: "9", Grant: "login", IssuedAt: time.Now().UTC(), ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if rr := revokeOwn(otherJTI); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 revoking another user's session, got %d", rr.Code)
+	}
+
+	if rr := revokeOwn(firstJTI); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 revoking own session, got %d", rr.Code)
+	}
+	if sessions = listOwn(); len(sessions) != 1 || sessions[0].JTI == firstJTI {
+		t.Fatalf("expected revoked session to be hidden, got %#v", sessions)
+	}
+	if rr := postJSON(u.Refresh, "/auth/refresh", `{"refresh_token":"`+first.RefreshToken+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected refresh family of revoked session to be revoked, got %d", rr.Code)
+	}
+
+	adminList := httptest.NewRequest(http.MethodGet, "/auth/admin/sessions?subject=9", nil)
+	if rr := serveAsUser(t, u, u.ListSubjectSessions, adminList, "1", "user_all"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 for non-admin, got %d", rr.Code)
+	}
+	rr := serveAsUser(t, u, u.ListSubjectSessions, httptest.NewRequest(http.MethodGet, "/auth/admin/sessions?subject=9", nil), "4", "admin")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), otherJTI) {
+		t.Fatalf("expected admin to list subject sessions, got %d %s", rr.Code, rr.Body.String())
+	}
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/auth/admin/sessions/"+otherJTI, nil), map[string]string{"jti": otherJTI})
+	if rr = serveAsUser(t, u, u.RevokeSubjectSession, req, "4", "admin"); rr.Code != http.StatusOK {
+		t.Fatalf("expected admin revoke status 200, got %d", rr.Code)
+	}
+	if _, ok := revocations.tokens[otherJTI]; !ok {
+		t.Fatal("expected jti revocation marker for admin-revoked session")
+	}
+}
+
+// TestAuthUseCaseTokenIssuerAudienceAndSkew verifies iss, aud and nbf checks, the clock-skew leeway and rejection reasons.
+func TestAuthUseCaseTokenIssuerAudienceAndSkew(t *testing.T) {
+	newUseCase := func(jwtSettings types.JWTSettings) *AuthUseCaseImpl {
+		return NewAuthUseCase(&fakeAuthRepo{}, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{JWT: jwtSettings})
+	}
+	dev := newUseCase(types.JWTSettings{Issuer: "go-gw-test-dev", Audiences: map[string][]string{"user": {"api_gw"}}, ClockSkewSec: 30})
+
+	issued, err := dev.issueToken("user", "1", []string{"user_all"}, time.Hour)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	if _, err = dev.validateTokenCore(context.Background(), issued.token); err != nil {
+		t.Fatalf("expected token of own issuer and audience to validate: %v", err)
+	}
+
+	pre := newUseCase(types.JWTSettings{Issuer: "go-gw-test-pre"})
+	if _, err = pre.validateTokenCore(context.Background(), issued.token); err == nil || !strings.Contains(err.Error(), tokenRejectIssuer) {
+		t.Fatalf("expected invalid_issuer, got %v", err)
+	}
+	otherAudience := newUseCase(types.JWTSettings{Issuer: "go-gw-test-dev", Audiences: map[string][]string{"user": {"orders_gw"}}})
+	if _, err = otherAudience.validateTokenCore(context.Background(), issued.token); err == nil || !strings.Contains(err.Error(), tokenRejectAudience) {
+		t.Fatalf("expected invalid_audience, got %v", err)
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		claims["iss"], claims["aud"], claims["jti"], claims["role"] = "go-gw-test-dev", []string{"api_gw"}, uuid.NewString(), "user_all"
+		claims["sub"], claims["token_type"] = "1", "user"
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		return signed
+	}
+	now := time.Now().UTC()
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		reason string
+	}{
+		{"nbf within skew", jwt.MapClaims{"nbf": now.Add(10 * time.Second).Unix(), "exp": now.Add(time.Hour).Unix()}, ""},
+		{"nbf beyond skew", jwt.MapClaims{"nbf": now.Add(time.Minute).Unix(), "exp": now.Add(time.Hour).Unix()}, tokenRejectNotYetValid},
+		{"exp within skew", jwt.MapClaims{"exp": now.Add(-10 * time.Second).Unix()}, ""},
+		{"exp beyond skew", jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}, tokenRejectExpired},
+		{"missing exp", jwt.MapClaims{}, tokenRejectClaims},
+	}
+	for _, tc := range cases {
+		_, err = dev.validateTokenCore(context.Background(), sign(tc.claims))
+		if tc.reason == "" && err != nil {
+			t.Fatalf("%s: expected token to validate: %v", tc.name, err)
+		}
+		if tc.reason != "" && (err == nil || !strings.Contains(err.Error(), tc.reason)) {
+			t.Fatalf("%s: expected %s, got %v", tc.name, tc.reason, err)
+		}
+	}
+	if got := testutil.ToFloat64(dev.metrics.tokenRejections.WithLabelValues(tokenRejectExpired)); got != 1 {
+		t.Fatalf("expected one expired rejection counted, got %v", got)
+	}
+
+	rr := postJSON(dev.Validate, "/auth/validate", `{"token":"`+sign(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()})+`"}`)
+	if rr.Code != http.StatusUnauthorized || strings.Contains(rr.Body.String(), tokenRejectExpired) {
+		t.Fatalf("expected generic 401, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// stubIdP is an in-process OIDC provider serving discovery, JWKS and a token endpoint that checks PKCE.
+type stubIdP struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	signer    *rsa.PrivateKey // signs ID tokens; differs from key to simulate a forged token.
+	challenge string
+	nonce     string
+	subject   string
+	groups    []string
+}
+
+// newStubIdP starts a stub provider whose ID tokens name clientID as audience.
+func newStubIdP(t *testing.T, clientID string) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate idp key: %v", err)
+	}
+	idp := &stubIdP{key: key, signer: key}
+
+	routes := http.NewServeMux()
+	routes.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	routes.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	routes.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":    idp.server.URL,
+			"aud":    clientID,
+			"sub":    idp.subject,
+			"email":  idp.subject + "@example.com",
+			"nonce":  idp.nonce,
+			"groups": idp.groups,
+			"iat":    now.Unix(),
+			"exp":    now.Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(idp.signer)
+		if err != nil {
+			t.Errorf("sign id token: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	idp.server = httptest.NewServer(routes)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// TestAuthUseCaseOIDCLogin verifies the authorization-code flow with PKCE, group mapping, JIT users and refusals.
+func TestAuthUseCaseOIDCLogin(t *testing.T) {
+	idp := newStubIdP(t, "gw-client")
+	authRepo := &fakeAuthRepo{user: types.UserRecord{ID: 1, Username: "user_all", Role: "user_all"}}
+	u := NewAuthUseCase(authRepo, newFakeRevocationRepo(), newFakeLoginAttemptRepo(), []byte("test-secret"), types.AuthSettings{
+		RoleScopes: map[string][]string{"user_all": {"users:read"}},
+		// an iat pushed past a cutoff may lie a millisecond ahead of the clock
+		JWT: types.JWTSettings{ClockSkewSec: 1},
+		OIDC: types.OIDCSettings{Providers: map[string]types.OIDCProvider{"corp": {
+			Issuer:      idp.server.URL,
+			ClientID:    "gw-client",
+			RedirectURI: "https://gw.example/auth/oidc/corp/callback",
+			GroupRoles:  map[string][]string{"engineers": {"user_all"}, "ops": {"users_gw"}},
+		}}},
+	})
+
+	// stateCookie is what the browser holds after the last start
+	var stateCookie *http.Cookie
+	start := func() string {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/auth/oidc/corp/login", nil), map[string]string{"provider": "corp"})
+		rr := httptest.NewRecorder()
+		u.OIDCLogin(rr, req)
+		if rr.Code != http.StatusFound {
+			t.Fatalf("expected login redirect, got %d %s", rr.Code, rr.Body.String())
+		}
+		stateCookie = nil
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == oidcStateCookieName && cookie.HttpOnly && cookie.MaxAge > 0 {
+				stateCookie = cookie
+			}
+		}
+		if stateCookie == nil {
+			t.Fatal("expected login to set the HttpOnly state cookie")
+		}
+		location, err := url.Parse(rr.Header().Get("Location"))
+		if err != nil || location.Path != "/authorize" {
+			t.Fatalf("expected redirect to authorization endpoint, got %q", rr.Header().Get("Location"))
+		}
+		query := location.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "gw-client" || query.Get("scope") != "openid email profile" {
+			t.Fatalf("unexpected authorization request %v", query)
+		}
+		idp.challenge, idp.nonce = query.Get("code_challenge"), query.Get("nonce")
+		return query.Get("state")
+	}
+	callbackFrom := func(state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		target := "/auth/oidc/corp/callback?code=good-code&state=" + url.QueryEscape(state)
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, target, nil), map[string]string{"provider": "corp"})
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		u.OIDCCallback(rr, req)
+		return rr
+	}
+	callback := func(state string) *httptest.ResponseRecorder {
+		return callbackFrom(state, stateCookie)
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/auth/oidc/other/login", nil), map[string]string{"provider": "other"})
+	rr := httptest.NewRecorder()
+	u.OIDCLogin(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown provider status 404, got %d", rr.Code)
+	}
+
+	idp.subject, idp.groups = "alice", []string{"Engineers", "unmapped"}
+	state := start()
+	// a callback URL opened in another browser, e.g. sent to a victim, is refused without spending the state
+	if rr = callbackFrom(state, nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected callback without state cookie status 400, got %d", rr.Code)
+	}
+	if rr = callbackFrom(state, &http.Cookie{Name: oidcStateCookieName, Value: hashRefreshToken("other-state")}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected callback with another login's cookie status 400, got %d", rr.Code)
+	}
+	rr = callback(state)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected oidc login status 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	cleared := rr.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != oidcStateCookieName || cleared[0].MaxAge >= 0 {
+		t.Fatalf("expected callback to clear the state cookie, got %v", cleared)
+	}
+	var login types.LoginResponse
+	if err := json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+	principal, err := u.validateTokenCore(context.Background(), login.Token)
+	if err != nil || principal.Subject != "100" || principal.Role != "user_all" || login.RefreshToken != "" {
+		t.Fatalf("expected gateway token of provisioned user, got %#v %v", principal, err)
+	}
+	if authRepo.user.Username != "corp:alice" || authRepo.user.PasswordHash != "" || len(authRepo.identities) != 1 || authRepo.identities[0].Email != "alice@example.com" {
+		t.Fatalf("expected linked local user without password, got %#v %#v", authRepo.user, authRepo.identities)
+	}
+	if len(authRepo.issuedTokens) != 1 || authRepo.issuedTokens[0].Grant != oidcFlow {
+		t.Fatalf("expected recorded login_oidc token, got %#v", authRepo.issuedTokens)
+	}
+	if rr = callback(state); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected replayed state status 400, got %d", rr.Code)
+	}
+
+	idp.groups = []string{"ops"}
+	if rr = callback(start()); rr.Code != http.StatusOK {
+		t.Fatalf("expected second oidc login status 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	if len(authRepo.identities) != 1 || authRepo.user.Role != "users_gw" {
+		t.Fatalf("expected existing user with synced role, got %#v", authRepo.user)
+	}
+	// the first token still carries user_all, which the provider no longer grants
+	revoked := authRepo.subjectRevocations
+	if len(revoked) != 1 || revoked[0].Subject != "100" || revoked[0].RevokedBy != "oidc:corp" || !strings.Contains(revoked[0].Reason, "user_all") {
+		t.Fatalf("expected lost role to revoke the user's tokens, got %#v", revoked)
+	}
+	if _, err = u.validateTokenCore(context.Background(), login.Token); err == nil {
+		t.Fatal("expected token with the lost role to be rejected")
+	}
+	var relogin types.LoginResponse
+	if err = json.NewDecoder(rr.Body).Decode(&relogin); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+	claims, err := u.parseTokenClaims(relogin.Token)
+	if err != nil {
+		t.Fatalf("parse synced login token: %v", err)
+	}
+	// issued after the cutoff by iat, not by waiting for the clock to move on
+	iat, _ := time.Parse(time.RFC3339, claims.IssuedAt)
+	if iat.UnixMilli() <= revoked[0].RevokedBefore.UnixMilli() {
+		t.Fatalf("expected iat after the cutoff, got %s vs %s", iat, revoked[0].RevokedBefore)
+	}
+	if _, err = u.validateTokenCore(context.Background(), relogin.Token); err != nil {
+		t.Fatalf("expected token of the synced login to validate, got %v", err)
+	}
+	if rr = callback(start()); rr.Code != http.StatusOK || len(authRepo.subjectRevocations) != 1 {
+		t.Fatalf("expected unchanged roles to revoke nothing, got %d %#v", rr.Code, authRepo.subjectRevocations)
+	}
+
+	idp.groups = []string{"guests"}
+	if rr = callback(start()); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 without mapped role, got %d", rr.Code)
+	}
+
+	idp.groups = []string{"engineers"}
+	state = start()
+	idp.nonce = "other-nonce"
+	if rr = callback(state); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected nonce mismatch status 401, got %d", rr.Code)
+	}
+
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate forger key: %v", err)
+	}
+	idp.signer = forger
+	if rr = callback(start()); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected forged id token status 401, got %d", rr.Code)
+	}
+	idp.signer = idp.key
+
+	state = start()
+	idp.challenge = "tampered"
+	if rr = callback(state); rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected failed code exchange status 502, got %d", rr.Code)
+	}
+
+	authRepo.user.Disabled = true
+	if rr = callback(start()); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected disabled user status 403, got %d", rr.Code)
+	}
+
+	refused := 0
+	for _, event := range authRepo.authEvents {
+		if event.EventType == oidcFlow && event.Outcome == auditOutcomeFailure {
+			refused++
+		}
+	}
+	if refused != 4 {
+		t.Fatalf("expected four refused oidc logins audited, got %d", refused)
+	}
+}
+
+// TestAuthUseCaseSessionCookie verifies cookie logins, CSRF checks on cookie credentials and logout clearing the cookies.
+func TestAuthUseCaseSessionCookie(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", PasswordHash: string(hash), Role: "user_all"},
+	}
+	u := newTestAuthUseCase(authRepo)
+	revocations := u.revocations.(*fakeRevocationRepo)
+
+	rr := postJSON(u.Login, "/auth/login", `{"username":"user_all","password":"123","session_cookie":true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login status 200, got %d", rr.Code)
+	}
+	var login types.LoginResponse
+	if err = json.NewDecoder(rr.Body).Decode(&login); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+	if login.Token != "" || login.RefreshToken != "" || login.CSRFToken == "" || login.ExpiresIn <= 0 {
+		t.Fatalf("expected only csrf_token and expires_in in the body, got %#v", login)
+	}
+	if len(authRepo.refreshTokens) != 0 {
+		t.Fatalf("expected no refresh token for cookie sessions, got %d", len(authRepo.refreshTokens))
+	}
+
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range rr.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	session, csrf := cookies[rest_qol.SessionCookieName], cookies[rest_qol.CSRFCookieName]
+	if session == nil || !session.HttpOnly || !session.Secure || session.SameSite != http.SameSiteStrictMode || session.MaxAge != int(login.ExpiresIn) {
+		t.Fatalf("unexpected session cookie %#v", session)
+	}
+	if csrf == nil || csrf.HttpOnly || csrf.Value != login.CSRFToken || rest_qol.CSRFTokenFor(session.Value) != login.CSRFToken {
+		t.Fatalf("unexpected csrf cookie %#v", csrf)
+	}
+
+	withCookie := func(req *http.Request, csrfToken string) *http.Request {
+		req.AddCookie(&http.Cookie{Name: rest_qol.SessionCookieName, Value: session.Value})
+		if csrfToken != "" {
+			req.Header.Set(rest_qol.CSRFHeader, csrfToken)
+		}
+		return req
+	}
+	serve := func(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		u.AuthMiddleware()(handler).ServeHTTP(rr, req)
+		return rr
+	}
+	if rr = serve(u.ListSessions, withCookie(httptest.NewRequest(http.MethodGet, "/auth/sessions", nil), "")); rr.Code != http.StatusOK {
+		t.Fatalf("expected cookie to authenticate GET, got %d", rr.Code)
+	}
+	jti := authRepo.issuedTokens[0].JTI
+	revoke := mux.SetURLVars(withCookie(httptest.NewRequest(http.MethodDelete, "/auth/sessions/"+jti, nil), ""), map[string]string{"jti": jti})
+	if rr = serve(u.RevokeSession, revoke); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected DELETE without csrf token to be refused, got %d", rr.Code)
+	}
+
+	logout := func(csrfToken string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		u.Logout(rr, withCookie(httptest.NewRequest(http.MethodPost, "/auth/logout", nil), csrfToken))
+		return rr
+	}
+	if rr = logout("wrong"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected logout without matching csrf token to be refused, got %d", rr.Code)
+	}
+	if _, ok := revocations.tokens[jti]; ok {
+		t.Fatal("expected refused logout to keep the token")
+	}
+	rr = logout(login.CSRFToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected logout status 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.MaxAge >= 0 || cookie.Value != "" {
+			t.Fatalf("expected logout to clear cookie %#v", cookie)
+		}
+	}
+	if len(rr.Result().Cookies()) != 2 {
+		t.Fatalf("expected both cookies cleared, got %d", len(rr.Result().Cookies()))
+	}
+	if _, ok := revocations.tokens[jti]; !ok {
+		t.Fatal("expected logout to revoke the cookie token")
+	}
+}
+
+// readMailedTokens waits for background mails and returns the token query parameter of each mail in the file, in order.
+func readMailedTokens(t *testing.T, u *AuthUseCaseImpl, path string) []string {
+	t.Helper()
+	u.outbox.Wait()
+
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("read mail file: %v", err)
+	}
+
+	tokens := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		if line == "" {
+			continue
+		}
+		var msg types.MailMessage
+		if err = json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("decode mail: %v", err)
+		}
+		token := ""
+		if _, query, ok := strings.Cut(msg.Body, "?token="); ok {
+			token, _, _ = strings.Cut(query, "\n")
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// TestAuthUseCaseSelfService verifies registration and password reset by mail with single-use tokens and per-address throttling.
+func TestAuthUseCaseSelfService(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Old-pass1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generate password hash: %v", err)
+	}
+	authRepo := &fakeAuthRepo{
+		user: types.UserRecord{ID: 1, Username: "user_all", Email: "known@example.com", PasswordHash: string(hash), Role: "user_all"},
+	}
+	u := newTestAuthUseCase(authRepo)
+	mailPath := filepath.Join(t.TempDir(), "mail.jsonl")
+	u.mailer = repo.NewFileMailer(mailPath)
+	verify := func(hash string, password string) bool {
+		ok, _, err := u.passwords.Verify(hash, password)
+		return err == nil && ok
+	}
+
+	rr := postJSON(u.Register, "/auth/register", `{"username":"new_user","email":"new@example.com","password":"New-pass1"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected registration to be disabled by default, got %d", rr.Code)
+	}
+
+	u.settings.SelfService = types.SelfServiceSettings{
+		RegistrationRole:    "user_all",
+		VerifyURL:           "https://app.example.com/verify",
+		ResetURL:            "https://app.example.com/reset",
+		MaxEmailsPerAddress: 2,
+	}
+
+	// known and unknown addresses get the same answer; only the unknown one gets a verify link
+	known := postJSON(u.Register, "/auth/register", `{"username":"other","email":"Known@Example.com","password":"New-pass1"}`)
+	fresh := postJSON(u.Register, "/auth/register", `{"username":"new_user","email":"new@example.com","password":"New-pass1"}`)
+	if known.Code != http.StatusAccepted || fresh.Code != http.StatusAccepted || known.Body.String() != fresh.Body.String() {
+		t.Fatalf("expected identical register responses, got %d %q and %d %q", known.Code, known.Body.String(), fresh.Code, fresh.Body.String())
+	}
+	tokens := readMailedTokens(t, u, mailPath)
+	if len(tokens) != 2 || tokens[0] != "" || tokens[1] == "" {
+		t.Fatalf("expected a notice to the known address and a verify link to the new one, got %q", tokens)
+	}
+	for _, token := range authRepo.emailTokens {
+		if token.TokenHash == tokens[1] || token.PasswordHash == "New-pass1" {
+			t.Fatal("expected token and password to be stored hashed")
+		}
+	}
+
+	rr = postJSON(u.Register, "/auth/register", `{"username":"new_user","email":"bad","password":"New-pass1"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid email to be rejected, got %d", rr.Code)
+	}
+
+	rr = postJSON(u.VerifyRegistration, "/auth/register/verify", `{"token":"`+tokens[1]+`"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected verified registration to create the user, got %d %s", rr.Code, rr.Body.String())
+	}
+	created := authRepo.createdUsers[0]
+	if created.Username != "new_user" || created.Email != "new@example.com" || created.Role != "user_all" || !verify(created.PasswordHash, "New-pass1") {
+		t.Fatalf("unexpected registered user %+v", created)
+	}
+	rr = postJSON(u.VerifyRegistration, "/auth/register/verify", `{"token":"`+tokens[1]+`"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected verify token to be single-use, got %d", rr.Code)
+	}
+
+	// known@example.com spent one of its two requests on register; both addresses are throttled alike once over the limit
+	for email, allowed := range map[string]int{"known@example.com": 1, "nobody@example.com": 2} {
+		for i := 0; i < allowed; i++ {
+			rr = postJSON(u.ForgotPassword, "/auth/password/forgot", `{"email":"`+email+`"}`)
+			if rr.Code != http.StatusAccepted || rr.Body.String() != fresh.Body.String() {
+				t.Fatalf("expected forgot for %s to be accepted like register, got %d %q", email, rr.Code, rr.Body.String())
+			}
+		}
+		rr = postJSON(u.ForgotPassword, "/auth/password/forgot", `{"email":"`+email+`"}`)
+		if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "3600" {
+			t.Fatalf("expected forgot for %s to be throttled, got %d", email, rr.Code)
+		}
+	}
+	tokens = readMailedTokens(t, u, mailPath)[2:]
+	if len(tokens) != 1 || tokens[0] == "" {
+		t.Fatalf("expected one reset link for the known address only, got %q", tokens)
+	}
+
+	rr = postJSON(u.ResetPassword, "/auth/password/reset", `{"token":"`+tokens[0]+`","new_password":"short"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected weak password to be rejected, got %d", rr.Code)
+	}
+	rr = postJSON(u.ResetPassword, "/auth/password/reset", `{"token":"`+tokens[0]+`","new_password":"Reset-pass1"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected reset to succeed after a rejected password, got %d %s", rr.Code, rr.Body.String())
+	}
+	if !verify(authRepo.user.PasswordHash, "Reset-pass1") {
+		t.Fatal("expected reset to store the new password")
+	}
+	revoked := authRepo.subjectRevocations
+	if len(revoked) != 1 || revoked[0].Subject != "1" || revoked[0].Reason != "password reset" {
+		t.Fatalf("expected reset to revoke the user's tokens, got %+v", revoked)
+	}
+	rr = postJSON(u.ResetPassword, "/auth/password/reset", `{"token":"`+tokens[0]+`","new_password":"Again-pass1"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected reset token to be single-use, got %d", rr.Code)
+	}
+
+	// expired tokens are refused even when unused
+	value, ok := u.saveEmailToken(context.Background(), httptest.NewRecorder(), types.EmailToken{
+		Purpose: types.EmailTokenPasswordReset,
+		Email:   "known@example.com",
+		UserID:  1,
+	}, -time.Minute)
+	if !ok {
+		t.Fatal("save expired token")
+	}
+	rr = postJSON(u.ResetPassword, "/auth/password/reset", `{"token":"`+value+`","new_password":"Again-pass1"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected expired reset token to be refused, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,104 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+	"github.com/yirez/go-gw-test/pkg/rest_qol"
+
+	"go.uber.org/zap"
+)
+
+// issueAccessSession issues and records an access token without refresh token. Session cookies get no
+// refresh token because it would have to live in JavaScript; OIDC logins leave renewal to the provider.
+// A non-zero notBefore is a subject cutoff the new token must not fall under.
+func (u *AuthUseCaseImpl) issueAccessSession(ctx context.Context, grant string, subject string, roles []string, requestedTTL time.Duration, notBefore time.Time) (issuedToken, error) {
+	if len(roles) == 0 {
+		return issuedToken{}, errors.New("session without roles")
+	}
+
+	access, err := u.issueTokenAfter("user", subject, roles, u.tokenTTLFor("user", roles, requestedTTL), nil, notBefore)
+	if err != nil {
+		return issuedToken{}, err
+	}
+	u.recordIssuedToken(ctx, grant, "user", subject, access, "", nil)
+
+	return access, nil
+}
+
+// writeLoginResponse writes a login response; with sessionCookie the token moves into the session cookie
+// and the body carries the CSRF token instead.
+func (u *AuthUseCaseImpl) writeLoginResponse(w http.ResponseWriter, resp types.LoginResponse, sessionCookie bool) {
+	if sessionCookie {
+		resp.CSRFToken = u.setSessionCookies(w, resp.Token, time.Duration(resp.ExpiresIn)*time.Second)
+		resp.Token = ""
+	}
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// setSessionCookies sets the HttpOnly session cookie and the script-readable CSRF cookie, both living as long as the token.
+func (u *AuthUseCaseImpl) setSessionCookies(w http.ResponseWriter, token string, ttl time.Duration) string {
+	csrfToken := rest_qol.CSRFTokenFor(token)
+	http.SetCookie(w, u.sessionCookie(rest_qol.SessionCookieName, token, int(ttl.Seconds()), true))
+	http.SetCookie(w, u.sessionCookie(rest_qol.CSRFCookieName, csrfToken, int(ttl.Seconds()), false))
+	return csrfToken
+}
+
+// clearSessionCookies expires both session cookies in the browser.
+func (u *AuthUseCaseImpl) clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, u.sessionCookie(rest_qol.SessionCookieName, "", -1, true))
+	http.SetCookie(w, u.sessionCookie(rest_qol.CSRFCookieName, "", -1, false))
+}
+
+// sessionCookie builds a cookie with the configured domain and SameSite mode; it is Secure unless insecure_cookies is set.
+func (u *AuthUseCaseImpl) sessionCookie(name string, value string, maxAge int, httpOnly bool) *http.Cookie {
+	settings := u.settings.BrowserSession
+	sameSite := http.SameSiteStrictMode
+	switch strings.ToLower(settings.SameSite) {
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   settings.Domain,
+		MaxAge:   maxAge,
+		Secure:   !settings.InsecureCookies,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}
+}
+
+// endCookieSession revokes the token of a session cookie. Tokens that no longer validate have nothing left to revoke.
+func (u *AuthUseCaseImpl) endCookieSession(ctx context.Context, token string) error {
+	principal, err := u.parseTokenClaims(token)
+	if err != nil {
+		return nil
+	}
+	// a zero expiry makes revokeTokenCore keep the marker for the longest token lifetime
+	expiresAt, _ := time.Parse(time.RFC3339, principal.ExpiresAt)
+
+	err = u.revokeTokenCore(ctx, types.RevokedToken{
+		JTI:       principal.APIKey,
+		Subject:   principal.Subject,
+		TokenType: principal.TokenType,
+		Reason:    "logout",
+		RevokedBy: principalRef(principal),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		zap.L().Error("revoke session cookie token", zap.String("jti", principal.APIKey), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
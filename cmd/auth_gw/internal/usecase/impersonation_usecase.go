@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// impersonateScope lets a principal mint tokens of other users through /auth/impersonate.
+	impersonateScope = "impersonate"
+	// defaultImpersonationTTL applies when auth_settings.impersonation.max_ttl_sec is unset.
+	defaultImpersonationTTL = 15 * time.Minute
+)
+
+// Impersonate issues a short-lived token of a user on behalf of the caller.
+// @Summary Impersonate user
+// @Description Issues a short-lived token of a user that carries an RFC 8693 act claim naming the caller. No refresh token is issued. Requires the impersonate scope. Users holding admin roles, protected roles or the impersonate scope cannot be impersonated, and impersonation tokens cannot impersonate again.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.ImpersonateRequest true "Impersonation payload"
+// @Success 200 {object} types.ImpersonateResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/impersonate [post]
+func (u *AuthUseCaseImpl) Impersonate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := principalFromContext(ctx)
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	// no chains: an impersonation token keeps the target's scopes, never the actor's
+	if principal.Actor != nil || !slices.Contains(strings.Fields(principal.Scope), impersonateScope) {
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+		return
+	}
+
+	var req types.ImpersonateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode impersonate request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.UserID <= 0 || req.Reason == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "user_id and reason are required"})
+		return
+	}
+	if req.ExpiresIn < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_in"})
+		return
+	}
+	if principal.TokenType == "user" && principal.Subject == fmt.Sprint(req.UserID) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot impersonate yourself"})
+		return
+	}
+
+	user, err := u.repo.FindUserByID(ctx, req.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "impersonation failed"})
+		return
+	}
+	if user.Disabled {
+		utils.WriteJSON(w, http.StatusConflict, map[string]string{"error": "user disabled"})
+		return
+	}
+
+	roles, err := u.subjectRoles(ctx, "user", user.ID, user.Role)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "impersonation failed"})
+		return
+	}
+	if u.impersonationProtected(roles) {
+		zap.L().Warn("impersonation of protected user refused", zap.Int64("user_id", user.ID), zap.String("by", principalRef(principal)))
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "user cannot be impersonated"})
+		return
+	}
+
+	actor := types.ActorClaim{Subject: principal.Subject, TokenType: principal.TokenType}
+	access, err := u.issueActingToken("user", fmt.Sprint(user.ID), roles, u.impersonationTTL(req.ExpiresIn), &actor)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "impersonation failed"})
+		return
+	}
+	u.recordIssuedToken(ctx, "impersonation", "user", fmt.Sprint(user.ID), access, "", &actor)
+
+	zap.L().Info("impersonation token issued",
+		zap.Int64("user_id", user.ID),
+		zap.String("jti", access.jti),
+		zap.Duration("ttl", access.ttl),
+		zap.String("by", principalRef(principal)),
+		zap.String("reason", req.Reason),
+	)
+	u.auditChange(ctx, "impersonation_started", principal, "user", user.ID, "jti "+access.jti+": "+req.Reason)
+
+	utils.WriteJSON(w, http.StatusOK, types.ImpersonateResponse{
+		Token:     access.token,
+		ExpiresIn: int64(access.ttl.Seconds()),
+		Subject:   fmt.Sprint(user.ID),
+		Actor:     actor,
+	})
+}
+
+// impersonationProtected reports whether roles include an admin or protected role, or grant impersonation themselves.
+func (u *AuthUseCaseImpl) impersonationProtected(roles []string) bool {
+	for _, role := range roles {
+		if slices.Contains(u.settings.AdminRoles, role) || slices.Contains(u.settings.Impersonation.ProtectedRoles, role) {
+			return true
+		}
+	}
+	return slices.Contains(u.scopesFor(roles), impersonateScope)
+}
+
+// impersonationTTL caps impersonation tokens by max_ttl_sec; a positive requested lifetime may only shorten it.
+func (u *AuthUseCaseImpl) impersonationTTL(requestedSec int64) time.Duration {
+	ttl := secondsOrDefault(u.settings.Impersonation.MaxTTLSec, defaultImpersonationTTL)
+	requested := time.Duration(requestedSec) * time.Second
+	if requested > 0 && requested < ttl {
+		return requested
+	}
+	return ttl
+}
+
+// refuseImpersonated rejects impersonation tokens on endpoints that change the user's own credentials;
+// it writes the response when true.
+func refuseImpersonated(w http.ResponseWriter, principal types.ValidateResponse) bool {
+	if principal.Actor == nil {
+		return false
+	}
+
+	utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "not allowed while impersonating"})
+	return true
+}
@@ -0,0 +1,325 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash is compared against when a user or service does not exist,
+// so unknown identifiers cost the same bcrypt time as wrong passwords.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy-password-for-timing"), bcrypt.DefaultCost)
+	if err != nil {
+		zap.L().Error("generate dummy password hash", zap.Error(err))
+	}
+	return hash
+})
+
+// loginMetrics counts login outcomes, lockouts, unlocks and rejected access tokens.
+type loginMetrics struct {
+	attempts        *prometheus.CounterVec
+	lockouts        *prometheus.CounterVec
+	unlocks         *prometheus.CounterVec
+	tokenRejections *prometheus.CounterVec
+}
+
+// newLoginMetrics builds unregistered login collectors; the router registers them.
+func newLoginMetrics() loginMetrics {
+	return loginMetrics{
+		attempts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "auth_login_attempts_total",
+				Help:        "Login and service-token attempts by flow and result.",
+				ConstLabels: prometheus.Labels{"service": "auth_gw"},
+			},
+			[]string{"flow", "result"},
+		),
+		lockouts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "auth_login_lockouts_total",
+				Help:        "Lockouts started after repeated failed attempts.",
+				ConstLabels: prometheus.Labels{"service": "auth_gw"},
+			},
+			[]string{"kind"},
+		),
+		unlocks: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "auth_login_unlocks_total",
+				Help:        "Admin unlocks of usernames, services or client IPs.",
+				ConstLabels: prometheus.Labels{"service": "auth_gw"},
+			},
+			[]string{"kind"},
+		),
+		tokenRejections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "auth_token_rejections_total",
+				Help:        "Access tokens refused by validation, by reason.",
+				ConstLabels: prometheus.Labels{"service": "auth_gw"},
+			},
+			[]string{"reason"},
+		),
+	}
+}
+
+// Collectors returns auth_gw specific Prometheus collectors.
+func (u *AuthUseCaseImpl) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{u.metrics.attempts, u.metrics.lockouts, u.metrics.unlocks, u.metrics.tokenRejections}
+}
+
+// Unlock lifts a lockout for a username, service_id or client IP.
+// @Summary Unlock login
+// @Description Clears lockout, failed-attempt counter and delay for a username, service_id or client IP. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.UnlockRequest true "Unlock payload"
+// @Success 200 {object} types.UnlockResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/unlock [post]
+func (u *AuthUseCaseImpl) Unlock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := principalFromContext(ctx)
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	if !u.isAdmin(principal) {
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+		return
+	}
+
+	var req types.UnlockRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode unlock request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	switch req.Kind {
+	case types.AttemptKindUser, types.AttemptKindService, types.AttemptKindIP:
+	default:
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be user, service or ip"})
+		return
+	}
+	if req.Identifier == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "identifier is required"})
+		return
+	}
+
+	wasLocked, err := u.attempts.Unlock(ctx, types.AttemptKey{Kind: req.Kind, Identifier: req.Identifier})
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "unlock failed"})
+		return
+	}
+
+	u.metrics.unlocks.WithLabelValues(req.Kind).Inc()
+	u.auditLockout(ctx, types.LockoutEvent{
+		Kind:       req.Kind,
+		Identifier: req.Identifier,
+		Action:     "unlocked",
+		Actor:      principalRef(principal),
+	})
+	u.audit(ctx, types.AuthEvent{
+		EventType: "login_unlocked",
+		Outcome:   auditOutcomeSuccess,
+		Subject:   req.Identifier,
+		Actor:     principalRef(principal),
+		Reason:    "kind " + req.Kind,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, types.UnlockResponse{Kind: req.Kind, Identifier: req.Identifier, WasLocked: wasLocked})
+}
+
+// allowLoginAttempt rejects attempts while any key is delayed or locked; it writes the response when false.
+func (u *AuthUseCaseImpl) allowLoginAttempt(ctx context.Context, w http.ResponseWriter, flow string, keys []types.AttemptKey) bool {
+	retryAfter, err := u.loginRetryAfter(ctx, flow, keys)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login temporarily unavailable"})
+		return false
+	}
+	if retryAfter <= 0 {
+		return true
+	}
+
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	utils.WriteJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many attempts"})
+	return false
+}
+
+// loginRetryAfter returns how long attempts on keys are blocked and counts throttled or failed checks.
+func (u *AuthUseCaseImpl) loginRetryAfter(ctx context.Context, flow string, keys []types.AttemptKey) (time.Duration, error) {
+	retryAfter, err := u.attempts.RetryAfter(ctx, keys...)
+	if err != nil {
+		u.metrics.attempts.WithLabelValues(flow, "error").Inc()
+		return 0, err
+	}
+	if retryAfter > 0 {
+		u.metrics.attempts.WithLabelValues(flow, "throttled").Inc()
+		u.audit(ctx, loginEvent(flow, keys[0], auditOutcomeFailure, "too many attempts"))
+	}
+	return retryAfter, nil
+}
+
+// recordLoginFailure counts a failed attempt on every key and applies delays or lockouts.
+func (u *AuthUseCaseImpl) recordLoginFailure(ctx context.Context, flow string, keys []types.AttemptKey, ip string) {
+	u.metrics.attempts.WithLabelValues(flow, "failure").Inc()
+	u.audit(ctx, loginEvent(flow, keys[0], auditOutcomeFailure, errInvalidCredentials.Error()))
+
+	window := time.Duration(u.protection.FailureWindowSec) * time.Second
+	for _, key := range keys {
+		failures, err := u.attempts.RecordFailure(ctx, key, window)
+		if err != nil {
+			continue
+		}
+
+		limit := u.protection.MaxFailures
+		if key.Kind == types.AttemptKindIP {
+			limit = u.protection.IPMaxFailures
+		}
+
+		if failures >= int64(limit) {
+			lockout := time.Duration(u.protection.LockoutSec) * time.Second
+			locked, err := u.attempts.Lock(ctx, key, lockout)
+			if err != nil || !locked {
+				continue
+			}
+
+			lockedUntil := time.Now().UTC().Add(lockout)
+			u.metrics.lockouts.WithLabelValues(key.Kind).Inc()
+			zap.L().Warn("login locked out",
+				zap.String("kind", key.Kind),
+				zap.String("identifier", key.Identifier),
+				zap.Int64("failures", failures),
+				zap.String("client_ip", ip),
+			)
+			u.auditLockout(ctx, types.LockoutEvent{
+				Kind:        key.Kind,
+				Identifier:  key.Identifier,
+				Action:      "locked",
+				Actor:       "system",
+				Failures:    failures,
+				ClientIP:    ip,
+				LockedUntil: &lockedUntil,
+			})
+			continue
+		}
+
+		if failures >= int64(u.protection.DelayAfterFailures) {
+			_ = u.attempts.Delay(ctx, key, u.loginDelay(failures))
+		}
+	}
+}
+
+// recordLoginSuccess clears failures of the authenticated identity; the client IP keeps its counter.
+func (u *AuthUseCaseImpl) recordLoginSuccess(ctx context.Context, flow string, key types.AttemptKey) {
+	u.metrics.attempts.WithLabelValues(flow, "success").Inc()
+	u.audit(ctx, loginEvent(flow, key, auditOutcomeSuccess, ""))
+	_ = u.attempts.Reset(ctx, key)
+}
+
+// recordLoginRefused audits an attempt refused for another reason than wrong credentials, e.g. a disabled user.
+// It does not count towards lockouts.
+func (u *AuthUseCaseImpl) recordLoginRefused(ctx context.Context, flow string, key types.AttemptKey, err error) {
+	u.audit(ctx, loginEvent(flow, key, auditOutcomeFailure, err.Error()))
+}
+
+// loginEvent builds the auth event of a login flow; the subject is the username or service_id as presented.
+func loginEvent(flow string, key types.AttemptKey, outcome string, reason string) types.AuthEvent {
+	return types.AuthEvent{
+		EventType: flow,
+		Outcome:   outcome,
+		TokenType: key.Kind,
+		Subject:   key.Identifier,
+		Reason:    reason,
+	}
+}
+
+// loginDelay doubles the base delay for every failure past the delay threshold, capped by the max delay.
+func (u *AuthUseCaseImpl) loginDelay(failures int64) time.Duration {
+	exponent := min(failures-int64(u.protection.DelayAfterFailures), 20)
+	delay := time.Duration(u.protection.BaseDelayMs) * time.Millisecond << exponent
+	return min(delay, time.Duration(u.protection.MaxDelayMs)*time.Millisecond)
+}
+
+// auditLockout persists a lockout event; audit failures are logged but never block logins.
+func (u *AuthUseCaseImpl) auditLockout(ctx context.Context, event types.LockoutEvent) {
+	event.CreatedAt = time.Now().UTC()
+	err := u.repo.SaveLockoutEvent(ctx, event)
+	if err != nil {
+		zap.L().Error("lockout audit write failed",
+			zap.String("kind", event.Kind),
+			zap.String("identifier", event.Identifier),
+			zap.String("action", event.Action),
+			zap.String("actor", event.Actor),
+			zap.Error(err),
+		)
+	}
+}
+
+// retryAfterSeconds formats a Retry-After header value, rounding up to whole seconds.
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds()))))
+}
+
+// clientIP returns the caller IP, honoring the first X-Forwarded-For entry only when trusted.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginProtectionWithDefaults fills unset login protection settings.
+func loginProtectionWithDefaults(settings types.LoginProtection) types.LoginProtection {
+	if settings.MaxFailures <= 0 {
+		settings.MaxFailures = 5
+	}
+	if settings.IPMaxFailures <= 0 {
+		settings.IPMaxFailures = 50
+	}
+	if settings.FailureWindowSec <= 0 {
+		settings.FailureWindowSec = 900
+	}
+	if settings.LockoutSec <= 0 {
+		settings.LockoutSec = 900
+	}
+	if settings.DelayAfterFailures <= 0 {
+		settings.DelayAfterFailures = 3
+	}
+	if settings.BaseDelayMs <= 0 {
+		settings.BaseDelayMs = 1000
+	}
+	if settings.MaxDelayMs <= 0 {
+		settings.MaxDelayMs = 30000
+	}
+	return settings
+}
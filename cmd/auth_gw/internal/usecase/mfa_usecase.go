@@ -0,0 +1,682 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMFAIssuer               = "go-gw-test"
+	defaultMFAChallengeTTL         = 5 * time.Minute
+	defaultMFAMaxChallengeAttempts = 5
+	defaultMFARecoveryCodes        = 10
+
+	// RFC 6238 parameters every common authenticator app supports.
+	totpPeriodSec = 30
+	totpDigits    = 6
+	// totpSkewSteps accepts codes of the neighbouring time steps for clock drift.
+	totpSkewSteps = 1
+)
+
+var (
+	errInvalidMFAChallenge = errors.New("invalid mfa challenge")
+	errInvalidMFACode      = errors.New("invalid mfa code")
+)
+
+// totpEncoding is the unpadded base32 authenticator apps expect for secrets.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// LoginMFA completes a login that returned an MFA challenge.
+// @Summary Complete MFA login
+// @Description Exchanges the mfa_token from /auth/login and a TOTP code or recovery code for tokens. When the login started a required enrollment, the first code confirms it and the response carries the recovery codes once. session_cookie sets the JWT as HttpOnly cookie like /auth/login.
+// @Tags auth-gw
+// @Accept json
+// @Produce json
+// @Param request body types.LoginMFARequest true "MFA login payload"
+// @Success 200 {object} types.LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /auth/login/mfa [post]
+func (u *AuthUseCaseImpl) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req types.LoginMFARequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode mfa login request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.MFAToken == "" || countNonEmpty(req.Code, req.RecoveryCode) != 1 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "mfa_token and one of code or recovery_code are required"})
+		return
+	}
+
+	challenge, user, err := u.openMFAChallenge(ctx, req.MFAToken)
+	if err != nil {
+		u.audit(ctx, types.AuthEvent{EventType: "login_mfa", Outcome: auditOutcomeFailure, Reason: err.Error()})
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	ip := clientIP(r, u.protection.TrustForwardedFor)
+	keys := []types.AttemptKey{
+		{Kind: types.AttemptKindUser, Identifier: user.Username},
+		{Kind: types.AttemptKindIP, Identifier: ip},
+	}
+	if !u.allowLoginAttempt(ctx, w, "login_mfa", keys) {
+		return
+	}
+
+	recoveryCodes, err := u.verifyMFA(ctx, user.ID, types.MFACodeRequest{Code: req.Code, RecoveryCode: req.RecoveryCode}, true)
+	if err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			if err = u.repo.IncrementMFAChallengeAttempts(ctx, challenge.ID); err != nil {
+				zap.L().Warn("count mfa challenge attempt", zap.Int64("user_id", user.ID), zap.Error(err))
+			}
+			u.recordLoginFailure(ctx, "login_mfa", keys, ip)
+		} else {
+			u.recordLoginRefused(ctx, "login_mfa", keys[0], err)
+		}
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	// deleting the challenge claims it, so a second request with the same mfa_token cannot also get tokens
+	deleted, err := u.repo.DeleteMFAChallenge(ctx, challenge.ID)
+	if err != nil || !deleted {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	roles, err := u.subjectRoles(ctx, "user", user.ID, user.Role)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+		return
+	}
+
+	requested := time.Duration(challenge.ExpiresIn) * time.Second
+	var access issuedToken
+	var refreshToken string
+	if req.SessionCookie {
+		access, err = u.issueAccessSession(ctx, "login_mfa", fmt.Sprint(user.ID), roles, requested, time.Time{})
+	} else {
+		access, refreshToken, err = u.issueSession(ctx, "login_mfa", "user", fmt.Sprint(user.ID), roles, requested, "", "")
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+		return
+	}
+
+	u.recordLoginSuccess(ctx, "login_mfa", keys[0])
+	u.writeLoginResponse(w, types.LoginResponse{
+		Token:         access.token,
+		ExpiresIn:     int64(access.ttl.Seconds()),
+		RefreshToken:  refreshToken,
+		RecoveryCodes: recoveryCodes,
+	}, req.SessionCookie)
+}
+
+// GetMFAStatus describes the MFA state of the calling user.
+// @Summary MFA status
+// @Description Returns whether TOTP is enabled or pending for the calling user, whether a role requires it and how many recovery codes are left.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} types.MFAStatusResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/mfa [get]
+func (u *AuthUseCaseImpl) GetMFAStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, userID, ok := mfaUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	mfa, err := u.repo.FindUserMFA(ctx, userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "mfa status failed"})
+		return
+	}
+
+	resp := types.MFAStatusResponse{
+		Enabled:  err == nil && mfa.ConfirmedAt != nil,
+		Pending:  err == nil && mfa.ConfirmedAt == nil,
+		Required: u.mfaRequired(principal.Roles),
+	}
+	if resp.Enabled {
+		resp.RecoveryCodesLeft, err = u.repo.CountRecoveryCodes(ctx, userID)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "mfa status failed"})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// EnrollMFA starts TOTP enrollment for the calling user.
+// @Summary Enroll MFA
+// @Description Generates a TOTP secret and provisioning URI for the calling user. Enrollment stays pending, replacing earlier pending ones, until /auth/mfa/confirm accepts a first code.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Success 201 {object} types.MFAEnrollmentResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/mfa/enroll [post]
+func (u *AuthUseCaseImpl) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	_, userID, ok := mfaUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	mfa, err := u.repo.FindUserMFA(ctx, userID)
+	if err == nil && mfa.ConfirmedAt != nil {
+		utils.WriteJSON(w, http.StatusConflict, map[string]string{"error": "mfa already enabled"})
+		return
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "mfa enrollment failed"})
+		return
+	}
+
+	user, err := u.repo.FindUserByID(ctx, userID)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "mfa enrollment failed"})
+		return
+	}
+
+	enrollment, err := u.startMFAEnrollment(ctx, user)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "mfa enrollment failed"})
+		return
+	}
+
+	zap.L().Info("mfa enrollment started", zap.Int64("user_id", userID))
+	utils.WriteJSON(w, http.StatusCreated, enrollment)
+}
+
+// ConfirmMFA confirms a pending TOTP enrollment with a first code.
+// @Summary Confirm MFA
+// @Description Confirms the pending enrollment of the calling user with a TOTP code and returns the recovery codes, shown only once.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.MFACodeRequest true "TOTP code"
+// @Success 200 {object} types.MFAConfirmResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/mfa/confirm [post]
+func (u *AuthUseCaseImpl) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, userID, ok := mfaUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req types.MFACodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "code is required"})
+		return
+	}
+
+	mfa, err := u.repo.FindUserMFA(ctx, userID)
+	if err != nil || mfa.ConfirmedAt != nil {
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "no pending mfa enrollment"})
+		return
+	}
+
+	recoveryCodes, err := u.verifyMFA(ctx, userID, types.MFACodeRequest{Code: req.Code}, true)
+	if err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid code"})
+			return
+		}
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "mfa confirmation failed"})
+		return
+	}
+
+	zap.L().Info("mfa enabled", zap.Int64("user_id", userID))
+	u.auditChange(ctx, "mfa_enabled", principal, "user", userID, "")
+	utils.WriteJSON(w, http.StatusOK, types.MFAConfirmResponse{RecoveryCodes: recoveryCodes})
+}
+
+// DisableMFA turns off TOTP for the calling user.
+// @Summary Disable MFA
+// @Description Removes the TOTP enrollment and recovery codes of the calling user. An enabled enrollment needs a current code or a recovery code. Refused while one of the user's roles requires MFA.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.MFACodeRequest false "TOTP or recovery code"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/mfa/disable [post]
+func (u *AuthUseCaseImpl) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, userID, ok := mfaUserFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if u.mfaRequired(principal.Roles) {
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "mfa is required for your roles"})
+		return
+	}
+
+	mfa, err := u.repo.FindUserMFA(ctx, userID)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "mfa not enabled"})
+		return
+	}
+
+	if mfa.ConfirmedAt != nil {
+		var req types.MFACodeRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || countNonEmpty(req.Code, req.RecoveryCode) != 1 {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "one of code or recovery_code is required"})
+			return
+		}
+
+		_, err = u.verifyMFA(ctx, userID, req, false)
+		if errors.Is(err, errInvalidMFACode) {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid code"})
+			return
+		}
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "disable mfa failed"})
+			return
+		}
+	}
+
+	err = u.repo.DeleteUserMFA(ctx, userID)
+	if !writeUpdateError(w, err, "mfa not enabled", "disable mfa failed") {
+		return
+	}
+
+	zap.L().Info("mfa disabled", zap.Int64("user_id", userID))
+	u.auditChange(ctx, "mfa_disabled", principal, "user", userID, "")
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "disabled"})
+}
+
+// ResetUserMFA removes the MFA enrollment of a user, e.g. after a lost device.
+// @Summary Reset user MFA
+// @Description Removes the TOTP enrollment, recovery codes and open MFA challenges of a user. Users whose roles require MFA enroll again at their next login. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/users/{id}/mfa [delete]
+func (u *AuthUseCaseImpl) ResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := idFromPath(w, r, "id", "invalid user id")
+	if !ok {
+		return
+	}
+
+	err := u.repo.DeleteUserMFA(r.Context(), userID)
+	if !writeUpdateError(w, err, "user has no mfa enrollment", "reset mfa failed") {
+		return
+	}
+
+	zap.L().Info("user mfa reset", zap.Int64("user_id", userID), zap.String("by", principalRef(principal)))
+	u.auditChange(r.Context(), "mfa_reset", principal, "user", userID, "")
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "reset"})
+}
+
+// mfaChallengeFor returns an MFA challenge when user has MFA enabled or a role of roles requires it, else nil.
+// A required but missing enrollment is started here and its secret returned with the challenge.
+func (u *AuthUseCaseImpl) mfaChallengeFor(ctx context.Context, user types.UserRecord, roles []string, expiresIn int) (*types.MFAChallengeResponse, error) {
+	mfa, err := u.repo.FindUserMFA(ctx, user.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	enabled := err == nil && mfa.ConfirmedAt != nil
+	if !enabled && !u.mfaRequired(roles) {
+		return nil, nil
+	}
+
+	resp := &types.MFAChallengeResponse{MFARequired: true}
+	if !enabled {
+		resp.Enrollment, err = u.startMFAEnrollment(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := newRefreshToken()
+	if err != nil {
+		zap.L().Error("generate mfa challenge", zap.Error(err))
+		return nil, err
+	}
+
+	ttl := secondsOrDefault(u.settings.MFA.ChallengeTTLSec, defaultMFAChallengeTTL)
+	now := time.Now().UTC()
+	_, err = u.repo.SaveMFAChallenge(ctx, types.MFAChallenge{
+		ChallengeHash: hashRefreshToken(token),
+		UserID:        user.ID,
+		ExpiresIn:     expiresIn,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp.MFAToken = token
+	resp.ExpiresIn = int64(ttl.Seconds())
+	return resp, nil
+}
+
+// openMFAChallenge loads a live challenge and its user; used up, expired or exhausted challenges are dropped.
+func (u *AuthUseCaseImpl) openMFAChallenge(ctx context.Context, token string) (types.MFAChallenge, types.UserRecord, error) {
+	challenge, err := u.repo.FindMFAChallenge(ctx, hashRefreshToken(token))
+	if err != nil {
+		return types.MFAChallenge{}, types.UserRecord{}, errInvalidMFAChallenge
+	}
+
+	maxAttempts := u.settings.MFA.MaxChallengeAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMFAMaxChallengeAttempts
+	}
+	if !time.Now().Before(challenge.ExpiresAt) || challenge.Attempts >= maxAttempts {
+		if _, err = u.repo.DeleteMFAChallenge(ctx, challenge.ID); err != nil {
+			zap.L().Warn("drop mfa challenge", zap.Int64("user_id", challenge.UserID), zap.Error(err))
+		}
+		return types.MFAChallenge{}, types.UserRecord{}, errInvalidMFAChallenge
+	}
+
+	user, err := u.repo.FindUserByID(ctx, challenge.UserID)
+	if err != nil {
+		return types.MFAChallenge{}, types.UserRecord{}, errInvalidMFAChallenge
+	}
+	if user.Disabled {
+		zap.L().Warn("disabled user mfa login refused", zap.Int64("user_id", user.ID))
+		return types.MFAChallenge{}, types.UserRecord{}, errUserDisabled
+	}
+
+	return challenge, user, nil
+}
+
+// startMFAEnrollment stores a pending enrollment with a new secret, replacing an earlier pending one.
+func (u *AuthUseCaseImpl) startMFAEnrollment(ctx context.Context, user types.UserRecord) (*types.MFAEnrollmentResponse, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		zap.L().Error("generate totp secret", zap.Error(err))
+		return nil, err
+	}
+
+	err = u.repo.SaveUserMFA(ctx, types.UserMFA{UserID: user.ID, Secret: secret, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.MFAEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: u.totpProvisioningURI(user.Username, secret),
+	}, nil
+}
+
+// verifyMFA checks a TOTP code or recovery code of a user and spends it. When confirmPending is set,
+// a code matching a pending enrollment confirms it and the new recovery codes are returned.
+func (u *AuthUseCaseImpl) verifyMFA(ctx context.Context, userID int64, req types.MFACodeRequest, confirmPending bool) ([]string, error) {
+	mfa, err := u.repo.FindUserMFA(ctx, userID)
+	if err != nil {
+		return nil, errInvalidMFACode
+	}
+
+	now := time.Now().UTC()
+	if req.RecoveryCode != "" {
+		if mfa.ConfirmedAt == nil {
+			return nil, errInvalidMFACode
+		}
+
+		used, err := u.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(req.RecoveryCode), now)
+		if err != nil {
+			return nil, err
+		}
+		if !used {
+			zap.L().Warn("invalid mfa recovery code", zap.Int64("user_id", userID))
+			return nil, errInvalidMFACode
+		}
+
+		zap.L().Info("mfa recovery code used", zap.Int64("user_id", userID))
+		return nil, nil
+	}
+
+	step, ok := matchTOTP(mfa.Secret, req.Code, now)
+	if !ok {
+		zap.L().Warn("invalid totp code", zap.Int64("user_id", userID))
+		return nil, errInvalidMFACode
+	}
+
+	if mfa.ConfirmedAt == nil {
+		if !confirmPending {
+			return nil, errInvalidMFACode
+		}
+
+		codes, hashes, err := u.newRecoveryCodes()
+		if err != nil {
+			return nil, err
+		}
+		err = u.repo.ConfirmUserMFA(ctx, userID, now, step, hashes)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// confirmed concurrently; the new codes were never stored
+			return nil, errInvalidMFACode
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		zap.L().Info("mfa enrollment confirmed", zap.Int64("user_id", userID))
+		return codes, nil
+	}
+
+	fresh, err := u.repo.UseTOTPStep(ctx, userID, step)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		zap.L().Warn("replayed totp code", zap.Int64("user_id", userID))
+		return nil, errInvalidMFACode
+	}
+
+	return nil, nil
+}
+
+// userNeedsMFA reports whether a password alone is not enough for user.
+func (u *AuthUseCaseImpl) userNeedsMFA(ctx context.Context, userID int64, roles []string) (bool, error) {
+	if u.mfaRequired(roles) {
+		return true, nil
+	}
+
+	mfa, err := u.repo.FindUserMFA(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return mfa.ConfirmedAt != nil, nil
+}
+
+// mfaRequired reports whether any of roles is listed in auth_settings.mfa.required_roles.
+func (u *AuthUseCaseImpl) mfaRequired(roles []string) bool {
+	for _, role := range u.settings.MFA.RequiredRoles {
+		if slices.Contains(roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// totpProvisioningURI builds the otpauth:// URI authenticator apps import, usually from a QR code.
+func (u *AuthUseCaseImpl) totpProvisioningURI(username string, secret string) string {
+	issuer := u.settings.MFA.Issuer
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriodSec))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+username) + "?" + query.Encode()
+}
+
+// newRecoveryCodes generates the configured number of recovery codes and their hashes.
+func (u *AuthUseCaseImpl) newRecoveryCodes() ([]string, []string, error) {
+	count := u.settings.MFA.RecoveryCodes
+	if count <= 0 {
+		count = defaultMFARecoveryCodes
+	}
+
+	codes := make([]string, 0, count)
+	hashes := make([]string, 0, count)
+	for range count {
+		buf := make([]byte, 7)
+		_, err := rand.Read(buf)
+		if err != nil {
+			zap.L().Error("generate recovery code", zap.Error(err))
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// mfaUserFromRequest returns the calling principal and its user id; MFA endpoints are for user tokens only, not impersonation tokens.
+func mfaUserFromRequest(w http.ResponseWriter, r *http.Request) (types.ValidateResponse, int64, bool) {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return types.ValidateResponse{}, 0, false
+	}
+	if principal.TokenType != "user" {
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "mfa is only available to users"})
+		return types.ValidateResponse{}, 0, false
+	}
+	if refuseImpersonated(w, principal) {
+		return types.ValidateResponse{}, 0, false
+	}
+
+	userID, err := strconv.ParseInt(principal.Subject, 10, 64)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return types.ValidateResponse{}, 0, false
+	}
+	return principal, userID, true
+}
+
+// newTOTPSecret generates a 160-bit TOTP secret, the size RFC 4226 recommends for HMAC-SHA1.
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// matchTOTP compares code with the codes of the current and neighbouring time steps and returns the matching step.
+func matchTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriodSec
+	for step := current - totpSkewSteps; step <= current+totpSkewSteps; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			zap.L().Error("compute totp code", zap.Error(err))
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the RFC 6238 code of a base32 secret for one time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// RFC 4226 dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// hashRecoveryCode returns the stored form of a recovery code; case, dashes and spaces are ignored.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,409 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// oauthError is an RFC 6749 token endpoint error with its HTTP status.
+type oauthError struct {
+	status      int
+	code        string
+	description string
+	retryAfter  string
+}
+
+// oauthClient carries client credentials presented through HTTP Basic or the form body.
+type oauthClient struct {
+	id     string
+	secret string
+}
+
+// OAuthToken issues tokens for the client_credentials, password and refresh_token grants.
+// @Summary OAuth 2.0 token
+// @Description RFC 6749 token endpoint. Clients are services authenticating with their service id and secret through HTTP Basic or client_id/client_secret form fields. client_credentials requires client authentication; password and refresh_token verify client credentials only when presented.
+// @Tags auth-gw
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "client_credentials, password or refresh_token"
+// @Param client_id formData string false "Service id when not using HTTP Basic"
+// @Param client_secret formData string false "Service secret when not using HTTP Basic"
+// @Param username formData string false "Username for the password grant"
+// @Param password formData string false "Password for the password grant"
+// @Param refresh_token formData string false "Refresh token for the refresh_token grant"
+// @Param scope formData string false "Space-delimited scopes; must be a subset of the granted scopes"
+// @Success 200 {object} types.OAuthTokenResponse
+// @Failure 400 {object} types.OAuthErrorResponse
+// @Failure 401 {object} types.OAuthErrorResponse
+// @Failure 429 {object} types.OAuthErrorResponse
+// @Failure 500 {object} types.OAuthErrorResponse
+// @Router /oauth/token [post]
+func (u *AuthUseCaseImpl) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		writeOAuthError(w, &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "request body must be application/x-www-form-urlencoded"})
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		zap.L().Error("parse oauth token request", zap.Error(err))
+		writeOAuthError(w, &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "invalid request body"})
+		return
+	}
+
+	client, oauthErr := oauthClientFromRequest(r)
+	if oauthErr != nil {
+		writeOAuthError(w, oauthErr)
+		return
+	}
+
+	ip := clientIP(r, u.protection.TrustForwardedFor)
+	var access issuedToken
+	var refreshToken string
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "client_credentials":
+		access, oauthErr = u.clientCredentialsGrant(ctx, client, r.PostForm, ip)
+	case "password":
+		access, refreshToken, oauthErr = u.passwordGrant(ctx, client, r.PostForm, ip)
+	case "refresh_token":
+		access, refreshToken, oauthErr = u.refreshTokenGrant(ctx, client, r.PostForm, ip)
+	case "":
+		oauthErr = &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "grant_type is required"}
+	default:
+		oauthErr = &oauthError{status: http.StatusBadRequest, code: "unsupported_grant_type", description: fmt.Sprintf("grant_type %q is not supported", grantType)}
+	}
+	if oauthErr != nil {
+		writeOAuthError(w, oauthErr)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.OAuthTokenResponse{
+		AccessToken:  access.token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(access.ttl.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        access.scope,
+	})
+}
+
+// OAuthIntrospect reports whether an access token is active and returns its claims.
+// @Summary OAuth 2.0 token introspection
+// @Description RFC 7662 introspection for access tokens. Requires a service token. Expired, revoked, malformed and refresh tokens are reported as {"active": false}.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Access token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} types.IntrospectionResponse
+// @Failure 400 {object} types.OAuthErrorResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /oauth/introspect [post]
+func (u *AuthUseCaseImpl) OAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := principalFromContext(ctx)
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	if principal.TokenType != "service" {
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		writeOAuthError(w, &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "request body must be application/x-www-form-urlencoded"})
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		zap.L().Error("parse introspection request", zap.Error(err))
+		writeOAuthError(w, &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "invalid request body"})
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "token is required"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	utils.WriteJSON(w, http.StatusOK, u.introspectCore(ctx, token))
+}
+
+// introspectCore maps a valid, unrevoked access token to introspection claims; anything else is inactive.
+func (u *AuthUseCaseImpl) introspectCore(ctx context.Context, token string) types.IntrospectionResponse {
+	claims, err := u.validateTokenCore(ctx, token)
+	if err != nil {
+		return types.IntrospectionResponse{Active: false}
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, claims.ExpiresAt)
+	if err != nil {
+		return types.IntrospectionResponse{Active: false}
+	}
+
+	resp := types.IntrospectionResponse{
+		Active:      true,
+		Subject:     claims.Subject,
+		Scope:       claims.Scope,
+		ExpiresAt:   expiresAt.Unix(),
+		JTI:         claims.APIKey,
+		TokenType:   "Bearer",
+		SubjectType: claims.TokenType,
+		Role:        claims.Role,
+		Roles:       claims.Roles,
+		Actor:       claims.Actor,
+	}
+	if issuedAt, err := time.Parse(time.RFC3339, claims.IssuedAt); err == nil {
+		resp.IssuedAt = issuedAt.Unix()
+	}
+	// services are the OAuth clients; user tokens are not bound to a client
+	if claims.TokenType == "service" {
+		resp.ClientID = claims.Subject
+	}
+
+	return resp
+}
+
+// clientCredentialsGrant authenticates the client as a service and issues a service token without a refresh token.
+func (u *AuthUseCaseImpl) clientCredentialsGrant(ctx context.Context, client oauthClient, form url.Values, ip string) (issuedToken, *oauthError) {
+	if client.id == "" {
+		return issuedToken{}, &oauthError{status: http.StatusUnauthorized, code: "invalid_client", description: "client authentication is required"}
+	}
+
+	service, oauthErr := u.authenticateOAuthClient(ctx, client, ip)
+	if oauthErr != nil {
+		return issuedToken{}, oauthErr
+	}
+
+	roles, err := u.subjectRoles(ctx, "service", service.ID, service.Role)
+	if err != nil {
+		return issuedToken{}, oauthServerError()
+	}
+
+	oauthErr = u.checkRequestedScope(form.Get("scope"), roles)
+	if oauthErr != nil {
+		return issuedToken{}, oauthErr
+	}
+
+	// RFC 6749 section 4.4.3: no refresh token, the client can authenticate again
+	access, err := u.issueToken("service", fmt.Sprint(service.ID), roles, u.tokenTTLFor("service", roles, 0))
+	if err != nil {
+		return issuedToken{}, oauthServerError()
+	}
+	u.recordIssuedToken(ctx, "client_credentials", "service", fmt.Sprint(service.ID), access, "", nil)
+
+	return access, nil
+}
+
+// passwordGrant authenticates a user with username and password and issues a user token pair.
+func (u *AuthUseCaseImpl) passwordGrant(ctx context.Context, client oauthClient, form url.Values, ip string) (issuedToken, string, *oauthError) {
+	username := form.Get("username")
+	password := form.Get("password")
+	if username == "" || password == "" {
+		return issuedToken{}, "", &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "username and password are required"}
+	}
+
+	if client.id != "" {
+		_, oauthErr := u.authenticateOAuthClient(ctx, client, ip)
+		if oauthErr != nil {
+			return issuedToken{}, "", oauthErr
+		}
+	}
+
+	// shares the /auth/login counters so switching endpoints does not reset brute-force protection
+	keys := []types.AttemptKey{
+		{Kind: types.AttemptKindUser, Identifier: username},
+		{Kind: types.AttemptKindIP, Identifier: ip},
+	}
+	oauthErr := u.checkOAuthAttempt(ctx, "login", keys)
+	if oauthErr != nil {
+		return issuedToken{}, "", oauthErr
+	}
+
+	user, err := u.authenticateUser(ctx, username, password)
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			u.recordLoginFailure(ctx, "login", keys, ip)
+		} else {
+			u.recordLoginRefused(ctx, "login", keys[0], err)
+		}
+		return issuedToken{}, "", &oauthError{status: http.StatusBadRequest, code: "invalid_grant", description: "invalid resource owner credentials"}
+	}
+	u.recordLoginSuccess(ctx, "login", keys[0])
+
+	roles, err := u.subjectRoles(ctx, "user", user.ID, user.Role)
+	if err != nil {
+		return issuedToken{}, "", oauthServerError()
+	}
+
+	// the grant has no second step, so MFA users must log in through /auth/login
+	needsMFA, err := u.userNeedsMFA(ctx, user.ID, roles)
+	if err != nil {
+		return issuedToken{}, "", oauthServerError()
+	}
+	if needsMFA {
+		return issuedToken{}, "", &oauthError{status: http.StatusBadRequest, code: "invalid_grant", description: "multi-factor authentication required; use /auth/login"}
+	}
+
+	oauthErr = u.checkRequestedScope(form.Get("scope"), roles)
+	if oauthErr != nil {
+		return issuedToken{}, "", oauthErr
+	}
+
+	access, refreshToken, err := u.issueSession(ctx, "password", "user", fmt.Sprint(user.ID), roles, 0, "", "")
+	if err != nil {
+		return issuedToken{}, "", oauthServerError()
+	}
+
+	return access, refreshToken, nil
+}
+
+// refreshTokenGrant rotates a refresh token issued by any grant or by the JSON endpoints.
+func (u *AuthUseCaseImpl) refreshTokenGrant(ctx context.Context, client oauthClient, form url.Values, ip string) (issuedToken, string, *oauthError) {
+	refreshToken := form.Get("refresh_token")
+	if refreshToken == "" {
+		return issuedToken{}, "", &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "refresh_token is required"}
+	}
+
+	if client.id != "" {
+		_, oauthErr := u.authenticateOAuthClient(ctx, client, ip)
+		if oauthErr != nil {
+			return issuedToken{}, "", oauthErr
+		}
+	}
+
+	// refresh tokens always carry the subject's current scope; a scope parameter is not applied
+	access, nextRefreshToken, err := u.rotateSession(ctx, refreshToken)
+	if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused) {
+		return issuedToken{}, "", &oauthError{status: http.StatusBadRequest, code: "invalid_grant", description: "invalid refresh token"}
+	}
+	if err != nil {
+		return issuedToken{}, "", oauthServerError()
+	}
+
+	return access, nextRefreshToken, nil
+}
+
+// authenticateOAuthClient verifies client credentials as a service with the /auth/service-token brute-force counters.
+func (u *AuthUseCaseImpl) authenticateOAuthClient(ctx context.Context, client oauthClient, ip string) (types.ServiceRecord, *oauthError) {
+	keys := []types.AttemptKey{
+		{Kind: types.AttemptKindService, Identifier: client.id},
+		{Kind: types.AttemptKindIP, Identifier: ip},
+	}
+	oauthErr := u.checkOAuthAttempt(ctx, "service_token", keys)
+	if oauthErr != nil {
+		return types.ServiceRecord{}, oauthErr
+	}
+
+	service, err := u.authenticateService(ctx, client.id, client.secret)
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			u.recordLoginFailure(ctx, "service_token", keys, ip)
+		} else {
+			u.recordLoginRefused(ctx, "service_token", keys[0], err)
+		}
+		return types.ServiceRecord{}, &oauthError{status: http.StatusUnauthorized, code: "invalid_client", description: "client authentication failed"}
+	}
+
+	u.recordLoginSuccess(ctx, "service_token", keys[0])
+	return service, nil
+}
+
+// checkOAuthAttempt maps a delayed or locked identity to a 429 token error.
+func (u *AuthUseCaseImpl) checkOAuthAttempt(ctx context.Context, flow string, keys []types.AttemptKey) *oauthError {
+	retryAfter, err := u.loginRetryAfter(ctx, flow, keys)
+	if err != nil {
+		return oauthServerError()
+	}
+	if retryAfter > 0 {
+		return &oauthError{
+			status:      http.StatusTooManyRequests,
+			code:        "temporarily_unavailable",
+			description: "too many attempts",
+			retryAfter:  retryAfterSeconds(retryAfter),
+		}
+	}
+	return nil
+}
+
+// oauthClientFromRequest reads client credentials; using HTTP Basic and form fields together is rejected.
+func oauthClientFromRequest(r *http.Request) (oauthClient, *oauthError) {
+	formID := r.PostForm.Get("client_id")
+	formSecret := r.PostForm.Get("client_secret")
+
+	basicID, basicSecret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		if formID != "" || formSecret != "" {
+			return oauthClient{}, &oauthError{status: http.StatusBadRequest, code: "invalid_request", description: "use only one client authentication method"}
+		}
+
+		// RFC 6749 section 2.3.1: Basic credentials are form-encoded before base64
+		id, idErr := url.QueryUnescape(basicID)
+		secret, secretErr := url.QueryUnescape(basicSecret)
+		if idErr != nil || secretErr != nil || id == "" || secret == "" {
+			return oauthClient{}, &oauthError{status: http.StatusUnauthorized, code: "invalid_client", description: "malformed client credentials"}
+		}
+		return oauthClient{id: id, secret: secret}, nil
+	}
+
+	if formID == "" && formSecret == "" {
+		return oauthClient{}, nil
+	}
+	if formID == "" || formSecret == "" {
+		return oauthClient{}, &oauthError{status: http.StatusUnauthorized, code: "invalid_client", description: "client_id and client_secret are required together"}
+	}
+
+	return oauthClient{id: formID, secret: formSecret}, nil
+}
+
+// checkRequestedScope rejects a requested scope that is not a subset of the scopes granted to roles.
+// A narrower request is accepted but the token still carries every granted scope, as RFC 6749 section 3.3 allows.
+func (u *AuthUseCaseImpl) checkRequestedScope(requested string, roles []string) *oauthError {
+	granted := u.scopesFor(roles)
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(granted, scope) {
+			return &oauthError{status: http.StatusBadRequest, code: "invalid_scope", description: fmt.Sprintf("scope %q is not granted", scope)}
+		}
+	}
+	return nil
+}
+
+// oauthServerError reports an internal failure without details.
+func oauthServerError() *oauthError {
+	return &oauthError{status: http.StatusInternalServerError, code: "server_error"}
+}
+
+// writeOAuthError writes an RFC 6749 error body; invalid_client always carries a Basic challenge.
+func writeOAuthError(w http.ResponseWriter, oauthErr *oauthError) {
+	if oauthErr.status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth_gw"`)
+	}
+	if oauthErr.retryAfter != "" {
+		w.Header().Set("Retry-After", oauthErr.retryAfter)
+	}
+
+	utils.WriteJSON(w, oauthErr.status, types.OAuthErrorResponse{Error: oauthErr.code, ErrorDescription: oauthErr.description})
+}
@@ -0,0 +1,382 @@
+package usecase
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// oidcHTTPTimeout bounds every call to an identity provider.
+	oidcHTTPTimeout = 10 * time.Second
+	// oidcJWKSMinRefresh keeps ID tokens with unknown key ids from hammering the provider's JWKS endpoint.
+	oidcJWKSMinRefresh = time.Minute
+	// oidcMaxResponseBytes caps documents read from identity providers.
+	oidcMaxResponseBytes = 1 << 20
+)
+
+var (
+	errOIDCUnknownKey = errors.New("id token signed with unknown key")
+	errOIDCNonce      = errors.New("id token nonce mismatch")
+)
+
+// oidcMetadata holds the discovery document fields auth_gw uses.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcJWK is one key of a JWKS document; only RSA and EC signing keys are used.
+type oidcJWK struct {
+	KeyID string `json:"kid"`
+	Type  string `json:"kty"`
+	Use   string `json:"use"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// oidcProvider talks to one configured identity provider, caching its discovery document and signing keys.
+type oidcProvider struct {
+	name   string
+	config types.OIDCProvider
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *oidcMetadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// newOIDCProviders builds the configured providers; viper lower-cases the provider names.
+func newOIDCProviders(settings types.OIDCSettings) map[string]*oidcProvider {
+	client := &http.Client{Timeout: oidcHTTPTimeout}
+	providers := make(map[string]*oidcProvider, len(settings.Providers))
+	for name, config := range settings.Providers {
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{"openid", "email", "profile"}
+		}
+		if config.GroupsClaim == "" {
+			config.GroupsClaim = "groups"
+		}
+		providers[strings.ToLower(name)] = &oidcProvider{name: strings.ToLower(name), config: config, client: client}
+	}
+
+	return providers
+}
+
+// discover loads the discovery document once; failures are retried on the next call.
+func (p *oidcProvider) discover(ctx context.Context) (oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return oidcMetadata{}, err
+	}
+	// a document naming another issuer would let that issuer's tokens in
+	if metadata.Issuer != p.config.Issuer {
+		zap.L().Error("oidc discovery issuer mismatch", zap.String("provider", p.name), zap.String("issuer", metadata.Issuer))
+		return oidcMetadata{}, fmt.Errorf("discovery issuer %q does not match %q", metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		zap.L().Error("oidc discovery incomplete", zap.String("provider", p.name))
+		return oidcMetadata{}, errors.New("discovery document without endpoints")
+	}
+
+	p.metadata = &metadata
+	return metadata, nil
+}
+
+// authorizationURL builds the authorization request of a login with an S256 PKCE challenge.
+func (p *oidcProvider) authorizationURL(metadata oidcMetadata, state string, nonce string, codeVerifier string) (string, error) {
+	endpoint, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURI)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+
+	return endpoint.String(), nil
+}
+
+// exchangeCode redeems an authorization code at the token endpoint and returns the ID token.
+func (p *oidcProvider) exchangeCode(ctx context.Context, metadata oidcMetadata, code string, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURI},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var resp struct {
+		IDToken string `json:"id_token"`
+	}
+	err = p.doJSON(req, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.IDToken == "" {
+		zap.L().Error("oidc token response without id_token", zap.String("provider", p.name))
+		return "", errors.New("token response without id_token")
+	}
+
+	return resp.IDToken, nil
+}
+
+// verifyIDToken checks the ID token signature against the provider's keys, its issuer, audience, expiry and nonce.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, metadata oidcMetadata, idToken string, nonce string, leeway time.Duration) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, metadata, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claimed, _ := claims["nonce"].(string); claimed != nonce {
+		return nil, errOIDCNonce
+	}
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return nil, errors.New("id token without sub")
+	}
+
+	return claims, nil
+}
+
+// signingKey returns the JWKS key of kid, refetching the JWKS at most once a minute for keys not seen yet.
+// Without a kid the only key of the set is used.
+func (p *oidcProvider) signingKey(ctx context.Context, metadata oidcMetadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < oidcJWKSMinRefresh {
+		return nil, errOIDCUnknownKey
+	}
+
+	var set struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	err := p.getJSON(ctx, metadata.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			zap.L().Warn("oidc jwk skipped", zap.String("provider", p.name), zap.String("kid", jwk.KeyID), zap.Error(err))
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, errOIDCUnknownKey
+}
+
+// lookupKey finds a cached key; callers hold p.mu.
+func (p *oidcProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok && kid != ""
+}
+
+// mapRoles returns the local roles of the groups claim followed by default_roles, without duplicates.
+// Groups are matched case-insensitively in name order, so the primary role does not depend on claim order.
+func (p *oidcProvider) mapRoles(claims jwt.MapClaims) []string {
+	var groups []string
+	switch value := claims[p.config.GroupsClaim].(type) {
+	case string:
+		groups = strings.Fields(value)
+	case []any:
+		for _, item := range value {
+			if group, ok := item.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+	for i, group := range groups {
+		groups[i] = strings.ToLower(group)
+	}
+	slices.Sort(groups)
+
+	var roles []string
+	for _, group := range groups {
+		// viper lower-cases map keys
+		for _, role := range p.config.GroupRoles[group] {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	for _, role := range p.config.DefaultRoles {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// getJSON fetches a JSON document from the provider.
+func (p *oidcProvider) getJSON(ctx context.Context, rawURL string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	return p.doJSON(req, out)
+}
+
+// doJSON sends req and decodes a 200 JSON response into out.
+func (p *oidcProvider) doJSON(req *http.Request, out any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		zap.L().Error("oidc request", zap.String("provider", p.name), zap.String("url", req.URL.Redacted()), zap.Error(err))
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseBytes))
+	if err != nil {
+		zap.L().Error("oidc read response", zap.String("provider", p.name), zap.Error(err))
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		zap.L().Error("oidc request failed", zap.String("provider", p.name), zap.String("url", req.URL.Redacted()), zap.Int("status", resp.StatusCode), zap.ByteString("body", body))
+		return fmt.Errorf("identity provider returned %d", resp.StatusCode)
+	}
+
+	err = json.Unmarshal(body, out)
+	if err != nil {
+		zap.L().Error("oidc decode response", zap.String("provider", p.name), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// publicKey converts an RSA or EC JWK into a verification key.
+func (k oidcJWK) publicKey() (crypto.PublicKey, error) {
+	switch k.Type {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Type)
+	}
+}
+
+// decodeJWKInt decodes a base64url big-endian JWK integer.
+func decodeJWKInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid jwk integer")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
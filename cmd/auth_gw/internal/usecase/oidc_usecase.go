@@ -0,0 +1,292 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/repo"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// oidcFlow names federated logins in auth_events, issued_tokens and auth_login_attempts_total.
+	oidcFlow = "login_oidc"
+	// defaultOIDCStateTTL applies when auth_settings.oidc.state_ttl_sec is unset.
+	defaultOIDCStateTTL = 10 * time.Minute
+	// oidcStateCookieName binds a started login to the browser that started it.
+	oidcStateCookieName = "gw_oidc_state"
+	// oidcStateCookiePath limits the state cookie to the OIDC endpoints.
+	oidcStateCookiePath = "/auth/oidc/"
+)
+
+var errNoMappedRole = errors.New("no role mapped")
+
+// OIDCLogin starts a federated login at an OIDC provider.
+// @Summary Start OIDC login
+// @Description Redirects to the provider's authorization endpoint with a fresh state, nonce and S256 PKCE challenge. The provider sends the browser back to /auth/oidc/{provider}/callback, which only accepts the state together with the gw_oidc_state cookie set here. With session_cookie=true the callback sets the JWT as HttpOnly session cookie.
+// @Tags auth-gw
+// @Param provider path string true "Provider name"
+// @Param session_cookie query bool false "Return the token as session cookie"
+// @Success 302
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /auth/oidc/{provider}/login [get]
+func (u *AuthUseCaseImpl) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	provider, ok := u.oidcProviderFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	metadata, err := provider.discover(ctx)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadGateway, map[string]string{"error": "identity provider unavailable"})
+		return
+	}
+
+	// state, nonce and verifier share the refresh token format: 256 random bits, base64url
+	var secrets [3]string
+	for i := range secrets {
+		secrets[i], err = newRefreshToken()
+		if err != nil {
+			zap.L().Error("generate oidc login state", zap.Error(err))
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+			return
+		}
+	}
+	state, nonce, codeVerifier := secrets[0], secrets[1], secrets[2]
+
+	redirect, err := provider.authorizationURL(metadata, state, nonce, codeVerifier)
+	if err != nil {
+		zap.L().Error("oidc authorization url", zap.String("provider", provider.name), zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadGateway, map[string]string{"error": "identity provider unavailable"})
+		return
+	}
+
+	now := time.Now().UTC()
+	stateTTL := secondsOrDefault(u.settings.OIDC.StateTTLSec, defaultOIDCStateTTL)
+	err = u.repo.SaveOIDCLoginState(ctx, types.OIDCLoginState{
+		StateHash:     hashRefreshToken(state),
+		Provider:      provider.name,
+		Nonce:         nonce,
+		CodeVerifier:  codeVerifier,
+		SessionCookie: r.URL.Query().Get("session_cookie") == "true",
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(stateTTL),
+	})
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+		return
+	}
+
+	// without it, a callback URL of a login started elsewhere would sign this browser into that account
+	http.SetCookie(w, u.oidcStateCookie(hashRefreshToken(state), int(stateTTL.Seconds())))
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// OIDCCallback finishes a federated login and issues a gateway token.
+// @Summary Finish OIDC login
+// @Description Redeems the authorization code with the PKCE verifier, verifies the ID token through the provider's JWKS, maps its groups to local roles and provisions or updates the linked local user. Returns a signed JWT without refresh token; users sign in at the provider again once it expires.
+// @Tags auth-gw
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State returned by the provider"
+// @Success 200 {object} types.LoginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /auth/oidc/{provider}/callback [get]
+func (u *AuthUseCaseImpl) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	provider, ok := u.oidcProviderFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	bound, err := r.Cookie(oidcStateCookieName)
+	http.SetCookie(w, u.oidcStateCookie("", -1))
+	// the state must come back to the browser that started the login; otherwise it is left unspent
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(bound.Value), []byte(hashRefreshToken(state))) != 1 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired state"})
+		return
+	}
+
+	// the state is spent whatever the outcome, so a callback URL cannot be replayed
+	login, err := u.repo.ConsumeOIDCLoginState(ctx, hashRefreshToken(state))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+		return
+	}
+	if err != nil || login.Provider != provider.name || time.Now().After(login.ExpiresAt) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired state"})
+		return
+	}
+
+	providerKey := types.AttemptKey{Kind: types.AttemptKindUser, Identifier: provider.name}
+	if reason := query.Get("error"); reason != "" {
+		u.refuseOIDCLogin(ctx, providerKey, fmt.Errorf("provider error: %s", reason))
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "login denied by identity provider"})
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "missing code"})
+		return
+	}
+
+	metadata, err := provider.discover(ctx)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadGateway, map[string]string{"error": "identity provider unavailable"})
+		return
+	}
+	idToken, err := provider.exchangeCode(ctx, metadata, code, login.CodeVerifier)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadGateway, map[string]string{"error": "identity provider unavailable"})
+		return
+	}
+
+	claims, err := provider.verifyIDToken(ctx, metadata, idToken, login.Nonce, time.Duration(u.settings.JWT.ClockSkewSec)*time.Second)
+	if err != nil {
+		zap.L().Warn("oidc id token rejected", zap.String("provider", provider.name), zap.Error(err))
+		u.refuseOIDCLogin(ctx, providerKey, err)
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+	key := types.AttemptKey{Kind: types.AttemptKindUser, Identifier: provider.name + ":" + subject}
+	roles := provider.mapRoles(claims)
+	if len(roles) == 0 {
+		zap.L().Warn("oidc login without mapped role", zap.String("provider", provider.name), zap.String("sub", subject))
+		u.refuseOIDCLogin(ctx, key, errNoMappedRole)
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "no role mapped for user"})
+		return
+	}
+
+	email, _ := claims["email"].(string)
+	user, previous, err := u.repo.SyncFederatedUser(ctx, types.UserIdentity{
+		Provider:    provider.name,
+		Subject:     subject,
+		Email:       email,
+		LastLoginAt: time.Now().UTC(),
+	}, key.Identifier, roles)
+	if errors.Is(err, repo.ErrUsernameTaken) {
+		u.refuseOIDCLogin(ctx, key, err)
+		utils.WriteJSON(w, http.StatusConflict, map[string]string{"error": "username taken"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+		return
+	}
+	if user.Disabled {
+		zap.L().Warn("disabled user login refused", zap.Int64("user_id", user.ID), zap.String("provider", provider.name))
+		u.refuseOIDCLogin(ctx, key, errUserDisabled)
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "user disabled"})
+		return
+	}
+
+	effective, err := u.subjectRoles(ctx, "user", user.ID, user.Role)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+		return
+	}
+	revokedBefore, err := u.revokeLostFederatedRoles(ctx, provider.name, user.ID, previous, effective)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+		return
+	}
+	// no refresh token: the provider stays the authority on whether the user may sign in
+	access, err := u.issueAccessSession(ctx, oidcFlow, fmt.Sprint(user.ID), effective, 0, revokedBefore)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "login failed"})
+		return
+	}
+	u.recordLoginSuccess(ctx, oidcFlow, key)
+
+	zap.L().Info("oidc login", zap.String("provider", provider.name), zap.String("sub", subject), zap.Int64("user_id", user.ID), zap.Strings("roles", effective))
+	u.writeLoginResponse(w, types.LoginResponse{
+		Token:     access.token,
+		ExpiresIn: int64(access.ttl.Seconds()),
+	}, login.SessionCookie)
+}
+
+// revokeLostFederatedRoles revokes the user's outstanding tokens when the provider's groups no longer grant a role
+// it held, since those tokens still carry it. previous are the roles before the sync, primary first.
+// It returns the cutoff the next token must be issued after, zero when nothing was revoked.
+func (u *AuthUseCaseImpl) revokeLostFederatedRoles(ctx context.Context, providerName string, userID int64, previous []string, effective []string) (time.Time, error) {
+	if len(previous) == 0 {
+		return time.Time{}, nil
+	}
+	var lost []string
+	for _, role := range u.effectiveRoles(previous[0], previous[1:]) {
+		if !slices.Contains(effective, role) {
+			lost = append(lost, role)
+		}
+	}
+	if len(lost) == 0 {
+		return time.Time{}, nil
+	}
+
+	revokedBefore := time.Now().UTC()
+	err := u.revokeSubjectCore(ctx, types.SubjectRevocation{
+		TokenType:     "user",
+		Subject:       fmt.Sprint(userID),
+		RevokedBefore: revokedBefore,
+		Reason:        "roles removed by identity provider: " + strings.Join(lost, " "),
+		RevokedBy:     "oidc:" + providerName,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return revokedBefore, nil
+}
+
+// oidcStateCookie builds the HttpOnly cookie holding the state hash of a started login. It is SameSite=Lax
+// because the provider's redirect back is a cross-site top-level navigation, which strict cookies would miss.
+func (u *AuthUseCaseImpl) oidcStateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    value,
+		Path:     oidcStateCookiePath,
+		MaxAge:   maxAge,
+		Secure:   !u.settings.BrowserSession.InsecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// oidcProviderFromPath resolves the {provider} path variable; it writes a 404 when unknown.
+func (u *AuthUseCaseImpl) oidcProviderFromPath(w http.ResponseWriter, r *http.Request) (*oidcProvider, bool) {
+	provider, ok := u.oidc[strings.ToLower(mux.Vars(r)["provider"])]
+	if !ok {
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "unknown provider"})
+		return nil, false
+	}
+	return provider, true
+}
+
+// refuseOIDCLogin counts and audits a refused federated login; like other refusals it does not lock anything out.
+func (u *AuthUseCaseImpl) refuseOIDCLogin(ctx context.Context, key types.AttemptKey, err error) {
+	u.metrics.attempts.WithLabelValues(oidcFlow, "failure").Inc()
+	u.recordLoginRefused(ctx, oidcFlow, key, err)
+}
@@ -0,0 +1,260 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordAlgorithmBcrypt   = "bcrypt"
+	passwordAlgorithmArgon2id = "argon2id"
+
+	// argon2id defaults follow the RFC 9106 second recommended option with a 64 MiB memory cost.
+	defaultArgon2idMemoryKiB   = 64 * 1024
+	defaultArgon2idIterations  = 3
+	defaultArgon2idParallelism = 2
+	defaultArgon2idSaltLength  = 16
+	defaultArgon2idKeyLength   = 32
+)
+
+var errUnknownPasswordHash = errors.New("unknown password hash format")
+
+// passwordHasher hashes passwords into a self-describing encoding that carries its own parameters.
+type passwordHasher interface {
+	// Hash encodes password with the hasher's current parameters.
+	Hash(password string) (string, error)
+	// Verify reports whether password matches an encoded hash of this hasher.
+	Verify(encoded string, password string) (bool, error)
+	// Handles reports whether an encoded hash belongs to this hasher.
+	Handles(encoded string) bool
+	// Outdated reports whether an encoded hash of this hasher used other parameters than the current ones.
+	Outdated(encoded string) bool
+}
+
+// passwordHashing verifies hashes of every known algorithm and hashes new passwords with the configured one.
+type passwordHashing struct {
+	preferred passwordHasher
+	hashers   []passwordHasher
+	dummyHash func() string
+}
+
+// newPasswordHashing builds the hashers from auth_settings; an unknown algorithm falls back to bcrypt.
+func newPasswordHashing(settings types.AuthSettings) *passwordHashing {
+	bcryptH := bcryptHasher{cost: settings.BcryptCost}
+	if bcryptH.cost < bcrypt.MinCost || bcryptH.cost > bcrypt.MaxCost {
+		bcryptH.cost = bcrypt.DefaultCost
+	}
+	argonH := newArgon2idHasher(settings.PasswordHashing.Argon2id)
+
+	p := &passwordHashing{preferred: bcryptH, hashers: []passwordHasher{bcryptH, argonH}}
+	switch strings.ToLower(settings.PasswordHashing.Algorithm) {
+	case "", passwordAlgorithmBcrypt:
+	case passwordAlgorithmArgon2id:
+		p.preferred = argonH
+	default:
+		zap.L().Error("unknown password hashing algorithm, using bcrypt", zap.String("algorithm", settings.PasswordHashing.Algorithm))
+	}
+
+	// the dummy hash uses the preferred hasher, so unknown usernames cost as much as current hashes
+	p.dummyHash = sync.OnceValue(func() string {
+		hash, err := p.preferred.Hash("dummy-password-for-timing")
+		if err != nil {
+			zap.L().Error("generate dummy password hash", zap.Error(err))
+		}
+		return hash
+	})
+	return p
+}
+
+// Hash encodes a new password with the preferred hasher.
+func (p *passwordHashing) Hash(password string) (string, error) {
+	hash, err := p.preferred.Hash(password)
+	if err != nil {
+		zap.L().Error("hash password", zap.Error(err))
+		return "", err
+	}
+	return hash, nil
+}
+
+// Verify checks password against an encoded hash of any known algorithm.
+// needsRehash is set on a match when the hash is not in the preferred algorithm or uses outdated parameters.
+func (p *passwordHashing) Verify(encoded string, password string) (ok bool, needsRehash bool, err error) {
+	for _, hasher := range p.hashers {
+		if !hasher.Handles(encoded) {
+			continue
+		}
+
+		ok, err = hasher.Verify(encoded, password)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, hasher != p.preferred || hasher.Outdated(encoded), nil
+	}
+
+	return false, false, errUnknownPasswordHash
+}
+
+// VerifyDummy spends the time of a real verification, for identifiers that do not exist.
+func (p *passwordHashing) VerifyDummy(password string) {
+	_, _, _ = p.Verify(p.dummyHash(), password)
+}
+
+// bcryptHasher handles $2a$/$2b$/$2y$ hashes, including those of pgcrypto gen_salt('bf').
+type bcryptHasher struct {
+	cost int
+}
+
+// Hash encodes password with the configured cost.
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify compares password against a bcrypt hash.
+func (h bcryptHasher) Verify(encoded string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Handles reports whether encoded is a bcrypt hash.
+func (h bcryptHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Outdated reports whether encoded used another cost.
+func (h bcryptHasher) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+// argon2idParams are the tunables an argon2id hash records in its PHC string.
+type argon2idParams struct {
+	memoryKiB   uint32
+	iterations  uint32
+	parallelism uint8
+	saltLength  uint32
+	keyLength   uint32
+}
+
+// argon2idHasher handles PHC strings of the form $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+type argon2idHasher struct {
+	params argon2idParams
+}
+
+// newArgon2idHasher fills unset argon2id settings with defaults.
+func newArgon2idHasher(settings types.Argon2idSettings) argon2idHasher {
+	params := argon2idParams{
+		memoryKiB:   defaultArgon2idMemoryKiB,
+		iterations:  defaultArgon2idIterations,
+		parallelism: defaultArgon2idParallelism,
+		saltLength:  defaultArgon2idSaltLength,
+		keyLength:   defaultArgon2idKeyLength,
+	}
+	if settings.MemoryKiB > 0 {
+		params.memoryKiB = uint32(settings.MemoryKiB)
+	}
+	if settings.Iterations > 0 {
+		params.iterations = uint32(settings.Iterations)
+	}
+	if settings.Parallelism > 0 && settings.Parallelism <= 255 {
+		params.parallelism = uint8(settings.Parallelism)
+	}
+	if settings.SaltLength >= 8 {
+		params.saltLength = uint32(settings.SaltLength)
+	}
+	if settings.KeyLength >= 16 {
+		params.keyLength = uint32(settings.KeyLength)
+	}
+	return argon2idHasher{params: params}
+}
+
+// Hash encodes password with a random salt and the configured parameters.
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.saltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memoryKiB, p.parallelism, p.keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memoryKiB, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify recomputes the key with the parameters stored in encoded and compares in constant time.
+func (h argon2idHasher) Verify(encoded string, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.iterations, params.memoryKiB, params.parallelism, params.keyLength)
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+// Handles reports whether encoded is an argon2id PHC string.
+func (h argon2idHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// Outdated reports whether encoded used other parameters than the configured ones.
+func (h argon2idHasher) Outdated(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+	return err != nil || params != h.params
+}
+
+// decodeArgon2id parses an argon2id PHC string into its parameters, salt and key.
+func decodeArgon2id(encoded string) (argon2idParams, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != passwordAlgorithmArgon2id {
+		return argon2idParams{}, nil, nil, errUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2idParams{}, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	var params argon2idParams
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memoryKiB, &params.iterations, &params.parallelism)
+	if err != nil || params.iterations == 0 || params.parallelism == 0 {
+		return argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+
+	params.saltLength = uint32(len(salt))
+	params.keyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,334 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/repo"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+	"github.com/yirez/go-gw-test/pkg/rest_qol"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// Refresh exchanges a refresh token for a new access token and a rotated refresh token.
+// @Summary Refresh token
+// @Description Rotates an opaque refresh token and returns a new token pair. Reusing an already rotated refresh token revokes its whole family.
+// @Tags auth-gw
+// @Accept json
+// @Produce json
+// @Param request body types.RefreshRequest true "Refresh payload"
+// @Success 200 {object} types.RefreshResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /auth/refresh [post]
+func (u *AuthUseCaseImpl) Refresh(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req types.RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode refresh request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.RefreshToken == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	resp, err := u.refreshCore(ctx, req.RefreshToken)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// Logout invalidates the refresh token family and the access tokens issued from it, or the session cookie.
+// @Summary Logout
+// @Description Revokes every refresh token of the presented token's family and the access tokens issued with them. A session cookie sent with its X-CSRF-Token is revoked and cleared; the body may then be empty.
+// @Tags auth-gw
+// @Accept json
+// @Produce json
+// @Param request body types.LogoutRequest false "Logout payload"
+// @Success 200 {object} types.LogoutResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/logout [post]
+func (u *AuthUseCaseImpl) Logout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req types.LogoutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		zap.L().Error("decode logout request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	sessionToken, hasCookie := rest_qol.SessionTokenFromCookie(r)
+	if hasCookie {
+		// without the check any site could log users out
+		if !rest_qol.ValidCSRFToken(sessionToken, r.Header.Get(rest_qol.CSRFHeader)) {
+			utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "invalid csrf token"})
+			return
+		}
+
+		u.clearSessionCookies(w)
+		err = u.endCookieSession(ctx, sessionToken)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "logout failed"})
+			return
+		}
+		if req.RefreshToken == "" {
+			utils.WriteJSON(w, http.StatusOK, types.LogoutResponse{Status: "logged_out"})
+			return
+		}
+	}
+
+	if req.RefreshToken == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	record, err := u.repo.FindRefreshToken(ctx, hashRefreshToken(req.RefreshToken))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	// logout is idempotent: an already revoked family is revoked again without error
+	err = u.revokeRefreshFamily(ctx, record, "logout")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "logout failed"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.LogoutResponse{Status: "logged_out"})
+}
+
+// refreshCore validates and rotates a refresh token, revoking its family on reuse.
+func (u *AuthUseCaseImpl) refreshCore(ctx context.Context, refreshToken string) (types.RefreshResponse, error) {
+	access, nextRefreshToken, err := u.rotateSession(ctx, refreshToken)
+	if err != nil {
+		return types.RefreshResponse{}, err
+	}
+
+	return types.RefreshResponse{
+		Token:        access.token,
+		ExpiresIn:    int64(access.ttl.Seconds()),
+		RefreshToken: nextRefreshToken,
+	}, nil
+}
+
+// rotateSession exchanges a refresh token for a new access token and the next refresh token of its family.
+func (u *AuthUseCaseImpl) rotateSession(ctx context.Context, refreshToken string) (issuedToken, string, error) {
+	tokenHash := hashRefreshToken(refreshToken)
+	record, err := u.repo.FindRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return issuedToken{}, "", errInvalidRefreshToken
+	}
+
+	if record.RevokedAt != nil {
+		return issuedToken{}, "", errInvalidRefreshToken
+	}
+
+	if record.RotatedAt != nil {
+		u.handleRefreshReuse(ctx, record)
+		return issuedToken{}, "", errRefreshTokenReused
+	}
+
+	if !time.Now().UTC().Before(record.ExpiresAt) {
+		return issuedToken{}, "", errInvalidRefreshToken
+	}
+
+	// only the subject cutoff applies to refresh tokens; jti markers belong to access tokens
+	revoked, err := u.revocations.IsRevoked(ctx, "", record.TokenType, record.Subject, record.CreatedAt)
+	if err != nil || revoked {
+		return issuedToken{}, "", errInvalidRefreshToken
+	}
+
+	roles, err := u.currentRoles(ctx, record.TokenType, record.Subject)
+	if err != nil {
+		return issuedToken{}, "", errInvalidRefreshToken
+	}
+
+	access, nextRefreshToken, err := u.issueSession(ctx, "refresh", record.TokenType, record.Subject, roles, 0, record.FamilyID, tokenHash)
+	if errors.Is(err, repo.ErrRefreshTokenReused) {
+		// lost a race against another exchange of the same token
+		u.handleRefreshReuse(ctx, record)
+		return issuedToken{}, "", errRefreshTokenReused
+	}
+	if err != nil {
+		return issuedToken{}, "", err
+	}
+
+	return access, nextRefreshToken, nil
+}
+
+// issueSession issues an access token with a refresh token of familyID; an empty familyID starts a new family.
+// When previousHash is set, that refresh token is rotated atomically with storing the new one.
+// The lifetime follows the shortest configured lifetime among roles; grant names the flow in the session listing.
+func (u *AuthUseCaseImpl) issueSession(ctx context.Context, grant string, tokenType string, subject string, roles []string, requestedTTL time.Duration, familyID string, previousHash string) (issuedToken, string, error) {
+	if len(roles) == 0 {
+		return issuedToken{}, "", errors.New("session without roles")
+	}
+
+	access, err := u.issueToken(tokenType, subject, roles, u.tokenTTLFor(tokenType, roles, requestedTTL))
+	if err != nil {
+		return issuedToken{}, "", err
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		zap.L().Error("generate refresh token", zap.Error(err))
+		return issuedToken{}, "", err
+	}
+
+	if familyID == "" {
+		familyID = uuid.NewString()
+	}
+
+	now := time.Now().UTC()
+	record := types.RefreshToken{
+		TokenHash:       hashRefreshToken(refreshToken),
+		FamilyID:        familyID,
+		Subject:         subject,
+		TokenType:       tokenType,
+		AccessJTI:       access.jti,
+		AccessExpiresAt: access.expiresAt,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(u.refreshTTL),
+	}
+
+	if previousHash == "" {
+		err = u.repo.SaveRefreshToken(ctx, record)
+	} else {
+		err = u.repo.RotateRefreshToken(ctx, previousHash, record)
+	}
+	if err != nil {
+		return issuedToken{}, "", err
+	}
+	u.recordIssuedToken(ctx, grant, tokenType, subject, access, familyID, nil)
+
+	return access, refreshToken, nil
+}
+
+// handleRefreshReuse revokes a family after one of its rotated tokens was presented again.
+func (u *AuthUseCaseImpl) handleRefreshReuse(ctx context.Context, record types.RefreshToken) {
+	zap.L().Warn("refresh token reuse detected",
+		zap.String("family_id", record.FamilyID),
+		zap.String("sub", record.Subject),
+		zap.String("token_type", record.TokenType),
+	)
+
+	u.audit(ctx, types.AuthEvent{
+		EventType: "refresh",
+		Outcome:   auditOutcomeFailure,
+		TokenType: record.TokenType,
+		Subject:   record.Subject,
+		Reason:    "refresh token reuse, family " + record.FamilyID + " revoked",
+	})
+
+	err := u.revokeRefreshFamily(ctx, record, "refresh_token_reuse")
+	if err != nil {
+		zap.L().Error("revoke reused refresh family", zap.String("family_id", record.FamilyID), zap.Error(err))
+	}
+}
+
+// revokeRefreshFamily revokes every refresh token of the record's family and their live access tokens.
+func (u *AuthUseCaseImpl) revokeRefreshFamily(ctx context.Context, record types.RefreshToken, reason string) error {
+	now := time.Now().UTC()
+	family, err := u.repo.RevokeRefreshFamily(ctx, record.FamilyID, now)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range family {
+		if member.AccessJTI == "" || !member.AccessExpiresAt.After(now) {
+			continue
+		}
+
+		err = u.revokeTokenCore(ctx, types.RevokedToken{
+			JTI:       member.AccessJTI,
+			Subject:   member.Subject,
+			TokenType: member.TokenType,
+			Reason:    reason,
+			RevokedBy: member.TokenType + ":" + member.Subject,
+			ExpiresAt: member.AccessExpiresAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// currentRoles reloads the subject so refreshed tokens carry its current roles; disabled subjects are refused.
+func (u *AuthUseCaseImpl) currentRoles(ctx context.Context, tokenType string, subject string) ([]string, error) {
+	id, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		zap.L().Error("parse refresh token subject", zap.String("sub", subject), zap.Error(err))
+		return nil, err
+	}
+
+	switch tokenType {
+	case "user":
+		user, err := u.repo.FindUserByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if user.Disabled {
+			return nil, errUserDisabled
+		}
+		return u.subjectRoles(ctx, tokenType, id, user.Role)
+	case "service":
+		service, err := u.repo.FindServiceByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if service.Disabled {
+			return nil, errServiceDisabled
+		}
+		return u.subjectRoles(ctx, tokenType, id, service.Role)
+	default:
+		return nil, errors.New("unknown token type")
+	}
+}
+
+// newRefreshToken generates an opaque 256-bit refresh token.
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashRefreshToken returns the stored form of a refresh token.
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,290 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Revoke revokes a token (by token or jti) or every token of a subject.
+// @Summary Revoke token
+// @Description Revokes a single token by token or jti, or all tokens issued so far for a subject. Callers may revoke their own tokens; admin roles may revoke any.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.RevokeRequest true "Revoke payload"
+// @Success 200 {object} types.RevokeResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/revoke [post]
+func (u *AuthUseCaseImpl) Revoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := principalFromContext(ctx)
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	var req types.RevokeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode revoke request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if countNonEmpty(req.Token, req.JTI, req.Subject) != 1 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "exactly one of token, jti or subject is required"})
+		return
+	}
+
+	switch {
+	case req.Token != "":
+		claims, err := u.parseTokenClaims(req.Token)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid token"})
+			return
+		}
+
+		// possession of the token is enough to revoke it
+		expiresAt, _ := time.Parse(time.RFC3339, claims.ExpiresAt)
+		err = u.revokeTokenCore(ctx, types.RevokedToken{
+			JTI:       claims.APIKey,
+			Subject:   claims.Subject,
+			TokenType: claims.TokenType,
+			Reason:    req.Reason,
+			RevokedBy: principalRef(principal),
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation failed"})
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, types.RevokeResponse{Revoked: "token", JTI: claims.APIKey})
+	case req.JTI != "":
+		if _, err = uuid.Parse(req.JTI); err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid jti"})
+			return
+		}
+		if req.JTI != principal.APIKey && !u.isAdmin(principal) {
+			utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+
+		// the token itself is unknown here, so revokeTokenCore keeps the marker for the longest token lifetime
+		err = u.revokeTokenCore(ctx, types.RevokedToken{
+			JTI:       req.JTI,
+			Reason:    req.Reason,
+			RevokedBy: principalRef(principal),
+		})
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation failed"})
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, types.RevokeResponse{Revoked: "token", JTI: req.JTI})
+	default:
+		tokenType := req.TokenType
+		if tokenType == "" {
+			tokenType = "user"
+		}
+		if tokenType != "user" && tokenType != "service" {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid token_type"})
+			return
+		}
+
+		isSelf := req.Subject == principal.Subject && tokenType == principal.TokenType
+		if !isSelf && !u.isAdmin(principal) {
+			utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+
+		revokedBefore := time.Now().UTC()
+		err = u.revokeSubjectCore(ctx, types.SubjectRevocation{
+			TokenType:     tokenType,
+			Subject:       req.Subject,
+			RevokedBefore: revokedBefore,
+			Reason:        req.Reason,
+			RevokedBy:     principalRef(principal),
+		})
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation failed"})
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, types.RevokeResponse{
+			Revoked:       "subject",
+			Subject:       req.Subject,
+			TokenType:     tokenType,
+			RevokedBefore: revokedBefore.Format(time.RFC3339),
+		})
+	}
+}
+
+// WarmRevocations restores Redis revocation markers from Postgres, e.g. after a Redis flush.
+func (u *AuthUseCaseImpl) WarmRevocations(ctx context.Context) error {
+	now := time.Now().UTC()
+
+	tokens, err := u.repo.ListRevokedTokens(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err = u.revocations.MarkTokenRevoked(ctx, token.JTI, token.ExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	subjects, err := u.repo.ListSubjectRevocations(ctx, now.Add(-u.longestTokenLifetime()))
+	if err != nil {
+		return err
+	}
+	for _, subject := range subjects {
+		ttl := subject.RevokedBefore.Add(u.longestTokenLifetime()).Sub(now)
+		err = u.revocations.MarkSubjectRevoked(ctx, subject.TokenType, subject.Subject, subject.RevokedBefore, ttl)
+		if err != nil {
+			return err
+		}
+	}
+
+	zap.L().Info("revocations warmed", zap.Int("tokens", len(tokens)), zap.Int("subjects", len(subjects)))
+	return nil
+}
+
+// revokeTokenCore persists a jti revocation, sets the Redis marker, and notifies gateways.
+func (u *AuthUseCaseImpl) revokeTokenCore(ctx context.Context, record types.RevokedToken) error {
+	record.RevokedAt = time.Now().UTC()
+	if record.ExpiresAt.IsZero() {
+		record.ExpiresAt = record.RevokedAt.Add(u.maxTTL)
+	}
+
+	err := u.repo.SaveRevokedToken(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	err = u.revocations.MarkTokenRevoked(ctx, record.JTI, record.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	// markers already enforce the revocation; the event only speeds up cache eviction
+	err = u.revocations.PublishRevocation(ctx, types.RevocationEvent{
+		Type:      "token",
+		JTI:       record.JTI,
+		Subject:   record.Subject,
+		TokenType: record.TokenType,
+		ExpiresAt: record.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		zap.L().Warn("publish token revocation", zap.String("jti", record.JTI), zap.Error(err))
+	}
+
+	zap.L().Info("token revoked",
+		zap.String("jti", record.JTI),
+		zap.String("sub", record.Subject),
+		zap.String("revoked_by", record.RevokedBy),
+		zap.String("reason", record.Reason),
+	)
+	reason := "jti " + record.JTI
+	if record.Reason != "" {
+		reason += ": " + record.Reason
+	}
+	u.audit(ctx, types.AuthEvent{
+		EventType: "token_revoked",
+		Outcome:   auditOutcomeSuccess,
+		TokenType: record.TokenType,
+		Subject:   record.Subject,
+		Actor:     record.RevokedBy,
+		Reason:    reason,
+	})
+	return nil
+}
+
+// revokeSubjectCore persists a subject cutoff, sets the Redis marker, and notifies gateways.
+func (u *AuthUseCaseImpl) revokeSubjectCore(ctx context.Context, record types.SubjectRevocation) error {
+	err := u.repo.SaveSubjectRevocation(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	err = u.revocations.MarkSubjectRevoked(ctx, record.TokenType, record.Subject, record.RevokedBefore, u.longestTokenLifetime())
+	if err != nil {
+		return err
+	}
+
+	err = u.revocations.PublishRevocation(ctx, types.RevocationEvent{
+		Type:          "subject",
+		Subject:       record.Subject,
+		TokenType:     record.TokenType,
+		RevokedBefore: record.RevokedBefore.Unix(),
+	})
+	if err != nil {
+		zap.L().Warn("publish subject revocation", zap.String("sub", record.Subject), zap.Error(err))
+	}
+
+	zap.L().Info("subject revoked",
+		zap.String("sub", record.Subject),
+		zap.String("token_type", record.TokenType),
+		zap.String("revoked_by", record.RevokedBy),
+		zap.String("reason", record.Reason),
+	)
+	u.audit(ctx, types.AuthEvent{
+		EventType: "subject_revoked",
+		Outcome:   auditOutcomeSuccess,
+		TokenType: record.TokenType,
+		Subject:   record.Subject,
+		Actor:     record.RevokedBy,
+		Reason:    record.Reason,
+	})
+	return nil
+}
+
+// longestTokenLifetime returns how long a subject cutoff must be kept to cover access tokens, refresh tokens and API keys.
+func (u *AuthUseCaseImpl) longestTokenLifetime() time.Duration {
+	return max(u.refreshTTL, u.maxTTL, secondsOrDefault(u.settings.APIKeys.MaxTTLSec, defaultAPIKeyMaxTTL))
+}
+
+// isAdmin reports whether the principal carries a configured admin role.
+func (u *AuthUseCaseImpl) isAdmin(principal types.ValidateResponse) bool {
+	for _, role := range u.settings.AdminRoles {
+		if role == principal.Role || slices.Contains(principal.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// principalFromContext returns the validated caller stored by AuthMiddleware.
+func principalFromContext(ctx context.Context) (types.ValidateResponse, bool) {
+	principal, ok := ctx.Value(ctxKeyPrincipal).(types.ValidateResponse)
+	return principal, ok
+}
+
+// principalRef formats a principal as token_type:sub for audit fields.
+func principalRef(principal types.ValidateResponse) string {
+	return principal.TokenType + ":" + principal.Subject
+}
+
+// countNonEmpty returns how many values are non-empty.
+func countNonEmpty(values ...string) int {
+	count := 0
+	for _, value := range values {
+		if value != "" {
+			count++
+		}
+	}
+	return count
+}
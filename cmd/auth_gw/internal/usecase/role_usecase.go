@@ -0,0 +1,191 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// SetUserRoles replaces the additional roles of a user.
+// @Summary Set user roles
+// @Description Replaces the roles assigned to a user next to its primary role. Tokens pick up the change at their next login or refresh. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body types.RolesRequest true "Roles payload"
+// @Success 200 {object} types.RolesResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/users/{id}/roles [put]
+func (u *AuthUseCaseImpl) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	u.setSubjectRoles(w, r, "user")
+}
+
+// SetServiceRoles replaces the additional roles of a service.
+// @Summary Set service roles
+// @Description Replaces the roles assigned to a service next to its primary role. Tokens pick up the change at their next service-token or refresh call. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param request body types.RolesRequest true "Roles payload"
+// @Success 200 {object} types.RolesResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services/{id}/roles [put]
+func (u *AuthUseCaseImpl) SetServiceRoles(w http.ResponseWriter, r *http.Request) {
+	u.setSubjectRoles(w, r, "service")
+}
+
+// setSubjectRoles validates and stores the assigned roles of a user or service.
+func (u *AuthUseCaseImpl) setSubjectRoles(w http.ResponseWriter, r *http.Request, tokenType string) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := idFromPath(w, r, "id", "invalid "+tokenType+" id")
+	if !ok {
+		return
+	}
+
+	var req types.RolesRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode roles request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	roles, err := normalizeRoles(req.Roles)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// an admin could otherwise drop the assigned role that makes it an admin
+	if isSelfSubject(principal, tokenType, id) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot change own roles"})
+		return
+	}
+
+	err = u.repo.SetRoleAssignments(ctx, tokenType, id, roles)
+	if !writeUpdateError(w, err, tokenType+" not found", "set roles failed") {
+		return
+	}
+
+	primary, err := u.primaryRole(ctx, tokenType, id)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "set roles failed"})
+		return
+	}
+
+	zap.L().Info("roles assigned",
+		zap.String("token_type", tokenType),
+		zap.Int64("subject_id", id),
+		zap.Strings("roles", roles),
+		zap.String("by", principalRef(principal)),
+	)
+	u.auditChange(ctx, "roles_assigned", principal, tokenType, id, strings.Join(roles, " "))
+	utils.WriteJSON(w, http.StatusOK, types.RolesResponse{
+		ID:             id,
+		Role:           primary,
+		Roles:          roles,
+		EffectiveRoles: u.effectiveRoles(primary, roles),
+	})
+}
+
+// primaryRole loads the role column of a user or service.
+func (u *AuthUseCaseImpl) primaryRole(ctx context.Context, tokenType string, id int64) (string, error) {
+	if tokenType == "service" {
+		service, err := u.repo.FindServiceByID(ctx, id)
+		return service.Role, err
+	}
+
+	user, err := u.repo.FindUserByID(ctx, id)
+	return user.Role, err
+}
+
+// subjectRoles loads the assigned roles of a user or service and expands them with its primary role.
+func (u *AuthUseCaseImpl) subjectRoles(ctx context.Context, tokenType string, id int64, primary string) ([]string, error) {
+	assigned, err := u.repo.ListRoleAssignments(ctx, tokenType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.effectiveRoles(primary, assigned), nil
+}
+
+// effectiveRoles returns the primary role first, then assigned roles, then every role they inherit.
+func (u *AuthUseCaseImpl) effectiveRoles(primary string, assigned []string) []string {
+	roles := make([]string, 0, 1+len(assigned))
+	seen := make(map[string]struct{})
+	queue := append([]string{primary}, assigned...)
+	// breadth-first, so cycles in role_inheritance end at already seen roles
+	for len(queue) > 0 {
+		role := queue[0]
+		queue = queue[1:]
+		if role == "" {
+			continue
+		}
+		if _, ok := seen[role]; ok {
+			continue
+		}
+		seen[role] = struct{}{}
+		roles = append(roles, role)
+		// viper lower-cases map keys
+		queue = append(queue, u.settings.RoleInheritance[strings.ToLower(role)]...)
+	}
+
+	return roles
+}
+
+// scopesFor returns the union of role_scopes of roles in first-seen order.
+func (u *AuthUseCaseImpl) scopesFor(roles []string) []string {
+	scopes := make([]string, 0)
+	for _, role := range roles {
+		// viper lower-cases map keys
+		for _, scope := range u.settings.RoleScopes[strings.ToLower(role)] {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return scopes
+}
+
+// normalizeRoles trims role names, drops duplicates and rejects names that cannot be claim values.
+func normalizeRoles(roles []string) ([]string, error) {
+	normalized := make([]string, 0, len(roles))
+	for _, role := range roles {
+		role = strings.TrimSpace(role)
+		if role == "" || strings.ContainsAny(role, " \t") {
+			return nil, fmt.Errorf("invalid role %q", role)
+		}
+		if !slices.Contains(normalized, role) {
+			normalized = append(normalized, role)
+		}
+	}
+	slices.Sort(normalized)
+
+	return normalized, nil
+}
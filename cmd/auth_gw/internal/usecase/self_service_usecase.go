@@ -0,0 +1,401 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/repo"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultVerificationTTL applies when auth_settings.self_service.verification_ttl_sec is unset.
+	defaultVerificationTTL = 24 * time.Hour
+	// defaultResetTTL applies when auth_settings.self_service.reset_ttl_sec is unset.
+	defaultResetTTL = time.Hour
+	// defaultMaxEmailsPerAddress and defaultEmailWindow throttle mails to one address.
+	defaultMaxEmailsPerAddress = 3
+	defaultEmailWindow         = time.Hour
+	// mailSendTimeout bounds one background send.
+	mailSendTimeout = 30 * time.Second
+	// maxEmailLength is the longest address RFC 5321 allows.
+	maxEmailLength = 254
+)
+
+// emailSentResponse is the answer to every accepted register and forgot request, whether or not an account exists.
+var emailSentResponse = types.StatusResponse{Status: "email_sent"}
+
+// Register starts a self-service registration.
+// @Summary Register
+// @Description Mails a verification link for a new account; the account is created once the token is posted to /auth/register/verify. The response is the same whether or not the address already has an account; its owner gets a notice instead. Disabled unless self_service.registration_role is set.
+// @Tags auth-gw
+// @Accept json
+// @Produce json
+// @Param request body types.RegisterRequest true "Registration payload"
+// @Success 202 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/register [post]
+func (u *AuthUseCaseImpl) Register(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if u.settings.SelfService.RegistrationRole == "" {
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "registration disabled"})
+		return
+	}
+
+	var req types.RegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode register request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	email, ok := normalizeEmail(req.Email)
+	if req.Username == "" || !ok {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "username and a valid email are required"})
+		return
+	}
+	err = u.validatePassword(req.Password)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// throttled before hashing, so the per-address limit bounds the hashing cost too; the throttle
+	// ignores whether the address has an account, and the hash still precedes any lookup, so known
+	// and unknown addresses take the same time
+	if !u.throttleEmail(ctx, w, email) {
+		return
+	}
+	hash, err := u.hashPassword(req.Password)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "registration failed"})
+		return
+	}
+
+	_, err = u.repo.FindUserByEmail(ctx, email)
+	switch {
+	case err == nil:
+		u.sendMail(ctx, types.MailMessage{
+			To:      email,
+			Subject: "Registration attempt",
+			Body:    "Someone tried to register a new account with this address, which already has one.\nIf you forgot your password, request a reset instead. Otherwise no action is needed.\n",
+		})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		token, ok := u.saveEmailToken(ctx, w, types.EmailToken{
+			Purpose:      types.EmailTokenRegister,
+			Email:        email,
+			Username:     req.Username,
+			PasswordHash: hash,
+		}, secondsOrDefault(u.settings.SelfService.VerificationTTLSec, defaultVerificationTTL))
+		if !ok {
+			return
+		}
+		u.sendMail(ctx, types.MailMessage{
+			To:      email,
+			Subject: "Verify your email address",
+			Body:    "Confirm your registration as " + req.Username + " with this link:\n" + tokenLink(u.settings.SelfService.VerifyURL, token) + "\n",
+		})
+	default:
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "registration failed"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, emailSentResponse)
+}
+
+// VerifyRegistration creates the account of a verified registration.
+// @Summary Verify registration
+// @Description Redeems a token mailed by /auth/register and creates the user with the registration role. Tokens are single-use.
+// @Tags auth-gw
+// @Accept json
+// @Produce json
+// @Param request body types.EmailTokenRequest true "Mailed token"
+// @Success 201 {object} types.UserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/register/verify [post]
+func (u *AuthUseCaseImpl) VerifyRegistration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req types.EmailTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode verify registration request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	token, ok := u.consumeEmailToken(ctx, w, req.Token, types.EmailTokenRegister)
+	if !ok {
+		return
+	}
+
+	user, err := u.repo.CreateUser(ctx, types.UserRecord{
+		Username:     token.Username,
+		PasswordHash: token.PasswordHash,
+		Role:         u.settings.SelfService.RegistrationRole,
+		Email:        token.Email,
+	})
+	if errors.Is(err, repo.ErrUsernameTaken) {
+		utils.WriteJSON(w, http.StatusConflict, map[string]string{"error": "username already exists"})
+		return
+	}
+	if errors.Is(err, repo.ErrEmailTaken) {
+		utils.WriteJSON(w, http.StatusConflict, map[string]string{"error": "email already registered"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "registration failed"})
+		return
+	}
+	// other pending registrations of the address can no longer succeed
+	_, _ = u.repo.DeleteEmailTokens(ctx, types.EmailTokenRegister, user.Email)
+
+	self := types.ValidateResponse{TokenType: "user", Subject: fmt.Sprint(user.ID)}
+	zap.L().Info("user registered", zap.Int64("user_id", user.ID), zap.String("role", user.Role))
+	u.auditChange(ctx, "user_registered", self, "user", user.ID, "username "+user.Username)
+	utils.WriteJSON(w, http.StatusCreated, userResponse(user))
+}
+
+// ForgotPassword mails a password reset link.
+// @Summary Forgot password
+// @Description Mails a password reset link to the address of an enabled user. The response is the same whether or not the address has an account.
+// @Tags auth-gw
+// @Accept json
+// @Produce json
+// @Param request body types.ForgotPasswordRequest true "Account email"
+// @Success 202 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/password/forgot [post]
+func (u *AuthUseCaseImpl) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req types.ForgotPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode forgot password request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "a valid email is required"})
+		return
+	}
+	if !u.throttleEmail(ctx, w, email) {
+		return
+	}
+
+	user, err := u.repo.FindUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "password reset failed"})
+		return
+	}
+	// unknown and disabled accounts get the same answer, just no mail
+	if err == nil && !user.Disabled {
+		token, ok := u.saveEmailToken(ctx, w, types.EmailToken{
+			Purpose: types.EmailTokenPasswordReset,
+			Email:   email,
+			UserID:  user.ID,
+		}, secondsOrDefault(u.settings.SelfService.ResetTTLSec, defaultResetTTL))
+		if !ok {
+			return
+		}
+		u.sendMail(ctx, types.MailMessage{
+			To:      email,
+			Subject: "Reset your password",
+			Body:    "Set a new password for " + user.Username + " with this link:\n" + tokenLink(u.settings.SelfService.ResetURL, token) + "\nIf you did not ask for this, ignore this mail.\n",
+		})
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, emailSentResponse)
+}
+
+// ResetPassword sets a new password with a mailed reset token.
+// @Summary Reset password
+// @Description Redeems a token mailed by /auth/password/forgot, sets the new password and revokes every token issued to the user so far. Tokens are single-use; the other outstanding reset tokens of the address are dropped.
+// @Tags auth-gw
+// @Accept json
+// @Produce json
+// @Param request body types.ResetPasswordRequest true "Reset payload"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/password/reset [post]
+func (u *AuthUseCaseImpl) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req types.ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode reset password request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	// checked before the token is spent, so a rejected password does not cost the user their link
+	err = u.validatePassword(req.NewPassword)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	hash, err := u.hashPassword(req.NewPassword)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "password reset failed"})
+		return
+	}
+
+	token, ok := u.consumeEmailToken(ctx, w, req.Token, types.EmailTokenPasswordReset)
+	if !ok {
+		return
+	}
+
+	user, err := u.repo.FindUserByID(ctx, token.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && (user.Disabled || user.Email != token.Email)) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "password reset failed"})
+		return
+	}
+
+	err = u.repo.UpdateUserPassword(ctx, user.ID, hash)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "password reset failed"})
+		return
+	}
+	_, _ = u.repo.DeleteEmailTokens(ctx, types.EmailTokenPasswordReset, token.Email)
+
+	// whoever knew the old password may hold live sessions
+	self := types.ValidateResponse{TokenType: "user", Subject: fmt.Sprint(user.ID)}
+	if !u.revokeSubjectTokens(ctx, w, self, "user", user.ID, "password reset") {
+		return
+	}
+
+	zap.L().Info("password reset", zap.Int64("user_id", user.ID))
+	u.auditChange(ctx, "password_reset", self, "user", user.ID, "")
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "password_reset"})
+}
+
+// throttleEmail counts a mail request for email and answers 429 once the address exceeds its budget; it writes the response when false.
+// Known and unknown addresses are counted alike, so the limit reveals nothing about accounts.
+func (u *AuthUseCaseImpl) throttleEmail(ctx context.Context, w http.ResponseWriter, email string) bool {
+	settings := u.settings.SelfService
+	limit := settings.MaxEmailsPerAddress
+	if limit <= 0 {
+		limit = defaultMaxEmailsPerAddress
+	}
+	window := secondsOrDefault(settings.EmailWindowSec, defaultEmailWindow)
+
+	count, err := u.attempts.RecordFailure(ctx, types.AttemptKey{Kind: types.AttemptKindEmail, Identifier: email}, window)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "request failed"})
+		return false
+	}
+	if count > int64(limit) {
+		zap.L().Warn("email requests throttled", zap.Int64("count", count), zap.Int("limit", limit))
+		w.Header().Set("Retry-After", retryAfterSeconds(window))
+		utils.WriteJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many requests"})
+		return false
+	}
+	return true
+}
+
+// saveEmailToken stores token under a fresh random value and returns that value for the mail; it writes the response when false.
+func (u *AuthUseCaseImpl) saveEmailToken(ctx context.Context, w http.ResponseWriter, token types.EmailToken, ttl time.Duration) (string, bool) {
+	value, err := newRefreshToken()
+	if err != nil {
+		zap.L().Error("generate email token", zap.Error(err))
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "request failed"})
+		return "", false
+	}
+
+	now := time.Now().UTC()
+	token.TokenHash = hashRefreshToken(value)
+	token.CreatedAt = now
+	token.ExpiresAt = now.Add(ttl)
+	err = u.repo.SaveEmailToken(ctx, token)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "request failed"})
+		return "", false
+	}
+	return value, true
+}
+
+// consumeEmailToken spends a mailed token of purpose; it writes the response when false.
+func (u *AuthUseCaseImpl) consumeEmailToken(ctx context.Context, w http.ResponseWriter, value string, purpose string) (types.EmailToken, bool) {
+	if value == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired token"})
+		return types.EmailToken{}, false
+	}
+
+	token, err := u.repo.ConsumeEmailToken(ctx, hashRefreshToken(value), purpose)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "request failed"})
+		return types.EmailToken{}, false
+	}
+	if err != nil || time.Now().After(token.ExpiresAt) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or expired token"})
+		return types.EmailToken{}, false
+	}
+	return token, true
+}
+
+// sendMail sends msg in the background, so the response time does not depend on whether a mail was sent.
+// Failures are only logged; the caller has already answered the same way either way.
+func (u *AuthUseCaseImpl) sendMail(ctx context.Context, msg types.MailMessage) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+	u.outbox.Add(1)
+	go func() {
+		defer u.outbox.Done()
+		defer cancel()
+
+		err := u.mailer.Send(ctx, msg)
+		if err != nil {
+			zap.L().Error("send mail", zap.String("subject", msg.Subject), zap.Error(err))
+		}
+	}()
+}
+
+// normalizeEmail returns the lower-cased bare address, or false when raw is not a single plain address.
+func normalizeEmail(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > maxEmailLength {
+		return "", false
+	}
+	address, err := mail.ParseAddress(raw)
+	if err != nil || address.Name != "" || address.Address != raw {
+		return "", false
+	}
+	return strings.ToLower(address.Address), true
+}
+
+// tokenLink appends token as query parameter to base; without base the bare token is mailed.
+func tokenLink(base string, token string) string {
+	if base == "" {
+		return token
+	}
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + "token=" + url.QueryEscape(token)
+}
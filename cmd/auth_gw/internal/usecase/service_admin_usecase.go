@@ -0,0 +1,441 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// serviceSecretTouchInterval limits last_used_at writes for busy service secrets.
+const serviceSecretTouchInterval = time.Minute
+
+// CreateService registers a service with a generated secret.
+// @Summary Register service
+// @Description Registers a service and returns its first generated secret. The secret is shown only once. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.CreateServiceRequest true "Service payload"
+// @Success 201 {object} types.ServiceCredentialsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services [post]
+func (u *AuthUseCaseImpl) CreateService(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req types.CreateServiceRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode create service request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Role = strings.TrimSpace(req.Role)
+	if req.Name == "" || req.Role == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "name and role are required"})
+		return
+	}
+	if req.ExpiresInSec < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_in_sec"})
+		return
+	}
+
+	secret, record, err := u.newServiceSecret(req.ExpiresInSec)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create service failed"})
+		return
+	}
+
+	service, record, err := u.repo.CreateService(ctx, types.ServiceRecord{Name: req.Name, Role: req.Role}, record)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create service failed"})
+		return
+	}
+
+	zap.L().Info("service registered", zap.Int64("service_id", service.ID), zap.String("role", service.Role), zap.String("by", principalRef(principal)))
+	u.auditChange(ctx, "service_created", principal, "service", service.ID, "name "+service.Name)
+	utils.WriteJSON(w, http.StatusCreated, serviceCredentialsResponse(service, record, secret))
+}
+
+// ListServices lists services with secret and signing key metadata.
+// @Summary List services
+// @Description Lists services with the metadata of their secrets and signing keys, including last use. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} types.ServicesResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services [get]
+func (u *AuthUseCaseImpl) ListServices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, ok := u.requireAdmin(w, r); !ok {
+		return
+	}
+
+	records, err := u.repo.ListServices(ctx)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "list services failed"})
+		return
+	}
+
+	now := time.Now().UTC()
+	services := make([]types.ServiceResponse, 0, len(records))
+	for _, record := range records {
+		secrets, err := u.repo.ListServiceSecrets(ctx, record.ID)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "list services failed"})
+			return
+		}
+		signingKeys, err := u.repo.ListSigningKeys(ctx, record.ID)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "list services failed"})
+			return
+		}
+
+		service := types.ServiceResponse{
+			ID:           record.ID,
+			Name:         record.Name,
+			Role:         record.Role,
+			Disabled:     record.Disabled,
+			LegacySecret: record.SecretHash != "",
+			Secrets:      make([]types.ServiceSecretResponse, 0, len(secrets)),
+			SigningKeys:  make([]types.SigningKeyResponse, 0, len(signingKeys)),
+		}
+		for _, secret := range secrets {
+			service.Secrets = append(service.Secrets, serviceSecretResponse(secret, now))
+		}
+		for _, key := range signingKeys {
+			service.SigningKeys = append(service.SigningKeys, signingKeyResponse(key, now))
+		}
+		services = append(services, service)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.ServicesResponse{Services: services})
+}
+
+// RotateServiceSecret adds a generated secret to a service, optionally retiring the existing ones after an overlap.
+// @Summary Rotate service secret
+// @Description Adds a new secret, shown only once. Existing secrets stay valid until retire_existing_in_sec passes, or keep their expiry when it is omitted. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param request body types.RotateServiceSecretRequest false "Rotation options"
+// @Success 201 {object} types.ServiceCredentialsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services/{id}/secrets [post]
+func (u *AuthUseCaseImpl) RotateServiceSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	serviceID, ok := idFromPath(w, r, "id", "invalid service id")
+	if !ok {
+		return
+	}
+
+	var req types.RotateServiceSecretRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		zap.L().Error("decode rotate service secret request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.ExpiresInSec < 0 || (req.RetireExistingInSec != nil && *req.RetireExistingInSec < 0) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "lifetimes must not be negative"})
+		return
+	}
+
+	secret, record, err := u.newServiceSecret(req.ExpiresInSec)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "rotate service secret failed"})
+		return
+	}
+
+	record.ServiceID = serviceID
+	var retireAt *time.Time
+	if req.RetireExistingInSec != nil {
+		at := record.CreatedAt.Add(time.Duration(*req.RetireExistingInSec) * time.Second)
+		retireAt = &at
+	}
+
+	record, err = u.repo.AddServiceSecret(ctx, record, retireAt)
+	if !writeUpdateError(w, err, "service not found", "rotate service secret failed") {
+		return
+	}
+
+	service, err := u.repo.FindServiceByID(ctx, serviceID)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "rotate service secret failed"})
+		return
+	}
+
+	zap.L().Info("service secret added",
+		zap.Int64("service_id", serviceID),
+		zap.Int64("secret_id", record.ID),
+		zap.Timep("retire_existing_at", retireAt),
+		zap.String("by", principalRef(principal)),
+	)
+	u.auditChange(ctx, "service_secret_added", principal, "service", serviceID, fmt.Sprintf("secret %d", record.ID))
+	utils.WriteJSON(w, http.StatusCreated, serviceCredentialsResponse(service, record, secret))
+}
+
+// DeleteServiceSecret removes one secret of a service right away.
+// @Summary Delete service secret
+// @Description Removes a service secret immediately; tokens already issued with it stay valid until they expire or are revoked. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param secret_id path int true "Secret ID"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services/{id}/secrets/{secret_id} [delete]
+func (u *AuthUseCaseImpl) DeleteServiceSecret(w http.ResponseWriter, r *http.Request) {
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	serviceID, ok := idFromPath(w, r, "id", "invalid service id")
+	if !ok {
+		return
+	}
+	secretID, ok := idFromPath(w, r, "secret_id", "invalid secret id")
+	if !ok {
+		return
+	}
+
+	err := u.repo.DeleteServiceSecret(r.Context(), serviceID, secretID)
+	if !writeUpdateError(w, err, "secret not found", "delete service secret failed") {
+		return
+	}
+
+	zap.L().Info("service secret deleted", zap.Int64("service_id", serviceID), zap.Int64("secret_id", secretID), zap.String("by", principalRef(principal)))
+	u.auditChange(r.Context(), "service_secret_deleted", principal, "service", serviceID, fmt.Sprintf("secret %d", secretID))
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "deleted"})
+}
+
+// DisableService disables a service and optionally revokes its live tokens.
+// @Summary Disable service
+// @Description Disables a service so none of its secrets or refresh tokens work. Set revoke_tokens to also revoke every token issued so far. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param request body types.DisableRequest false "Disable options"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services/{id}/disable [post]
+func (u *AuthUseCaseImpl) DisableService(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	serviceID, ok := idFromPath(w, r, "id", "invalid service id")
+	if !ok {
+		return
+	}
+
+	var req types.DisableRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		zap.L().Error("decode disable service request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if isSelfSubject(principal, "service", serviceID) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot disable own service"})
+		return
+	}
+
+	err = u.repo.SetServiceDisabled(ctx, serviceID, true)
+	if !writeUpdateError(w, err, "service not found", "disable service failed") {
+		return
+	}
+
+	if req.RevokeTokens && !u.revokeSubjectTokens(ctx, w, principal, "service", serviceID, req.Reason) {
+		return
+	}
+
+	zap.L().Info("service disabled", zap.Int64("service_id", serviceID), zap.String("by", principalRef(principal)), zap.Bool("revoke_tokens", req.RevokeTokens))
+	u.auditChange(ctx, "service_disabled", principal, "service", serviceID, req.Reason)
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "disabled"})
+}
+
+// EnableService re-enables a disabled service.
+// @Summary Enable service
+// @Description Re-enables a disabled service. Revoked tokens stay revoked. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Service ID"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services/{id}/enable [post]
+func (u *AuthUseCaseImpl) EnableService(w http.ResponseWriter, r *http.Request) {
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	serviceID, ok := idFromPath(w, r, "id", "invalid service id")
+	if !ok {
+		return
+	}
+
+	err := u.repo.SetServiceDisabled(r.Context(), serviceID, false)
+	if !writeUpdateError(w, err, "service not found", "enable service failed") {
+		return
+	}
+
+	zap.L().Info("service enabled", zap.Int64("service_id", serviceID), zap.String("by", principalRef(principal)))
+	u.auditChange(r.Context(), "service_enabled", principal, "service", serviceID, "")
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "enabled"})
+}
+
+// matchServiceSecret compares secret against the active secrets of a service and returns the matching one.
+// Services that were never rotated still authenticate with the legacy secret_hash; the returned secret then has ID 0.
+func (u *AuthUseCaseImpl) matchServiceSecret(ctx context.Context, service types.ServiceRecord, secret string) (types.ServiceSecret, error) {
+	secrets, err := u.repo.ListServiceSecrets(ctx, service.ID)
+	if err != nil {
+		return types.ServiceSecret{}, err
+	}
+
+	if len(secrets) == 0 && service.SecretHash != "" {
+		secrets = []types.ServiceSecret{{ServiceID: service.ID, SecretHash: service.SecretHash}}
+	}
+
+	now := time.Now().UTC()
+	compared := false
+	for _, candidate := range secrets {
+		if !candidate.Active(now) {
+			continue
+		}
+
+		compared = true
+		if bcrypt.CompareHashAndPassword([]byte(candidate.SecretHash), []byte(secret)) == nil {
+			return candidate, nil
+		}
+	}
+
+	if !compared {
+		// same bcrypt cost as a wrong secret, so response time does not reveal services without active secrets
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(secret))
+	}
+
+	zap.L().Error("compare service secret failed", zap.Int64("service_id", service.ID))
+	return types.ServiceSecret{}, errInvalidCredentials
+}
+
+// touchServiceSecret records secret use, at most once per serviceSecretTouchInterval; failures are only logged.
+func (u *AuthUseCaseImpl) touchServiceSecret(ctx context.Context, secret types.ServiceSecret) {
+	now := time.Now().UTC()
+	if secret.ID == 0 || (secret.LastUsedAt != nil && now.Sub(*secret.LastUsedAt) < serviceSecretTouchInterval) {
+		return
+	}
+
+	err := u.repo.TouchServiceSecret(ctx, secret.ID, now)
+	if err != nil {
+		zap.L().Warn("touch service secret", zap.Int64("secret_id", secret.ID), zap.Error(err))
+	}
+}
+
+// newServiceSecret generates a secret and its unsaved record; a positive expiresInSec bounds its lifetime.
+func (u *AuthUseCaseImpl) newServiceSecret(expiresInSec int64) (string, types.ServiceSecret, error) {
+	secret, err := newRefreshToken()
+	if err != nil {
+		zap.L().Error("generate service secret", zap.Error(err))
+		return "", types.ServiceSecret{}, err
+	}
+
+	hash, err := u.hashPassword(secret)
+	if err != nil {
+		return "", types.ServiceSecret{}, err
+	}
+
+	record := types.ServiceSecret{SecretHash: hash, CreatedAt: time.Now().UTC()}
+	if expiresInSec > 0 {
+		expiresAt := record.CreatedAt.Add(time.Duration(expiresInSec) * time.Second)
+		record.ExpiresAt = &expiresAt
+	}
+
+	return secret, record, nil
+}
+
+// serviceCredentialsResponse maps a service and a freshly generated secret to the one-time response.
+func serviceCredentialsResponse(service types.ServiceRecord, record types.ServiceSecret, secret string) types.ServiceCredentialsResponse {
+	resp := types.ServiceCredentialsResponse{
+		ServiceID: fmt.Sprint(service.ID),
+		Name:      service.Name,
+		Role:      service.Role,
+		SecretID:  record.ID,
+		Secret:    secret,
+	}
+	if record.ExpiresAt != nil {
+		resp.ExpiresAt = record.ExpiresAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+// serviceSecretResponse maps secret metadata to its API form.
+func serviceSecretResponse(secret types.ServiceSecret, now time.Time) types.ServiceSecretResponse {
+	resp := types.ServiceSecretResponse{
+		ID:        secret.ID,
+		CreatedAt: secret.CreatedAt.UTC().Format(time.RFC3339),
+		Active:    secret.Active(now),
+	}
+	if secret.ExpiresAt != nil {
+		resp.ExpiresAt = secret.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if secret.LastUsedAt != nil {
+		resp.LastUsedAt = secret.LastUsedAt.UTC().Format(time.RFC3339)
+	}
+	return resp
+}
@@ -0,0 +1,286 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// sessionCleanupInterval is how often records of expired tokens are deleted.
+const sessionCleanupInterval = time.Hour
+
+// ListSessions lists the caller's outstanding tokens.
+// @Summary List own sessions
+// @Description Lists unexpired, unrevoked access tokens of the caller with client IP, user agent and the last request seen by api_gw.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} types.SessionsResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/sessions [get]
+func (u *AuthUseCaseImpl) ListSessions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	u.writeSessions(w, r, principal, principal.TokenType, principal.Subject)
+}
+
+// RevokeSession revokes one of the caller's tokens.
+// @Summary Revoke own session
+// @Description Revokes one of the caller's access tokens by jti, together with the refresh token family it was issued with. Not allowed with impersonation tokens.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param jti path string true "Session jti"
+// @Success 200 {object} types.SessionRevokeResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/sessions/{jti} [delete]
+func (u *AuthUseCaseImpl) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	if refuseImpersonated(w, principal) {
+		return
+	}
+
+	u.revokeSession(w, r, principal, func(record types.IssuedToken) bool {
+		return record.TokenType == principal.TokenType && record.Subject == principal.Subject
+	})
+}
+
+// ListSubjectSessions lists the outstanding tokens of any user or service.
+// @Summary List sessions of a subject
+// @Description Lists unexpired, unrevoked access tokens of a user or service. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param token_type query string false "user (default) or service"
+// @Param subject query string true "User or service id"
+// @Success 200 {object} types.SessionsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/sessions [get]
+func (u *AuthUseCaseImpl) ListSubjectSessions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	tokenType := r.URL.Query().Get("token_type")
+	if tokenType == "" {
+		tokenType = "user"
+	}
+	if tokenType != "user" && tokenType != "service" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid token_type"})
+		return
+	}
+	subject := r.URL.Query().Get("subject")
+	if subject == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "subject is required"})
+		return
+	}
+
+	u.writeSessions(w, r, principal, tokenType, subject)
+}
+
+// RevokeSubjectSession revokes any token by jti.
+// @Summary Revoke session
+// @Description Revokes an access token by jti, together with the refresh token family it was issued with. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param jti path string true "Session jti"
+// @Success 200 {object} types.SessionRevokeResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/sessions/{jti} [delete]
+func (u *AuthUseCaseImpl) RevokeSubjectSession(w http.ResponseWriter, r *http.Request) {
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	u.revokeSession(w, r, principal, func(types.IssuedToken) bool { return true })
+}
+
+// RunSessionCleanup deletes records of expired tokens, abandoned OIDC logins and unused email tokens until ctx is done.
+func (u *AuthUseCaseImpl) RunSessionCleanup(ctx context.Context) {
+	for {
+		deleted, err := u.repo.DeleteIssuedTokensExpiredBefore(ctx, time.Now().UTC())
+		if err == nil && deleted > 0 {
+			zap.L().Info("expired sessions pruned", zap.Int64("deleted", deleted))
+		}
+		deleted, err = u.repo.DeleteOIDCLoginStatesExpiredBefore(ctx, time.Now().UTC())
+		if err == nil && deleted > 0 {
+			zap.L().Info("abandoned oidc logins pruned", zap.Int64("deleted", deleted))
+		}
+		deleted, err = u.repo.DeleteEmailTokensExpiredBefore(ctx, time.Now().UTC())
+		if err == nil && deleted > 0 {
+			zap.L().Info("expired email tokens pruned", zap.Int64("deleted", deleted))
+		}
+
+		timer := time.NewTimer(sessionCleanupInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// recordIssuedToken stores an issued access token for session listings; failures are logged but never block issuance.
+func (u *AuthUseCaseImpl) recordIssuedToken(ctx context.Context, grant string, tokenType string, subject string, access issuedToken, familyID string, actor *types.ActorClaim) {
+	record := types.IssuedToken{
+		JTI:       access.jti,
+		TokenType: tokenType,
+		Subject:   subject,
+		Grant:     grant,
+		FamilyID:  familyID,
+		IssuedAt:  time.Now().UTC(),
+		ExpiresAt: access.expiresAt,
+	}
+	if actor != nil {
+		record.ActorSubject = actor.Subject
+		record.ActorTokenType = actor.TokenType
+	}
+	if source, ok := ctx.Value(ctxKeyAuditSource).(auditSource); ok {
+		record.ClientIP = source.clientIP
+		record.UserAgent = source.userAgent
+	}
+
+	err := u.repo.SaveIssuedToken(ctx, record)
+	if err != nil {
+		zap.L().Error("issued token record failed", zap.String("jti", access.jti), zap.String("grant", grant), zap.Error(err))
+	}
+}
+
+// writeSessions writes the live sessions of a subject, marking the principal's own token.
+func (u *AuthUseCaseImpl) writeSessions(w http.ResponseWriter, r *http.Request, principal types.ValidateResponse, tokenType string, subject string) {
+	ctx := r.Context()
+	records, err := u.repo.ListIssuedTokens(ctx, tokenType, subject, time.Now().UTC())
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "list sessions failed"})
+		return
+	}
+
+	// revocations are kept as markers only, so each token is checked like /auth/validate would
+	live := make([]types.IssuedToken, 0, len(records))
+	jtis := make([]string, 0, len(records))
+	for _, record := range records {
+		revoked, err := u.revocations.IsRevoked(ctx, record.JTI, record.TokenType, record.Subject, record.IssuedAt)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "list sessions failed"})
+			return
+		}
+		if !revoked {
+			live = append(live, record)
+			jtis = append(jtis, record.JTI)
+		}
+	}
+
+	// activity is informational; without Redis the listing still works
+	lastSeen, err := u.revocations.TokensLastSeen(ctx, jtis)
+	if err != nil {
+		lastSeen = nil
+	}
+
+	resp := types.SessionsResponse{Sessions: make([]types.SessionResponse, 0, len(live))}
+	for _, record := range live {
+		session := sessionResponse(record)
+		if seenAt, ok := lastSeen[record.JTI]; ok {
+			session.LastSeenAt = seenAt.UTC().Format(time.RFC3339)
+		}
+		session.Current = record.JTI == principal.APIKey
+		resp.Sessions = append(resp.Sessions, session)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, resp)
+}
+
+// revokeSession revokes the session named by the jti path variable when allowed reports true for its record.
+func (u *AuthUseCaseImpl) revokeSession(w http.ResponseWriter, r *http.Request, principal types.ValidateResponse, allowed func(types.IssuedToken) bool) {
+	ctx := r.Context()
+	jti := mux.Vars(r)["jti"]
+	if _, err := uuid.Parse(jti); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid jti"})
+		return
+	}
+
+	record, err := u.repo.FindIssuedToken(ctx, jti)
+	// sessions of other subjects look missing, so jtis cannot be probed
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !allowed(record)) {
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation failed"})
+		return
+	}
+
+	err = u.revokeTokenCore(ctx, types.RevokedToken{
+		JTI:       record.JTI,
+		Subject:   record.Subject,
+		TokenType: record.TokenType,
+		Reason:    "session revoked",
+		RevokedBy: principalRef(principal),
+		ExpiresAt: record.ExpiresAt,
+	})
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation failed"})
+		return
+	}
+
+	// without its refresh family the session could be renewed right away
+	if record.FamilyID != "" {
+		err = u.revokeRefreshFamily(ctx, types.RefreshToken{FamilyID: record.FamilyID}, "session_revoked")
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation failed"})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.SessionRevokeResponse{Revoked: "session", JTI: record.JTI})
+}
+
+// sessionResponse maps an issued token record to its API form.
+func sessionResponse(record types.IssuedToken) types.SessionResponse {
+	resp := types.SessionResponse{
+		JTI:       record.JTI,
+		TokenType: record.TokenType,
+		Subject:   record.Subject,
+		Grant:     record.Grant,
+		ClientIP:  record.ClientIP,
+		UserAgent: record.UserAgent,
+		IssuedAt:  record.IssuedAt.UTC().Format(time.RFC3339),
+		ExpiresAt: record.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if record.ActorSubject != "" {
+		resp.Actor = &types.ActorClaim{Subject: record.ActorSubject, TokenType: record.ActorTokenType}
+	}
+	return resp
+}
@@ -0,0 +1,243 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+	"github.com/yirez/go-gw-test/pkg/rest_qol"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	// signingKeyPrefix marks signing key ids so they are not mistaken for API keys.
+	signingKeyPrefix = "gws_"
+	// signingKeyTouchInterval limits last_used_at writes for busy signing keys.
+	signingKeyTouchInterval = time.Minute
+)
+
+var errInvalidSignature = errors.New("invalid request signature")
+
+// CreateSigningKey adds an HMAC request signing key to a service.
+// @Summary Create service signing key
+// @Description Adds a request signing key to a service and returns its key_id and secret; the secret is shown only once. Lifetimes follow auth_settings.api_keys. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param request body types.CreateSigningKeyRequest false "Signing key options"
+// @Success 201 {object} types.SigningKeyCredentialsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services/{id}/signing-keys [post]
+func (u *AuthUseCaseImpl) CreateSigningKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	serviceID, ok := idFromPath(w, r, "id", "invalid service id")
+	if !ok {
+		return
+	}
+
+	var req types.CreateSigningKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		zap.L().Error("decode create signing key request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.ExpiresInSec < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expires_in_sec"})
+		return
+	}
+	ttl, err := u.apiKeyTTL(req.ExpiresInSec)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	keyID, err := newSigningKeyID()
+	if err != nil {
+		zap.L().Error("generate signing key id", zap.Error(err))
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create signing key failed"})
+		return
+	}
+	secret, err := newRefreshToken()
+	if err != nil {
+		zap.L().Error("generate signing key secret", zap.Error(err))
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create signing key failed"})
+		return
+	}
+
+	now := time.Now().UTC()
+	key, err := u.repo.CreateSigningKey(ctx, types.ServiceSigningKey{
+		KeyID:     keyID,
+		APIKey:    uuid.NewString(),
+		ServiceID: serviceID,
+		Secret:    secret,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if !writeUpdateError(w, err, "service not found", "create signing key failed") {
+		return
+	}
+
+	zap.L().Info("service signing key created", zap.Int64("service_id", serviceID), zap.String("key_id", key.KeyID), zap.String("by", principalRef(principal)))
+	u.auditChange(ctx, "signing_key_created", principal, "service", serviceID, "key "+key.KeyID)
+	utils.WriteJSON(w, http.StatusCreated, types.SigningKeyCredentialsResponse{
+		ServiceID: fmt.Sprint(serviceID),
+		KeyID:     key.KeyID,
+		Secret:    secret,
+		ExpiresAt: key.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// DeleteSigningKey removes one signing key of a service right away.
+// @Summary Delete service signing key
+// @Description Removes a request signing key immediately; api_gw verifies every signed request with auth_gw, so the key stops working at once. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param key_id path string true "Signing key ID"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/services/{id}/signing-keys/{key_id} [delete]
+func (u *AuthUseCaseImpl) DeleteSigningKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	serviceID, ok := idFromPath(w, r, "id", "invalid service id")
+	if !ok {
+		return
+	}
+	keyID := mux.Vars(r)["key_id"]
+	if !strings.HasPrefix(keyID, signingKeyPrefix) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid signing key id"})
+		return
+	}
+
+	_, err := u.repo.DeleteSigningKey(r.Context(), serviceID, keyID)
+	if !writeUpdateError(w, err, "signing key not found", "delete signing key failed") {
+		return
+	}
+
+	zap.L().Info("service signing key deleted", zap.Int64("service_id", serviceID), zap.String("key_id", keyID), zap.String("by", principalRef(principal)))
+	u.auditChange(r.Context(), "signing_key_deleted", principal, "service", serviceID, "key "+keyID)
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "deleted"})
+}
+
+// validateSignatureCore verifies a request signature and maps the signing key to service token metadata.
+// Timestamp and nonce checks happen in api_gw before it asks, since only it sees the request.
+func (u *AuthUseCaseImpl) validateSignatureCore(ctx context.Context, sig types.SignatureValidation) (types.ValidateResponse, error) {
+	if !strings.HasPrefix(sig.KeyID, signingKeyPrefix) || sig.StringToSign == "" {
+		return types.ValidateResponse{}, errInvalidSignature
+	}
+
+	key, err := u.repo.FindSigningKey(ctx, sig.KeyID)
+	if err != nil {
+		return types.ValidateResponse{}, errInvalidSignature
+	}
+
+	expected := rest_qol.ComputeSignature([]byte(key.Secret), sig.StringToSign)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sig.Signature))) {
+		zap.L().Warn("request signature mismatch", zap.String("key_id", sig.KeyID))
+		return types.ValidateResponse{}, errInvalidSignature
+	}
+
+	now := time.Now().UTC()
+	if !now.Before(key.ExpiresAt) {
+		return types.ValidateResponse{}, errInvalidSignature
+	}
+
+	subject := fmt.Sprint(key.ServiceID)
+	roles, err := u.currentRoles(ctx, "service", subject)
+	if err != nil {
+		zap.L().Warn("signing key owner refused", zap.String("key_id", sig.KeyID), zap.String("sub", subject), zap.Error(err))
+		return types.ValidateResponse{}, errInvalidSignature
+	}
+
+	revoked, err := u.revocations.IsRevoked(ctx, key.APIKey, "service", subject, key.CreatedAt)
+	if err != nil {
+		return types.ValidateResponse{}, errors.New("revocation check failed")
+	}
+	if revoked {
+		zap.L().Warn("revoked signing key presented", zap.String("key_id", sig.KeyID), zap.String("sub", subject))
+		return types.ValidateResponse{}, errTokenRevoked
+	}
+
+	u.touchSigningKey(ctx, key, now)
+	return types.ValidateResponse{
+		APIKey:    key.APIKey,
+		Subject:   subject,
+		TokenType: "service",
+		Role:      roles[0],
+		Roles:     roles,
+		Scope:     strings.Join(u.scopesFor(roles), " "),
+		IssuedAt:  key.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ExpiresAt: key.ExpiresAt.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// touchSigningKey records key use, at most once per signingKeyTouchInterval; failures are only logged.
+func (u *AuthUseCaseImpl) touchSigningKey(ctx context.Context, key types.ServiceSigningKey, now time.Time) {
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < signingKeyTouchInterval {
+		return
+	}
+
+	err := u.repo.TouchSigningKey(ctx, key.ID, now)
+	if err != nil {
+		zap.L().Warn("touch signing key", zap.String("key_id", key.KeyID), zap.Error(err))
+	}
+}
+
+// newSigningKeyID generates a public signing key id of the form gws_<16 hex>.
+func newSigningKeyID() (string, error) {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return signingKeyPrefix + hex.EncodeToString(buf), nil
+}
+
+// signingKeyResponse maps signing key metadata to its API form.
+func signingKeyResponse(key types.ServiceSigningKey, now time.Time) types.SigningKeyResponse {
+	resp := types.SigningKeyResponse{
+		KeyID:     key.KeyID,
+		CreatedAt: key.CreatedAt.UTC().Format(time.RFC3339),
+		ExpiresAt: key.ExpiresAt.UTC().Format(time.RFC3339),
+		Active:    now.Before(key.ExpiresAt),
+	}
+	if key.LastUsedAt != nil {
+		resp.LastUsedAt = key.LastUsedAt.UTC().Format(time.RFC3339)
+	}
+	return resp
+}
@@ -0,0 +1,479 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/repo"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/types"
+	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/utils"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// bcryptMaxPasswordBytes is the longest input bcrypt accepts.
+const bcryptMaxPasswordBytes = 72
+
+// CreateUser creates a user with a policy-checked password.
+// @Summary Create user
+// @Description Creates a user record with a bcrypt-hashed password. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.CreateUserRequest true "User payload"
+// @Success 201 {object} types.UserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/users [post]
+func (u *AuthUseCaseImpl) CreateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req types.CreateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode create user request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	req.Role = strings.TrimSpace(req.Role)
+	if req.Username == "" || req.Role == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "username and role are required"})
+		return
+	}
+
+	err = u.validatePassword(req.Password)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	hash, err := u.hashPassword(req.Password)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create user failed"})
+		return
+	}
+
+	user, err := u.repo.CreateUser(ctx, types.UserRecord{Username: req.Username, PasswordHash: hash, Role: req.Role})
+	if errors.Is(err, repo.ErrUsernameTaken) {
+		utils.WriteJSON(w, http.StatusConflict, map[string]string{"error": "username already exists"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "create user failed"})
+		return
+	}
+
+	zap.L().Info("user created", zap.Int64("user_id", user.ID), zap.String("role", user.Role))
+	u.auditChange(ctx, "user_created", principal, "user", user.ID, "username "+user.Username)
+	utils.WriteJSON(w, http.StatusCreated, userResponse(user))
+}
+
+// ListUsers lists users without password hashes.
+// @Summary List users
+// @Description Lists user records. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} types.UsersResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/users [get]
+func (u *AuthUseCaseImpl) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := u.requireAdmin(w, r); !ok {
+		return
+	}
+
+	records, err := u.repo.ListUsers(r.Context())
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "list users failed"})
+		return
+	}
+
+	users := make([]types.UserResponse, 0, len(records))
+	for _, record := range records {
+		users = append(users, userResponse(record))
+	}
+
+	utils.WriteJSON(w, http.StatusOK, types.UsersResponse{Users: users})
+}
+
+// DisableUser disables a user and optionally revokes its live tokens.
+// @Summary Disable user
+// @Description Disables a user so it can no longer log in or refresh. Set revoke_tokens to also revoke every token issued so far. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body types.DisableRequest false "Disable options"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/users/{id}/disable [post]
+func (u *AuthUseCaseImpl) DisableUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := idFromPath(w, r, "id", "invalid user id")
+	if !ok {
+		return
+	}
+
+	var req types.DisableRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		zap.L().Error("decode disable user request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if isSelfSubject(principal, "user", userID) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot disable own user"})
+		return
+	}
+
+	err = u.repo.SetUserDisabled(ctx, userID, true)
+	if !writeUpdateError(w, err, "user not found", "disable user failed") {
+		return
+	}
+
+	if req.RevokeTokens && !u.revokeSubjectTokens(ctx, w, principal, "user", userID, req.Reason) {
+		return
+	}
+
+	zap.L().Info("user disabled", zap.Int64("user_id", userID), zap.String("by", principalRef(principal)), zap.Bool("revoke_tokens", req.RevokeTokens))
+	u.auditChange(ctx, "user_disabled", principal, "user", userID, req.Reason)
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "disabled"})
+}
+
+// EnableUser re-enables a disabled user.
+// @Summary Enable user
+// @Description Re-enables a disabled user. Revoked tokens stay revoked. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/users/{id}/enable [post]
+func (u *AuthUseCaseImpl) EnableUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := idFromPath(w, r, "id", "invalid user id")
+	if !ok {
+		return
+	}
+
+	err := u.repo.SetUserDisabled(r.Context(), userID, false)
+	if !writeUpdateError(w, err, "user not found", "enable user failed") {
+		return
+	}
+
+	zap.L().Info("user enabled", zap.Int64("user_id", userID), zap.String("by", principalRef(principal)))
+	u.auditChange(r.Context(), "user_enabled", principal, "user", userID, "")
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "enabled"})
+}
+
+// DeleteUser deletes a user and optionally revokes its live tokens.
+// @Summary Delete user
+// @Description Deletes a user record. Pass revoke_tokens=true to also revoke every token issued so far. Requires an admin role.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Param revoke_tokens query bool false "Revoke the user's tokens"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/admin/users/{id} [delete]
+func (u *AuthUseCaseImpl) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := u.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := idFromPath(w, r, "id", "invalid user id")
+	if !ok {
+		return
+	}
+
+	revokeTokens := false
+	if raw := r.URL.Query().Get("revoke_tokens"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid revoke_tokens"})
+			return
+		}
+		revokeTokens = parsed
+	}
+
+	if isSelfSubject(principal, "user", userID) {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot delete own user"})
+		return
+	}
+
+	err := u.repo.DeleteUser(ctx, userID)
+	if !writeUpdateError(w, err, "user not found", "delete user failed") {
+		return
+	}
+
+	if revokeTokens && !u.revokeSubjectTokens(ctx, w, principal, "user", userID, "user deleted") {
+		return
+	}
+
+	zap.L().Info("user deleted", zap.Int64("user_id", userID), zap.String("by", principalRef(principal)), zap.Bool("revoke_tokens", revokeTokens))
+	u.auditChange(ctx, "user_deleted", principal, "user", userID, "")
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "deleted"})
+}
+
+// ChangePassword lets a user replace its own password after verifying the current one.
+// @Summary Change password
+// @Description Changes the caller's password. Requires a user token and the current password.
+// @Tags auth-gw
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body types.ChangePasswordRequest true "Password change payload"
+// @Success 200 {object} types.StatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/password [post]
+func (u *AuthUseCaseImpl) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := principalFromContext(ctx)
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	if principal.TokenType != "user" {
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+		return
+	}
+	if refuseImpersonated(w, principal) {
+		return
+	}
+
+	var req types.ChangePasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		zap.L().Error("decode change password request", zap.Error(err))
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "current_password and new_password are required"})
+		return
+	}
+
+	userID, err := strconv.ParseInt(principal.Subject, 10, 64)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	user, err := u.repo.FindUserByID(ctx, userID)
+	if err != nil || user.Disabled {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	ok, _, err = u.passwords.Verify(user.PasswordHash, req.CurrentPassword)
+	if err != nil || !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid current password"})
+		return
+	}
+
+	err = u.validatePassword(req.NewPassword)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	hash, err := u.hashPassword(req.NewPassword)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "change password failed"})
+		return
+	}
+
+	err = u.repo.UpdateUserPassword(ctx, userID, hash)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "change password failed"})
+		return
+	}
+
+	zap.L().Info("password changed", zap.Int64("user_id", userID))
+	u.auditChange(ctx, "password_changed", principal, "user", userID, "")
+	utils.WriteJSON(w, http.StatusOK, types.StatusResponse{Status: "password_changed"})
+}
+
+// requireAdmin returns the caller when it carries an admin role; it writes the response when false.
+func (u *AuthUseCaseImpl) requireAdmin(w http.ResponseWriter, r *http.Request) (types.ValidateResponse, bool) {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return types.ValidateResponse{}, false
+	}
+	if !u.isAdmin(principal) {
+		utils.WriteJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+		return types.ValidateResponse{}, false
+	}
+	return principal, true
+}
+
+// revokeSubjectTokens revokes every token issued so far for a user or service; it writes the response when false.
+func (u *AuthUseCaseImpl) revokeSubjectTokens(ctx context.Context, w http.ResponseWriter, principal types.ValidateResponse, tokenType string, id int64, reason string) bool {
+	err := u.revokeSubjectCore(ctx, types.SubjectRevocation{
+		TokenType:     tokenType,
+		Subject:       fmt.Sprint(id),
+		RevokedBefore: time.Now().UTC(),
+		Reason:        reason,
+		RevokedBy:     principalRef(principal),
+	})
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "revocation failed"})
+		return false
+	}
+	return true
+}
+
+// validatePassword checks a new password against the configured policy.
+func (u *AuthUseCaseImpl) validatePassword(password string) error {
+	policy := passwordPolicyWithDefaults(u.settings.PasswordPolicy)
+	if len(password) < policy.MinLength {
+		return fmt.Errorf("password must be at least %d characters", policy.MinLength)
+	}
+	if len(password) > policy.MaxLength {
+		return fmt.Errorf("password must be at most %d bytes", policy.MaxLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			hasSymbol = true
+		}
+	}
+
+	switch {
+	case policy.RequireUpper && !hasUpper:
+		return errors.New("password must contain an uppercase letter")
+	case policy.RequireLower && !hasLower:
+		return errors.New("password must contain a lowercase letter")
+	case policy.RequireDigit && !hasDigit:
+		return errors.New("password must contain a digit")
+	case policy.RequireSymbol && !hasSymbol:
+		return errors.New("password must contain a symbol")
+	}
+	return nil
+}
+
+// hashPassword hashes a password with the configured algorithm.
+func (u *AuthUseCaseImpl) hashPassword(password string) (string, error) {
+	return u.passwords.Hash(password)
+}
+
+// rehashPassword replaces the stored hash of a user after a successful login; failures are only logged.
+func (u *AuthUseCaseImpl) rehashPassword(ctx context.Context, userID int64, password string) {
+	hash, err := u.hashPassword(password)
+	if err != nil {
+		return
+	}
+
+	err = u.repo.UpdateUserPassword(ctx, userID, hash)
+	if err != nil {
+		zap.L().Warn("rehash password", zap.Int64("user_id", userID), zap.Error(err))
+		return
+	}
+	zap.L().Info("password rehashed", zap.Int64("user_id", userID))
+}
+
+// passwordPolicyWithDefaults fills unset password policy lengths.
+func passwordPolicyWithDefaults(policy types.PasswordPolicy) types.PasswordPolicy {
+	if policy.MinLength <= 0 {
+		policy.MinLength = 8
+	}
+	if policy.MaxLength <= 0 || policy.MaxLength > bcryptMaxPasswordBytes {
+		policy.MaxLength = bcryptMaxPasswordBytes
+	}
+	return policy
+}
+
+// idFromPath parses a positive numeric path variable; it writes the response when false.
+func idFromPath(w http.ResponseWriter, r *http.Request, key string, message string) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)[key], 10, 64)
+	if err != nil || id <= 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": message})
+		return 0, false
+	}
+	return id, true
+}
+
+// writeUpdateError maps repository errors of record updates; it writes the response when false.
+func writeUpdateError(w http.ResponseWriter, err error, notFound string, message string) bool {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": notFound})
+		return false
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": message})
+		return false
+	}
+	return true
+}
+
+// isSelfSubject reports whether the principal is the user or service with id.
+func isSelfSubject(principal types.ValidateResponse, tokenType string, id int64) bool {
+	return principal.TokenType == tokenType && principal.Subject == fmt.Sprint(id)
+}
+
+// userResponse maps a user record to its API form.
+func userResponse(record types.UserRecord) types.UserResponse {
+	return types.UserResponse{ID: record.ID, Username: record.Username, Email: record.Email, Role: record.Role, Disabled: record.Disabled}
+}
@@ -1,38 +1,93 @@
 package main
 
 import (
+	"context"
 	g "github.com/yirez/go-gw-test/cmd/auth_gw/internal/globals"
 	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/repo"
 	"github.com/yirez/go-gw-test/pkg/rest_qol"
 	"net/http"
-	"time"
 
 	_ "github.com/yirez/go-gw-test/cmd/auth_gw/docs"
 	"github.com/yirez/go-gw-test/cmd/auth_gw/internal/usecase"
 
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger"
+	"go.uber.org/zap"
 )
 
 // NewRouter builds the gorilla mux router for auth_gw.
 func NewRouter() http.Handler {
 	authRepo := repo.NewAuthRepo(g.Cfg.StandardConfigs.Clients.DB)
-	authUseCase := usecase.NewAuthUseCase(authRepo, g.Cfg.JwtSigningKey, time.Hour)
+	revocationRepo := repo.NewRevocationRepo(g.Cfg.StandardConfigs.Clients.Redis)
+	attemptRepo := repo.NewLoginAttemptRepo(g.Cfg.StandardConfigs.Clients.Redis)
+	authUseCase := usecase.NewAuthUseCase(authRepo, revocationRepo, attemptRepo, g.Cfg.JwtSigningKey, g.Cfg.AuthSettings)
+
+	err := authUseCase.WarmRevocations(context.Background())
+	if err != nil {
+		zap.L().Error("warm revocations", zap.Error(err))
+	}
+	go authUseCase.RunAuditRetention(context.Background())
+	go authUseCase.RunSessionCleanup(context.Background())
 
 	router := mux.NewRouter()
 	metrics := rest_qol.NewHTTPMetrics("auth_gw")
+	metrics.MustRegister(authUseCase.Collectors()...)
 
 	rest_qol.RegisterOperationalRoutes(router, httpSwagger.WrapHandler, metrics.Handler())
 
 	router.HandleFunc("/auth/login", authUseCase.Login).Methods(http.MethodPost)
+	router.HandleFunc("/auth/login/mfa", authUseCase.LoginMFA).Methods(http.MethodPost)
 	router.HandleFunc("/auth/service-token", authUseCase.ServiceToken).Methods(http.MethodPost)
+	router.HandleFunc("/auth/refresh", authUseCase.Refresh).Methods(http.MethodPost)
+	router.HandleFunc("/auth/logout", authUseCase.Logout).Methods(http.MethodPost)
+	router.HandleFunc("/auth/register", authUseCase.Register).Methods(http.MethodPost)
+	router.HandleFunc("/auth/register/verify", authUseCase.VerifyRegistration).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password/forgot", authUseCase.ForgotPassword).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password/reset", authUseCase.ResetPassword).Methods(http.MethodPost)
+	router.HandleFunc("/auth/oidc/{provider}/login", authUseCase.OIDCLogin).Methods(http.MethodGet)
+	router.HandleFunc("/auth/oidc/{provider}/callback", authUseCase.OIDCCallback).Methods(http.MethodGet)
+	router.HandleFunc("/oauth/token", authUseCase.OAuthToken).Methods(http.MethodPost)
+	router.HandleFunc("/oauth/introspect", authUseCase.OAuthIntrospect).Methods(http.MethodPost)
 	router.HandleFunc("/auth/validate", authUseCase.Validate).Methods(http.MethodPost)
+	router.HandleFunc("/auth/revoke", authUseCase.Revoke).Methods(http.MethodPost)
+	router.HandleFunc("/auth/impersonate", authUseCase.Impersonate).Methods(http.MethodPost)
+	router.HandleFunc("/auth/sessions", authUseCase.ListSessions).Methods(http.MethodGet)
+	router.HandleFunc("/auth/sessions/{jti}", authUseCase.RevokeSession).Methods(http.MethodDelete)
+	router.HandleFunc("/auth/mfa", authUseCase.GetMFAStatus).Methods(http.MethodGet)
+	router.HandleFunc("/auth/mfa/enroll", authUseCase.EnrollMFA).Methods(http.MethodPost)
+	router.HandleFunc("/auth/mfa/confirm", authUseCase.ConfirmMFA).Methods(http.MethodPost)
+	router.HandleFunc("/auth/mfa/disable", authUseCase.DisableMFA).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password", authUseCase.ChangePassword).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/unlock", authUseCase.Unlock).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/users", authUseCase.CreateUser).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/users", authUseCase.ListUsers).Methods(http.MethodGet)
+	router.HandleFunc("/auth/admin/users/{id}", authUseCase.DeleteUser).Methods(http.MethodDelete)
+	router.HandleFunc("/auth/admin/users/{id}/disable", authUseCase.DisableUser).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/users/{id}/enable", authUseCase.EnableUser).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/users/{id}/roles", authUseCase.SetUserRoles).Methods(http.MethodPut)
+	router.HandleFunc("/auth/admin/users/{id}/mfa", authUseCase.ResetUserMFA).Methods(http.MethodDelete)
+	router.HandleFunc("/auth/admin/services", authUseCase.CreateService).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/services", authUseCase.ListServices).Methods(http.MethodGet)
+	router.HandleFunc("/auth/admin/services/{id}/secrets", authUseCase.RotateServiceSecret).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/services/{id}/secrets/{secret_id}", authUseCase.DeleteServiceSecret).Methods(http.MethodDelete)
+	router.HandleFunc("/auth/admin/services/{id}/signing-keys", authUseCase.CreateSigningKey).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/services/{id}/signing-keys/{key_id}", authUseCase.DeleteSigningKey).Methods(http.MethodDelete)
+	router.HandleFunc("/auth/admin/services/{id}/disable", authUseCase.DisableService).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/services/{id}/enable", authUseCase.EnableService).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/services/{id}/roles", authUseCase.SetServiceRoles).Methods(http.MethodPut)
+	router.HandleFunc("/auth/admin/api-keys", authUseCase.CreateAPIKey).Methods(http.MethodPost)
+	router.HandleFunc("/auth/admin/api-keys", authUseCase.ListAPIKeys).Methods(http.MethodGet)
+	router.HandleFunc("/auth/admin/api-keys/{id}", authUseCase.DeleteAPIKey).Methods(http.MethodDelete)
+	router.HandleFunc("/auth/admin/sessions", authUseCase.ListSubjectSessions).Methods(http.MethodGet)
+	router.HandleFunc("/auth/admin/sessions/{jti}", authUseCase.RevokeSubjectSession).Methods(http.MethodDelete)
+	router.HandleFunc("/auth/admin/audit-events", authUseCase.ListAuthEvents).Methods(http.MethodGet)
 
 	router.NotFoundHandler = http.HandlerFunc(authUseCase.NotFound)
 
 	router.Use(rest_qol.RequestIDMiddleware("direct-auth-gw-"))
 	router.Use(metrics.Middleware())
 	router.Use(rest_qol.AccessLoggingMiddleware())
+	router.Use(authUseCase.AuditMiddleware())
 	router.Use(authUseCase.AuthMiddleware())
 
 	return router
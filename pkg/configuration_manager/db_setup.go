@@ -25,7 +25,8 @@ func initDB(dbConfig *types.DBConfig) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError turns unique violations into gorm.ErrDuplicatedKey so repos can map them to conflicts.
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		err = fmt.Errorf("open gorm db: %w", err)
 		log.Printf("init db open: %v", err)
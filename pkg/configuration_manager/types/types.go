@@ -48,9 +48,11 @@ type RedisConfig struct {
 
 // AuthConfig captures auth service connection details.
 type AuthConfig struct {
-	Endpoint  string `mapstructure:"endpoint"`
-	ServiceID string `mapstructure:"service_id"`
-	Secret    string `mapstructure:"secret"`
+	Endpoint              string `mapstructure:"endpoint"`
+	ServiceID             string `mapstructure:"service_id"`
+	Secret                string `mapstructure:"secret"`
+	ValidationCacheTTLSec int    `mapstructure:"validation_cache_ttl_sec"`
+	SignatureMaxSkewSec   int    `mapstructure:"signature_max_skew_sec"` // accepted request signing clock skew, 0 uses 300.
 }
 
 // InitChecklist controls which standard clients should be initialized.
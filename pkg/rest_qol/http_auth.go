@@ -1,18 +1,48 @@
 package rest_qol
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 )
 
-// BearerTokenFromRequest extracts the Bearer token from Authorization header.
+const (
+	// SessionCookieName is the HttpOnly cookie holding the gateway token of browser sessions.
+	SessionCookieName = "gw_session"
+	// CSRFCookieName is the script-readable cookie holding the CSRF token of the session.
+	CSRFCookieName = "gw_csrf"
+	// CSRFHeader carries the CSRF token on unsafe requests authenticated by the session cookie.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// ErrCSRFTokenInvalid is returned for unsafe requests whose session cookie lacks a matching CSRF token.
+var ErrCSRFTokenInvalid = errors.New("csrf token missing or invalid")
+
+// BearerTokenFromRequest extracts the Bearer token from Authorization header, else from the session cookie.
+// Session cookies are only accepted on unsafe methods together with the CSRF token of the session.
 func BearerTokenFromRequest(r *http.Request) (string, error) {
 	if r == nil {
 		return "", fmt.Errorf("request is nil")
 	}
 
-	return BearerTokenFromHeader(r.Header.Get("Authorization"))
+	header := r.Header.Get("Authorization")
+	if header != "" {
+		return BearerTokenFromHeader(header)
+	}
+
+	token, ok := SessionTokenFromCookie(r)
+	if !ok {
+		return BearerTokenFromHeader(header)
+	}
+	if !IsSafeMethod(r.Method) && !ValidCSRFToken(token, r.Header.Get(CSRFHeader)) {
+		return "", ErrCSRFTokenInvalid
+	}
+
+	return token, nil
 }
 
 // BearerTokenFromHeader extracts the Bearer token from an Authorization header value.
@@ -33,3 +63,49 @@ func BearerTokenFromHeader(header string) (string, error) {
 
 	return token, nil
 }
+
+// SessionTokenFromCookie returns the gateway token of the session cookie, if the request carries one.
+func SessionTokenFromCookie(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil || strings.TrimSpace(cookie.Value) == "" {
+		return "", false
+	}
+
+	return strings.TrimSpace(cookie.Value), true
+}
+
+// CSRFTokenFor derives the CSRF token of a session token. It is bound to the session, so a cookie
+// planted by another site cannot come with a matching header, and any gateway can check it statelessly.
+func CSRFTokenFor(sessionToken string) string {
+	sum := sha256.Sum256([]byte("gw-csrf:" + sessionToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidCSRFToken reports whether csrfToken belongs to sessionToken.
+func ValidCSRFToken(sessionToken string, csrfToken string) bool {
+	if csrfToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(CSRFTokenFor(sessionToken)), []byte(csrfToken)) == 1
+}
+
+// IsSafeMethod reports whether method is read-only by RFC 9110 and needs no CSRF token.
+func IsSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// StripSessionCookies removes the session and CSRF cookies from r, so they are not forwarded downstream.
+func StripSessionCookies(r *http.Request) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != SessionCookieName && cookie.Name != CSRFCookieName {
+			r.AddCookie(cookie)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package rest_qol
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// errAny marks cases that expect some error without a sentinel to match.
+var errAny = errors.New("any error")
+
+// TestBearerTokenFromRequest verifies header precedence over the session cookie and the CSRF check on unsafe methods.
+func TestBearerTokenFromRequest(t *testing.T) {
+	cases := []struct {
+		name    string
+		method  string
+		header  string
+		cookie  string
+		csrf    string
+		want    string
+		wantErr error
+	}{
+		{name: "header", method: http.MethodPost, header: "Bearer h-token", want: "h-token"},
+		{name: "header wins over cookie", method: http.MethodGet, header: "Bearer h-token", cookie: "c-token", want: "h-token"},
+		{name: "invalid header does not fall back to cookie", method: http.MethodGet, header: "Basic abc", cookie: "c-token", wantErr: errAny},
+		{name: "empty bearer", method: http.MethodGet, header: "Bearer  ", wantErr: errAny},
+		{name: "nothing", method: http.MethodGet, wantErr: errAny},
+		{name: "blank cookie", method: http.MethodGet, cookie: "   ", wantErr: errAny},
+		{name: "cookie on get", method: http.MethodGet, cookie: "c-token", want: "c-token"},
+		{name: "cookie on head", method: http.MethodHead, cookie: "c-token", want: "c-token"},
+		{name: "cookie on options", method: http.MethodOptions, cookie: "c-token", want: "c-token"},
+		{name: "cookie on post with csrf", method: http.MethodPost, cookie: "c-token", csrf: CSRFTokenFor("c-token"), want: "c-token"},
+		{name: "cookie on delete with csrf", method: http.MethodDelete, cookie: "c-token", csrf: CSRFTokenFor("c-token"), want: "c-token"},
+		{name: "cookie on post without csrf", method: http.MethodPost, cookie: "c-token", wantErr: ErrCSRFTokenInvalid},
+		{name: "cookie on put with wrong csrf", method: http.MethodPut, cookie: "c-token", csrf: "wrong", wantErr: ErrCSRFTokenInvalid},
+		{name: "cookie on patch with csrf of another session", method: http.MethodPatch, cookie: "c-token", csrf: CSRFTokenFor("other"), wantErr: ErrCSRFTokenInvalid},
+		{name: "header on post needs no csrf", method: http.MethodPost, header: "Bearer h-token", cookie: "c-token", want: "h-token"},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, "/orders", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		if tc.cookie != "" {
+			req.Header.Set("Cookie", SessionCookieName+"="+tc.cookie)
+		}
+		if tc.csrf != "" {
+			req.Header.Set(CSRFHeader, tc.csrf)
+		}
+
+		got, err := BearerTokenFromRequest(req)
+		switch {
+		case tc.wantErr == errAny:
+			if err == nil {
+				t.Fatalf("%s: expected error, got token %q", tc.name, got)
+			}
+		case tc.wantErr != nil:
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("%s: expected %v, got %q, %v", tc.name, tc.wantErr, got, err)
+			}
+		case err != nil:
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		case got != tc.want:
+			t.Fatalf("%s: expected token %q, got %q", tc.name, tc.want, got)
+		}
+	}
+
+	if _, err := BearerTokenFromRequest(nil); err == nil {
+		t.Fatalf("expected error for nil request")
+	}
+}
+
+// TestStripSessionCookies verifies only the session and CSRF cookies are removed before forwarding.
+func TestStripSessionCookies(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("Cookie", "theme=dark; "+SessionCookieName+"=c-token; lang=en; "+CSRFCookieName+"=csrf")
+
+	StripSessionCookies(req)
+
+	cookies := req.Cookies()
+	if len(cookies) != 2 || cookies[0].Name != "theme" || cookies[0].Value != "dark" || cookies[1].Name != "lang" || cookies[1].Value != "en" {
+		t.Fatalf("expected only unrelated cookies in order, got %v", cookies)
+	}
+
+	// requests carrying only session cookies lose the Cookie header entirely
+	req = httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("Cookie", SessionCookieName+"=c-token; "+CSRFCookieName+"=csrf")
+
+	StripSessionCookies(req)
+
+	if header := req.Header.Get("Cookie"); header != "" {
+		t.Fatalf("expected no cookie header, got %q", header)
+	}
+}
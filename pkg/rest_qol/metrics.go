@@ -12,6 +12,7 @@ import (
 
 // HTTPMetrics provides Prometheus instrumentation and an export handler.
 type HTTPMetrics struct {
+	registry        *prometheus.Registry
 	requestTotal    *prometheus.CounterVec
 	requestDuration *prometheus.HistogramVec
 	handler         http.Handler
@@ -43,6 +44,7 @@ func NewHTTPMetrics(serviceName string) *HTTPMetrics {
 	registry.MustRegister(requestTotal, requestDuration)
 
 	return &HTTPMetrics{
+		registry:        registry,
 		requestTotal:    requestTotal,
 		requestDuration: requestDuration,
 		handler:         promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
@@ -54,6 +56,11 @@ func (m *HTTPMetrics) Handler() http.Handler {
 	return m.handler
 }
 
+// MustRegister adds service-specific collectors to the exported registry.
+func (m *HTTPMetrics) MustRegister(collectors ...prometheus.Collector) {
+	m.registry.MustRegister(collectors...)
+}
+
 // Middleware instruments request count and request duration.
 func (m *HTTPMetrics) Middleware() mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
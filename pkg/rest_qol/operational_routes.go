@@ -1,16 +1,32 @@
 package rest_qol
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// readinessCheckTimeout bounds each readiness check.
+const readinessCheckTimeout = 2 * time.Second
+
+// ReadinessCheck is a named dependency check reported by /readyz.
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
 // RegisterOperationalRoutes registers common liveness/readiness/metrics routes and optional swagger UI route.
-func RegisterOperationalRoutes(router *mux.Router, swaggerHandler http.Handler, metricsHandler http.Handler) {
+// Readiness checks, when given, must all pass for /readyz to report ready.
+func RegisterOperationalRoutes(router *mux.Router, swaggerHandler http.Handler, metricsHandler http.Handler, checks ...ReadinessCheck) {
 	router.HandleFunc("/healthz", HealthHandler).Methods(http.MethodGet)
-	router.HandleFunc("/readyz", ReadyHandler).Methods(http.MethodGet)
+	if len(checks) > 0 {
+		router.HandleFunc("/readyz", ReadyHandlerWithChecks(checks...)).Methods(http.MethodGet)
+	} else {
+		router.HandleFunc("/readyz", ReadyHandler).Methods(http.MethodGet)
+	}
 	if metricsHandler != nil {
 		router.Path("/metrics").Methods(http.MethodGet).Handler(metricsHandler)
 	} else {
@@ -31,6 +47,27 @@ func ReadyHandler(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
 }
 
+// ReadyHandlerWithChecks returns a readiness handler that reports 503 and the failing checks until all pass.
+func ReadyHandlerWithChecks(checks ...ReadinessCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failures := make(map[string]string)
+		for _, check := range checks {
+			ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+			err := check.Check(ctx)
+			cancel()
+			if err != nil {
+				failures[check.Name] = err.Error()
+			}
+		}
+
+		if len(failures) > 0 {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "not_ready", "checks": failures})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	}
+}
+
 // MetricsMissingHandler returns a placeholder metrics status response.
 func MetricsMissingHandler(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "metrics_not_implemented"})
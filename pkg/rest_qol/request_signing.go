@@ -0,0 +1,156 @@
+package rest_qol
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request signing scheme shared by signing clients and api_gw.
+const (
+	SignatureAlgorithm       = "GW-HMAC-SHA256"
+	SignatureTimestampHeader = "X-Gw-Timestamp" // unix seconds.
+	SignatureNonceHeader     = "X-Gw-Nonce"
+)
+
+// SignRequest signs req with a per-service signing key: it sets the timestamp and nonce headers
+// and an Authorization header of the form "GW-HMAC-SHA256 KeyId=<id>, Signature=<hex>".
+// The body is read for hashing and restored.
+func SignRequest(req *http.Request, keyID string, secret []byte) error {
+	if req == nil {
+		return fmt.Errorf("request is nil")
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+
+	bodyHash, err := RequestBodyHash(req, 0)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(nonceBytes)
+	req.Header.Set(SignatureTimestampHeader, timestamp)
+	req.Header.Set(SignatureNonceHeader, nonce)
+
+	canonical := CanonicalRequest(req.Method, req.URL.EscapedPath(), req.URL.RawQuery, timestamp, nonce, bodyHash)
+	req.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Signature=%s", SignatureAlgorithm, keyID, ComputeSignature(secret, canonical)))
+	return nil
+}
+
+// CanonicalRequest builds the string that is signed: algorithm, method, path, sorted query, timestamp, nonce and body hash, one per line.
+func CanonicalRequest(method string, escapedPath string, rawQuery string, timestamp string, nonce string, bodyHash string) string {
+	if escapedPath == "" {
+		escapedPath = "/"
+	}
+
+	return strings.Join([]string{
+		SignatureAlgorithm,
+		strings.ToUpper(method),
+		escapedPath,
+		canonicalQuery(rawQuery),
+		timestamp,
+		nonce,
+		bodyHash,
+	}, "\n")
+}
+
+// ComputeSignature returns the hex HMAC-SHA256 of a canonical request.
+func ComputeSignature(secret []byte, canonical string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RequestBodyHash returns the hex SHA-256 of the request body and restores the body for later readers.
+// A positive maxBytes rejects larger bodies.
+func RequestBodyHash(req *http.Request, maxBytes int64) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	reader := io.Reader(req.Body)
+	if maxBytes > 0 {
+		reader = io.LimitReader(req.Body, maxBytes+1)
+	}
+	body, err := io.ReadAll(reader)
+	_ = req.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("read body: %w", err)
+	}
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		return "", fmt.Errorf("body exceeds %d bytes", maxBytes)
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// IsSignedRequest reports whether the Authorization header uses the request signing scheme.
+func IsSignedRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), SignatureAlgorithm+" ")
+}
+
+// ParseSignatureAuthorization extracts key id and signature from a signing Authorization header value.
+func ParseSignatureAuthorization(header string) (string, string, error) {
+	params, ok := strings.CutPrefix(header, SignatureAlgorithm+" ")
+	if !ok {
+		return "", "", fmt.Errorf("invalid signature authorization header")
+	}
+
+	values := make(map[string]string, 2)
+	for _, part := range strings.Split(params, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return "", "", fmt.Errorf("invalid signature parameter %q", part)
+		}
+		if name != "KeyId" && name != "Signature" {
+			continue
+		}
+		// repeated parameters are ambiguous between verifiers, so they are rejected rather than picked
+		if _, seen := values[name]; seen {
+			return "", "", fmt.Errorf("duplicate signature parameter %q", name)
+		}
+		values[name] = value
+	}
+
+	keyID, signature := values["KeyId"], values["Signature"]
+
+	if keyID == "" || signature == "" {
+		return "", "", fmt.Errorf("signature authorization header missing KeyId or Signature")
+	}
+	return keyID, signature, nil
+}
+
+// canonicalQuery sorts query parameters by name and value so clients and api_gw encode them the same way.
+func canonicalQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// unparsable queries are signed verbatim
+		return rawQuery
+	}
+
+	for key := range values {
+		sort.Strings(values[key])
+	}
+	return values.Encode()
+}
@@ -0,0 +1,133 @@
+package rest_qol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestParseSignatureAuthorization verifies key id and signature parsing, including malformed and ambiguous headers.
+func TestParseSignatureAuthorization(t *testing.T) {
+	cases := []struct {
+		name      string
+		header    string
+		keyID     string
+		signature string
+		wantErr   bool
+	}{
+		{name: "canonical", header: "GW-HMAC-SHA256 KeyId=svc, Signature=abc", keyID: "svc", signature: "abc"},
+		{name: "no spaces", header: "GW-HMAC-SHA256 KeyId=svc,Signature=abc", keyID: "svc", signature: "abc"},
+		{name: "extra spaces", header: "GW-HMAC-SHA256   KeyId=svc ,  Signature=abc  ", keyID: "svc", signature: "abc"},
+		{name: "reversed order", header: "GW-HMAC-SHA256 Signature=abc, KeyId=svc", keyID: "svc", signature: "abc"},
+		{name: "unknown parameter ignored", header: "GW-HMAC-SHA256 KeyId=svc, Version=2, Signature=abc", keyID: "svc", signature: "abc"},
+		{name: "other scheme", header: "Bearer token", wantErr: true},
+		{name: "scheme without params", header: "GW-HMAC-SHA256", wantErr: true},
+		{name: "missing key id", header: "GW-HMAC-SHA256 Signature=abc", wantErr: true},
+		{name: "missing signature", header: "GW-HMAC-SHA256 KeyId=svc", wantErr: true},
+		{name: "empty key id", header: "GW-HMAC-SHA256 KeyId=, Signature=abc", wantErr: true},
+		{name: "parameter without value", header: "GW-HMAC-SHA256 KeyId=svc, Signature", wantErr: true},
+		{name: "space around equals", header: "GW-HMAC-SHA256 KeyId = svc, Signature = abc", wantErr: true},
+		{name: "duplicate key id", header: "GW-HMAC-SHA256 KeyId=svc, KeyId=other, Signature=abc", wantErr: true},
+		{name: "duplicate empty key id", header: "GW-HMAC-SHA256 KeyId=, KeyId=svc, Signature=abc", wantErr: true},
+		{name: "duplicate signature", header: "GW-HMAC-SHA256 KeyId=svc, Signature=abc, Signature=def", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		keyID, signature, err := ParseSignatureAuthorization(tc.header)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("%s: expected error, got key id %q signature %q", tc.name, keyID, signature)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if keyID != tc.keyID || signature != tc.signature {
+			t.Fatalf("%s: expected %q/%q, got %q/%q", tc.name, tc.keyID, tc.signature, keyID, signature)
+		}
+	}
+}
+
+// TestCanonicalQuery verifies query parameters are sorted by name and value and unparsable queries pass through verbatim.
+func TestCanonicalQuery(t *testing.T) {
+	cases := []struct {
+		name     string
+		rawQuery string
+		want     string
+	}{
+		{name: "empty", rawQuery: "", want: ""},
+		{name: "sorted by name", rawQuery: "b=2&a=1", want: "a=1&b=2"},
+		{name: "repeated key sorted by value", rawQuery: "tag=z&id=7&tag=a&tag=m", want: "id=7&tag=a&tag=m&tag=z"},
+		{name: "encoding normalised", rawQuery: "q=a+b&p=%7E", want: "p=~&q=a+b"},
+		{name: "key without value", rawQuery: "flag&a=1", want: "a=1&flag="},
+		{name: "unparsable kept verbatim", rawQuery: "b=%zz&a=1", want: "b=%zz&a=1"},
+		{name: "semicolon kept verbatim", rawQuery: "b=1;a=2", want: "b=1;a=2"},
+	}
+
+	for _, tc := range cases {
+		if got := canonicalQuery(tc.rawQuery); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
+// TestRequestBodyHash verifies body hashing honours maxBytes at its boundary and leaves the body readable.
+func TestRequestBodyHash(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		maxBytes int64
+		wantErr  bool
+	}{
+		{name: "unlimited", body: "hello world", maxBytes: 0},
+		{name: "below limit", body: "hello", maxBytes: 6},
+		{name: "at limit", body: "hello", maxBytes: 5},
+		{name: "one over limit", body: "hello", maxBytes: 4, wantErr: true},
+		{name: "empty body with limit", body: "", maxBytes: 1},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(tc.body))
+		got, err := RequestBodyHash(req, tc.maxBytes)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("%s: expected error for %d byte body over %d", tc.name, len(tc.body), tc.maxBytes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+
+		sum := sha256.Sum256([]byte(tc.body))
+		if want := hex.EncodeToString(sum[:]); got != want {
+			t.Fatalf("%s: expected hash %s, got %s", tc.name, want, got)
+		}
+
+		// both the restored body and GetBody serve the full body to later readers
+		body, _ := io.ReadAll(req.Body)
+		if string(body) != tc.body {
+			t.Fatalf("%s: expected restored body %q, got %q", tc.name, tc.body, body)
+		}
+		again, err := req.GetBody()
+		if err != nil {
+			t.Fatalf("%s: get body: %v", tc.name, err)
+		}
+		body, _ = io.ReadAll(again)
+		if string(body) != tc.body {
+			t.Fatalf("%s: expected GetBody to return %q, got %q", tc.name, tc.body, body)
+		}
+	}
+
+	// requests without a body hash like an empty one
+	got, err := RequestBodyHash(httptest.NewRequest(http.MethodGet, "/orders", nil), 1)
+	sum := sha256.Sum256(nil)
+	if err != nil || got != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected empty body hash, got %q, %v", got, err)
+	}
+}